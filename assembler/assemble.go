@@ -29,6 +29,28 @@ const (
 	AsciizDirective
 	OrgDirective
 	VarDirective
+	BasicUpstartDirective
+	MacroDirective
+	EndMacroDirective
+	IfDirective
+	ElseIfDirective
+	ElseDirective
+	EndIfDirective
+	IfDefDirective
+	IfNDefDirective
+	ReptDirective
+	EndReptDirective
+	ForDirective
+	EndForDirective
+	WhileDirective
+	EndWhileDirective
+	ProcDirective
+	EndProcDirective
+	ScopeDirective
+	EndScopeDirective
+	EncodingDirective
+	IncBinDirective
+	IncPrgDirective
 )
 
 type Instruction struct {
@@ -75,6 +97,36 @@ type Assembler struct {
 	directives            map[string]int
 	lexerConfig           *lexer.LanguageConfig
 	programCounter        uint16
+	basicUpstartWidths    map[uint16]int // Digits reserved for each .BASICUPSTART's address, by program counter
+	macros                map[string]*macro
+	macroCount            int                   // Expansions so far, which makes each expansion's local labels unique
+	origins               []origin              // Where each token came from
+	conditions            []conditional         // Open .IF blocks, innermost last
+	conditionResults      map[int]bool          // First pass result of each .IF, .ELSEIF and defined(), by token index
+	instructionSizes      map[int]int           // First pass size of each instruction, by token index
+	unsizedCalls          map[int]bool          // Function call operands the first pass met an undefined symbol in, by token index
+	unresolved            bool                  // Whether the first pass has met an undefined symbol since this was cleared
+	variables             map[string]bool       // Constants assigned with .VAR, which can be assigned again
+	variableValues        map[int]assignment    // Assignment made by each .VAR in the first pass, by token index
+	scopes                []scope               // Open .PROC and .SCOPE blocks, innermost last
+	localPrefix           string                // The global label that cheap locals defined here belong to
+	generatedLabels       map[string]bool       // The names given to the labels of macro expansions and loop copies
+	outerLookups          map[string]string     // First pass lookups found around a scope, by the names they were not found under
+	anonymousScopes       int                   // Anonymous .SCOPE blocks so far in this pass
+	encoding              string                // The .ENCODING strings and character literals are assembled in
+	firstPass             bool                  // Whether the first pass is running
+	assembledLines        []assembledLine       // The lines the second pass assembled, in order, for the listing
+	scopeRanges           []scopeRange          // The blocks the second pass assembled, in the order they closed
+	segmentRanges         []DebugSegment        // The segments the second pass filled
+	definitions           map[string]definition // Where each label and constant was defined
+	fileResolver          utils.FileResolver    // Resolves the files .INCBIN includes, nil if there is none
+	binaryFiles           map[string][]byte     // The files .INCBIN has read, by name
+}
+
+// assignment is the value a .VAR was assigned, under the name it is recorded as
+type assignment struct {
+	key   string
+	value any
 }
 
 type Directive struct {
@@ -111,18 +163,43 @@ func New(opcodes []*cpu.OpCodeDef) *Assembler {
 	}
 
 	directives := map[string]int{
-		".BYTE":   ByteDirective,
-		".WORD":   WordDirective,
-		".TEXT":   TextDirective,
-		".STRING": StringDirective,
-		".STR":    StrDirective,
-		".ASC":    AscDirective,
-		".ASCIIZ": AsciizDirective,
-		".ORG":    OrgDirective,
-		".DB":     DbDirective,
-		".DW":     DwDirective,
-		".DS":     DsDirective,
-		".VAR":    VarDirective,
+		".BYTE":         ByteDirective,
+		".WORD":         WordDirective,
+		".TEXT":         TextDirective,
+		".STRING":       StringDirective,
+		".STR":          StrDirective,
+		".ASC":          AscDirective,
+		".ASCIIZ":       AsciizDirective,
+		".ORG":          OrgDirective,
+		".DB":           DbDirective,
+		".DW":           DwDirective,
+		".DS":           DsDirective,
+		".VAR":          VarDirective,
+		".BASICUPSTART": BasicUpstartDirective,
+		".MACRO":        MacroDirective,
+		".ENDM":         EndMacroDirective,
+		".ENDMACRO":     EndMacroDirective,
+		".IF":           IfDirective,
+		".ELSEIF":       ElseIfDirective,
+		".ELSE":         ElseDirective,
+		".ENDIF":        EndIfDirective,
+		".IFDEF":        IfDefDirective,
+		".IFNDEF":       IfNDefDirective,
+		".REPT":         ReptDirective,
+		".ENDR":         EndReptDirective,
+		".ENDREPT":      EndReptDirective,
+		".FOR":          ForDirective,
+		".ENDFOR":       EndForDirective,
+		".WHILE":        WhileDirective,
+		".ENDW":         EndWhileDirective,
+		".ENDWHILE":     EndWhileDirective,
+		".PROC":         ProcDirective,
+		".ENDPROC":      EndProcDirective,
+		".SCOPE":        ScopeDirective,
+		".ENDSCOPE":     EndScopeDirective,
+		".ENCODING":     EncodingDirective,
+		".INCBIN":       IncBinDirective,
+		".INCPRG":       IncPrgDirective,
 	}
 
 	assembler := &Assembler{
@@ -132,6 +209,13 @@ func New(opcodes []*cpu.OpCodeDef) *Assembler {
 		constants:             make(map[string]interface{}),
 		addressingModeSymbols: addressingModeSymbols,
 		directives:            directives,
+		basicUpstartWidths:    make(map[uint16]int),
+		macros:                make(map[string]*macro),
+		conditionResults:      make(map[int]bool),
+		variables:             make(map[string]bool),
+		variableValues:        make(map[int]assignment),
+		definitions:           make(map[string]definition),
+		binaryFiles:           make(map[string][]byte),
 		programCounter:        0x0000,
 	}
 
@@ -158,13 +242,23 @@ func (a *Assembler) Assemble(r io.Reader, filename string) ([]AssembledData, err
 	// Reset assembler state for each assembly
 	a.reset()
 
-	tokens, err := lexer.NewLexer(a.lexerConfig).Tokenize(r, filename)
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("[Assembler assemble] Read [%w]", err)
+	}
+	tokens, err := lexer.NewLexer(a.lexerConfig).Tokenize(bytes.NewReader(source), filename)
 	if err != nil {
 		return nil, fmt.Errorf("[Assembler assemble] Tokenize [%w]", err)
 	}
+	file := &sourceFile{name: filename, lines: strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")}
+
+	tokens, err = a.expandMacros(a.joinSymbolNames(tokens, sourceOrigins(tokens, file)))
+	if err != nil {
+		return nil, fmt.Errorf("[Assembler assemble] expandMacros [%w]", err)
+	}
 
 	// First pass: calculate memory layout and collect labels
-	segments, err := a.preprocessor(tokens)
+	segments, tokens, err := a.preprocessor(tokens)
 	if err != nil {
 		return nil, fmt.Errorf("[Assembler assemble] preprocessor [%w]", err)
 	}
@@ -182,6 +276,7 @@ func (a *Assembler) Assemble(r io.Reader, filename string) ([]AssembledData, err
 func (a *Assembler) AssembleFile(mainFile string, fileResolver utils.FileResolver) ([]AssembledData, error) {
 	// Reset assembler state for each assembly
 	a.reset()
+	a.fileResolver = fileResolver
 
 	reader, err := fileResolver.Resolve(mainFile)
 	if err != nil {
@@ -189,13 +284,18 @@ func (a *Assembler) AssembleFile(mainFile string, fileResolver utils.FileResolve
 	}
 
 	asmLexer := NewAssemblerLexer(fileResolver)
-	tokens, err := asmLexer.Tokens(a.lexerConfig, reader, mainFile)
+	tokens, origins, err := asmLexer.tokensWithOrigins(a.lexerConfig, reader, mainFile)
 	if err != nil {
 		return nil, fmt.Errorf("Assembler AssembleFile Tokenize [%w]", err)
 	}
 
+	tokens, err = a.expandMacros(a.joinSymbolNames(tokens, origins))
+	if err != nil {
+		return nil, fmt.Errorf("Assembler AssembleFile expandMacros [%w]", err)
+	}
+
 	// First pass: calculate memory layout and collect labels
-	segments, err := a.preprocessor(tokens)
+	segments, tokens, err := a.preprocessor(tokens)
 	if err != nil {
 		return nil, fmt.Errorf("Assembler preprocessor [%w]", err)
 	}
@@ -213,6 +313,20 @@ func (a *Assembler) AssembleFile(mainFile string, fileResolver utils.FileResolve
 func (a *Assembler) reset() {
 	a.labels = make(map[string]uint64)
 	a.constants = make(map[string]interface{})
+	a.basicUpstartWidths = make(map[uint16]int)
+	a.macros = make(map[string]*macro)
+	a.macroCount = 0
+	a.origins = nil
+	a.conditionResults = make(map[int]bool)
+	a.instructionSizes = make(map[int]int)
+	a.unsizedCalls = make(map[int]bool)
+	a.variables = make(map[string]bool)
+	a.variableValues = make(map[int]assignment)
+	a.definitions = make(map[string]definition)
+	a.generatedLabels = make(map[string]bool)
+	a.outerLookups = make(map[string]string)
+	a.fileResolver = nil
+	a.binaryFiles = make(map[string][]byte)
 	a.programCounter = 0x0000
 	// a.originAddress = 0x0000
 }
@@ -225,6 +339,12 @@ func (a *Assembler) generateCode(tokens []lexer.Token, segments []AssembledData)
 	}
 
 	a.programCounter = 0x00
+	a.conditions = nil
+	a.resetScopes()
+	a.encoding = asciiEncoding
+	a.firstPass = false
+	a.assembledLines = nil
+	a.scopeRanges = nil
 	currentSegmentIndex := -1
 
 	// Find the initial segment
@@ -235,6 +355,7 @@ func (a *Assembler) generateCode(tokens []lexer.Token, segments []AssembledData)
 	appendToMemory := func(data []byte) {
 		if currentSegmentIndex >= 0 && currentSegmentIndex < len(segments) {
 			segments[currentSegmentIndex].Data.Write(data)
+			a.recordData(data)
 			a.programCounter += uint16(len(data))
 		}
 	}
@@ -259,34 +380,37 @@ func (a *Assembler) generateCode(tokens []lexer.Token, segments []AssembledData)
 		if t.ID == lexer.EOFType {
 			break
 		}
+		start := asmTokens.Index()
+		a.recordLine(asmTokens)
 
 		switch t.ID {
 		case MnemonicToken:
 			err := a.generateInstructionCode(t, asmTokens, appendToMemory)
 			if err != nil {
-				return err
+				return a.statementError(t, start, err)
 			}
 			tokenPosition = 0 // Reset position after processing instruction - it swallows the line
 
 		case AsterixSymbolToken:
 			err := a.checkForOrgAsterixDirective(asmTokens, updateCurrentSegment)
 			if err != nil {
-				return err
+				return a.statementError(t, start, err)
 			}
 
 		case PeriodToken:
 			err := a.processAssemblerDirective(asmTokens, appendToMemory, updateCurrentSegment)
 			if err != nil {
-				return err
+				return a.statementError(t, start, err)
 			}
 
 		case LabelToken:
-			// Labels already processed in first pass
+			// Labels already processed in first pass; follow them for the cheap locals after them
+			a.labelKey(strings.TrimSuffix(t.Literal, ":"))
 			continue
 
 		case PlusToken, MinusToken:
 			if tokenPosition != 1 {
-				return fmt.Errorf("[generateCode] unexpected token '%s'", t.Literal)
+				return a.statementError(t, start, fmt.Errorf("[generateCode] unexpected token '%s'", t.Literal))
 			}
 			tokenPosition = 0 // Reset the token position, there may be another "+" or "-"
 			continue
@@ -298,13 +422,13 @@ func (a *Assembler) generateCode(tokens []lexer.Token, segments []AssembledData)
 			nextToken := asmTokens.Peek()
 			if nextToken.ID == EqualsSymbolToken {
 				// Skip constant assignments in second pass, already processed
-				asmTokens.Next() // consume equals
-				asmTokens.Next() // consume value
+				skipToEndOfLine(asmTokens)
 				continue
 			} else if tokenPosition == 1 { // Label Identifier without colon
+				a.labelKey(t.Literal)
 				continue
 			}
-			return fmt.Errorf("[generateCode] unknown identifier '%s'", t.Literal)
+			return a.statementError(t, start, fmt.Errorf("[generateCode] unknown identifier '%s'", t.Literal))
 
 		case lexer.EndOfLineType:
 			tokenPosition = 0 // Reset position on new line
@@ -313,7 +437,15 @@ func (a *Assembler) generateCode(tokens []lexer.Token, segments []AssembledData)
 			continue
 		}
 	}
-	return nil
+	a.finishLine()
+	a.segmentRanges = nil
+	for _, segment := range segments {
+		a.segmentRanges = append(a.segmentRanges, DebugSegment{Start: segment.StartAddress, Length: segment.Data.Len()})
+	}
+	if err := a.checkConditionalsClosed(); err != nil {
+		return err
+	}
+	return a.checkScopesClosed()
 }
 
 func (a *Assembler) addressForAsterixOrgDirective(asmTokens *Tokens, finalizeSegment func()) error {
@@ -390,8 +522,34 @@ func (a *Assembler) processAssemblerDirective(asmTokens *Tokens, insertIntoMemor
 				if err != nil {
 					return err
 				}
-			case VarDirective: // Leave this to the preprocessor
-				a.skipDirectiveTokens(asmTokens)
+			case BasicUpstartDirective:
+				err := a.processBasicUpstartDirective(asmTokens, insertIntoMemory)
+				if err != nil {
+					return err
+				}
+			case VarDirective:
+				a.replayVarDirective(asmTokens)
+			case IfDirective, ElseIfDirective, ElseDirective, EndIfDirective, IfDefDirective, IfNDefDirective:
+				err := a.conditionalDirective(asmTokens, directiveID, false)
+				if err != nil {
+					return err
+				}
+			case ProcDirective, EndProcDirective, ScopeDirective, EndScopeDirective:
+				err := a.scopeDirective(asmTokens, directiveID, false)
+				if err != nil {
+					return err
+				}
+			case EncodingDirective:
+				err := a.encodingDirective(asmTokens)
+				if err != nil {
+					return err
+				}
+			case IncBinDirective, IncPrgDirective:
+				data, err := a.incbinDirective(asmTokens, directiveID == IncPrgDirective)
+				if err != nil {
+					return err
+				}
+				insertIntoMemory(data)
 			default:
 				return fmt.Errorf("[Assembler processAssemblerDirective] Unknown Directive %s", directiveName)
 			}
@@ -416,6 +574,7 @@ func (a *Assembler) generateCodeForOrgDirective(asmTokens *Tokens, updateCurrent
 }
 
 func (a *Assembler) generateInstructionCode(t lexer.Token, asmTokens *Tokens, insertIntoMemory func([]byte)) error {
+	index := asmTokens.Index()
 	addressingMode, err := a.parseAddressingMode(t.Literal, asmTokens, false)
 	if err != nil {
 		return err
@@ -424,10 +583,15 @@ func (a *Assembler) generateInstructionCode(t lexer.Token, asmTokens *Tokens, in
 	if !ok {
 		return fmt.Errorf("[Assembler Assemble] invalid addressing mode for instruction: %s %s (%d:%d)", t.Literal, addressingMode.AddressingMode, t.SourceLine, t.SourceColumn)
 	}
+	// The labels after this instruction were placed by the size the first pass gave it
+	if size := 1 + len(addressingMode.Operands); size != a.instructionSizes[index] {
+		return fmt.Errorf("[Assembler Assemble] %s is %d bytes, but the first pass sized it as %d; define the symbols its operand uses before it (line %d)", t.Literal, size, a.instructionSizes[index], t.SourceLine)
+	}
 
 	data := []byte{byte(instruction.Opcode)}
 	data = append(data, addressingMode.Operands...)
 	insertIntoMemory(data)
+	a.recordInstruction(instruction.Definition)
 	return nil
 }
 
@@ -447,7 +611,11 @@ parseLoop:
 		switch t.ID {
 		case lexer.EndOfLineType, lexer.EOFType:
 			break parseLoop
-		case lexer.HexLiteral, lexer.IntegerLiteral, MinusToken, PlusToken:
+		case lexer.HexLiteral, lexer.IntegerLiteral, lexer.FloatLiteral, lexer.StringLiteral, MinusToken, PlusToken, TildeToken, ExclamationToken, CaretToken, LeftParenthesis:
+			if t.ID == LeftParenthesis && !strings.HasSuffix(parsedAddressingMode, "#") {
+				parsedAddressingMode += t.Literal // An indirect addressing mode
+				break
+			}
 
 			peekToken := asmTokens.Peek()
 			if t.ID == MinusToken || t.ID == PlusToken && (isTerminatorToken(peekToken.ID) || peekToken.ID == t.ID) {
@@ -504,11 +672,42 @@ parseLoop:
 				break
 			}
 
+			functionCall := isFunctionCall(t, asmTokens.Peek())
+			callIndex := asmTokens.Index()
+			a.unresolved = false
 			evaluatedValue, err := a.EvaluateExpression(asmTokens, mnemonic, preprocess)
 			if err != nil {
 				return AddressingMode{}, err
 			}
 
+			if functionCall {
+				// Sized by its value, like a number, unless the first pass did not know the value of an
+				// address and sized it as a forward referenced label
+				if a.firstPass {
+					a.unsizedCalls[callIndex] = a.unresolved && !strings.HasSuffix(parsedAddressingMode, "#")
+				}
+				if a.unsizedCalls[callIndex] {
+					operandSizeMask, _, err := a.preprocessorLabelSizer(mnemonic)
+					if err != nil {
+						return AddressingMode{}, err
+					}
+					operandValues = append(operandValues, ReduceBytes(evaluatedValue, len(operandSizeMask)/2))
+					parsedAddressingMode += operandSizeMask
+					break
+				}
+				operandSizeMask, v, err := minimumOperandSize(false, evaluatedValue)
+				if err != nil {
+					return AddressingMode{}, err
+				}
+				operandValues = append(operandValues, v)
+				parsedAddressingMode += operandSizeMask
+				break
+			}
+
+			if _, _, err := minimumOperandSize(false, evaluatedValue); err != nil {
+				return AddressingMode{}, err
+			}
+
 			// Work out what the size mask should be for this label/constant
 			operandSizeMask, _, _ := a.LabelOrConstantIdentifier(mnemonic, identifier, preprocess)
 
@@ -623,6 +822,7 @@ func (a *Assembler) PlusMinusLabel(mnemonic string, t lexer.Token, asmTokens *To
 }
 
 func (a *Assembler) LabelOrConstantIdentifier(mnemonic, identifier string, preprocess bool) (sizeMask string, value any, err error) {
+	identifier = a.lookupSymbol(identifier)
 	if value, ok := a.constants[identifier]; ok {
 		operandSizeMask, v, err := minimumOperandSize(false, value)
 		if err != nil {
@@ -631,14 +831,20 @@ func (a *Assembler) LabelOrConstantIdentifier(mnemonic, identifier string, prepr
 		return operandSizeMask, v, nil
 	}
 	if address, ok := a.labels[identifier]; ok {
+		if mnemonic == "" { // An address in an expression that is not an operand
+			return twoByteOperand, ReduceBytes(address, 2), nil
+		}
 		return a.parseLabelOffset(mnemonic, address)
 	}
 	if preprocess {
+		a.unresolved = true
 		return a.preprocessorLabelSizer(mnemonic)
 	}
 	return "", nil, fmt.Errorf("undefined identifier: %s", identifier)
 }
 
+// EvaluateExpression evaluates the expression starting at the current token, converting a float result to
+// an integer.
 func (a *Assembler) EvaluateExpression(asmTokens *Tokens, mnemonic string, preprocess bool) (int64, error) {
 	// Parse the expression using Pratt parser
 	result, err := a.parseCurrentExpression(asmTokens, mnemonic, 0, preprocess)
@@ -646,7 +852,7 @@ func (a *Assembler) EvaluateExpression(asmTokens *Tokens, mnemonic string, prepr
 		return 0, err
 	}
 
-	return result, nil
+	return result.toInteger()
 }
 
 func (a *Assembler) parseLabelOffset(mnemonic string, address uint64) (string, any, error) {
@@ -719,41 +925,16 @@ func (a *Assembler) tokenAddressValue(t lexer.Token) (uint16, error) {
 }
 
 func (a *Assembler) processByteDirective(asmTokens *Tokens, insertIntoMemory func([]byte)) error {
+	values, err := a.expressionList(asmTokens, false)
+	if err != nil {
+		return fmt.Errorf("[processByteDirective] %w", err)
+	}
 	var bytes []byte
-
-	for {
-		t := asmTokens.Peek()
-		if isTerminatorToken(t.ID) {
-			break
-		}
-		asmTokens.Next() // Consume the token
-		if t.ID == CommaToken {
-			continue
-		}
-
-		switch t.ID {
-		case lexer.HexLiteral, lexer.IntegerLiteral:
-			value, err := toUint64(t.Value)
-			if err != nil {
-				return fmt.Errorf("[processByteDirective] invalid byte value: %w", err)
-			}
-			if value > 255 {
-				return fmt.Errorf("[processByteDirective] byte value %d exceeds 255", value)
-			}
-			bytes = append(bytes, byte(value))
-		case IdentifierToken:
-			if value, ok := a.constants[t.Literal]; ok {
-				if byteVal, ok := value.(uint8); ok {
-					bytes = append(bytes, byteVal)
-				} else {
-					return fmt.Errorf("[processByteDirective] variable %s is not a byte", t.Literal)
-				}
-			} else {
-				return fmt.Errorf("[processByteDirective] undefined variable: %s", t.Literal)
-			}
-		default:
-			return fmt.Errorf("[processByteDirective] unexpected token: %s", t.Literal)
+	for _, value := range values {
+		if value < -128 || value > 255 {
+			return fmt.Errorf("[processByteDirective] byte value %d is not -128-255", value)
 		}
+		bytes = append(bytes, byte(value))
 	}
 
 	if len(bytes) > 0 {
@@ -763,45 +944,17 @@ func (a *Assembler) processByteDirective(asmTokens *Tokens, insertIntoMemory fun
 }
 
 func (a *Assembler) processWordDirective(asmTokens *Tokens, insertIntoMemory func([]byte)) error {
+	values, err := a.expressionList(asmTokens, false)
+	if err != nil {
+		return fmt.Errorf("[processWordDirective] %w", err)
+	}
 	var bytes []byte
-
-	for {
-		t := asmTokens.Peek()
-		if isTerminatorToken(t.ID) {
-			break
-		}
-		asmTokens.Next() // Consume the token
-		if t.ID == CommaToken {
-			continue
-		}
-
-		switch t.ID {
-		case lexer.HexLiteral, lexer.IntegerLiteral:
-			value, err := toUint64(t.Value)
-			if err != nil {
-				return fmt.Errorf("[processWordDirective] invalid word value: %w", err)
-			}
-			if value > 65535 {
-				return fmt.Errorf("[processWordDirective] word value %d exceeds 65535", value)
-			}
-			// Store in little-endian format
-			bytes = append(bytes, byte(value&0xFF), byte((value>>8)&0xFF))
-		case IdentifierToken:
-			if address, ok := a.labels[t.Literal]; ok {
-				// Store address in little-endian format
-				bytes = append(bytes, byte(address&0xFF), byte((address>>8)&0xFF))
-			} else if value, ok := a.constants[t.Literal]; ok {
-				if wordVal, ok := value.(uint16); ok {
-					bytes = append(bytes, byte(wordVal&0xFF), byte((wordVal>>8)&0xFF))
-				} else {
-					return fmt.Errorf("[processWordDirective] variable %s is not a word", t.Literal)
-				}
-			} else {
-				return fmt.Errorf("[processWordDirective] undefined label/variable: %s", t.Literal)
-			}
-		default:
-			return fmt.Errorf("[processWordDirective] unexpected token: %s", t.Literal)
+	for _, value := range values {
+		if value < -32768 || value > 65535 {
+			return fmt.Errorf("[processWordDirective] word value %d is not -32768-65535", value)
 		}
+		// Store in little-endian format
+		bytes = append(bytes, byte(value&0xFF), byte((value>>8)&0xFF))
 	}
 
 	if len(bytes) > 0 {
@@ -810,6 +963,30 @@ func (a *Assembler) processWordDirective(asmTokens *Tokens, insertIntoMemory fun
 	return nil
 }
 
+// expressionList evaluates the values of a .BYTE or .WORD directive: expressions separated by commas, or
+// by spaces where that is not ambiguous. A string on its own gives a value for each of its characters.
+func (a *Assembler) expressionList(asmTokens *Tokens, preprocess bool) ([]int64, error) {
+	var values []int64
+	for !isTerminatorToken(asmTokens.Peek().ID) {
+		t := asmTokens.Next()
+		if t.ID == CommaToken {
+			continue
+		}
+		if str, ok := t.Value.(string); ok && t.ID == lexer.StringLiteral && a.getPrecedence(asmTokens.Peek().ID) == PRECEDENCE_LOWEST {
+			for _, b := range a.encodeText(str) {
+				values = append(values, int64(b))
+			}
+			continue
+		}
+		value, err := a.EvaluateExpression(asmTokens, "", preprocess)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
 func (a *Assembler) processTextDirective(asmTokens *Tokens, insertIntoMemory func([]byte)) error {
 	t := asmTokens.Next()
 	if t.ID != lexer.StringLiteral {
@@ -821,7 +998,7 @@ func (a *Assembler) processTextDirective(asmTokens *Tokens, insertIntoMemory fun
 		return fmt.Errorf("[processTextDirective] invalid string value")
 	}
 
-	bytes := []byte(str)
+	bytes := a.encodeText(str)
 	insertIntoMemory(bytes)
 	return nil
 }
@@ -838,7 +1015,7 @@ func (a *Assembler) processAsciizDirective(asmTokens *Tokens, insertIntoMemory f
 	}
 
 	// Add null terminator to the string
-	bytes := []byte(str)
+	bytes := a.encodeText(str)
 	bytes = append(bytes, 0) // Add null terminator
 	insertIntoMemory(bytes)
 	return nil
@@ -915,17 +1092,10 @@ func (a *Assembler) processDataSpaceDirective(asmTokens *Tokens, insertIntoMemor
 	return nil
 }
 
-func (a *Assembler) skipDirectiveTokens(asmTokens *Tokens) {
-	// Skip tokens until end of line
-	for {
-		t := asmTokens.Next()
-		if isTerminatorToken(t.ID) {
-			break
-		}
-	}
-}
-
+// processVarDirective assigns a .VAR in the first pass. Unlike a constant, a .VAR can be assigned again,
+// and the value it has at each assignment is recorded for the second pass to replay.
 func (a *Assembler) processVarDirective(asmTokens *Tokens) error {
+	index := asmTokens.Index()
 	t := asmTokens.Next()
 	if t.ID != IdentifierToken {
 		return fmt.Errorf("[Assembler processVarDirective] expected identifier")
@@ -933,15 +1103,32 @@ func (a *Assembler) processVarDirective(asmTokens *Tokens) error {
 	nextToken := asmTokens.Peek()
 
 	if nextToken.ID == EqualsSymbolToken {
-		err := a.processConstantAssignment(t, asmTokens)
-		if err != nil {
-			return err
+		key := a.lookupSymbol(t.Literal)
+		if a.variables[key] {
+			if err := a.assignConstant(key, asmTokens); err != nil {
+				return err
+			}
+		} else {
+			if err := a.processConstantAssignment(t, asmTokens); err != nil {
+				return err
+			}
+			key = a.symbolKey(t.Literal)
 		}
+		a.variables[key] = true
+		a.variableValues[index] = assignment{key: key, value: a.constants[key]}
 		return nil
 	}
 	return fmt.Errorf("[Assembler] Invalid Format for Var %s", t.Literal)
 }
 
+// replayVarDirective gives a .VAR the value it was assigned at this point in the first pass.
+func (a *Assembler) replayVarDirective(asmTokens *Tokens) {
+	if assigned, found := a.variableValues[asmTokens.Index()]; found {
+		a.constants[assigned.key] = assigned.value
+	}
+	skipToEndOfLine(asmTokens)
+}
+
 func (a *Assembler) identifierTokenCreator(identifier string) lexer.Token {
 	// Program Counter
 	// if identifier == "*" {
@@ -1,12 +1,15 @@
 package assembler_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/assembler/cbmbasic"
 	"github.com/jrsteele09/go-6502-emulator/cpu"
 	"github.com/jrsteele09/go-6502-emulator/debugger"
 	"github.com/jrsteele09/go-6502-emulator/memory"
@@ -42,6 +45,186 @@ func TestAssemble_TestIncludeFile(t *testing.T) {
 	disassembleAndCompare(t, segments, false)
 }
 
+func TestAssemble_Listing(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewOSFileResolver("./test_assembly_files/TestListing")
+
+	_, err := asm.AssembleFile("main.asm", resolver)
+	require.NoError(t, err, "AssembleFile failed")
+
+	var listing bytes.Buffer
+	require.NoError(t, asm.WriteListing(&listing, assembler.ListingOptions{}))
+	expected, err := os.ReadFile("./test_assembly_files/TestListing/main.lst")
+	require.NoError(t, err)
+	require.Equal(t, string(expected), listing.String())
+}
+
+func TestAssemble_Symbols(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewMemoryFileResolver(map[string]string{
+		"main.asm": "*=$C000\nstart: JSR player::update\n.include \"lib.asm\"\nOFFSET = -2\n",
+		"lib.asm":  "BORDER = $D020\n.PROC player\nupdate: INC BORDER\n  RTS\n.ENDPROC\n",
+	})
+
+	_, err := asm.AssembleFile("main.asm", resolver)
+	require.NoError(t, err, "AssembleFile failed")
+
+	require.Equal(t, []assembler.Symbol{
+		{Name: "BORDER", Kind: assembler.ConstantSymbol, Value: 0xD020, File: "lib.asm", Line: 1},
+		{Name: "OFFSET", Kind: assembler.ConstantSymbol, Value: -2, File: "main.asm", Line: 4},
+		{Name: "player", Kind: assembler.LabelSymbol, Value: 0xC003, File: "lib.asm", Line: 2},
+		{Name: "player::update", Scope: "player", Kind: assembler.LabelSymbol, Value: 0xC003, File: "lib.asm", Line: 3},
+		{Name: "start", Kind: assembler.LabelSymbol, Value: 0xC000, File: "main.asm", Line: 2},
+	}, asm.Symbols())
+
+	for format, expected := range map[assembler.SymbolFormat]string{
+		assembler.VICESymbols: "al C:c003 .player\nal C:c003 .player__update\nal C:c000 .start\n",
+		assembler.PlainSymbols: "BORDER = $D020 ; constant\nOFFSET = -2 ; constant\nplayer = $C003 ; label\n" +
+			"player::update = $C003 ; label\nstart = $C000 ; label\n",
+	} {
+		var symbols bytes.Buffer
+		skipped, err := asm.WriteSymbols(&symbols, format)
+		require.NoError(t, err)
+		require.Empty(t, skipped)
+		require.Equal(t, expected, symbols.String(), "format %s", format)
+	}
+
+	var document bytes.Buffer
+	_, err = asm.WriteSymbols(&document, assembler.JSONSymbols)
+	require.NoError(t, err)
+	var decoded struct{ Symbols []assembler.Symbol }
+	require.NoError(t, json.Unmarshal(document.Bytes(), &decoded))
+	require.Equal(t, asm.Symbols(), decoded.Symbols)
+
+	_, err = asm.WriteSymbols(&document, "sym")
+	require.Error(t, err)
+
+	read, err := assembler.ReadSymbols(bytes.NewReader(document.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, asm.Symbols(), read)
+	var plain bytes.Buffer
+	_, err = asm.WriteSymbols(&plain, assembler.PlainSymbols)
+	require.NoError(t, err)
+	read, err = assembler.ReadSymbols(&plain)
+	require.NoError(t, err)
+	require.Equal(t, assembler.Symbol{Name: "player::update", Scope: "player", Kind: assembler.LabelSymbol, Value: 0xC003}, read[3])
+	require.Equal(t, int64(-2), read[1].Value)
+	_, err = assembler.ReadSymbols(strings.NewReader("start = $C000\n"))
+	require.ErrorContains(t, err, "expected name = value ; label or constant (line 1)")
+
+	// VICE names have only letters, digits and underscores; a name VICE can parse keeps it over one changed to it
+	source := "*=$C000\n.MACRO wait\nskip: NOP\n.ENDM\nmain:\n@loop: wait\n.SCOPE\nx: NOP\n.ENDSCOPE\nskip_1: NOP\n"
+	_, err = asm.Assemble(strings.NewReader(source), "vice.asm")
+	require.NoError(t, err)
+	var vice bytes.Buffer
+	skipped, err := asm.WriteSymbols(&vice, assembler.VICESymbols)
+	require.NoError(t, err)
+	require.Equal(t, "al C:c001 ._1__x\nal C:c000 .main\nal C:c000 .main_loop\nal C:c002 .skip_1\n", vice.String())
+	require.Equal(t, []string{"skip@1"}, skipped)
+}
+
+func TestAssemble_DebugInfo(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewMemoryFileResolver(map[string]string{
+		"main.asm": "*=$C000\nstart: JSR player::update\n.include \"lib.asm\"\nmsg: .BYTE 1, 2\n  bump $FB\n.REPT 2\n  NOP\n.ENDR\n",
+		"lib.asm":  ".MACRO bump addr\n  INC addr\n.ENDM\n.PROC player\nupdate: INC $D020\n  RTS\n.ENDPROC\n",
+	})
+
+	_, err := asm.AssembleFile("main.asm", resolver)
+	require.NoError(t, err, "AssembleFile failed")
+
+	info := asm.DebugInfo()
+	require.Equal(t, &assembler.DebugInfo{
+		Version:  assembler.DebugInfoVersion,
+		Segments: []assembler.DebugSegment{{Start: 0xC000, Length: 13}},
+		Lines: []assembler.DebugLine{
+			{Address: 0xC000, Length: 3, File: "main.asm", Line: 2, Column: 1, Kind: assembler.CodeKind},
+			{Address: 0xC003, Length: 3, File: "lib.asm", Line: 5, Column: 1, Kind: assembler.CodeKind, Scope: "player"},
+			{Address: 0xC006, Length: 1, File: "lib.asm", Line: 6, Column: 3, Kind: assembler.CodeKind, Scope: "player"},
+			{Address: 0xC007, Length: 2, File: "main.asm", Line: 4, Column: 1, Kind: assembler.DataKind},
+			{Address: 0xC009, Length: 2, File: "lib.asm", Line: 2, Column: 3, Kind: assembler.CodeKind, Expansion: 1},
+			{Address: 0xC00B, Length: 1, File: "main.asm", Line: 7, Column: 3, Kind: assembler.CodeKind},
+			{Address: 0xC00C, Length: 1, File: "main.asm", Line: 7, Column: 3, Kind: assembler.CodeKind},
+		},
+		Scopes:     []assembler.DebugScope{{Name: "player", Start: 0xC003, End: 0xC007, File: "lib.asm", Line: 4}},
+		Expansions: []assembler.DebugExpansion{{ID: 1, Macro: "bump", File: "main.asm", Line: 5}},
+	}, info)
+
+	var written bytes.Buffer
+	require.NoError(t, info.Write(&written))
+	read, err := assembler.ReadDebugInfo(&written)
+	require.NoError(t, err)
+	require.Equal(t, info, read)
+
+	line, found := read.LineAt(0xC005)
+	require.True(t, found)
+	require.Equal(t, uint(5), line.Line)
+	_, found = read.LineAt(0xC00D)
+	require.False(t, found)
+	require.Equal(t, []uint16{0xC00B, 0xC00C}, read.Addresses("main.asm", 7))
+	require.Equal(t, []string{"player"}, read.ScopesAt(0xC006))
+	require.Empty(t, read.ScopesAt(0xC007))
+
+	_, err = assembler.ReadDebugInfo(strings.NewReader(`{"version": 2}`))
+	require.ErrorContains(t, err, "unsupported debug info version 2")
+}
+
+func TestAssemble_IncBin(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	files := map[string]string{
+		"data.bin": "\x10\x11\x12\x13\x14\x15",
+		"tune.prg": "\x00\x10\xA9\x01\x60",
+	}
+
+	for source, expected := range map[string][]byte{
+		".INCBIN \"data.bin\"\n":                       {0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0xEA},
+		".INCBIN \"data.bin\", 4\n":                    {0x14, 0x15, 0xEA},
+		".INCBIN \"data.bin\", 1, 2\n":                 {0x11, 0x12, 0xEA},
+		"SKIP = 2\n.INCBIN \"data.bin\", SKIP * 2\n":   {0x14, 0x15, 0xEA},
+		".INCBIN \"data.bin\", 6\n":                    {0xEA},
+		".INCPRG \"tune.prg\"\n":                       {0xA9, 0x01, 0x60, 0xEA},
+		".INCPRG \"tune.prg\", 1, 1\n":                 {0x01, 0xEA},
+		".REPT 2\n.INCBIN \"data.bin\", 0, 1\n.ENDR\n": {0x10, 0x10, 0xEA},
+	} {
+		// The NOP after the included bytes is assembled at the address the first pass gave it
+		files["main.asm"] = "*=$C000\n" + source + "  NOP\nend:\n  JMP end\n"
+		segments, err := asm.AssembleFile("main.asm", utils.NewMemoryFileResolver(files))
+		require.NoError(t, err, source)
+		require.Len(t, segments, 1, source)
+		end := uint16(0xC000 + len(expected))
+		expected = append(expected, 0x4C, byte(end), byte(end>>8))
+		require.Equal(t, expected, segments[0].Data.Bytes(), source)
+	}
+}
+
+func TestAssemble_IncBinErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	files := map[string]string{"data.bin": "\x10\x11\x12\x13", "short.prg": "\x00"}
+
+	for source, expected := range map[string]string{
+		".INCBIN data.bin\n":                     "expected a file name after .INCBIN (line 2)",
+		".INCBIN \"missing.bin\"\n":              "missing.bin",
+		".INCBIN \"data.bin\", 5\n":              "offset 5 is outside 'data.bin', which is 4 bytes (line 2)",
+		".INCBIN \"data.bin\", 2, 3\n":           "3 bytes from offset 2 runs past the end of 'data.bin', which is 4 bytes (line 2)",
+		".INCBIN \"data.bin\", 0, 1, 2\n":        "unexpected ',' after .INCBIN (line 2)",
+		".INCPRG \"short.prg\"\n":                "'short.prg' is too short for a PRG file (line 2)",
+		".INCBIN \"data.bin\", skip\nskip = 1\n": "undefined identifier: skip",
+		".INCBIN \"data.bin\", 0, end-*\nend:\n": "undefined identifier: end",
+	} {
+		files["main.asm"] = "*=$C000\n" + source
+		_, err := asm.AssembleFile("main.asm", utils.NewMemoryFileResolver(files))
+		require.ErrorContains(t, err, expected, source)
+	}
+
+	_, err := asm.Assemble(strings.NewReader("*=$C000\n.INCBIN \"data.bin\"\n"), "main.asm")
+	require.ErrorContains(t, err, "cannot include 'data.bin' without a file resolver")
+}
+
 func TestAssemble_Snake(t *testing.T) {
 	// SETUP
 	_, cpu := createHardware()
@@ -223,3 +406,367 @@ func compareDisassembly(t *testing.T, expected, actual string) {
 		require.Equal(t, expectedArray[i], actualArray[i], "Mismatch at line %d: expected %q, got %q", i+1, expectedArray[i], actualArray[i])
 	}
 }
+
+func TestAssemble_BasicUpstart(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	segments, err := asm.Assemble(strings.NewReader("*=$0801\n.BASICUPSTART start\nstart:\n  INC $D020\n  JMP start\n"), "upstart.asm")
+
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	require.Equal(t, uint16(cbmbasic.BASICStart), segments[0].StartAddress)
+	data := segments[0].Data.Bytes()
+	lines, err := cbmbasic.DetokenizeBASIC(data, cbmbasic.BASICStart)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, "10 SYS 2062", lines[0].String(), "a forward reference reserves five digits")
+	target, _, ok := cbmbasic.BASICEntryPoint(lines)
+	require.True(t, ok)
+	require.Equal(t, uint16(0x080E), target)
+	require.Equal(t, []byte{0xEE, 0x20, 0xD0, 0x4C, 0x0E, 0x08}, data[target-cbmbasic.BASICStart:])
+}
+
+func TestAssemble_BasicUpstartToAddress(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	segments, err := asm.Assemble(strings.NewReader("*=$0801\n.BASICUPSTART $C000\n"), "upstart.asm")
+
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0C, 0x08, 0x0A, 0x00, 0x9E, '4', '9', '1', '5', '2', 0x00, 0x00, 0x00}, segments[0].Data.Bytes())
+}
+
+func TestPrependBASICUpstart(t *testing.T) {
+	program := assembler.AssembledData{StartAddress: 0x0810}
+	program.Data.Write([]byte{0x60})
+
+	segments, err := assembler.PrependBASICUpstart([]assembler.AssembledData{program})
+
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	require.Equal(t, uint16(cbmbasic.BASICStart), segments[0].StartAddress)
+	data := segments[0].Data.Bytes()
+	require.Equal(t, []byte{0x0B, 0x08, 0x0A, 0x00, 0x9E, '2', '0', '6', '4', 0x00, 0x00, 0x00}, data[:12])
+	require.Equal(t, byte(0x60), data[0x0F], "the gap to the program is zero filled")
+
+	program.StartAddress = 0x0805
+	_, err = assembler.PrependBASICUpstart([]assembler.AssembledData{program})
+	require.Error(t, err)
+}
+
+func TestAssemble_Macros(t *testing.T) {
+	// SETUP
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewOSFileResolver("./test_assembly_files/TestMacros")
+
+	// ASSEMBLE
+	segments, err := asm.AssembleFile("main.asm", resolver)
+
+	// ASSERT ASSEMBLED RESULTS
+	require.NoError(t, err, "AssembleFile failed")
+	require.Len(t, segments, 1, "Expected exactly one segment")
+	require.Equal(t, uint16(0xC000), segments[0].StartAddress, "Expected start address $C000")
+
+	// ASSERT DISASSEMBLY
+	disassembleAndCompare(t, segments, false)
+}
+
+func TestAssemble_MacroErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	source := "*=$C000\n.MACRO store value\n  LDA #value\n  STA undefined\n.ENDM\n  store 1\n"
+	_, err := asm.Assemble(strings.NewReader(source), "macro.asm")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "in macro store at macro.asm:4, invoked at macro.asm:6")
+
+	_, err = asm.Assemble(strings.NewReader(".MACRO store value\n  LDA #value\n.ENDM\n  store\n"), "macro.asm")
+	require.ErrorContains(t, err, "missing argument 'value' to macro 'store' (line 4)")
+
+	_, err = asm.Assemble(strings.NewReader(".MACRO forever\n  NOP\n  forever\n.ENDM\n  forever\n"), "macro.asm")
+	require.ErrorContains(t, err, "in macro forever at macro.asm:3, invoked at macro.asm:5: [expandMacros] macro forever invokes itself")
+
+	// A macro cannot stop its recursion with .IF, as the body is expanded before the condition is assembled
+	source = ".MACRO count n\n  .BYTE n\n  .IF n > 0\n    count n-1\n  .ENDIF\n.ENDM\n  count 3\n"
+	_, err = asm.Assemble(strings.NewReader(source), "macro.asm")
+	require.ErrorContains(t, err, "recursive macros are not supported")
+
+	source = ".MACRO ping\n  pong\n.ENDM\n.MACRO pong\n  ping\n.ENDM\n  ping\n"
+	_, err = asm.Assemble(strings.NewReader(source), "macro.asm")
+	require.ErrorContains(t, err, "invoked from macro ping at macro.asm:2, invoked at macro.asm:7: [expandMacros] macro ping invokes itself")
+
+	resolver := utils.NewMemoryFileResolver(map[string]string{
+		"main.asm":   "*=$C000\n.include \"macros.asm\"\n  store 1\n",
+		"macros.asm": ".MACRO store value\n  LDA #value\n  STA undefined\n.ENDM\n",
+	})
+	_, err = asm.AssembleFile("main.asm", resolver)
+	require.ErrorContains(t, err, "in macro store at macros.asm:3, invoked at main.asm:3")
+
+	_, err = asm.Assemble(strings.NewReader(".MACRO open\n  NOP\n"), "macro.asm")
+	require.ErrorContains(t, err, "missing .ENDM for macro 'open' (line 1)")
+}
+
+func TestAssemble_ConditionalAssembly(t *testing.T) {
+	// SETUP
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewOSFileResolver("./test_assembly_files/TestConditionalAssembly")
+
+	// ASSEMBLE
+	segments, err := asm.AssembleFile("main.asm", resolver)
+
+	// ASSERT ASSEMBLED RESULTS
+	require.NoError(t, err, "AssembleFile failed")
+	require.Len(t, segments, 1, "Expected exactly one segment")
+	require.Equal(t, uint16(0xC000), segments[0].StartAddress, "Expected start address $C000")
+
+	// ASSERT DISASSEMBLY
+	disassembleAndCompare(t, segments, false)
+}
+
+func TestAssemble_ConditionalErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for source, expected := range map[string]string{
+		"*=$C000\n.IF 1\n  NOP\n":                     "missing .ENDIF for .IF (line 2)",
+		"*=$C000\n.IF 0\n  NOP\n":                     "missing .ENDIF for .IF (line 2)",
+		"*=$C000\n  NOP\n.ELSE\n":                     ".ELSE without .IF (line 3)",
+		"*=$C000\n.IF 1\n.ELSE\n.ELSE\n.ENDIF\n":      "duplicate .ELSE (line 4)",
+		"*=$C000\n.IF MISSING\n  NOP\n.ENDIF\n":       "undefined identifier: MISSING",
+		"*=$C000\n.IF later\n  NOP\n.ENDIF\nlater:\n": "undefined identifier: later",
+	} {
+		_, err := asm.Assemble(strings.NewReader(source), "conditional.asm")
+		require.ErrorContains(t, err, expected, source)
+	}
+}
+
+func TestAssemble_Loops(t *testing.T) {
+	// SETUP
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewOSFileResolver("./test_assembly_files/TestLoops")
+
+	// ASSEMBLE
+	segments, err := asm.AssembleFile("main.asm", resolver)
+
+	// ASSERT ASSEMBLED RESULTS
+	require.NoError(t, err, "AssembleFile failed")
+	require.Len(t, segments, 1, "Expected exactly one segment")
+	require.Equal(t, uint16(0xC000), segments[0].StartAddress, "Expected start address $C000")
+
+	// ASSERT DISASSEMBLY
+	disassembleAndCompare(t, segments, false)
+}
+
+func TestAssemble_LoopErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for source, expected := range map[string]string{
+		"*=$C000\n.REPT 2\n  NOP\n":                           "missing .ENDR for .REPT (line 2)",
+		"*=$C000\n.FOR i = 0 TO 1\n  NOP\n.ENDR\n":            ".ENDR does not close the .FOR at line 2 (line 4)",
+		"*=$C000\n  NOP\n.ENDFOR\n":                           ".ENDFOR without a loop (line 3)",
+		"*=$C000\n.REPT later\n  NOP\n.ENDR\nlater:\n":        "undefined identifier: later",
+		"*=$C000\n.FOR i = 0 TO 3 STEP 0\n  NOP\n.ENDFOR\n":   "STEP is 0 (line 2)",
+		"*=$C000\n.FOR i = 0 3\n  NOP\n.ENDFOR\n":             "expected TO",
+		"*=$C000\n.WHILE 1\n  NOP\n.ENDW\n":                   ".WHILE loop has more than 65536 iterations (line 2)",
+		"*=$C000\n.REPT 2\nlabel:  NOP\n.ENDR\n  JMP label\n": "undefined identifier: label",
+	} {
+		_, err := asm.Assemble(strings.NewReader(source), "loop.asm")
+		require.ErrorContains(t, err, expected, source)
+	}
+}
+
+func TestAssemble_Scopes(t *testing.T) {
+	// SETUP
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+	resolver := utils.NewOSFileResolver("./test_assembly_files/TestScopes")
+
+	// ASSEMBLE
+	segments, err := asm.AssembleFile("main.asm", resolver)
+
+	// ASSERT ASSEMBLED RESULTS
+	require.NoError(t, err, "AssembleFile failed")
+	require.Len(t, segments, 1, "Expected exactly one segment")
+	require.Equal(t, uint16(0xC000), segments[0].StartAddress, "Expected start address $C000")
+
+	// ASSERT DISASSEMBLY
+	disassembleAndCompare(t, segments, false)
+}
+
+func TestAssemble_ScopeErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for source, expected := range map[string]string{
+		"*=$C000\n.PROC main\n  NOP\n":                                                          "missing .ENDPROC for .PROC (line 2)",
+		"*=$C000\n.SCOPE\n  NOP\n.ENDPROC\n":                                                    ".ENDPROC does not close the .SCOPE at line 2 (line 4)",
+		"*=$C000\n  NOP\n.ENDSCOPE\n":                                                           ".ENDSCOPE without .PROC or .SCOPE (line 3)",
+		"*=$C000\n.PROC\n.ENDPROC\n":                                                            "expected a name after .PROC (line 2)",
+		"*=$C000\n.PROC a\ninner: NOP\n.ENDPROC\n  JMP inner\n":                                 "undefined identifier: inner",
+		"*=$C000\nfirst: NOP\n@loop: NOP\nsecond: JMP @loop\n":                                  "undefined identifier: second@loop",
+		"*=$C000\n.SCOPE s\nx: NOP\nx: NOP\n.ENDSCOPE\n":                                        "duplicate label 's::x' already defined",
+		"*=$C000\nptr = $FB\n.PROC foo\n  LDA ptr\nptr = $FD\n.ENDPROC\n":                       "'foo::ptr' is defined after 'ptr' was used in its scope to refer to 'ptr'; define it before that use or rename it (line 5)",
+		"*=$C000\nptr: NOP\n.SCOPE s\n.SCOPE t\n  JMP ptr\n.ENDSCOPE\nt::ptr: NOP\n.ENDSCOPE\n": "'s::t::ptr' is defined after 'ptr'",
+	} {
+		_, err := asm.Assemble(strings.NewReader(source), "scope.asm")
+		require.ErrorContains(t, err, expected, source)
+	}
+}
+
+func TestAssemble_CheapLocalsAroundGeneratedLabels(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	// The labels a macro expansion or loop copy defines do not start a new set of cheap locals
+	for source, expected := range map[string][]byte{
+		"*=$C000\n.MACRO wait\n  BNE skip\n  NOP\nskip:\n.ENDM\nmain:  NOP\n@loop: wait\n  JMP @loop\n": {0xEA, 0xD0, 0x01, 0xEA, 0x4C, 0x01, 0xC0},
+		"*=$C000\ndraw:\n@top:  NOP\n.REPT 2\nline:  NOP\n.ENDR\n  JMP @top\n":                          {0xEA, 0xEA, 0xEA, 0x4C, 0x00, 0xC0},
+		"*=$C000\nmain:  LDX #2\n.next: DEX\n  BNE .next\n":                                             {0xA2, 0x02, 0xCA, 0xD0, 0xFD},
+	} {
+		segments, err := asm.Assemble(strings.NewReader(source), "locals.asm")
+		require.NoError(t, err, source)
+		require.Equal(t, expected, segments[0].Data.Bytes(), source)
+	}
+}
+
+func TestAssemble_Expressions(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for expression, expected := range map[string][]byte{
+		"$F0 & $3C | 1":             {0x31},
+		"$F0 ^ $FF":                 {0x0F},
+		"~$0F":                      {0xF0},
+		"1 << 4 + 1":                {0x20}, // Shifts bind less tightly than sums
+		"$80 >> 3":                  {0x10},
+		"17 % 5, 17 %10":            {2, 7}, // %10 after an operand is a remainder, not binary
+		"2 + 3 * 4 - 10 / 5":        {12},
+		"3 < 4, 3 > 4, 4 <= 4":      {1, 0, 1},
+		"4 >= 5, 2 == 2, 2 != 2":    {0, 1, 0},
+		"1 && 0, 1 || 0, !0, !7":    {0, 1, 1, 0},
+		"1 | 2 == 2":                {1}, // Equality binds more tightly than bitwise operators
+		"<ADDR, >ADDR, ^ADDR":       {0x56, 0x34, 0x12},
+		"<ADDR + 1, >(ADDR + $100)": {0x57, 0x35}, // < and > apply to the sum that follows
+		"<ADDR | 1":                 {0x57},
+		"1 ? 2 : 3, 0 ? 2 : 3":      {2, 3},
+		"0 ? 1 : 0 ? 2 : 3":         {3},
+		"2 * (3 + 4)":               {14},
+		"-1, -(2 + 3)":              {0xFF, 0xFB},
+	} {
+		segments, err := asm.Assemble(strings.NewReader("ADDR = $123456\n*=$C000\n.BYTE "+expression+"\n"), "expressions.asm")
+		require.NoError(t, err, expression)
+		require.Equal(t, expected, segments[0].Data.Bytes(), expression)
+	}
+
+	segments, err := asm.Assemble(strings.NewReader("*=$C000\ntable:\n.WORD table, ~0\n  LDA #<table\n  LDX #(2 + 3) * 2\n  AND #~$80 & $FF\n"), "expressions.asm")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0xC0, 0xFF, 0xFF, 0xA9, 0x00, 0xA2, 0x0A, 0x29, 0x7F}, segments[0].Data.Bytes())
+}
+
+func TestAssemble_ExpressionErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for source, expected := range map[string]string{
+		"*=$C000\n.BYTE 1 % 0\n":      "division by zero",
+		"*=$C000\n.BYTE 1 << 64\n":    "shift count 64 is not 0-63",
+		"*=$C000\n.BYTE 1 ? 2\n":      "expected ':' in conditional expression",
+		"*=$C000\n.BYTE $100\n":       "byte value 256 is not -128-255",
+		"*=$C000\n.WORD $10000\n":     "word value 65536 is not -32768-65535",
+		"*=$C000\n.BYTE 1, MISSING\n": "undefined identifier: MISSING",
+	} {
+		_, err := asm.Assemble(strings.NewReader(source), "expressions.asm")
+		require.ErrorContains(t, err, expected, source)
+	}
+}
+
+func TestAssemble_Functions(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for expression, expected := range map[string][]byte{
+		"'A', 'a' + 1":                  {0x41, 0x62},
+		"\"Hi\", 0":                     {0x48, 0x69, 0x00}, // A string on its own gives its characters
+		"lo(ADDR), hi(ADDR)":            {0x34, 0x12},
+		"round(127 * sin(PI / 2))":      {127},
+		"round(100 * cos(PI)) & $FF":    {0x9C},
+		"round(2.5), floor(2.7), 7 / 2": {3, 2, 3}, // Integer division stays integer
+		"floor(-0.5), 2.9, -2.9":        {0xFF, 2, 0xFE},
+		"min(3, 1, 2), max(3, 1, 2)":    {1, 3},
+		"abs(-5), abs(-1.5) * 2":        {5, 3},
+		"len(\"hello\")":                {5},
+		"defined(ADDR), defined(LATER)": {1, 0}, // Only symbols defined above count
+		"PI * 10":                       {31},
+		"LO(ADDR)":                      {0x34},
+	} {
+		segments, err := asm.Assemble(strings.NewReader("ADDR = $1234\nPI = 3.14159\n*=$C000\n.BYTE "+expression+"\nLATER = 1\n"), "functions.asm")
+		require.NoError(t, err, expression)
+		require.Equal(t, expected, segments[0].Data.Bytes(), expression)
+	}
+
+	segments, err := asm.Assemble(strings.NewReader("*=$C000\n  LDA #'A'\n  LDX #lo(table)\n  LDY hi(table)\n  .WORD max(table, $C100)\ntable:\n"), "functions.asm")
+	require.NoError(t, err)
+	// hi(table) is absolute, as the first pass sizes a call on a label defined later like the label
+	require.Equal(t, []byte{0xA9, 0x41, 0xA2, 0x09, 0xAC, 0xC0, 0x00, 0x00, 0xC1}, segments[0].Data.Bytes())
+
+	// A call on a label defined later, zero page or not, is assembled at the size the first pass gave it
+	segments, err = asm.Assemble(strings.NewReader("*=$0010\n  LDA abs(fwd)\n  LDA max(table, 0)\n  LDX #lo(fwd)\nfwd:  NOP\ntable:\n"), "functions.asm")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xAD, 0x18, 0x00, 0xAD, 0x19, 0x00, 0xA2, 0x18, 0xEA}, segments[0].Data.Bytes())
+
+	_, err = asm.Assemble(strings.NewReader("*=$0010\n  LDA LATER\nLATER = $20\n"), "functions.asm")
+	require.ErrorContains(t, err, "LDA is 2 bytes, but the first pass sized it as 3")
+}
+
+func TestAssemble_Encoding(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	source := `*=$C000
+	.TEXT "Hi!"
+	.ENCODING "petscii"
+	.TEXT "Hi!"
+	.ASCIIZ "ok"
+	.BYTE "Az", 'a'
+	LDA #'Z'
+	.ENCODING "ascii"
+	.BYTE 'a'
+`
+	segments, err := asm.Assemble(strings.NewReader(source), "encoding.asm")
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x48, 0x69, 0x21, // ASCII
+		0xC8, 0x49, 0x21, // PETSCII: capitals shifted, lower case unshifted
+		0x4F, 0x4B, 0x00,
+		0xC1, 0x5A, 0x41,
+		0xA9, 0xDA,
+		0x61,
+	}, segments[0].Data.Bytes())
+}
+
+func TestAssemble_FunctionErrors(t *testing.T) {
+	_, cpu := createHardware()
+	asm := assembler.New(cpu.OpCodes())
+
+	for source, expected := range map[string]string{
+		"*=$C000\n.BYTE min(1)\n":                                      "min() takes 2 or more arguments, not 1",
+		"*=$C000\n.BYTE sin(1, 2)\n":                                   "sin() takes 1 arguments, not 2",
+		"*=$C000\n.BYTE len(3)\n":                                      "len() expects a string",
+		"*=$C000\n.BYTE defined(1)\n":                                  "defined() expects a symbol",
+		"*=$C000\n.BYTE lo(1\n":                                        "expected ')' after the arguments of lo()",
+		"*=$C000\n.BYTE 'AB' + 1\n":                                    "only a single character can be used",
+		"*=$C000\n.BYTE 1.5 * 200\n":                                   "byte value 300 is not -128-255",
+		"*=$C000\n.BYTE 10.0 * 1000000000 * 1000000000 * 1000000000\n": "out of range for an integer",
+		"*=$C000\n.BYTE 1.0 / 0\n":                                     "division by zero",
+		"*=$C000\n.ENCODING \"ebcdic\"\n":                              "unknown encoding 'ebcdic'",
+		"*=$C000\n.ENCODING ascii\n":                                   "expected an encoding name after .ENCODING",
+	} {
+		_, err := asm.Assemble(strings.NewReader(source), "functions.asm")
+		require.ErrorContains(t, err, expected, source)
+	}
+}
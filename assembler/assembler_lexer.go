@@ -27,6 +27,23 @@ const (
 	SemiColonToken
 	GreaterThanToken
 	LessThanToken
+	ColonToken
+	AtToken
+	AmpersandToken
+	PipeToken
+	CaretToken
+	TildeToken
+	ExclamationToken
+	PercentToken
+	QuestionToken
+	ShiftLeftToken
+	ShiftRightToken
+	EqualToken
+	NotEqualToken
+	LessOrEqualToken
+	GreaterOrEqualToken
+	LogicalAndToken
+	LogicalOrToken
 )
 
 // KeywordTokens defines keyword to token mappings
@@ -38,7 +55,17 @@ var prefixTokenizers = map[string]lexer.TokenizerFunc{
 	"%": lexer.BinaryTokenizer,
 }
 
-var OperatorTokens = map[string]lexer.TokenIdentifier{}
+// OperatorTokens defines operators of more than one rune to token mappings
+var OperatorTokens = map[string]lexer.TokenIdentifier{
+	"<<": ShiftLeftToken,
+	">>": ShiftRightToken,
+	"==": EqualToken,
+	"!=": NotEqualToken,
+	"<=": LessOrEqualToken,
+	">=": GreaterOrEqualToken,
+	"&&": LogicalAndToken,
+	"||": LogicalOrToken,
+}
 
 // SymbolTokens defines single delimeter runes to token mappings
 var SymbolTokens = map[rune]lexer.TokenIdentifier{
@@ -54,6 +81,15 @@ var SymbolTokens = map[rune]lexer.TokenIdentifier{
 	'/': DivideSymbolToken,
 	'>': GreaterThanToken,
 	'<': LessThanToken,
+	':': ColonToken,
+	'@': AtToken,
+	'&': AmpersandToken,
+	'|': PipeToken,
+	'^': CaretToken,
+	'~': TildeToken,
+	'!': ExclamationToken,
+	'%': PercentToken,
+	'?': QuestionToken,
 }
 
 // comments defines comment syntax mappings
@@ -87,25 +123,30 @@ func NewAssemblerLexer(resolver utils.FileResolver) *AssemblerLexer {
 // SourceLine and SourceColumn as they appear in their original files.
 // It tokenizes line-by-line so that line numbers remain accurate in the produced tokens.
 func (p *AssemblerLexer) Tokens(cfg *lexer.LanguageConfig, input io.Reader, filename string) ([]lexer.Token, error) {
+	tokens, _, err := p.tokensWithOrigins(cfg, input, filename)
+	return tokens, err
+}
+
+// tokensWithOrigins tokenizes the input like Tokens, and also returns the file each token was read from.
+func (p *AssemblerLexer) tokensWithOrigins(cfg *lexer.LanguageConfig, input io.Reader, filename string) ([]lexer.Token, []origin, error) {
 	// Reset included files for each processing session
 	p.includedFiles = make(map[string]bool)
 	p.includeCount = make(map[string]int)
 	p.importOnce = make(map[string]bool)
 
-	tokens, err := p.readerTokens(cfg, input, filename, 0)
-	if err != nil {
-		return nil, err
-	}
-	return tokens, nil
+	return p.readerTokens(cfg, input, &sourceFile{name: filename}, 0)
 }
 
-// readerTokens recursively processes a reader, expanding includes and returning tokens
-func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader, filename string, depth int) ([]lexer.Token, error) {
+// readerTokens recursively processes a reader, expanding includes and returning tokens and the file each
+// one was read from
+func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader, file *sourceFile, depth int) ([]lexer.Token, []origin, error) {
 	if depth > p.MaxIncludeDepth {
-		return nil, fmt.Errorf("maximum include depth (%d) exceeded", p.MaxIncludeDepth)
+		return nil, nil, fmt.Errorf("maximum include depth (%d) exceeded", p.MaxIncludeDepth)
 	}
 
+	filename := file.name
 	var out []lexer.Token
+	var origins []origin
 	scanner := bufio.NewScanner(input)
 	lineNum := 0
 	var sourceCode strings.Builder
@@ -132,6 +173,7 @@ func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader
 				t.SourceLine = lineOffset + 1
 			}
 			out = append(out, t)
+			origins = append(origins, origin{file: file})
 		}
 		sourceCode.Reset()
 		return nil
@@ -140,12 +182,13 @@ func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader
 	for scanner.Scan() {
 		lineNum++
 		line := scanner.Text()
+		file.lines = append(file.lines, line)
 		trimmedLine := strings.TrimSpace(line)
 
 		// Check for preprocessor commands
 		if strings.HasPrefix(trimmedLine, "#") {
 			if skip, err := p.handlePreprocessorCommand(trimmedLine, filename); err != nil {
-				return nil, err
+				return nil, nil, err
 			} else if skip {
 				continue
 			}
@@ -160,12 +203,12 @@ func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader
 			}
 			// Flush any accumulated non-include lines before processing include
 			if err := tokenizeSource(filename); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			// Prevent circular includes
 			if p.includedFiles[includeFilePath] {
-				return nil, fmt.Errorf("circular include detected: '%s' (line %d)", includeFilePath, lineNum)
+				return nil, nil, fmt.Errorf("circular include detected: '%s' (line %d)", includeFilePath, lineNum)
 			}
 
 			p.includedFiles[includeFilePath] = true
@@ -173,15 +216,17 @@ func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader
 
 			includeFileReader, err := p.fileResolver.Resolve(includeFilePath)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				return nil, nil, fmt.Errorf("line %d: %w", lineNum, err)
 			}
 
-			includedTokens, err := p.readerTokens(cfg, includeFileReader, includeFilePath, depth+1)
+			included := &sourceFile{name: includeFilePath, includedFrom: file, includeLine: uint(lineNum)}
+			includedTokens, includedOrigins, err := p.readerTokens(cfg, includeFileReader, included, depth+1)
 			if err != nil {
-				return nil, fmt.Errorf("in file '%s': %w", includeFilePath, err)
+				return nil, nil, fmt.Errorf("in file '%s': %w", includeFilePath, err)
 			}
 
 			out = append(out, includedTokens...)
+			origins = append(origins, includedOrigins...)
 			delete(p.includedFiles, includeFilePath)
 			sourceLine = lineNum + 1
 			continue
@@ -194,19 +239,20 @@ func (p *AssemblerLexer) readerTokens(cfg *lexer.LanguageConfig, input io.Reader
 
 	// Flush any remaining chunk
 	if err := tokenizeSource(filename); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading input: %w", err)
+		return nil, nil, fmt.Errorf("error reading input: %w", err)
 	}
 
 	// Ensure a single EOF token terminates the stream
 	if depth == 0 {
 		out = append(out, lexer.NewToken(lexer.EOFType, "", 0))
+		origins = append(origins, origin{file: file})
 	}
 
-	return out, nil
+	return out, origins, nil
 }
 
 func (p *AssemblerLexer) handlePreprocessorCommand(trimmedLine string, filename string) (skipLine bool, err error) {
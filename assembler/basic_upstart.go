@@ -0,0 +1,137 @@
+package assembler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler/cbmbasic"
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// BASICUpstartLine is the line number of the generated "SYS" line
+const BASICUpstartLine = 10
+
+const (
+	// basicUpstartOverhead is the stub's size without the address digits: the line's link pointer, line
+	// number, SYS token and terminating zero, and the zero link that ends the program.
+	basicUpstartOverhead = 8
+	// maxAddressDigits is the width reserved for an address that is not known in the first pass
+	maxAddressDigits = 5
+)
+
+// BASICUpstart returns a tokenized BASIC program, loaded at address, of the single line "10 SYS target".
+// The target's decimal digits are right aligned in width characters, padded with spaces, so that the
+// stub's size can be fixed before the target is known. A width of 0 uses the target's own digit count.
+func BASICUpstart(address, target uint16, width int) ([]byte, error) {
+	digits := strconv.Itoa(int(target))
+	if width == 0 {
+		width = len(digits)
+	}
+	if len(digits) > width {
+		return nil, fmt.Errorf("[BASICUpstart] SYS %d does not fit in %d digits", target, width)
+	}
+
+	stub, err := cbmbasic.TokenizeBASIC(fmt.Sprintf("%d SYS%*s", BASICUpstartLine, width, digits), address)
+	if err != nil {
+		return nil, fmt.Errorf("[BASICUpstart] %w", err)
+	}
+	return stub, nil
+}
+
+// PrependBASICUpstart puts a BASIC stub at BASICStart that runs the lowest segment with SYS. The stub and
+// the lowest segment are merged into one segment, with any gap between them filled with zeros, so that
+// formats that take a single segment still load and RUN the result.
+func PrependBASICUpstart(segments []AssembledData) ([]AssembledData, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("[PrependBASICUpstart] no segments")
+	}
+	sorted := make([]AssembledData, len(segments))
+	copy(sorted, segments)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartAddress < sorted[j].StartAddress })
+
+	target := sorted[0].StartAddress
+	stub, err := BASICUpstart(cbmbasic.BASICStart, target, 0)
+	if err != nil {
+		return nil, err
+	}
+	if int(target) < cbmbasic.BASICStart+len(stub) {
+		return nil, fmt.Errorf("[PrependBASICUpstart] program at $%04X overlaps the BASIC stub at $%04X-$%04X", target, cbmbasic.BASICStart, cbmbasic.BASICStart+len(stub)-1)
+	}
+
+	merged := AssembledData{StartAddress: cbmbasic.BASICStart}
+	merged.Data.Write(stub)
+	merged.Data.Write(make([]byte, int(target)-cbmbasic.BASICStart-len(stub)))
+	merged.Data.Write(sorted[0].Data.Bytes())
+
+	result := []AssembledData{merged}
+	for _, segment := range sorted[1:] {
+		var copied AssembledData
+		copied.StartAddress = segment.StartAddress
+		copied.Data.Write(segment.Data.Bytes())
+		result = append(result, copied)
+	}
+	return result, nil
+}
+
+// basicUpstartWidth is the number of digits to reserve in the first pass for a stub that SYSes to the
+// given address operand
+func (a *Assembler) basicUpstartWidth(t lexer.Token) int {
+	if target, err := a.basicUpstartTarget(t); err == nil {
+		return len(strconv.Itoa(int(target)))
+	}
+	return maxAddressDigits // A forward reference
+}
+
+// basicUpstartTarget resolves the operand of .BASICUPSTART: a label, a constant or an address
+func (a *Assembler) basicUpstartTarget(t lexer.Token) (uint16, error) {
+	var value any
+	switch t.ID {
+	case lexer.HexLiteral, lexer.IntegerLiteral:
+		value = t.Value
+	case IdentifierToken:
+		if address, ok := a.labels[a.lookupSymbol(t.Literal)]; ok {
+			value = address
+		} else if constant, ok := a.constants[a.lookupSymbol(t.Literal)]; ok {
+			value = constant
+		} else {
+			return 0, fmt.Errorf("undefined label: %s", t.Literal)
+		}
+	default:
+		return 0, fmt.Errorf("expected label or address after .BASICUPSTART, got '%s'", t.Literal)
+	}
+	target, err := toUint64(value)
+	if err != nil {
+		return 0, err
+	}
+	if target > 0xFFFF {
+		return 0, fmt.Errorf("address %d exceeds 65535", target)
+	}
+	return uint16(target), nil
+}
+
+// calculateBasicUpstartDirectiveSize sizes the stub in the first pass and remembers its width for the
+// second, when forward references have been resolved
+func (a *Assembler) calculateBasicUpstartDirectiveSize(asmTokens *Tokens) (int, error) {
+	t := asmTokens.Next()
+	if isTerminatorToken(t.ID) {
+		return 0, fmt.Errorf("[calculateBasicUpstartDirectiveSize] expected label after .BASICUPSTART")
+	}
+	width := a.basicUpstartWidth(t)
+	a.basicUpstartWidths[a.programCounter] = width
+	return basicUpstartOverhead + width, nil
+}
+
+func (a *Assembler) processBasicUpstartDirective(asmTokens *Tokens, insertIntoMemory func([]byte)) error {
+	t := asmTokens.Next()
+	target, err := a.basicUpstartTarget(t)
+	if err != nil {
+		return fmt.Errorf("[processBasicUpstartDirective] %w", err)
+	}
+	stub, err := BASICUpstart(a.programCounter, target, a.basicUpstartWidths[a.programCounter])
+	if err != nil {
+		return fmt.Errorf("[processBasicUpstartDirective] %w", err)
+	}
+	insertIntoMemory(stub)
+	return nil
+}
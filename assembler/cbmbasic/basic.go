@@ -0,0 +1,341 @@
+// Package cbmbasic tokenizes and lists Commodore BASIC V2 programs, as the C64 stores them in memory
+package cbmbasic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BASICStart is where the C64 loads and runs BASIC programs
+const BASICStart = 0x0801
+
+// BASIC V2 tokens with a meaning to the tokenizer or to finding a program's entry point
+const (
+	TokenDATA  = 0x83
+	TokenREM   = 0x8F
+	TokenPOKE  = 0x97
+	TokenPRINT = 0x99
+	TokenSYS   = 0x9E
+	TokenUSR   = 0xB7
+	TokenPi    = 0xFF
+)
+
+// usrVector is the address USR() jumps through (785/786 decimal)
+const usrVector = 0x0311
+
+// basicTokens are the BASIC V2 keywords, indexed by token - $80
+var basicTokens = []string{
+	"END", "FOR", "NEXT", "DATA", "INPUT#", "INPUT", "DIM", "READ",
+	"LET", "GOTO", "RUN", "IF", "RESTORE", "GOSUB", "RETURN", "REM",
+	"STOP", "ON", "WAIT", "LOAD", "SAVE", "VERIFY", "DEF", "POKE",
+	"PRINT#", "PRINT", "CONT", "LIST", "CLR", "CMD", "SYS", "OPEN",
+	"CLOSE", "GET", "NEW", "TAB(", "TO", "FN", "SPC(", "THEN",
+	"NOT", "STEP", "+", "-", "*", "/", "^", "AND",
+	"OR", ">", "=", "<", "SGN", "INT", "ABS", "USR",
+	"FRE", "POS", "SQR", "RND", "LOG", "EXP", "COS", "SIN",
+	"TAN", "ATN", "PEEK", "LEN", "STR$", "VAL", "ASC", "CHR$",
+	"LEFT$", "RIGHT$", "MID$", "GO",
+}
+
+// basicControlCodes are the names, written between braces, of the PETSCII control codes found in strings
+var basicControlCodes = map[byte]string{
+	0x05: "wht", 0x08: "dish", 0x09: "ensh", 0x0E: "swlc", 0x11: "down", 0x12: "rvon", 0x13: "home",
+	0x14: "del", 0x1C: "red", 0x1D: "rght", 0x1E: "grn", 0x1F: "blu", 0x5C: "pound", 0x81: "orng",
+	0x85: "f1", 0x86: "f3", 0x87: "f5", 0x88: "f7", 0x89: "f2", 0x8A: "f4", 0x8B: "f6", 0x8C: "f8",
+	0x8D: "sret", 0x8E: "swuc", 0x90: "blk", 0x91: "up", 0x92: "rvof", 0x93: "clr", 0x94: "inst",
+	0x95: "brn", 0x96: "lred", 0x97: "gry1", 0x98: "gry2", 0x99: "lgrn", 0x9A: "lblu", 0x9B: "gry3",
+	0x9C: "pur", 0x9D: "left", 0x9E: "yel", 0x9F: "cyn", TokenPi: "pi",
+}
+
+// Limits of a BASIC V2 line
+const (
+	MaxBASICLineNumber = 63999
+	maxBASICLineLength = 255
+)
+
+// maxBASICLines stops a listing of a corrupt program whose links loop
+const maxBASICLines = 10000
+
+// BASICLine is one line of a tokenized BASIC program
+type BASICLine struct {
+	Address uint16 // Address of the line's link pointer
+	Number  uint16
+	Tokens  []byte // The tokenized line, without its link, number and terminating zero
+}
+
+// String returns the line as LIST shows it
+func (l BASICLine) String() string {
+	return fmt.Sprintf("%d %s", l.Number, DetokenizeLine(l.Tokens))
+}
+
+// DetokenizeBASIC decodes the tokenized BASIC program in data, which is loaded at start, following the
+// link pointers until the zero link that ends the program. It returns an error, with the lines decoded
+// so far, when a link points outside data or backwards.
+func DetokenizeBASIC(data []byte, start uint16) ([]BASICLine, error) {
+	var lines []BASICLine
+	offset := 0
+	for len(lines) < maxBASICLines {
+		if offset+2 > len(data) {
+			return lines, fmt.Errorf("BASIC program truncated at $%04X", start+uint16(offset))
+		}
+		link := uint16(data[offset]) | uint16(data[offset+1])<<8
+		if link == 0 {
+			return lines, nil
+		}
+		next := int(link) - int(start)
+		if next <= offset+4 || next > len(data) {
+			return lines, fmt.Errorf("invalid BASIC link $%04X at $%04X", link, start+uint16(offset))
+		}
+		end := offset + 4
+		for end < next && data[end] != 0 {
+			end++
+		}
+		if end >= next {
+			return lines, fmt.Errorf("unterminated BASIC line at $%04X", start+uint16(offset))
+		}
+		lines = append(lines, BASICLine{
+			Address: start + uint16(offset),
+			Number:  uint16(data[offset+2]) | uint16(data[offset+3])<<8,
+			Tokens:  data[offset+4 : end],
+		})
+		offset = next
+	}
+	return lines, fmt.Errorf("BASIC program has more than %d lines", maxBASICLines)
+}
+
+// DetokenizeLine expands the tokens of a BASIC line to keywords, as LIST does. Text between quotes is
+// not expanded, control codes are written by name, such as {clr}, and other characters that cannot be
+// shown are written as {$xx}.
+func DetokenizeLine(tokens []byte) string {
+	var sb strings.Builder
+	quoted := false
+	for _, b := range tokens {
+		switch {
+		case b == '"':
+			quoted = !quoted
+			sb.WriteByte(b)
+		case !quoted && b >= 0x80 && int(b-0x80) < len(basicTokens):
+			sb.WriteString(basicTokens[b-0x80])
+		case b >= 0x20 && b <= 0x5E && b != 0x5C: // Printable, except the pound sign
+			sb.WriteByte(b)
+		case b >= 0xC1 && b <= 0xDA: // Shifted letters
+			sb.WriteByte(b - 0xC1 + 'a')
+		case basicControlCodes[b] != "":
+			fmt.Fprintf(&sb, "{%s}", basicControlCodes[b])
+		default:
+			fmt.Fprintf(&sb, "{$%02x}", b)
+		}
+	}
+	return sb.String()
+}
+
+// TokenizeBASIC turns a BASIC V2 listing, one numbered line per line of text, into a tokenized program
+// loaded at start: each line's link pointer, line number, tokens and terminating zero, followed by the
+// zero link that ends the program. Blank lines are skipped and line numbers must increase.
+func TokenizeBASIC(text string, start uint16) ([]byte, error) {
+	var program []byte
+	lastNumber := -1
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			continue
+		}
+		digits := 0
+		for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+			digits++
+		}
+		number, err := strconv.Atoi(line[:digits])
+		if err != nil || number > MaxBASICLineNumber {
+			return nil, fmt.Errorf("line %d: expected a line number from 0 to %d", i+1, MaxBASICLineNumber)
+		}
+		if number <= lastNumber {
+			return nil, fmt.Errorf("line %d: line number %d does not follow %d", i+1, number, lastNumber)
+		}
+		lastNumber = number
+		tokens, err := TokenizeLine(strings.TrimLeft(line[digits:], " "))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		next := int(start) + len(program) + 4 + len(tokens) + 1
+		if next+2 > 0x10000 {
+			return nil, fmt.Errorf("line %d: program does not fit in memory", i+1)
+		}
+		program = append(program, byte(next), byte(next>>8), byte(number), byte(number>>8))
+		program = append(program, tokens...)
+		program = append(program, 0x00)
+	}
+	return append(program, 0x00, 0x00), nil
+}
+
+// TokenizeLine crunches the text of a BASIC line, after its number, into tokens as the C64's editor
+// does: keywords are matched in token order outside quotes, REM and DATA, ? is PRINT, and letters are
+// folded to upper case except between quotes, where lower case letters are shifted. Control codes and
+// other characters are written as {name} or {$xx}, as DetokenizeLine writes them.
+func TokenizeLine(text string) ([]byte, error) {
+	var tokens []byte
+	quoted, rem, data := false, false, false
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated {")
+			}
+			b, err := basicEscape(text[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, b)
+			i += end + 1
+			continue
+		case c >= 0x80:
+			return nil, fmt.Errorf("character %q is not PETSCII, write it as {$xx}", c)
+		case rem:
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			if c >= 'a' && c <= 'z' {
+				c = c - 'a' + 0xC1
+			}
+		case c == ':' && data:
+			data = false
+		case data:
+		case c == '?':
+			c = TokenPRINT
+		default:
+			if token, n := matchBASICKeyword(text[i:]); n > 0 {
+				tokens = append(tokens, token)
+				i += n
+				rem = token == TokenREM
+				data = token == TokenDATA
+				continue
+			}
+		}
+		if !quoted && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		tokens = append(tokens, c)
+		i++
+	}
+	if len(tokens) > maxBASICLineLength {
+		return nil, fmt.Errorf("line is %d bytes long, the limit is %d", len(tokens), maxBASICLineLength)
+	}
+	return tokens, nil
+}
+
+// matchBASICKeyword returns the token of the first keyword, in token order, that text starts with, and
+// the keyword's length
+func matchBASICKeyword(text string) (byte, int) {
+	for i, keyword := range basicTokens {
+		if len(text) >= len(keyword) && strings.EqualFold(text[:len(keyword)], keyword) {
+			return byte(0x80 + i), len(keyword)
+		}
+	}
+	return 0, 0
+}
+
+// basicEscape decodes the name or $xx hex code of a character written between braces
+func basicEscape(name string) (byte, error) {
+	if strings.HasPrefix(name, "$") {
+		value, err := strconv.ParseUint(name[1:], 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid character code {%s}", name)
+		}
+		return byte(value), nil
+	}
+	for b, n := range basicControlCodes {
+		if strings.EqualFold(n, name) {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown control code {%s}", name)
+}
+
+// BASICEntryPoint finds where a BASIC loader starts machine code: the address of the first SYS followed
+// by a literal decimal address, or the address POKEd into the USR vector (785/786) by a program that calls
+// USR. It returns the address and the keyword that reaches it. Only tokens outside strings, REM and DATA
+// are keywords. When no SYS has a literal address, such as SYS 40960+256, it returns "SYS" without an
+// address rather than guessing.
+func BASICEntryPoint(lines []BASICLine) (uint16, string, bool) {
+	usrLo, usrHi := -1, -1
+	usrCalled, sysFound := false, false
+	for _, line := range lines {
+		quoted, data := false, false
+		for i := 0; i < len(line.Tokens); i++ {
+			switch b := line.Tokens[i]; {
+			case b == '"':
+				quoted = !quoted
+				continue
+			case quoted:
+				continue
+			case data:
+				data = b != ':'
+				continue
+			}
+			switch line.Tokens[i] {
+			case TokenREM:
+				i = len(line.Tokens)
+			case TokenDATA:
+				data = true
+			case TokenSYS:
+				sysFound = true
+				if address, _, ok := basicNumber(line.Tokens[i+1:], ":"); ok && address <= 0xFFFF {
+					return uint16(address), "SYS", true
+				}
+			case TokenUSR:
+				usrCalled = true
+			case TokenPOKE:
+				address, n, ok := basicNumber(line.Tokens[i+1:], ",")
+				rest := line.Tokens[i+1+n:]
+				if !ok || len(rest) == 0 {
+					continue
+				}
+				value, _, ok := basicNumber(rest[1:], ":")
+				if !ok || value > 0xFF {
+					continue
+				}
+				switch address {
+				case usrVector:
+					usrLo = value
+				case usrVector + 1:
+					usrHi = value
+				}
+			}
+		}
+	}
+	if usrCalled && usrLo >= 0 && usrHi >= 0 {
+		return uint16(usrHi<<8 | usrLo), "USR", true
+	}
+	if sysFound {
+		return 0, "SYS", false
+	}
+	return 0, "", false
+}
+
+// basicNumber parses a literal decimal number, optionally in parentheses and surrounded by spaces,
+// returning it with the number of bytes consumed. The number must be the whole expression: followed by
+// the end of the line or one of the terminators, so 2064 is not read from the start of 2064+1.
+func basicNumber(tokens []byte, terminators string) (int, int, bool) {
+	i := 0
+	for i < len(tokens) && (tokens[i] == ' ' || tokens[i] == '(') {
+		i++
+	}
+	start := i
+	for i < len(tokens) && tokens[i] >= '0' && tokens[i] <= '9' {
+		i++
+	}
+	value, err := strconv.Atoi(string(tokens[start:i]))
+	if err != nil {
+		return 0, 0, false
+	}
+	for i < len(tokens) && (tokens[i] == ' ' || tokens[i] == ')') {
+		i++
+	}
+	if i < len(tokens) && !strings.ContainsRune(terminators, rune(tokens[i])) {
+		return 0, 0, false
+	}
+	return value, i, true
+}
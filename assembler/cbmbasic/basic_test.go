@@ -0,0 +1,167 @@
+package cbmbasic
+
+import (
+	"testing"
+)
+
+// sysStub is "10 SYS 2064" at $0801 followed by an RTS at $0810
+var sysStub = []byte{
+	0x0C, 0x08, 0x0A, 0x00, 0x9E, 0x20, 0x32, 0x30, 0x36, 0x34, 0x00, // 10 SYS 2064
+	0x00, 0x00, // End of program
+	0x00, 0x00, 0x00,
+	0x60,
+}
+
+func TestDetokenizeBASIC(t *testing.T) {
+	lines, err := DetokenizeBASIC(sysStub, BASICStart)
+	if err != nil {
+		t.Fatalf("DetokenizeBASIC() error = %v", err)
+	}
+	if len(lines) != 1 || lines[0].String() != "10 SYS 2064" || lines[0].Address != BASICStart {
+		t.Errorf("DetokenizeBASIC() = %v", lines)
+	}
+
+	address, keyword, ok := BASICEntryPoint(lines)
+	if !ok || address != 2064 || keyword != "SYS" {
+		t.Errorf("BASICEntryPoint() = %d, %q, %v, expected 2064, SYS", address, keyword, ok)
+	}
+}
+
+func TestDetokenizeBASICBrokenLink(t *testing.T) {
+	data := append([]byte{}, sysStub...)
+	data[0] = 0x01 // Link points backwards
+	if _, err := DetokenizeBASIC(data, BASICStart); err == nil {
+		t.Error("DetokenizeBASIC() should fail for a backwards link")
+	}
+	if _, err := DetokenizeBASIC(sysStub[:8], BASICStart); err == nil {
+		t.Error("DetokenizeBASIC() should fail for a truncated program")
+	}
+}
+
+func TestDetokenizeLine(t *testing.T) {
+	tests := []struct {
+		tokens []byte
+		want   string
+	}{
+		{[]byte{0x99, '"', 'H', 'I', 0x8F, '"', ';'}, `PRINT"HI{$8f}";`},
+		{[]byte{0x8B, 'A', 0xB2, '1', 0xA7, 0x89, '2', '0'}, "IFA=1THENGOTO20"},
+		{[]byte{0x99, 0xFF, '"', 0xC1, '"'}, `PRINT{pi}"a"`},
+	}
+	for _, tt := range tests {
+		if got := DetokenizeLine(tt.tokens); got != tt.want {
+			t.Errorf("DetokenizeLine(% X) = %q, want %q", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestBASICEntryPointUSR(t *testing.T) {
+	lines := []BASICLine{
+		{Number: 10, Tokens: []byte("\x97785,0:\x97786,192")},
+		{Number: 20, Tokens: []byte("A\xb2\xb7(0)")},
+	}
+	address, keyword, ok := BASICEntryPoint(lines)
+	if !ok || address != 0xC000 || keyword != "USR" {
+		t.Errorf("BASICEntryPoint() = $%04X, %q, %v, expected $C000, USR", address, keyword, ok)
+	}
+
+	if _, _, ok := BASICEntryPoint([]BASICLine{{Number: 10, Tokens: []byte("\x8f\x9e2064")}}); ok {
+		t.Error("BASICEntryPoint() should ignore a SYS in a REM")
+	}
+}
+
+func TestBASICEntryPointLiteral(t *testing.T) {
+	tests := []struct {
+		line    string
+		address uint16
+		keyword string
+		ok      bool
+	}{
+		{"10 SYS 2064", 2064, "SYS", true},
+		{"10 SYS(2064):END", 2064, "SYS", true},
+		{"10 SYS 40960+256", 0, "SYS", false},
+		{"10 SYS 2064*2", 0, "SYS", false},
+		{"10 SYS A", 0, "SYS", false},
+		{"10 PRINT \"{yel}HI\":SYS 2064", 2064, "SYS", true}, // {yel} is the SYS token's code
+		{"10 PRINT \"{gry1}\";:SYS 2064", 2064, "SYS", true}, // {gry1} is the POKE token's code
+		{"10 DATA {yel}:SYS 2064", 2064, "SYS", true},
+		{"10 SYS A\n20 SYS 4096", 4096, "SYS", true}, // A SYS without a literal address is passed over
+		{"10 PRINT \"{yel}\"", 0, "", false},
+	}
+	for _, tt := range tests {
+		data, err := TokenizeBASIC(tt.line, BASICStart)
+		if err != nil {
+			t.Fatalf("TokenizeBASIC(%q) error = %v", tt.line, err)
+		}
+		lines, err := DetokenizeBASIC(data, BASICStart)
+		if err != nil {
+			t.Fatalf("DetokenizeBASIC(%q) error = %v", tt.line, err)
+		}
+		address, keyword, ok := BASICEntryPoint(lines)
+		if address != tt.address || ok != tt.ok || keyword != tt.keyword {
+			t.Errorf("BASICEntryPoint(%q) = %d, %q, %v, expected %d, %q, %v", tt.line, address, keyword, ok, tt.address, tt.keyword, tt.ok)
+		}
+	}
+}
+
+func TestTokenizeBASIC(t *testing.T) {
+	data, err := TokenizeBASIC("10 sys 2064\n\n", BASICStart)
+	if err != nil {
+		t.Fatalf("TokenizeBASIC() error = %v", err)
+	}
+	want := []byte{0x0C, 0x08, 0x0A, 0x00, 0x9E, ' ', '2', '0', '6', '4', 0x00, 0x00, 0x00}
+	if string(data) != string(want) {
+		t.Errorf("TokenizeBASIC() = % X, want % X", data, want)
+	}
+
+	if _, err := TokenizeBASIC("20 END\n10 END", BASICStart); err == nil {
+		t.Error("TokenizeBASIC() should fail when line numbers do not increase")
+	}
+	if _, err := TokenizeBASIC("PRINT", BASICStart); err == nil {
+		t.Error("TokenizeBASIC() should fail without a line number")
+	}
+}
+
+func TestTokenizeLine(t *testing.T) {
+	tests := []struct {
+		text string
+		want []byte
+	}{
+		{`?"{clr}Hi{$8f}":goto10`, []byte{0x99, '"', 0x93, 'H', 0xC9, 0x8F, '"', ':', 0x89, '1', '0'}},
+		{"data print,a:print", []byte{0x83, ' ', 'P', 'R', 'I', 'N', 'T', ',', 'A', ':', 0x99}},
+		{"rem print", []byte{0x8F, ' ', 'P', 'R', 'I', 'N', 'T'}},
+		{"a={pi}*2^3", []byte{'A', 0xB2, 0xFF, 0xAC, '2', 0xAE, '3'}},
+	}
+	for _, tt := range tests {
+		got, err := TokenizeLine(tt.text)
+		if err != nil {
+			t.Errorf("TokenizeLine(%q) error = %v", tt.text, err)
+		} else if string(got) != string(tt.want) {
+			t.Errorf("TokenizeLine(%q) = % X, want % X", tt.text, got, tt.want)
+		}
+	}
+
+	for _, text := range []string{"PRINT\"{nope}\"", "PRINT\"{clr\"", "PRINT\"é\""} {
+		if _, err := TokenizeLine(text); err == nil {
+			t.Errorf("TokenizeLine(%q) should fail", text)
+		}
+	}
+}
+
+func TestBASICRoundTrip(t *testing.T) {
+	listing := "10 PRINT\"{clr}{wht}hello{rvon}\";\n20 FORI=1TO10:NEXT\n30 REM {pound}\n"
+	data, err := TokenizeBASIC(listing, BASICStart)
+	if err != nil {
+		t.Fatalf("TokenizeBASIC() error = %v", err)
+	}
+	lines, err := DetokenizeBASIC(data, BASICStart)
+	if err != nil {
+		t.Fatalf("DetokenizeBASIC() error = %v", err)
+	}
+	var got string
+	for _, line := range lines {
+		got += line.String() + "\n"
+	}
+	if got != listing {
+		t.Errorf("round trip = %q, want %q", got, listing)
+	}
+}
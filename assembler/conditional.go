@@ -0,0 +1,177 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// conditional is an open .IF block
+type conditional struct {
+	line    uint // Line of the .IF
+	taken   bool // One of the block's branches has been assembled
+	sawElse bool
+}
+
+// conditionalDirective handles .IF, .IFDEF, .IFNDEF, .ELSEIF, .ELSE and .ENDIF in either pass, skipping
+// the branches that are not assembled. The first pass evaluates each condition once and records the
+// result by the directive's token index; the second pass reuses it, so both passes assemble the same
+// branches even when a condition refers to a symbol that is only defined later.
+func (a *Assembler) conditionalDirective(asmTokens *Tokens, directive int, preprocess bool) error {
+	for {
+		t := asmTokens.Current()
+		var assemble bool
+		switch directive {
+		case IfDirective, IfDefDirective, IfNDefDirective:
+			a.conditions = append(a.conditions, conditional{line: t.SourceLine})
+			result, err := a.condition(asmTokens, directive, preprocess)
+			if err != nil {
+				return err
+			}
+			assemble = result
+
+		case ElseIfDirective:
+			open, err := a.openConditional(t)
+			if err != nil {
+				return err
+			}
+			if open.sawElse {
+				return fmt.Errorf("[conditionalDirective] .ELSEIF after .ELSE (line %d)", t.SourceLine)
+			}
+			if open.taken {
+				skipToEndOfLine(asmTokens)
+				break
+			}
+			result, err := a.condition(asmTokens, directive, preprocess)
+			if err != nil {
+				return err
+			}
+			assemble = result
+
+		case ElseDirective:
+			open, err := a.openConditional(t)
+			if err != nil {
+				return err
+			}
+			if open.sawElse {
+				return fmt.Errorf("[conditionalDirective] duplicate .ELSE (line %d)", t.SourceLine)
+			}
+			open.sawElse = true
+			assemble = !open.taken
+
+		case EndIfDirective:
+			if _, err := a.openConditional(t); err != nil {
+				return err
+			}
+			a.conditions = a.conditions[:len(a.conditions)-1]
+			return nil
+		}
+
+		if assemble {
+			a.conditions[len(a.conditions)-1].taken = true
+			return nil
+		}
+		next, err := a.skipConditionalBranch(asmTokens)
+		if err != nil {
+			return err
+		}
+		directive = next
+	}
+}
+
+// condition returns whether the branch of the .IF, .IFDEF, .IFNDEF or .ELSEIF at the current token is
+// assembled.
+func (a *Assembler) condition(asmTokens *Tokens, directive int, preprocess bool) (bool, error) {
+	t := asmTokens.Current()
+	index := asmTokens.Index()
+	if !preprocess {
+		result, found := a.conditionResults[index]
+		if !found {
+			return false, fmt.Errorf("[condition] condition at line %d was not evaluated in the first pass", t.SourceLine)
+		}
+		skipToEndOfLine(asmTokens)
+		return result, nil
+	}
+
+	var result bool
+	switch directive {
+	case IfDefDirective, IfNDefDirective:
+		name := asmTokens.Next()
+		if name.ID != IdentifierToken {
+			return false, fmt.Errorf("[condition] expected a symbol after .%s (line %d)", strings.ToUpper(t.Literal), t.SourceLine)
+		}
+		result = a.isDefined(a.lookupSymbol(name.Literal)) == (directive == IfDefDirective)
+	default:
+		if isTerminatorToken(asmTokens.Peek().ID) {
+			return false, fmt.Errorf("[condition] expected an expression after .%s (line %d)", strings.ToUpper(t.Literal), t.SourceLine)
+		}
+		asmTokens.Next()
+		value, err := a.EvaluateExpression(asmTokens, "", false)
+		if err != nil {
+			return false, fmt.Errorf("[condition] .%s (line %d): %w", strings.ToUpper(t.Literal), t.SourceLine, err)
+		}
+		result = value != 0
+	}
+	if !isTerminatorToken(asmTokens.Peek().ID) {
+		return false, fmt.Errorf("[condition] unexpected '%s' after .%s condition (line %d)", asmTokens.Peek().Literal, strings.ToUpper(t.Literal), t.SourceLine)
+	}
+	a.conditionResults[index] = result
+	return result, nil
+}
+
+// openConditional returns the innermost open .IF block, failing if directive is outside one.
+func (a *Assembler) openConditional(directive lexer.Token) (*conditional, error) {
+	if len(a.conditions) == 0 {
+		return nil, fmt.Errorf("[openConditional] .%s without .IF (line %d)", strings.ToUpper(directive.Literal), directive.SourceLine)
+	}
+	return &a.conditions[len(a.conditions)-1], nil
+}
+
+// skipConditionalBranch skips the tokens of a branch that is not assembled, including any blocks nested
+// in it, and returns the .ELSEIF, .ELSE or .ENDIF that ends it, leaving its name as the current token.
+func (a *Assembler) skipConditionalBranch(asmTokens *Tokens) (int, error) {
+	depth := 0
+	for {
+		t := asmTokens.Next()
+		if t.ID == lexer.EOFType {
+			open := a.conditions[len(a.conditions)-1]
+			return 0, fmt.Errorf("[skipConditionalBranch] missing .ENDIF for .IF (line %d)", open.line)
+		}
+		if t.ID != PeriodToken || asmTokens.Peek().ID != IdentifierToken {
+			continue
+		}
+		directive := a.directives["."+strings.ToUpper(asmTokens.Peek().Literal)]
+		switch directive {
+		case IfDirective, IfDefDirective, IfNDefDirective:
+			depth++
+		case EndIfDirective:
+			if depth > 0 {
+				depth--
+				continue
+			}
+			asmTokens.Next()
+			return directive, nil
+		case ElseIfDirective, ElseDirective:
+			if depth == 0 {
+				asmTokens.Next()
+				return directive, nil
+			}
+		}
+	}
+}
+
+// checkConditionalsClosed fails if a pass ends inside a .IF block.
+func (a *Assembler) checkConditionalsClosed() error {
+	if len(a.conditions) > 0 {
+		return fmt.Errorf("missing .ENDIF for .IF (line %d)", a.conditions[len(a.conditions)-1].line)
+	}
+	return nil
+}
+
+// skipToEndOfLine skips the rest of a line, leaving the end of line for the caller.
+func skipToEndOfLine(asmTokens *Tokens) {
+	for !isTerminatorToken(asmTokens.Peek().ID) {
+		asmTokens.Next()
+	}
+}
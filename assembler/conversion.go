@@ -41,11 +41,21 @@ func toInt64(value any) (int64, error) {
 		return int64(v), nil
 	case int:
 		return int64(v), nil
+	case float64:
+		return floatToInt64(v)
 	default:
 		return 0, fmt.Errorf("invalid integer type: %T", value)
 	}
 }
 
+// floatToInt64 converts a float to an integer, dropping any fraction, failing if it is out of range
+func floatToInt64(value float64) (int64, error) {
+	if math.IsNaN(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+		return 0, fmt.Errorf("value %g is out of range for an integer", value)
+	}
+	return int64(value), nil
+}
+
 // minimumOperandSize converts any integer type to operand size mask and value
 // Returns "nn" for 8-bit values, "nnnn" for 16-bit values, etc.
 // Handles negative flag by promoting to larger size if needed
@@ -0,0 +1,223 @@
+package assembler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// DebugInfoVersion is the version of the debug-info format written. ReadDebugInfo rejects other versions,
+// and the version only changes when a field changes meaning or is removed; new fields may be added to it.
+const DebugInfoVersion = 1
+
+// DebugKind tells the bytes of an instruction from those of a data directive
+type DebugKind string
+
+const (
+	CodeKind DebugKind = "code"
+	DataKind DebugKind = "data"
+)
+
+// DebugInfo links an assembled program to its source, for debuggers, coverage and profiling tools. It is
+// written as a JSON document:
+//
+//	{
+//	  "version": 1,
+//	  "segments": [{"start": 49152, "length": 12}],
+//	  "lines": [{"address": 49152, "length": 2, "file": "main.asm", "line": 3, "column": 9, "kind": "code"}, ...],
+//	  "scopes": [{"name": "player", "start": 49154, "end": 49160, "file": "lib.asm", "line": 2}],
+//	  "expansions": [{"id": 1, "macro": "add16", "file": "main.asm", "line": 6}]
+//	}
+//
+// Addresses are decimal numbers.
+type DebugInfo struct {
+	Version    int              `json:"version"`
+	Segments   []DebugSegment   `json:"segments"`
+	Lines      []DebugLine      `json:"lines"`
+	Scopes     []DebugScope     `json:"scopes"`
+	Expansions []DebugExpansion `json:"expansions"`
+}
+
+// DebugSegment is a run of contiguous bytes the program is made of, as the assembler returns them
+type DebugSegment struct {
+	Start  uint16 `json:"start"`
+	Length int    `json:"length"`
+}
+
+// DebugLine is the bytes a line of source assembled to, in the order they were assembled. A line in a loop
+// or a macro has one for each time it was assembled; a line that assembled to no bytes has none.
+type DebugLine struct {
+	Address   uint16    `json:"address"`
+	Length    int       `json:"length"`
+	File      string    `json:"file"` // The file the line is in: a macro's own file for the lines of its body
+	Line      uint      `json:"line"`
+	Column    uint      `json:"column"` // Of the line's first token
+	Kind      DebugKind `json:"kind"`
+	Scope     string    `json:"scope,omitempty"`     // The qualified name of the innermost .PROC or .SCOPE it is in
+	Expansion int       `json:"expansion,omitempty"` // The id of the macro expansion it is part of, 0 outside macros
+}
+
+// DebugScope is the addresses a .PROC or .SCOPE block covers, from its start up to, not including, its end
+type DebugScope struct {
+	Name  string `json:"name"` // Qualified by the scopes around it, such as player::update
+	Start uint16 `json:"start"`
+	End   uint16 `json:"end"`
+	File  string `json:"file"`
+	Line  uint   `json:"line"`
+}
+
+// DebugExpansion is an invocation of a macro
+type DebugExpansion struct {
+	ID     int    `json:"id"`
+	Macro  string `json:"macro"`
+	File   string `json:"file"` // Where the macro was invoked
+	Line   uint   `json:"line"`
+	Parent int    `json:"parent,omitempty"` // The id of the expansion the invocation is part of, 0 if none
+}
+
+// scopeRange is a .PROC or .SCOPE block the second pass assembled
+type scopeRange struct {
+	name       string
+	file       *sourceFile
+	line       uint
+	start, end uint16
+}
+
+// recordScope records, in the second pass, the addresses of the innermost block as it closes.
+func (a *Assembler) recordScope(closed scope) {
+	if a.firstPass {
+		return
+	}
+	var names []string
+	for _, s := range a.scopes {
+		names = append(names, s.name)
+	}
+	a.scopeRanges = append(a.scopeRanges, scopeRange{
+		name:  strings.Join(names, scopeSeparator),
+		file:  closed.file,
+		line:  closed.line,
+		start: closed.start,
+		end:   a.programCounter,
+	})
+}
+
+// DebugInfo returns the debug info of the last assembly.
+func (a *Assembler) DebugInfo() *DebugInfo {
+	info := &DebugInfo{
+		Version:    DebugInfoVersion,
+		Segments:   append([]DebugSegment{}, a.segmentRanges...),
+		Lines:      []DebugLine{},
+		Scopes:     []DebugScope{},
+		Expansions: []DebugExpansion{},
+	}
+
+	ids := make(map[*macroExpansion]int)
+	var expansionID func(e *macroExpansion) int
+	expansionID = func(e *macroExpansion) int {
+		if e == nil {
+			return 0
+		}
+		if id, found := ids[e]; found {
+			return id
+		}
+		parent := expansionID(e.parent) // Parents before the expansions they invoke
+		ids[e] = len(info.Expansions) + 1
+		info.Expansions = append(info.Expansions, DebugExpansion{ID: ids[e], Macro: e.macro.name, File: fileName(e.file), Line: e.line, Parent: parent})
+		return ids[e]
+	}
+
+	for _, l := range a.assembledLines {
+		if len(l.data) == 0 {
+			continue
+		}
+		kind := DataKind
+		if l.instruction != nil {
+			kind = CodeKind
+		}
+		info.Lines = append(info.Lines, DebugLine{
+			Address:   l.address,
+			Length:    len(l.data),
+			File:      fileName(l.origin.file),
+			Line:      l.line,
+			Column:    l.column,
+			Kind:      kind,
+			Scope:     l.scope,
+			Expansion: expansionID(l.origin.expansion),
+		})
+	}
+	for _, s := range a.scopeRanges {
+		info.Scopes = append(info.Scopes, DebugScope{Name: s.name, Start: s.start, End: s.end, File: fileName(s.file), Line: s.line})
+	}
+	return info
+}
+
+// fileName returns the name of a file, or "" if it is not known
+func fileName(f *sourceFile) string {
+	if f == nil {
+		return ""
+	}
+	return f.name
+}
+
+// Write writes the debug info as JSON.
+func (d *DebugInfo) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// ReadDebugInfo reads debug info written by Write.
+func ReadDebugInfo(r io.Reader) (*DebugInfo, error) {
+	var info DebugInfo
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return nil, fmt.Errorf("[ReadDebugInfo] %w", err)
+	}
+	if info.Version != DebugInfoVersion {
+		return nil, fmt.Errorf("[ReadDebugInfo] unsupported debug info version %d, expected %d", info.Version, DebugInfoVersion)
+	}
+	return &info, nil
+}
+
+// LineAt returns the line whose bytes include an address. Where the program assembled more than once to
+// the same address, it is the line assembled last, whose bytes are the ones in memory.
+func (d *DebugInfo) LineAt(address uint16) (DebugLine, bool) {
+	for i := len(d.Lines) - 1; i >= 0; i-- {
+		l := d.Lines[i]
+		if int(address) >= int(l.Address) && int(address) < int(l.Address)+l.Length {
+			return l, true
+		}
+	}
+	return DebugLine{}, false
+}
+
+// Addresses returns the address of each time a line of a file was assembled, in order: where to put a
+// breakpoint on the line.
+func (d *DebugInfo) Addresses(file string, line uint) []uint16 {
+	var addresses []uint16
+	for _, l := range d.Lines {
+		if l.File == file && l.Line == line {
+			addresses = append(addresses, l.Address)
+		}
+	}
+	return addresses
+}
+
+// ScopesAt returns the qualified names of the scopes whose addresses include an address, outermost first.
+func (d *DebugInfo) ScopesAt(address uint16) []string {
+	var names []string
+	for _, s := range d.Scopes {
+		if address >= s.Start && address < s.End {
+			names = append(names, s.Name)
+		}
+	}
+	// A block closes, and is recorded, before the one around it
+	slices.SortStableFunc(names, func(x, y string) int {
+		return strings.Count(x, scopeSeparator) - strings.Count(y, scopeSeparator)
+	})
+	return names
+}
@@ -0,0 +1,61 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// Text encodings, selected with .ENCODING, that strings and character literals are assembled in
+const (
+	asciiEncoding   = "ascii"
+	petsciiEncoding = "petscii"
+)
+
+// encodingDirective selects the encoding of the strings and character literals that follow it, in either
+// pass: .ENCODING "petscii" or .ENCODING "ascii".
+func (a *Assembler) encodingDirective(asmTokens *Tokens) error {
+	t := asmTokens.Current()
+	nameToken := asmTokens.Next()
+	name, _ := nameToken.Value.(string)
+	if nameToken.ID != lexer.StringLiteral {
+		return fmt.Errorf("[encodingDirective] expected an encoding name after .ENCODING (line %d)", t.SourceLine)
+	}
+	switch name = strings.ToLower(name); name {
+	case asciiEncoding, petsciiEncoding:
+		a.encoding = name
+	default:
+		return fmt.Errorf("[encodingDirective] unknown encoding '%s', expected \"ascii\" or \"petscii\" (line %d)", name, t.SourceLine)
+	}
+	if !isTerminatorToken(asmTokens.Peek().ID) {
+		return fmt.Errorf("[encodingDirective] unexpected '%s' after .ENCODING (line %d)", asmTokens.Peek().Literal, t.SourceLine)
+	}
+	return nil
+}
+
+// encodeText returns the bytes a string is assembled as in the active encoding.
+func (a *Assembler) encodeText(str string) []byte {
+	bytes := []byte(str)
+	if a.encoding == petsciiEncoding {
+		for i, b := range bytes {
+			bytes[i] = asciiToPETSCII(b)
+		}
+	}
+	return bytes
+}
+
+// asciiToPETSCII converts an ASCII character to PETSCII the way C64 cross assemblers do: lower case letters
+// become the unshifted letters, $41-$5A, and capitals the shifted letters, $C1-$DA, so that text appears
+// as written in the C64's lower case character set. A newline becomes RETURN.
+func asciiToPETSCII(b byte) byte {
+	switch {
+	case b >= 'a' && b <= 'z':
+		return b - 'a' + 0x41
+	case b >= 'A' && b <= 'Z':
+		return b - 'A' + 0xC1
+	case b == '\n':
+		return 0x0D
+	}
+	return b
+}
@@ -2,41 +2,152 @@ package assembler
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/jrsteele09/go-lexer/lexer"
 )
 
-// Operator precedence levels for Pratt parser
+// Operator precedence levels for Pratt parser, lowest first
+//
+//	?:            conditional, c ? a : b, which groups right to left
+//	||            logical or, 0 or 1
+//	&&            logical and, 0 or 1
+//	|             bitwise or
+//	^             bitwise exclusive or
+//	&             bitwise and
+//	== !=         equality, 0 or 1
+//	< <= > >=     comparison, 0 or 1
+//	<< >>         shift
+//	+ -           sum
+//	* / %         product, quotient and remainder
+//	- ~ !         unary minus, bitwise not and logical not
+//
+// In front of an operand, < > and ^ give the low byte, high byte and bank byte (bits 16-23) of the
+// arithmetic expression that follows: everything up to a comparison or bitwise operator.
+//
+// Integer arithmetic is exact. Once a float literal or a function such as sin() is involved the arithmetic
+// is done in float, and the result is converted to an integer, dropping any fraction, where it is used.
+// The bitwise and shift operators need integers, and convert a float operand the same way.
 const (
-	PRECEDENCE_LOWEST  = iota
-	PRECEDENCE_SUM     // +, -
-	PRECEDENCE_PRODUCT // *, /
-	PRECEDENCE_PREFIX  // -x (unary minus)
+	PRECEDENCE_LOWEST      = iota
+	PRECEDENCE_TERNARY     // c ? a : b
+	PRECEDENCE_LOGICAL_OR  // ||
+	PRECEDENCE_LOGICAL_AND // &&
+	PRECEDENCE_BIT_OR      // |
+	PRECEDENCE_BIT_XOR     // ^
+	PRECEDENCE_BIT_AND     // &
+	PRECEDENCE_EQUALITY    // ==, !=
+	PRECEDENCE_COMPARISON  // <, <=, >, >=
+	PRECEDENCE_SHIFT       // <<, >>
+	PRECEDENCE_SUM         // +, -
+	PRECEDENCE_PRODUCT     // *, /, %
+	PRECEDENCE_PREFIX      // -x, ~x, !x (unary)
 )
 
 // getPrecedence returns the precedence of an operator token
 func (a *Assembler) getPrecedence(tokenID lexer.TokenIdentifier) int {
 	switch tokenID {
+	case QuestionToken:
+		return PRECEDENCE_TERNARY
+	case LogicalOrToken:
+		return PRECEDENCE_LOGICAL_OR
+	case LogicalAndToken:
+		return PRECEDENCE_LOGICAL_AND
+	case PipeToken:
+		return PRECEDENCE_BIT_OR
+	case CaretToken:
+		return PRECEDENCE_BIT_XOR
+	case AmpersandToken:
+		return PRECEDENCE_BIT_AND
+	case EqualToken, NotEqualToken:
+		return PRECEDENCE_EQUALITY
+	case LessThanToken, LessOrEqualToken, GreaterThanToken, GreaterOrEqualToken:
+		return PRECEDENCE_COMPARISON
+	case ShiftLeftToken, ShiftRightToken:
+		return PRECEDENCE_SHIFT
 	case PlusToken, MinusToken:
 		return PRECEDENCE_SUM
-	case AsterixSymbolToken, DivideSymbolToken:
+	case AsterixSymbolToken, DivideSymbolToken, PercentToken:
 		return PRECEDENCE_PRODUCT
 	default:
 		return PRECEDENCE_LOWEST
 	}
 }
 
-func (a *Assembler) parseNextExpression(asmTokens *Tokens, mnemonic string, precedence int, preprocess bool) (int64, error) {
+// number is the value of an expression: an integer, or a float once a float is involved
+type number struct {
+	integer int64
+	float   float64
+	isFloat bool
+}
+
+func integerNumber(i int64) number {
+	return number{integer: i}
+}
+
+func floatNumber(f float64) number {
+	return number{float: f, isFloat: true}
+}
+
+func boolNumber(b bool) number {
+	if b {
+		return integerNumber(1)
+	}
+	return integerNumber(0)
+}
+
+// numberValue returns the number a constant holds
+func numberValue(value any) (number, error) {
+	if f, ok := value.(float64); ok {
+		return floatNumber(f), nil
+	}
+	i, err := toInt64(value)
+	return integerNumber(i), err
+}
+
+func (n number) toFloat() float64 {
+	if n.isFloat {
+		return n.float
+	}
+	return float64(n.integer)
+}
+
+// toInteger converts a number to an integer, dropping any fraction, failing if it is out of range
+func (n number) toInteger() (int64, error) {
+	if n.isFloat {
+		return floatToInt64(n.float)
+	}
+	return n.integer, nil
+}
+
+func (n number) isTrue() bool {
+	if n.isFloat {
+		return n.float != 0
+	}
+	return n.integer != 0
+}
+
+// value returns the number as a constant holds it: int64 or float64
+func (n number) value() any {
+	if n.isFloat {
+		return n.float
+	}
+	return n.integer
+}
+
+func (a *Assembler) parseNextExpression(asmTokens *Tokens, mnemonic string, precedence int, preprocess bool) (number, error) {
 	asmTokens.Next() // Advance to next token
 	return a.parseCurrentExpression(asmTokens, mnemonic, precedence, preprocess)
 }
 
 // parseExpression implements a Pratt parser for mathematical expressions
-func (a *Assembler) parseCurrentExpression(asmTokens *Tokens, mnemonic string, precedence int, preprocess bool) (int64, error) {
+func (a *Assembler) parseCurrentExpression(asmTokens *Tokens, mnemonic string, precedence int, preprocess bool) (number, error) {
 	// Parse prefix expression (primary)
 	left, err := a.parsePrimary(asmTokens, mnemonic, preprocess)
 	if err != nil {
-		return 0, err
+		return number{}, err
 	}
 
 	// Parse infix expressions based on precedence
@@ -46,6 +157,22 @@ func (a *Assembler) parseCurrentExpression(asmTokens *Tokens, mnemonic string, p
 			break
 		}
 
+		// The lexer reads % followed by 0s and 1s as a binary number; after an operand it is a remainder
+		if strings.HasPrefix(nextToken.Literal, "%") && nextToken.ID != PercentToken {
+			if PRECEDENCE_PRODUCT <= precedence {
+				break
+			}
+			asmTokens.Next()
+			right, err := strconv.ParseInt(nextToken.Literal[1:], 10, 64)
+			if err != nil {
+				return number{}, fmt.Errorf("[parseExpression] invalid operand: %s", nextToken.Literal)
+			}
+			if left, err = applyOperator(lexer.NewToken(PercentToken, "%", nil), left, integerNumber(right)); err != nil {
+				return number{}, err
+			}
+			continue
+		}
+
 		tokenPrecedence := a.getPrecedence(nextToken.ID)
 		if tokenPrecedence <= precedence {
 			break
@@ -54,38 +181,168 @@ func (a *Assembler) parseCurrentExpression(asmTokens *Tokens, mnemonic string, p
 		// Consume the operator token
 		operatorToken := asmTokens.Next()
 
+		if operatorToken.ID == QuestionToken {
+			whenTrue, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_LOWEST, preprocess)
+			if err != nil {
+				return number{}, err
+			}
+			if colon := asmTokens.Next(); colon.ID != ColonToken {
+				return number{}, fmt.Errorf("[parseExpression] expected ':' in conditional expression")
+			}
+			// Parsed at a lower precedence so that a ? b : c ? d : e groups as a ? b : (c ? d : e)
+			whenFalse, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_TERNARY-1, preprocess)
+			if err != nil {
+				return number{}, err
+			}
+			if left.isTrue() {
+				left = whenTrue
+			} else {
+				left = whenFalse
+			}
+			continue
+		}
+
 		// Parse the right operand
 		right, err := a.parseNextExpression(asmTokens, mnemonic, tokenPrecedence, preprocess)
 		if err != nil {
-			return 0, err
+			return number{}, err
 		}
 
 		// Apply the operator
-		switch operatorToken.ID {
-		case PlusToken:
-			left = left + right
-		case MinusToken:
-			left = left - right
-		case AsterixSymbolToken:
-			left = left * right
-		case DivideSymbolToken:
-			if right == 0 {
-				return 0, fmt.Errorf("[parseExpression] division by zero")
-			}
-			left = left / right
-		default:
-			return 0, fmt.Errorf("[parseExpression] unknown operator: %s", operatorToken.Literal)
+		if left, err = applyOperator(operatorToken, left, right); err != nil {
+			return number{}, err
 		}
 	}
 
 	return left, nil
 }
 
-// parsePrimary parses primary expressions (literals, identifiers, parentheses, unary minus)
-func (a *Assembler) parsePrimary(asmTokens *Tokens, mnemonic string, preprocess bool) (int64, error) {
+// applyOperator applies a binary operator to its operands, in float if either of them is a float.
+// Comparisons and logical operators give 1 for true and 0 for false.
+func applyOperator(operatorToken lexer.Token, left, right number) (number, error) {
+	switch operatorToken.ID {
+	case LogicalAndToken:
+		return boolNumber(left.isTrue() && right.isTrue()), nil
+	case LogicalOrToken:
+		return boolNumber(left.isTrue() || right.isTrue()), nil
+	case ShiftLeftToken, ShiftRightToken, AmpersandToken, PipeToken, CaretToken:
+		l, err := left.toInteger()
+		if err != nil {
+			return number{}, fmt.Errorf("[parseExpression] %w", err)
+		}
+		r, err := right.toInteger()
+		if err != nil {
+			return number{}, fmt.Errorf("[parseExpression] %w", err)
+		}
+		result, err := applyIntegerOperator(operatorToken, l, r)
+		return integerNumber(result), err
+	}
+	if left.isFloat || right.isFloat {
+		return applyFloatOperator(operatorToken, left.toFloat(), right.toFloat())
+	}
+	result, err := applyIntegerOperator(operatorToken, left.integer, right.integer)
+	return integerNumber(result), err
+}
+
+// applyIntegerOperator applies a binary operator to integer operands.
+func applyIntegerOperator(operatorToken lexer.Token, left, right int64) (int64, error) {
+	boolValue := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	switch operatorToken.ID {
+	case PlusToken:
+		return left + right, nil
+	case MinusToken:
+		return left - right, nil
+	case AsterixSymbolToken:
+		return left * right, nil
+	case DivideSymbolToken:
+		if right == 0 {
+			return 0, fmt.Errorf("[parseExpression] division by zero")
+		}
+		return left / right, nil
+	case PercentToken:
+		if right == 0 {
+			return 0, fmt.Errorf("[parseExpression] division by zero")
+		}
+		return left % right, nil
+	case ShiftLeftToken, ShiftRightToken:
+		if right < 0 || right > 63 {
+			return 0, fmt.Errorf("[parseExpression] shift count %d is not 0-63", right)
+		}
+		if operatorToken.ID == ShiftLeftToken {
+			return left << right, nil
+		}
+		return left >> right, nil
+	case AmpersandToken:
+		return left & right, nil
+	case PipeToken:
+		return left | right, nil
+	case CaretToken:
+		return left ^ right, nil
+	case EqualToken:
+		return boolValue(left == right), nil
+	case NotEqualToken:
+		return boolValue(left != right), nil
+	case LessThanToken:
+		return boolValue(left < right), nil
+	case LessOrEqualToken:
+		return boolValue(left <= right), nil
+	case GreaterThanToken:
+		return boolValue(left > right), nil
+	case GreaterOrEqualToken:
+		return boolValue(left >= right), nil
+	default:
+		return 0, fmt.Errorf("[parseExpression] unknown operator: %s", operatorToken.Literal)
+	}
+}
+
+// applyFloatOperator applies an arithmetic or comparison operator to float operands.
+func applyFloatOperator(operatorToken lexer.Token, left, right float64) (number, error) {
+	switch operatorToken.ID {
+	case PlusToken:
+		return floatNumber(left + right), nil
+	case MinusToken:
+		return floatNumber(left - right), nil
+	case AsterixSymbolToken:
+		return floatNumber(left * right), nil
+	case DivideSymbolToken:
+		if right == 0 {
+			return number{}, fmt.Errorf("[parseExpression] division by zero")
+		}
+		return floatNumber(left / right), nil
+	case PercentToken:
+		if right == 0 {
+			return number{}, fmt.Errorf("[parseExpression] division by zero")
+		}
+		return floatNumber(math.Mod(left, right)), nil
+	case EqualToken:
+		return boolNumber(left == right), nil
+	case NotEqualToken:
+		return boolNumber(left != right), nil
+	case LessThanToken:
+		return boolNumber(left < right), nil
+	case LessOrEqualToken:
+		return boolNumber(left <= right), nil
+	case GreaterThanToken:
+		return boolNumber(left > right), nil
+	case GreaterOrEqualToken:
+		return boolNumber(left >= right), nil
+	default:
+		return number{}, fmt.Errorf("[parseExpression] unknown operator: %s", operatorToken.Literal)
+	}
+}
+
+// parsePrimary parses primary expressions (literals, identifiers, function calls, parentheses, unary
+// operators)
+func (a *Assembler) parsePrimary(asmTokens *Tokens, mnemonic string, preprocess bool) (number, error) {
 	token := asmTokens.Current()
 	if isTerminatorToken(token.ID) {
-		return 0, fmt.Errorf("[parsePrimary] unexpected end of expression")
+		return number{}, fmt.Errorf("[parsePrimary] unexpected end of expression")
 	}
 
 	switch token.ID {
@@ -93,42 +350,104 @@ func (a *Assembler) parsePrimary(asmTokens *Tokens, mnemonic string, preprocess
 		// Convert literal to int64
 		value, err := toInt64(token.Value)
 		if err != nil {
-			return 0, fmt.Errorf("invalid literal: %w", err)
+			return number{}, fmt.Errorf("invalid literal: %w", err)
+		}
+		return integerNumber(value), nil
+
+	case lexer.FloatLiteral:
+		value, ok := token.Value.(float64)
+		if !ok {
+			return number{}, fmt.Errorf("invalid literal: %s", token.Literal)
 		}
-		return value, nil
+		return floatNumber(value), nil
+
+	case lexer.StringLiteral:
+		// A character literal, 'A', is the character's code in the active encoding
+		str, _ := token.Value.(string)
+		if len(str) != 1 {
+			return number{}, fmt.Errorf("[parsePrimary] string %s in an expression; only a single character can be used", token.Literal)
+		}
+		return integerNumber(int64(a.encodeText(str)[0])), nil
 
 	case IdentifierToken:
+		if isFunctionCall(token, asmTokens.Peek()) {
+			return a.callFunction(asmTokens, mnemonic, preprocess)
+		}
+		if value, ok := a.constants[a.lookupSymbol(token.Literal)]; ok {
+			return numberValue(value) // At full size; the result is sized once the expression is evaluated
+		}
 		_, value, err := a.LabelOrConstantIdentifier(mnemonic, token.Literal, preprocess)
 		if err != nil || value == nil {
-			return 0, err
+			return number{}, err
 		}
 
-		return toInt64(value)
+		return numberValue(value)
 
 	case MinusToken:
 		// Unary minus
 		right, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_PREFIX, preprocess)
 		if err != nil {
-			return 0, err
+			return number{}, err
+		}
+		if right.isFloat {
+			return floatNumber(-right.float), nil
+		}
+		return integerNumber(-right.integer), nil
+
+	case ExclamationToken:
+		// Logical not
+		right, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_PREFIX, preprocess)
+		if err != nil {
+			return number{}, err
+		}
+		return boolNumber(!right.isTrue()), nil
+
+	case TildeToken:
+		// Bitwise not
+		right, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_PREFIX, preprocess)
+		if err != nil {
+			return number{}, err
+		}
+		value, err := right.toInteger()
+		if err != nil {
+			return number{}, fmt.Errorf("[parsePrimary] %w", err)
+		}
+		return integerNumber(^value), nil
+
+	case LessThanToken, GreaterThanToken, CaretToken:
+		// Low, high and bank byte of the arithmetic expression that follows
+		right, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_COMPARISON, preprocess)
+		if err != nil {
+			return number{}, err
+		}
+		value, err := right.toInteger()
+		if err != nil {
+			return number{}, fmt.Errorf("[parsePrimary] %w", err)
+		}
+		switch token.ID {
+		case GreaterThanToken:
+			return integerNumber((value >> 8) & 0xFF), nil
+		case CaretToken:
+			return integerNumber((value >> 16) & 0xFF), nil
 		}
-		return -right, nil
+		return integerNumber(value & 0xFF), nil
 
 	case LeftParenthesis:
 		// Parenthesized expression
 		result, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_LOWEST, preprocess)
 		if err != nil {
-			return 0, err
+			return number{}, err
 		}
 
 		// Expect closing parenthesis
 		closeParen := asmTokens.Next()
 		if closeParen.ID != RightParenthesis {
-			return 0, fmt.Errorf("expected closing parenthesis")
+			return number{}, fmt.Errorf("expected closing parenthesis")
 		}
 
 		return result, nil
 
 	default:
-		return 0, fmt.Errorf("unexpected token: %s", token.Literal)
+		return number{}, fmt.Errorf("unexpected token: %s", token.Literal)
 	}
 }
@@ -0,0 +1,152 @@
+package assembler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// function is a built-in function an expression can call
+type function struct {
+	arguments int  // The number of arguments, or the fewest if variadic
+	variadic  bool // Whether it takes more arguments than that
+	call      func(args []number) (number, error)
+}
+
+// functions are the built-in functions, by lower case name. len and defined take a string and a symbol
+// rather than values, and are evaluated by callFunction itself.
+var functions = map[string]function{
+	"lo":      {arguments: 1, call: byteFunction(0)},
+	"hi":      {arguments: 1, call: byteFunction(8)},
+	"sin":     {arguments: 1, call: floatFunction(math.Sin)},
+	"cos":     {arguments: 1, call: floatFunction(math.Cos)},
+	"round":   {arguments: 1, call: integerFunction(math.Round)},
+	"floor":   {arguments: 1, call: integerFunction(math.Floor)},
+	"min":     {arguments: 2, variadic: true, call: pickFunction(func(n, best float64) bool { return n < best })},
+	"max":     {arguments: 2, variadic: true, call: pickFunction(func(n, best float64) bool { return n > best })},
+	"abs":     {arguments: 1, call: absFunction},
+	"len":     {arguments: 1},
+	"defined": {arguments: 1},
+}
+
+// isFunctionCall returns whether an identifier is the name of a built-in function being called
+func isFunctionCall(t, next lexer.Token) bool {
+	_, found := functions[strings.ToLower(t.Literal)]
+	return found && next.ID == LeftParenthesis
+}
+
+// callFunction evaluates the call to the built-in function whose name is the current token.
+func (a *Assembler) callFunction(asmTokens *Tokens, mnemonic string, preprocess bool) (number, error) {
+	name := strings.ToLower(asmTokens.Current().Literal)
+	f := functions[name]
+	asmTokens.Next() // Consume the opening parenthesis
+
+	var result number
+	switch name {
+	case "len":
+		t := asmTokens.Next()
+		str, ok := t.Value.(string)
+		if t.ID != lexer.StringLiteral || !ok {
+			return number{}, fmt.Errorf("[callFunction] len() expects a string")
+		}
+		result = integerNumber(int64(len(a.encodeText(str))))
+
+	case "defined":
+		t := asmTokens.Next()
+		if t.ID != IdentifierToken {
+			return number{}, fmt.Errorf("[callFunction] defined() expects a symbol")
+		}
+		// Whether the symbol is defined above this point, as found in the first pass, so that both passes
+		// agree on the value
+		index := asmTokens.Index()
+		if a.firstPass {
+			a.conditionResults[index] = a.isDefined(a.lookupSymbol(t.Literal))
+		}
+		result = boolNumber(a.conditionResults[index])
+
+	default:
+		var args []number
+		for {
+			arg, err := a.parseNextExpression(asmTokens, mnemonic, PRECEDENCE_LOWEST, preprocess)
+			if err != nil {
+				return number{}, err
+			}
+			args = append(args, arg)
+			if asmTokens.Peek().ID != CommaToken {
+				break
+			}
+			asmTokens.Next()
+		}
+		if len(args) < f.arguments || (len(args) > f.arguments && !f.variadic) {
+			expected := fmt.Sprintf("%d", f.arguments)
+			if f.variadic {
+				expected += " or more"
+			}
+			return number{}, fmt.Errorf("[callFunction] %s() takes %s arguments, not %d", name, expected, len(args))
+		}
+		var err error
+		if result, err = f.call(args); err != nil {
+			return number{}, fmt.Errorf("[callFunction] %s(): %w", name, err)
+		}
+	}
+
+	if closeParen := asmTokens.Next(); closeParen.ID != RightParenthesis {
+		return number{}, fmt.Errorf("[callFunction] expected ')' after the arguments of %s()", name)
+	}
+	return result, nil
+}
+
+// byteFunction returns a function giving the byte of its argument that starts at a bit: lo() and hi()
+func byteFunction(shift int) func(args []number) (number, error) {
+	return func(args []number) (number, error) {
+		value, err := args[0].toInteger()
+		if err != nil {
+			return number{}, err
+		}
+		return integerNumber((value >> shift) & 0xFF), nil
+	}
+}
+
+// floatFunction returns a function that applies f to its argument in float: sin() and cos()
+func floatFunction(f func(float64) float64) func(args []number) (number, error) {
+	return func(args []number) (number, error) {
+		return floatNumber(f(args[0].toFloat())), nil
+	}
+}
+
+// integerFunction returns a function that applies f to its argument and gives an integer: round() and
+// floor()
+func integerFunction(f func(float64) float64) func(args []number) (number, error) {
+	return func(args []number) (number, error) {
+		if !args[0].isFloat {
+			return args[0], nil
+		}
+		value, err := floatToInt64(f(args[0].float))
+		return integerNumber(value), err
+	}
+}
+
+// pickFunction returns a function that gives the argument better than all the others: min() and max()
+func pickFunction(better func(n, best float64) bool) func(args []number) (number, error) {
+	return func(args []number) (number, error) {
+		best := args[0]
+		for _, arg := range args[1:] {
+			if better(arg.toFloat(), best.toFloat()) {
+				best = arg
+			}
+		}
+		return best, nil
+	}
+}
+
+func absFunction(args []number) (number, error) {
+	if args[0].isFloat {
+		return floatNumber(math.Abs(args[0].float)), nil
+	}
+	if args[0].integer < 0 {
+		return integerNumber(-args[0].integer), nil
+	}
+	return args[0], nil
+}
@@ -0,0 +1,94 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// prgHeaderSize is the size of the load address at the start of a PRG file, which .INCPRG skips
+const prgHeaderSize = 2
+
+// incbinDirective returns the bytes .INCBIN or .INCPRG includes, in either pass:
+//
+//	.INCBIN "sprites.bin"            ; The whole file
+//	.INCBIN "charset.bin", 256       ; From offset 256 to the end
+//	.INCBIN "charset.bin", 256, 512  ; 512 bytes from offset 256
+//	.INCPRG "tune.prg"               ; The file without its 2 byte load address
+//
+// The offset of .INCPRG counts from the end of the load address. The file is read through the resolver
+// AssembleFile was given, once for each assembly; the offset and length must be known in the first pass.
+func (a *Assembler) incbinDirective(asmTokens *Tokens, skipHeader bool) ([]byte, error) {
+	t := asmTokens.Current()
+	directive := ".INCBIN"
+	if skipHeader {
+		directive = ".INCPRG"
+	}
+
+	nameToken := asmTokens.Next()
+	name, _ := nameToken.Value.(string)
+	if nameToken.ID != lexer.StringLiteral {
+		return nil, fmt.Errorf("[incbinDirective] expected a file name after %s (line %d)", directive, t.SourceLine)
+	}
+	data, err := a.binaryFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("[incbinDirective] %w (line %d)", err, t.SourceLine)
+	}
+	if skipHeader {
+		if len(data) < prgHeaderSize {
+			return nil, fmt.Errorf("[incbinDirective] '%s' is too short for a PRG file (line %d)", name, t.SourceLine)
+		}
+		data = data[prgHeaderSize:]
+	}
+
+	var arguments []int64
+	for asmTokens.Peek().ID == CommaToken && len(arguments) < 2 {
+		asmTokens.Next() // Consume the comma
+		asmTokens.Next()
+		value, err := a.EvaluateExpression(asmTokens, "", false) // Known in the first pass, so both passes include the same bytes
+		if err != nil {
+			return nil, fmt.Errorf("[incbinDirective] %w (line %d)", err, t.SourceLine)
+		}
+		arguments = append(arguments, value)
+	}
+	if !isTerminatorToken(asmTokens.Peek().ID) {
+		return nil, fmt.Errorf("[incbinDirective] unexpected '%s' after %s (line %d)", asmTokens.Peek().Literal, directive, t.SourceLine)
+	}
+
+	offset, length := int64(0), int64(len(data))
+	if len(arguments) > 0 {
+		offset = arguments[0]
+		length -= offset
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return nil, fmt.Errorf("[incbinDirective] offset %d is outside '%s', which is %d bytes (line %d)", offset, name, len(data), t.SourceLine)
+	}
+	if len(arguments) > 1 {
+		length = arguments[1]
+	}
+	if length < 0 || offset+length > int64(len(data)) {
+		return nil, fmt.Errorf("[incbinDirective] %d bytes from offset %d runs past the end of '%s', which is %d bytes (line %d)", length, offset, name, len(data), t.SourceLine)
+	}
+	return data[offset : offset+length], nil
+}
+
+// binaryFile returns the contents of a file .INCBIN includes, reading it the first time it is included.
+func (a *Assembler) binaryFile(name string) ([]byte, error) {
+	if data, found := a.binaryFiles[name]; found {
+		return data, nil
+	}
+	if a.fileResolver == nil {
+		return nil, fmt.Errorf("cannot include '%s' without a file resolver, assemble it with AssembleFile", name)
+	}
+	reader, err := a.fileResolver.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading '%s': %w", name, err)
+	}
+	a.binaryFiles[name] = data
+	return data, nil
+}
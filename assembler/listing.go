@@ -0,0 +1,211 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// DefaultListingBytes is the number of bytes a listing shows on each line unless told otherwise
+const DefaultListingBytes = 4
+
+// ListingOptions controls the layout of a listing
+type ListingOptions struct {
+	BytesPerLine int // Bytes shown on a line; the rest continue on lines of their own below it
+}
+
+// assembledLine is a line of source as the second pass assembled it, once for each time it was assembled
+type assembledLine struct {
+	origin      origin
+	line        uint
+	column      uint
+	address     uint16
+	data        []byte
+	instruction *cpu.OpCodeDef // The instruction assembled, nil for other lines
+	scope       string         // The qualified name of the innermost block it is in
+}
+
+// listingRow is a row of a listing, its columns formatted
+type listingRow struct {
+	address, bytes, cycles, location, text string
+}
+
+// recordLine starts a new assembled line at the first token of each line the second pass assembles.
+func (a *Assembler) recordLine(asmTokens *Tokens) {
+	t, index := asmTokens.Current(), asmTokens.Index()
+	if isTerminatorToken(t.ID) || index >= len(a.origins) || (index > 0 && asmTokens.All()[index-1].ID != lexer.EndOfLineType) {
+		return
+	}
+	a.finishLine()
+	a.assembledLines = append(a.assembledLines, assembledLine{
+		origin:  a.origins[index],
+		line:    t.SourceLine,
+		column:  t.SourceColumn,
+		address: a.programCounter,
+		scope:   strings.TrimSuffix(a.scopePrefix(), scopeSeparator),
+	})
+}
+
+// finishLine gives the line being assembled, if it wrote no bytes, the address it leaves the program counter
+// at, so that an origin shows the address it sets.
+func (a *Assembler) finishLine() {
+	if len(a.assembledLines) > 0 {
+		if line := &a.assembledLines[len(a.assembledLines)-1]; len(line.data) == 0 {
+			line.address = a.programCounter
+		}
+	}
+}
+
+// recordData adds bytes written to memory to the line being assembled.
+func (a *Assembler) recordData(data []byte) {
+	if len(a.assembledLines) > 0 {
+		line := &a.assembledLines[len(a.assembledLines)-1]
+		line.data = append(line.data, data...)
+	}
+}
+
+// recordInstruction notes the instruction the line being assembled holds.
+func (a *Assembler) recordInstruction(instruction *cpu.OpCodeDef) {
+	if len(a.assembledLines) > 0 {
+		a.assembledLines[len(a.assembledLines)-1].instruction = instruction
+	}
+}
+
+// WriteListing writes a listing of the last assembly: each line of source with its file and line number,
+// and, for the lines that were assembled, the address, the bytes and an instruction's cycle count. The
+// lines of included files follow their .include, and the lines a macro expands to follow its invocation,
+// marked with a '+' for each level of macro. A line assembled more than once, in a loop, is listed each
+// time. The listing ends with the symbol table.
+func (a *Assembler) WriteListing(w io.Writer, options ListingOptions) error {
+	bytesPerLine := options.BytesPerLine
+	if bytesPerLine <= 0 {
+		bytesPerLine = DefaultListingBytes
+	}
+
+	var rows []listingRow
+	listed := make(map[*sourceFile]uint) // The last line listed of each file
+	var fill func(f *sourceFile, through uint)
+	fill = func(f *sourceFile, through uint) {
+		if f.includedFrom != nil {
+			fill(f.includedFrom, f.includeLine) // A file's lines follow the .include that read it
+		}
+		for line := listed[f] + 1; line <= through && int(line) <= len(f.lines); line++ {
+			rows = append(rows, listingRow{location: f.location(line), text: f.text(line)})
+		}
+		listed[f] = max(listed[f], through)
+	}
+	// leave lists the rest of a file the listing has moved on from, and of the files that included it,
+	// up to the one the listing has moved to
+	leave := func(from, to *sourceFile) {
+		for f := from; f != nil && !f.includes(to); f = f.includedFrom {
+			fill(f, uint(len(f.lines)))
+		}
+	}
+
+	var current *sourceFile
+	for _, l := range a.assembledLines {
+		file, depth := l.origin.file, 0
+		if file == nil {
+			continue
+		}
+		if e := l.origin.expansion; e != nil {
+			for depth = 1; e.parent != nil; depth++ {
+				e = e.parent
+			}
+			leave(current, e.file)
+			fill(e.file, e.line) // Up to and including the invocation
+			current = e.file
+		} else {
+			leave(current, file)
+			fill(file, l.line-1)
+			listed[file] = max(listed[file], l.line)
+			current = file
+		}
+
+		row := listingRow{address: fmt.Sprintf("%04X", l.address), location: file.location(l.line), text: strings.Repeat("+", depth) + file.text(l.line)}
+		if l.instruction != nil {
+			row.cycles = fmt.Sprint(l.instruction.Cycles)
+		}
+		row.bytes = hexBytes(l.data[:min(len(l.data), bytesPerLine)])
+		rows = append(rows, row)
+		for offset := bytesPerLine; offset < len(l.data); offset += bytesPerLine {
+			rows = append(rows, listingRow{
+				address: fmt.Sprintf("%04X", l.address+uint16(offset)),
+				bytes:   hexBytes(l.data[offset:min(len(l.data), offset+bytesPerLine)]),
+			})
+		}
+	}
+	if current == nil && len(a.origins) > 0 {
+		current = a.origins[len(a.origins)-1].file // The main file, whose end of file token comes last
+	}
+	leave(current, nil)
+
+	locationWidth := 0
+	for _, row := range rows {
+		locationWidth = max(locationWidth, len(row.location))
+	}
+	for _, row := range rows {
+		line := fmt.Sprintf("%-4s  %-*s  %3s  %-*s  %s", row.address, bytesPerLine*3-1, row.bytes, row.cycles, locationWidth, row.location, row.text)
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return a.writeSymbolTable(w)
+}
+
+// writeSymbolTable writes the labels and constants, in alphabetical order, for the end of a listing.
+func (a *Assembler) writeSymbolTable(w io.Writer) error {
+	type symbol struct {
+		name, value, kind string
+	}
+	var symbols []symbol
+	for name, address := range a.labels {
+		symbols = append(symbols, symbol{name, fmt.Sprintf("$%04X", address), "label"})
+	}
+	for name, value := range a.constants {
+		symbols = append(symbols, symbol{name, constantString(value), "constant"})
+	}
+	slices.SortFunc(symbols, func(x, y symbol) int { return strings.Compare(x.name, y.name) })
+
+	nameWidth, valueWidth := 0, 0
+	for _, s := range symbols {
+		nameWidth, valueWidth = max(nameWidth, len(s.name)), max(valueWidth, len(s.value))
+	}
+	if _, err := fmt.Fprintf(w, "\nSymbols:\n"); err != nil {
+		return err
+	}
+	for _, s := range symbols {
+		if _, err := fmt.Fprintf(w, "  %-*s  %-*s  %s\n", nameWidth, s.name, valueWidth, s.value, s.kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// constantString formats the value of a constant: an integer in hex, a negative one in decimal, or a float
+func constantString(value any) string {
+	if f, ok := value.(float64); ok {
+		return fmt.Sprint(f)
+	}
+	i, err := toInt64(value)
+	switch {
+	case err != nil:
+		return fmt.Sprint(value)
+	case i < 0:
+		return fmt.Sprint(i)
+	}
+	return fmt.Sprintf("$%02X", i)
+}
+
+// hexBytes formats bytes as two digit hex numbers separated by spaces
+func hexBytes(data []byte) string {
+	hex := make([]string, len(data))
+	for i, b := range data {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, " ")
+}
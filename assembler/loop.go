@@ -0,0 +1,226 @@
+package assembler
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// maxLoopIterations limits the iterations of a loop, which stops a .WHILE whose condition never becomes
+// false assembling forever
+const maxLoopIterations = 65536
+
+// loopEnds maps each loop directive to the directive that ends it
+var loopEnds = map[int]int{
+	ReptDirective:  EndReptDirective,
+	ForDirective:   EndForDirective,
+	WhileDirective: EndWhileDirective,
+}
+
+// loopDirective unrolls the .REPT, .FOR or .WHILE block at the current token during the first pass,
+// replacing it in the token stream with a copy of its body for each iteration. The first pass carries on
+// through the copies, so the program counter and labels are tracked as if they had been written out, and
+// the second pass assembles the same tokens. In each copy a .FOR loop's variable is replaced by its value,
+// and the labels and constants the body defines get the iteration as a suffix: the loop variable's value
+// for .FOR, counting from 0 for .REPT and .WHILE.
+//
+//	.FOR row = 0 TO 24 STEP 8
+//	line:  STA $0400 + row * 40     ; line_0, line_8, line_16, line_24
+//	.ENDFOR
+//
+// A .WHILE is unrolled one iteration at a time, its body followed by the .WHILE block again, so that its
+// condition sees the .VAR assignments made by the iterations before it.
+func (a *Assembler) loopDirective(asmTokens *Tokens, directive int) error {
+	t := asmTokens.Current()
+	name := strings.ToUpper(t.Literal)
+	tokens := asmTokens.All()
+	start := asmTokens.Index() - 1 // The period before the directive
+	bodyStart, bodyEnd, end, err := a.loopBlock(tokens, asmTokens.Index(), directive)
+	if err != nil {
+		return err
+	}
+	body := tokens[bodyStart:bodyEnd]
+
+	var replacement []lexer.Token
+	var replacementOrigins []origin
+	repeat := func(copied []lexer.Token, from int) {
+		replacement = append(replacement, copied...)
+		if len(a.origins) == len(tokens) {
+			replacementOrigins = append(replacementOrigins, a.origins[from:from+len(copied)]...)
+		}
+	}
+	switch directive {
+	case ReptDirective:
+		count, err := a.loopExpression(asmTokens, t)
+		if err != nil {
+			return err
+		}
+		if count < 0 || count > maxLoopIterations {
+			return fmt.Errorf("[loopDirective] .REPT count %d is not 0-%d (line %d)", count, maxLoopIterations, t.SourceLine)
+		}
+		for i := int64(0); i < count; i++ {
+			repeat(a.loopBody(body, "", 0, i), bodyStart)
+		}
+
+	case ForDirective:
+		variable, from, to, step, err := a.forHeader(asmTokens, t)
+		if err != nil {
+			return err
+		}
+		for value, count := from, 0; (step > 0 && value <= to) || (step < 0 && value >= to); value += step {
+			if count++; count > maxLoopIterations {
+				return fmt.Errorf("[loopDirective] .FOR loop has more than %d iterations (line %d)", maxLoopIterations, t.SourceLine)
+			}
+			repeat(a.loopBody(body, variable, value, value), bodyStart)
+		}
+
+	case WhileDirective:
+		condition, err := a.loopExpression(asmTokens, t)
+		if err != nil {
+			return err
+		}
+		if condition != 0 {
+			iteration, _ := t.Value.(int64) // Iterations so far, recorded on the directive of the copy that follows each one
+			if iteration >= maxLoopIterations {
+				return fmt.Errorf("[loopDirective] .WHILE loop has more than %d iterations (line %d)", maxLoopIterations, t.SourceLine)
+			}
+			repeat(a.loopBody(body, "", 0, iteration), bodyStart)
+			again := slices.Clone(tokens[start:end])
+			again[1].Value = iteration + 1
+			repeat(again, start)
+		}
+	}
+	if !isTerminatorToken(asmTokens.Peek().ID) {
+		return fmt.Errorf("[loopDirective] unexpected '%s' after .%s (line %d)", asmTokens.Peek().Literal, name, t.SourceLine)
+	}
+
+	// Start the copies on a line of their own; the end of the block's last line follows them
+	eol := lexer.NewToken(lexer.EndOfLineType, "\n", nil)
+	eol.SourceLine = t.SourceLine
+	a.spliceTokens(asmTokens, start, end, append([]lexer.Token{eol}, replacement...), replacementOrigins)
+	return nil
+}
+
+// loopBlock finds the end of the loop whose directive name is at tokens[i] and returns the indexes of the
+// start and end of its body and the end of line of the directive that closes it.
+func (a *Assembler) loopBlock(tokens []lexer.Token, i int, directive int) (int, int, int, error) {
+	type openLoop struct {
+		directive int
+		line      uint
+	}
+	open := []openLoop{{directive, tokens[i].SourceLine}}
+	bodyStart := endOfLine(tokens, i) + 1
+	for j := bodyStart; j < len(tokens); j++ {
+		switch d := a.directiveAt(tokens, j); d {
+		case ReptDirective, ForDirective, WhileDirective:
+			open = append(open, openLoop{d, tokens[j].SourceLine})
+		case EndReptDirective, EndForDirective, EndWhileDirective:
+			innermost := open[len(open)-1]
+			if loopEnds[innermost.directive] != d {
+				return 0, 0, 0, fmt.Errorf("[loopBlock] .%s does not close the %s at line %d (line %d)", strings.ToUpper(tokens[j+1].Literal), a.directiveName(innermost.directive), innermost.line, tokens[j].SourceLine)
+			}
+			if open = open[:len(open)-1]; len(open) == 0 {
+				return bodyStart, j, endOfLine(tokens, j), nil
+			}
+		}
+	}
+	innermost := open[len(open)-1]
+	return 0, 0, 0, fmt.Errorf("[loopBlock] missing %s for %s (line %d)", a.directiveName(loopEnds[innermost.directive]), a.directiveName(innermost.directive), innermost.line)
+}
+
+// directiveName returns the first name, in alphabetical order, of a directive
+func (a *Assembler) directiveName(directive int) string {
+	var names []string
+	for name, d := range a.directives {
+		if d == directive {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names[0]
+}
+
+// loopExpression evaluates the expression after a loop directive. Loops are unrolled in the first pass,
+// so every symbol in it must already be defined.
+func (a *Assembler) loopExpression(asmTokens *Tokens, directive lexer.Token) (int64, error) {
+	if isTerminatorToken(asmTokens.Peek().ID) {
+		return 0, fmt.Errorf("[loopExpression] expected an expression after .%s (line %d)", strings.ToUpper(directive.Literal), directive.SourceLine)
+	}
+	asmTokens.Next()
+	value, err := a.EvaluateExpression(asmTokens, "", false)
+	if err != nil {
+		return 0, fmt.Errorf("[loopExpression] .%s (line %d): %w", strings.ToUpper(directive.Literal), directive.SourceLine, err)
+	}
+	return value, nil
+}
+
+// forHeader parses the variable, bounds and step of a .FOR loop: name = from TO to [STEP step].
+func (a *Assembler) forHeader(asmTokens *Tokens, directive lexer.Token) (string, int64, int64, int64, error) {
+	variable := asmTokens.Next()
+	if variable.ID != IdentifierToken || asmTokens.Peek().ID != EqualsSymbolToken {
+		return "", 0, 0, 0, fmt.Errorf("[forHeader] expected 'variable = start TO end' after .FOR (line %d)", directive.SourceLine)
+	}
+	asmTokens.Next() // Consume the equals
+	from, err := a.loopExpression(asmTokens, directive)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	if keyword := asmTokens.Peek(); keyword.ID != IdentifierToken || !strings.EqualFold(keyword.Literal, "TO") {
+		return "", 0, 0, 0, fmt.Errorf("[forHeader] expected TO after the start of the .FOR loop (line %d)", directive.SourceLine)
+	}
+	asmTokens.Next()
+	to, err := a.loopExpression(asmTokens, directive)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	step := int64(1)
+	if keyword := asmTokens.Peek(); keyword.ID == IdentifierToken && strings.EqualFold(keyword.Literal, "STEP") {
+		asmTokens.Next()
+		if step, err = a.loopExpression(asmTokens, directive); err != nil {
+			return "", 0, 0, 0, err
+		}
+		if step == 0 {
+			return "", 0, 0, 0, fmt.Errorf("[forHeader] .FOR loop STEP is 0 (line %d)", directive.SourceLine)
+		}
+	}
+	return variable.Literal, from, to, step, nil
+}
+
+// loopBody returns a copy of a loop's body for one iteration, with the loop variable, if any, replaced by
+// its value and the labels and constants the body defines given the iteration's suffix.
+func (a *Assembler) loopBody(body []lexer.Token, variable string, value int64, suffix int64) []lexer.Token {
+	locals := definedSymbols(body, func(name string) bool { return name == variable })
+	tag := "_" + strings.ReplaceAll(strconv.FormatInt(suffix, 10), "-", "m")
+	for name := range locals {
+		a.generatedLabels[name+tag] = true
+	}
+	copied := make([]lexer.Token, 0, len(body))
+	for _, t := range body {
+		name := strings.TrimSuffix(t.Literal, ":")
+		switch {
+		case t.ID == IdentifierToken && variable != "" && t.Literal == variable:
+			literal := lexer.NewToken(lexer.IntegerLiteral, strconv.FormatInt(value, 10), value)
+			literal.SourceLine, literal.SourceColumn = t.SourceLine, t.SourceColumn
+			t = literal
+		case t.ID == IdentifierToken && locals[name]:
+			t.Literal = name + tag
+		case t.ID == LabelToken && locals[name]:
+			t.Literal = name + tag + ":"
+		}
+		copied = append(copied, t)
+	}
+	return copied
+}
+
+// spliceTokens replaces tokens[start:end] with replacement, and continues processing from the first token
+// of the replacement. origins are the origins of all but the first token of the replacement, which has the
+// origin of the tokens it replaces.
+func (a *Assembler) spliceTokens(asmTokens *Tokens, start, end int, replacement []lexer.Token, origins []origin) {
+	if len(a.origins) == len(asmTokens.All()) {
+		a.origins = slices.Replace(a.origins, start, end, append([]origin{a.origins[start]}, origins...)...)
+	}
+	asmTokens.Replace(start, end, replacement)
+}
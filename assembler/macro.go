@@ -0,0 +1,321 @@
+package assembler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// maxMacroDepth limits macros invoking macros
+const maxMacroDepth = 16
+
+// macro is a .MACRO definition: its parameters, in order, with any default values, and its body
+type macro struct {
+	name     string
+	params   []string
+	defaults map[string][]lexer.Token
+	body     []lexer.Token
+	file     *sourceFile // The file it is defined in
+}
+
+// macroExpansion records the invocation that produced a run of tokens, so that errors in the expanded
+// code can report both the line in the macro and the line that invoked it.
+type macroExpansion struct {
+	macro  *macro
+	file   *sourceFile     // File of the invocation
+	line   uint            // Line of the invocation
+	parent *macroExpansion // The expansion the invocation is part of, nil for the source itself
+}
+
+// expandMacros removes the .MACRO definitions from the token stream and replaces each invocation with
+// the macro's body, its parameters substituted by the arguments. Labels and constants defined in a body
+// are renamed for each expansion so that a macro can be invoked more than once.
+//
+// Macros are expanded before conditional assembly and loops, so a macro cannot end its own recursion
+// with .IF: every invocation in its body is expanded whatever the condition. A macro that invokes itself,
+// directly or through another macro, is an error.
+//
+//	.MACRO add16 dest, value=1
+//	  CLC
+//	  ...
+//	.ENDM
+//	  add16 $FB, 2
+//	  add16 value=$100, dest=$FD
+func (a *Assembler) expandMacros(tokens []lexer.Token, origins []origin) ([]lexer.Token, error) {
+	expanded, expandedOrigins, err := a.expandTokens(tokens, origins, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	a.origins = expandedOrigins
+	return expanded, nil
+}
+
+// expandTokens expands the macro definitions and invocations in tokens, produced by parent, and returns
+// the expanded tokens with the origin of each one.
+func (a *Assembler) expandTokens(tokens []lexer.Token, tokenOrigins []origin, parent *macroExpansion, depth int) ([]lexer.Token, []origin, error) {
+	var out []lexer.Token
+	var origins []origin
+	lineStart := true
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch directive := a.directiveAt(tokens, i); {
+		case directive == MacroDirective:
+			next, err := a.defineMacro(tokens, i, tokenOrigins[i].file)
+			if err != nil {
+				return nil, nil, err
+			}
+			i = next
+			lineStart = true
+			continue
+
+		case directive == EndMacroDirective:
+			return nil, nil, fmt.Errorf("[expandMacros] .ENDM without .MACRO (line %d)", t.SourceLine)
+
+		case lineStart && t.ID == IdentifierToken && a.macros[t.Literal] != nil:
+			m := a.macros[t.Literal]
+			end := endOfLine(tokens, i)
+			expansion := &macroExpansion{macro: m, file: tokenOrigins[i].file, line: t.SourceLine, parent: parent}
+			for e := parent; e != nil; e = e.parent {
+				if e.macro == m {
+					return nil, nil, a.macroError(t, parent, fmt.Errorf("[expandMacros] macro %s invokes itself, recursive macros are not supported (line %d)", m.name, t.SourceLine))
+				}
+			}
+			if depth >= maxMacroDepth {
+				return nil, nil, a.macroError(t, parent, fmt.Errorf("[expandMacros] macro %s nested more than %d deep (line %d)", m.name, maxMacroDepth, t.SourceLine))
+			}
+			body, err := a.substituteMacro(m, tokens[i+1:end], expansion)
+			if err != nil {
+				return nil, nil, a.macroError(t, parent, err)
+			}
+			bodyOrigins := make([]origin, len(body))
+			for j := range bodyOrigins {
+				bodyOrigins[j] = origin{file: m.file, expansion: expansion}
+			}
+			expanded, expandedOrigins, err := a.expandTokens(body, bodyOrigins, expansion, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, expanded...)
+			origins = append(origins, expandedOrigins...)
+			i = end - 1 // The invocation's end of line follows the expansion
+			continue
+		}
+
+		out = append(out, t)
+		origins = append(origins, tokenOrigins[i])
+		switch t.ID {
+		case lexer.EndOfLineType:
+			lineStart = true
+		case LabelToken:
+			// A label can precede an invocation; separate it so the body starts on a line of its own
+			if lineStart && i+1 < len(tokens) && a.macros[tokens[i+1].Literal] != nil && tokens[i+1].ID == IdentifierToken {
+				eol := lexer.NewToken(lexer.EndOfLineType, "\n", nil)
+				eol.SourceLine = t.SourceLine
+				out = append(out, eol)
+				origins = append(origins, tokenOrigins[i])
+			}
+		default:
+			lineStart = false
+		}
+	}
+	return out, origins, nil
+}
+
+// directiveAt returns the directive at tokens[i], or 0 if there is none.
+func (a *Assembler) directiveAt(tokens []lexer.Token, i int) int {
+	if tokens[i].ID != PeriodToken || i+1 >= len(tokens) || tokens[i+1].ID != IdentifierToken {
+		return 0
+	}
+	return a.directives["."+strings.ToUpper(tokens[i+1].Literal)]
+}
+
+// endOfLine returns the index of the end of line or end of file token that ends the line at tokens[i].
+func endOfLine(tokens []lexer.Token, i int) int {
+	for i < len(tokens) && tokens[i].ID != lexer.EndOfLineType && tokens[i].ID != lexer.EOFType {
+		i++
+	}
+	return i
+}
+
+// defineMacro records the macro defined by the .MACRO directive at tokens[i], in file, and returns the
+// index of the last token of its .ENDM line.
+func (a *Assembler) defineMacro(tokens []lexer.Token, i int, file *sourceFile) (int, error) {
+	directive := tokens[i]
+	end := endOfLine(tokens, i)
+	header := tokens[i+2 : end]
+	if len(header) == 0 || header[0].ID != IdentifierToken {
+		return 0, fmt.Errorf("[defineMacro] expected a macro name after .MACRO (line %d)", directive.SourceLine)
+	}
+	name := header[0].Literal
+	if _, found := a.macros[name]; found {
+		return 0, fmt.Errorf("[defineMacro] duplicate macro '%s' (line %d)", name, directive.SourceLine)
+	}
+
+	m := &macro{name: name, defaults: make(map[string][]lexer.Token), file: file}
+	for _, param := range splitArguments(header[1:]) {
+		if len(param) == 0 || param[0].ID != IdentifierToken || (len(param) > 1 && param[1].ID != EqualsSymbolToken) {
+			return 0, fmt.Errorf("[defineMacro] invalid parameter in macro '%s' (line %d)", name, directive.SourceLine)
+		}
+		paramName := param[0].Literal
+		if slices.Contains(m.params, paramName) {
+			return 0, fmt.Errorf("[defineMacro] duplicate parameter '%s' in macro '%s' (line %d)", paramName, name, directive.SourceLine)
+		}
+		m.params = append(m.params, paramName)
+		if len(param) > 1 {
+			if len(param) == 2 {
+				return 0, fmt.Errorf("[defineMacro] expected a default value for parameter '%s' in macro '%s' (line %d)", paramName, name, directive.SourceLine)
+			}
+			m.defaults[paramName] = param[2:]
+		}
+	}
+
+	for j := end + 1; j < len(tokens); j = endOfLine(tokens, j) + 1 {
+		switch a.directiveAt(tokens, j) {
+		case MacroDirective:
+			return 0, fmt.Errorf("[defineMacro] .MACRO inside macro '%s' (line %d)", name, tokens[j].SourceLine)
+		case EndMacroDirective:
+			m.body = tokens[end+1 : j]
+			a.macros[name] = m
+			return endOfLine(tokens, j), nil
+		}
+	}
+	return 0, fmt.Errorf("[defineMacro] missing .ENDM for macro '%s' (line %d)", name, directive.SourceLine)
+}
+
+// splitArguments splits a comma separated list of arguments, ignoring commas inside parentheses.
+func splitArguments(tokens []lexer.Token) [][]lexer.Token {
+	if len(tokens) == 0 {
+		return nil
+	}
+	var args [][]lexer.Token
+	start, depth := 0, 0
+	for i, t := range tokens {
+		switch t.ID {
+		case LeftParenthesis:
+			depth++
+		case RightParenthesis:
+			depth--
+		case CommaToken:
+			if depth == 0 {
+				args = append(args, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, tokens[start:])
+}
+
+// substituteMacro returns the body of a macro for one invocation: the parameters replaced by the
+// arguments, given by position and then by name, and the labels and constants it defines renamed.
+func (a *Assembler) substituteMacro(m *macro, argTokens []lexer.Token, expansion *macroExpansion) ([]lexer.Token, error) {
+	args := make(map[string][]lexer.Token)
+	named := false
+	for i, arg := range splitArguments(argTokens) {
+		if len(arg) >= 2 && arg[0].ID == IdentifierToken && arg[1].ID == EqualsSymbolToken {
+			if !slices.Contains(m.params, arg[0].Literal) {
+				return nil, fmt.Errorf("[substituteMacro] macro '%s' has no parameter '%s' (line %d)", m.name, arg[0].Literal, expansion.line)
+			}
+			args[arg[0].Literal] = arg[2:]
+			named = true
+			continue
+		}
+		if named {
+			return nil, fmt.Errorf("[substituteMacro] positional argument after named arguments to macro '%s' (line %d)", m.name, expansion.line)
+		}
+		if i >= len(m.params) {
+			return nil, fmt.Errorf("[substituteMacro] too many arguments to macro '%s' (line %d)", m.name, expansion.line)
+		}
+		args[m.params[i]] = arg
+	}
+	for _, param := range m.params {
+		if len(args[param]) == 0 {
+			args[param] = m.defaults[param]
+		}
+		if len(args[param]) == 0 {
+			return nil, fmt.Errorf("[substituteMacro] missing argument '%s' to macro '%s' (line %d)", param, m.name, expansion.line)
+		}
+	}
+
+	a.macroCount++
+	locals := a.macroLocals(m)
+	for name := range locals {
+		a.generatedLabels[fmt.Sprintf("%s@%d", name, a.macroCount)] = true
+	}
+	var body []lexer.Token
+	for _, t := range m.body {
+		if arg, found := args[t.Literal]; found && t.ID == IdentifierToken {
+			for _, argToken := range arg {
+				argToken.SourceLine, argToken.SourceColumn = t.SourceLine, t.SourceColumn
+				body = append(body, argToken)
+			}
+			continue
+		}
+		if arg, found := args[strings.TrimSuffix(t.Literal, ":")]; found && t.ID == LabelToken && len(arg) == 1 && arg[0].ID == IdentifierToken {
+			t.Literal = arg[0].Literal + ":" // A label named by an argument
+		} else if name := strings.TrimSuffix(t.Literal, ":"); locals[name] && (t.ID == IdentifierToken || t.ID == LabelToken) {
+			t.Literal = fmt.Sprintf("%s@%d", name, a.macroCount)
+			if t.ID == LabelToken {
+				t.Literal += ":"
+			}
+		}
+		body = append(body, t)
+	}
+	return body, nil
+}
+
+// macroLocals returns the labels and constants a macro's body defines, other than its parameters and
+// invocations of other macros.
+func (a *Assembler) macroLocals(m *macro) map[string]bool {
+	return definedSymbols(m.body, func(name string) bool {
+		return slices.Contains(m.params, name) || a.macros[name] != nil
+	})
+}
+
+// definedSymbols returns the labels and constants a block of tokens defines: labels, and identifiers at
+// the start of a line, other than those excluded.
+func definedSymbols(tokens []lexer.Token, excluded func(name string) bool) map[string]bool {
+	symbols := make(map[string]bool)
+	lineStart := true
+	for _, t := range tokens {
+		if name := strings.TrimSuffix(t.Literal, ":"); (t.ID == LabelToken || (lineStart && t.ID == IdentifierToken)) && !excluded(name) {
+			symbols[name] = true
+		}
+		lineStart = t.ID == lexer.EndOfLineType || (lineStart && t.ID == LabelToken)
+	}
+	return symbols
+}
+
+// macroError adds the macro invocations that produced a token to an error about it.
+func (a *Assembler) macroError(t lexer.Token, expansion *macroExpansion, err error) error {
+	if expansion == nil {
+		return err
+	}
+	location := fmt.Sprintf("in macro %s at %s", expansion.macro.name, lineLocation(expansion.macro.file, t.SourceLine))
+	for e := expansion; e != nil; e = e.parent {
+		if e.parent != nil {
+			location += fmt.Sprintf(", invoked from macro %s at %s", e.parent.macro.name, lineLocation(e.file, e.line))
+		} else {
+			location += fmt.Sprintf(", invoked at %s", lineLocation(e.file, e.line))
+		}
+	}
+	return fmt.Errorf("%s: %w", location, err)
+}
+
+// lineLocation returns a line as file:line, or as "line n" when the file is not known
+func lineLocation(f *sourceFile, line uint) string {
+	if f == nil {
+		return fmt.Sprintf("line %d", line)
+	}
+	return f.location(line)
+}
+
+// statementError adds the macro invocations that produced the statement starting at token index to an
+// error from assembling it.
+func (a *Assembler) statementError(t lexer.Token, index int, err error) error {
+	if index < 0 || index >= len(a.origins) {
+		return err
+	}
+	return a.macroError(t, a.origins[index].expansion, err)
+}
@@ -489,3 +489,239 @@ func (d *D64Format) readFileFromD64(diskData []byte, startTrack, startSector uin
 
 	return fileData, nil
 }
+
+// D64File is an entry in the directory of a D64 disk image
+type D64File struct {
+	Name   string
+	Type   byte // File type, e.g. $82 for a closed PRG file
+	Track  uint8
+	Sector uint8
+	Blocks int
+}
+
+// D64 directory layout
+const (
+	d64DirTrack            = 18
+	d64DirEntrySize        = 32
+	d64DirEntriesPerSector = 8
+	d64FileTypePRG         = 0x82
+	d64MaxTrack            = 35
+)
+
+// Directory returns the files in a D64 disk image, following the directory's sector chain
+func (d *D64Format) Directory(diskData []byte) ([]D64File, error) {
+	var files []D64File
+	err := d.eachDirectoryEntry(diskData, func(_ uint8, entryOffset int) bool {
+		if diskData[entryOffset] != 0 {
+			files = append(files, d.directoryEntry(diskData, entryOffset))
+		}
+		return true
+	})
+	return files, err
+}
+
+// ReadFile returns the first file in a D64 disk image whose name matches pattern, as CBM DOS does:
+// '*' matches the rest of the name and '?' any one character. A PRG file's data starts with its
+// load address.
+func (d *D64Format) ReadFile(diskData []byte, pattern string) (D64File, []byte, error) {
+	if len(diskData) != D64TotalSize {
+		return D64File{}, nil, fmt.Errorf("invalid D64 image size: %d bytes, expected %d", len(diskData), D64TotalSize)
+	}
+	files, err := d.Directory(diskData)
+	if err != nil {
+		return D64File{}, nil, err
+	}
+	for _, f := range files {
+		if f.Type&0x80 == 0 || !MatchD64Name(pattern, f.Name) {
+			continue
+		}
+		data, err := d.readFileFromD64(diskData, f.Track, f.Sector)
+		if err != nil {
+			return D64File{}, nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		return f, data, nil
+	}
+	return D64File{}, nil, fmt.Errorf("file not found: %s", pattern)
+}
+
+// WriteFile adds a PRG file to a D64 disk image, allocating free sectors and a directory entry.
+// data should start with the load address. Sectors used by existing files are never reused, even
+// when the BAM does not record them.
+func (d *D64Format) WriteFile(diskData []byte, name string, data []byte) error {
+	if len(diskData) != D64TotalSize {
+		return fmt.Errorf("invalid D64 image size: %d bytes, expected %d", len(diskData), D64TotalSize)
+	}
+	if len(name) > D64MaxFilename {
+		name = name[:D64MaxFilename]
+	}
+	used, err := d.usedSectors(diskData)
+	if err != nil {
+		return err
+	}
+	files, _ := d.Directory(diskData)
+	for _, f := range files {
+		if f.Name == name {
+			return fmt.Errorf("file exists: %s", name)
+		}
+	}
+
+	// Find every block before writing, so a full disk is left unchanged
+	blocks := max(1, (len(data)+253)/254)
+	var chain [][2]uint8
+	for track := uint8(1); track <= d64MaxTrack && len(chain) < blocks; track++ {
+		if track == d64DirTrack {
+			continue
+		}
+		for sector := uint8(0); sector < d.getSectorsPerTrack(track) && len(chain) < blocks; sector++ {
+			if !used[[2]uint8{track, sector}] {
+				chain = append(chain, [2]uint8{track, sector})
+			}
+		}
+	}
+	if len(chain) < blocks {
+		return fmt.Errorf("disk full")
+	}
+	entryOffset, err := d.freeDirectoryEntry(diskData)
+	if err != nil {
+		return err
+	}
+
+	for i, ts := range chain {
+		blockOffset := d.trackSectorToOffset(ts[0], ts[1])
+		block := data[min(i*254, len(data)):min((i+1)*254, len(data))]
+		clear(diskData[blockOffset : blockOffset+D64BlockSize])
+		if i < len(chain)-1 {
+			diskData[blockOffset] = chain[i+1][0]
+			diskData[blockOffset+1] = chain[i+1][1]
+		} else {
+			diskData[blockOffset] = 0
+			diskData[blockOffset+1] = byte(len(block) + 1)
+		}
+		copy(diskData[blockOffset+2:], block)
+		d.allocateSector(diskData, ts[0], ts[1])
+	}
+
+	diskData[entryOffset] = d64FileTypePRG
+	diskData[entryOffset+1] = chain[0][0]
+	diskData[entryOffset+2] = chain[0][1]
+	copy(diskData[entryOffset+3:entryOffset+3+D64MaxFilename], d.padStringShifted(name, D64MaxFilename))
+	diskData[entryOffset+28] = byte(blocks)
+	diskData[entryOffset+29] = byte(blocks >> 8)
+	return nil
+}
+
+// MatchD64Name reports whether a file name matches a CBM DOS pattern: '*' matches the rest of the name
+// and '?' any one character
+func MatchD64Name(pattern, name string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '*':
+			return true
+		case i >= len(name):
+			return false
+		case pattern[i] != '?' && pattern[i] != name[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(name)
+}
+
+// eachDirectoryEntry calls f with the directory sector and offset of every directory entry until f
+// returns false
+func (d *D64Format) eachDirectoryEntry(diskData []byte, f func(sector uint8, entryOffset int) bool) error {
+	track, sector := uint8(d64DirTrack), uint8(1)
+	for visited := 0; track != 0; visited++ {
+		if track != d64DirTrack || sector >= d.getSectorsPerTrack(track) || visited == int(d.getSectorsPerTrack(d64DirTrack)) {
+			return fmt.Errorf("invalid directory sector: %d/%d", track, sector)
+		}
+		dirOffset := d.trackSectorToOffset(track, sector)
+		for i := 0; i < d64DirEntriesPerSector; i++ {
+			if !f(sector, dirOffset+2+i*d64DirEntrySize) {
+				return nil
+			}
+		}
+		track, sector = diskData[dirOffset], diskData[dirOffset+1]
+	}
+	return nil
+}
+
+// directoryEntry decodes the directory entry at entryOffset
+func (d *D64Format) directoryEntry(diskData []byte, entryOffset int) D64File {
+	name := diskData[entryOffset+3 : entryOffset+3+D64MaxFilename]
+	return D64File{
+		Name:   string(bytes.TrimRight(name, "\xA0")),
+		Type:   diskData[entryOffset],
+		Track:  diskData[entryOffset+1],
+		Sector: diskData[entryOffset+2],
+		Blocks: int(diskData[entryOffset+28]) | int(diskData[entryOffset+29])<<8,
+	}
+}
+
+// usedSectors returns every sector of the directory track and of the files in the directory
+func (d *D64Format) usedSectors(diskData []byte) (map[[2]uint8]bool, error) {
+	used := make(map[[2]uint8]bool)
+	for sector := uint8(0); sector < d.getSectorsPerTrack(d64DirTrack); sector++ {
+		used[[2]uint8{d64DirTrack, sector}] = true
+	}
+	files, err := d.Directory(diskData)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		track, sector := f.Track, f.Sector
+		for track != 0 && track <= d64MaxTrack && !used[[2]uint8{track, sector}] {
+			used[[2]uint8{track, sector}] = true
+			blockOffset := d.trackSectorToOffset(track, sector)
+			track, sector = diskData[blockOffset], diskData[blockOffset+1]
+		}
+	}
+	return used, nil
+}
+
+// freeDirectoryEntry returns the offset of an unused directory entry, extending the directory onto
+// another sector of the directory track when every entry is in use
+func (d *D64Format) freeDirectoryEntry(diskData []byte) (int, error) {
+	free := -1
+	dirSectors := make(map[uint8]bool)
+	var last uint8
+	err := d.eachDirectoryEntry(diskData, func(sector uint8, entryOffset int) bool {
+		dirSectors[sector], last = true, sector
+		if diskData[entryOffset] == 0 {
+			free = entryOffset
+			return false
+		}
+		return true
+	})
+	if err != nil || free >= 0 {
+		return free, err
+	}
+
+	// Sector 0 of the directory track holds the BAM
+	for sector := uint8(1); sector < d.getSectorsPerTrack(d64DirTrack); sector++ {
+		if dirSectors[sector] {
+			continue
+		}
+		newOffset := d.trackSectorToOffset(d64DirTrack, sector)
+		clear(diskData[newOffset : newOffset+D64BlockSize])
+		diskData[newOffset+1] = 0xFF
+		lastOffset := d.trackSectorToOffset(d64DirTrack, last)
+		diskData[lastOffset] = d64DirTrack
+		diskData[lastOffset+1] = sector
+		d.allocateSector(diskData, d64DirTrack, sector)
+		return newOffset + 2, nil
+	}
+	return -1, fmt.Errorf("directory full")
+}
+
+// allocateSector marks a sector as used in the BAM
+func (d *D64Format) allocateSector(diskData []byte, track, sector uint8) {
+	entry := d.trackSectorToOffset(d64DirTrack, 0) + 4*int(track)
+	bit := byte(1) << (sector % 8)
+	if diskData[entry+1+int(sector/8)]&bit == 0 {
+		return
+	}
+	diskData[entry+1+int(sector/8)] &^= bit
+	if diskData[entry] > 0 {
+		diskData[entry]--
+	}
+}
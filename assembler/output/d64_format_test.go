@@ -285,3 +285,65 @@ func TestD64Format_PadStringShifted(t *testing.T) {
 		}
 	}
 }
+
+func TestD64Format_WriteAndReadFile(t *testing.T) {
+	d64 := NewD64Format("TEST", "01")
+	disk, err := d64.CreateData([]assembler.AssembledData{
+		{StartAddress: 0x0801, Data: utils.Value(bytes.NewBuffer(make([]byte, 300)))},
+	})
+	if err != nil {
+		t.Fatalf("CreateData() error = %v", err)
+	}
+
+	// Fill the first directory sector, so the ninth file extends the directory
+	big := append([]byte{0x00, 0x10}, bytes.Repeat([]byte{0x55}, 600)...)
+	for i := 1; i <= 8; i++ {
+		if err := d64.WriteFile(disk, fmt.Sprintf("FILE%d", i), big); err != nil {
+			t.Fatalf("WriteFile(FILE%d) error = %v", i, err)
+		}
+	}
+	if err := d64.WriteFile(disk, "FILE1", big); err == nil {
+		t.Error("WriteFile() of an existing name should fail")
+	}
+
+	files, err := d64.Directory(disk)
+	if err != nil {
+		t.Fatalf("Directory() error = %v", err)
+	}
+	if len(files) != 9 || files[0].Name != "PROGRAM" || files[8].Name != "FILE8" || files[8].Blocks != 3 {
+		t.Errorf("Directory() = %+v", files)
+	}
+
+	_, program, err := d64.ReadFile(disk, "PROG*")
+	if err != nil || len(program) != 302 {
+		t.Errorf("ReadFile(PROG*) = %d bytes, error %v; the original file should be intact", len(program), err)
+	}
+	for i := 1; i <= 8; i++ {
+		f, data, err := d64.ReadFile(disk, fmt.Sprintf("FILE%d", i))
+		if err != nil || !bytes.Equal(data, big) {
+			t.Errorf("ReadFile(FILE%d) = %+v, %d bytes, error %v", i, f, len(data), err)
+		}
+	}
+	if _, _, err := d64.ReadFile(disk, "NOPE"); err == nil {
+		t.Error("ReadFile() of a missing file should fail")
+	}
+}
+
+func TestMatchD64Name(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"GAME", "GAME", true},
+		{"GAME", "GAMES", false},
+		{"GA*", "GAMES", true},
+		{"*", "ANYTHING", true},
+		{"G?ME", "GAME", true},
+		{"G?ME", "GAM", false},
+	}
+	for _, tt := range tests {
+		if got := MatchD64Name(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchD64Name(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
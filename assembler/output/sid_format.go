@@ -0,0 +1,245 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/utils"
+)
+
+var _ BinaryFormat = (*SIDFormat)(nil)
+
+// SID file format constants
+const (
+	SIDHeaderSize   = 0x7C // Size of a version 2 header
+	SIDVersion      = 2
+	SIDMaxSongs     = 256
+	SIDMaxString    = 32 // Name, author and released fields
+	PSIDMagic       = "PSID"
+	RSIDMagic       = "RSID"
+	SIDSpeedVBlank  = 0 // Play is called once per frame
+	SIDSpeedCIA     = 1 // Play is called at the rate of CIA 1 timer A
+	SIDFlagPAL      = 0x0004
+	SIDFlagNTSC     = 0x0008
+	SIDFlag6581     = 0x0010
+	SIDFlag8580     = 0x0020
+	sidClockMask    = SIDFlagPAL | SIDFlagNTSC
+	sidSpeedSongMax = 32 // Songs beyond 32 share the speed bit of song 32
+)
+
+// SIDHeader holds the fields of a PSID/RSID version 2 header.
+type SIDHeader struct {
+	Magic       string // PSIDMagic or RSIDMagic
+	Version     uint16
+	LoadAddress uint16
+	InitAddress uint16 // Called with the song number (0 based) in A
+	PlayAddress uint16 // Called once per frame; 0 if init installs its own interrupt handler
+	Songs       uint16
+	StartSong   uint16 // Default song, 1 based
+	Speed       uint32 // Bit n set: song n+1 is timed by CIA 1 timer A rather than the vertical blank
+	Name        string
+	Author      string
+	Released    string
+	Flags       uint16
+}
+
+// SongSpeed returns SIDSpeedVBlank or SIDSpeedCIA for a 1 based song number.
+func (h SIDHeader) SongSpeed(song int) int {
+	bit := min(max(song, 1), sidSpeedSongMax) - 1
+	if h.Magic == PSIDMagic && h.Speed&(1<<bit) != 0 {
+		return SIDSpeedCIA
+	}
+	return SIDSpeedVBlank
+}
+
+// NTSC returns whether the tune is timed for an NTSC machine.
+func (h SIDHeader) NTSC() bool {
+	return h.Flags&sidClockMask == SIDFlagNTSC
+}
+
+// SIDFormat implements the BinaryFormat interface for PSID tunes
+// SID format: big-endian header with the player entry points and credits, followed by the load
+// address (little-endian) and the tune's code and data
+type SIDFormat struct {
+	SIDHeader
+}
+
+// NewSIDFormat creates a new PSID format generator. An init address of 0 means the load address.
+func NewSIDFormat(name, author, released string, initAddr, playAddr uint16, songs, startSong uint16) *SIDFormat {
+	if songs == 0 {
+		songs = 1
+	}
+	if startSong == 0 || startSong > songs {
+		startSong = 1
+	}
+	return &SIDFormat{SIDHeader{
+		Magic:       PSIDMagic,
+		Version:     SIDVersion,
+		InitAddress: initAddr,
+		PlayAddress: playAddr,
+		Songs:       songs,
+		StartSong:   startSong,
+		Name:        truncate(name, SIDMaxString),
+		Author:      truncate(author, SIDMaxString),
+		Released:    truncate(released, SIDMaxString),
+		Flags:       SIDFlagPAL | SIDFlag6581,
+	}}
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// CreateFile creates a PSID file from assembled segments
+func (s *SIDFormat) CreateFile(filename string, segments []assembler.AssembledData, verbose bool) error {
+	data, err := s.CreateData(segments)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Printf("SID name: %s\n", s.Name)
+		fmt.Printf("SID load address: $%04X, init: $%04X, play: $%04X\n", s.LoadAddress, s.InitAddress, s.PlayAddress)
+		fmt.Printf("Songs: %d, start song: %d\n", s.Songs, s.StartSong)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create SID file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(data); err != nil {
+		return fmt.Errorf("failed to write SID data: %w", err)
+	}
+
+	return nil
+}
+
+// CreateData creates PSID format data in memory from assembled segments
+// Segments are merged into one block, with any gaps between them filled with zeros
+func (s *SIDFormat) CreateData(segments []assembler.AssembledData) ([]byte, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no segments to convert to SID")
+	}
+
+	data, loadAddr, err := NewPRGFormat().validateAndMergeContiguousSegments(segments)
+	if err != nil {
+		return nil, fmt.Errorf("SID format error: %w", err)
+	}
+	s.LoadAddress = loadAddr
+	if s.InitAddress == 0 {
+		s.InitAddress = loadAddr
+	}
+	if s.Songs == 0 || s.Songs > SIDMaxSongs {
+		return nil, fmt.Errorf("SID format error: song count must be 1-%d", SIDMaxSongs)
+	}
+
+	out := make([]byte, SIDHeaderSize, SIDHeaderSize+2+len(data))
+	copy(out[0x00:], PSIDMagic)
+	binary.BigEndian.PutUint16(out[0x04:], SIDVersion)
+	binary.BigEndian.PutUint16(out[0x06:], SIDHeaderSize)
+	binary.BigEndian.PutUint16(out[0x08:], 0) // The load address precedes the data
+	binary.BigEndian.PutUint16(out[0x0A:], s.InitAddress)
+	binary.BigEndian.PutUint16(out[0x0C:], s.PlayAddress)
+	binary.BigEndian.PutUint16(out[0x0E:], s.Songs)
+	binary.BigEndian.PutUint16(out[0x10:], s.StartSong)
+	binary.BigEndian.PutUint32(out[0x12:], s.Speed)
+	copy(out[0x16:0x36], s.Name)
+	copy(out[0x36:0x56], s.Author)
+	copy(out[0x56:0x76], s.Released)
+	binary.BigEndian.PutUint16(out[0x76:], s.Flags)
+
+	out = append(out, byte(loadAddr), byte(loadAddr>>8))
+	return append(out, data...), nil
+}
+
+// LoadFile loads a PSID or RSID file and returns its code and data as a single segment
+func (s *SIDFormat) LoadFile(filename string, verbose bool) ([]assembler.AssembledData, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SID file: %w", err)
+	}
+
+	header, segments, err := ParseSID(data)
+	if err != nil {
+		return nil, err
+	}
+	s.SIDHeader = header
+
+	if verbose {
+		fmt.Printf("%s: %s by %s (%s)\n", header.Magic, header.Name, header.Author, header.Released)
+		fmt.Printf("Load: $%04X, init: $%04X, play: $%04X\n", header.LoadAddress, header.InitAddress, header.PlayAddress)
+		fmt.Printf("Songs: %d, start song: %d\n", header.Songs, header.StartSong)
+	}
+
+	return segments, nil
+}
+
+// IsSID reports whether data starts with a PSID or RSID header
+func IsSID(data []byte) bool {
+	return len(data) >= 4 && (string(data[:4]) == PSIDMagic || string(data[:4]) == RSIDMagic)
+}
+
+// ParseSID decodes a PSID or RSID file of any version into its header and a single segment holding
+// the tune. When the header's load address is 0 the first two data bytes hold it.
+func ParseSID(data []byte) (SIDHeader, []assembler.AssembledData, error) {
+	if !IsSID(data) || len(data) < 0x76 {
+		return SIDHeader{}, nil, fmt.Errorf("invalid SID file: bad signature")
+	}
+	h := SIDHeader{
+		Magic:       string(data[0:4]),
+		Version:     binary.BigEndian.Uint16(data[0x04:]),
+		LoadAddress: binary.BigEndian.Uint16(data[0x08:]),
+		InitAddress: binary.BigEndian.Uint16(data[0x0A:]),
+		PlayAddress: binary.BigEndian.Uint16(data[0x0C:]),
+		Songs:       binary.BigEndian.Uint16(data[0x0E:]),
+		StartSong:   binary.BigEndian.Uint16(data[0x10:]),
+		Speed:       binary.BigEndian.Uint32(data[0x12:]),
+		Name:        sidString(data[0x16:0x36]),
+		Author:      sidString(data[0x36:0x56]),
+		Released:    sidString(data[0x56:0x76]),
+	}
+	dataOffset := int(binary.BigEndian.Uint16(data[0x06:]))
+	if h.Version >= 2 && len(data) >= 0x78 {
+		h.Flags = binary.BigEndian.Uint16(data[0x76:])
+	}
+	if dataOffset > len(data) {
+		return SIDHeader{}, nil, fmt.Errorf("invalid SID file: data offset $%04X beyond end of file", dataOffset)
+	}
+
+	tune := data[dataOffset:]
+	if h.LoadAddress == 0 {
+		if len(tune) < 2 {
+			return SIDHeader{}, nil, fmt.Errorf("invalid SID file: missing load address")
+		}
+		h.LoadAddress = uint16(tune[0]) | uint16(tune[1])<<8
+		tune = tune[2:]
+	}
+	if h.InitAddress == 0 {
+		h.InitAddress = h.LoadAddress
+	}
+	if h.StartSong == 0 {
+		h.StartSong = 1
+	}
+
+	segments := []assembler.AssembledData{
+		{
+			StartAddress: h.LoadAddress,
+			Data:         utils.Value(bytes.NewBuffer(tune)),
+		},
+	}
+	return h, segments, nil
+}
+
+// sidString decodes a zero padded header string
+func sidString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
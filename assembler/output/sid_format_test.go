@@ -0,0 +1,105 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/utils"
+)
+
+func TestSIDFormat_CreateData(t *testing.T) {
+	sid := NewSIDFormat("Test Tune", "Tester", "2026 Test", 0, 0x1003, 3, 2)
+	segments := []assembler.AssembledData{
+		{StartAddress: 0x1000, Data: utils.Value(bytes.NewBuffer([]byte{0x4C, 0x06, 0x10}))}, // JMP init
+		{StartAddress: 0x1003, Data: utils.Value(bytes.NewBuffer([]byte{0x60}))},             // RTS
+	}
+
+	data, err := sid.CreateData(segments)
+	if err != nil {
+		t.Fatalf("SIDFormat.CreateData() error = %v", err)
+	}
+	if len(data) != SIDHeaderSize+2+4 {
+		t.Fatalf("SIDFormat.CreateData() length = %d, want %d", len(data), SIDHeaderSize+6)
+	}
+	if string(data[0:4]) != PSIDMagic {
+		t.Errorf("magic = %q, want %q", data[0:4], PSIDMagic)
+	}
+	for offset, want := range map[int]uint16{
+		0x04: SIDVersion, 0x06: SIDHeaderSize, 0x08: 0, 0x0A: 0x1000, 0x0C: 0x1003, 0x0E: 3, 0x10: 2,
+		0x76: SIDFlagPAL | SIDFlag6581,
+	} {
+		if got := binary.BigEndian.Uint16(data[offset:]); got != want {
+			t.Errorf("header word at $%02X = $%04X, want $%04X", offset, got, want)
+		}
+	}
+	if got := sidString(data[0x16:0x36]); got != "Test Tune" {
+		t.Errorf("name = %q", got)
+	}
+	if data[SIDHeaderSize] != 0x00 || data[SIDHeaderSize+1] != 0x10 {
+		t.Errorf("load address = $%02X%02X, want $1000", data[SIDHeaderSize+1], data[SIDHeaderSize])
+	}
+
+	if _, err := sid.CreateData(nil); err == nil {
+		t.Error("SIDFormat.CreateData() with no segments should fail")
+	}
+}
+
+func TestSIDLoadFile(t *testing.T) {
+	sid := NewSIDFormat("Round Trip", "Author", "Released", 0x2000, 0x2003, 1, 1)
+	sid.Speed = 1
+	sid.Flags = SIDFlagNTSC | SIDFlag8580
+	file := filepath.Join(t.TempDir(), "tune.sid")
+	segments := []assembler.AssembledData{
+		{StartAddress: 0x2000, Data: utils.Value(bytes.NewBuffer([]byte{0x60, 0xEA, 0xEA, 0x60}))},
+	}
+	if err := sid.CreateFile(file, segments, false); err != nil {
+		t.Fatalf("Failed to create SID file: %v", err)
+	}
+
+	loader := &SIDFormat{}
+	loaded, err := loader.LoadFile(file, false)
+	if err != nil {
+		t.Fatalf("Failed to load SID file: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0].StartAddress != 0x2000 || !bytes.Equal(loaded[0].Data.Bytes(), []byte{0x60, 0xEA, 0xEA, 0x60}) {
+		t.Errorf("loaded segments = %+v", loaded)
+	}
+	want := sid.SIDHeader
+	want.LoadAddress = 0x2000
+	if loader.SIDHeader != want {
+		t.Errorf("loaded header = %+v, want %+v", loader.SIDHeader, want)
+	}
+	if loader.SongSpeed(1) != SIDSpeedCIA || !loader.NTSC() {
+		t.Error("speed and clock flags were not preserved")
+	}
+}
+
+func TestParseSIDWithHeaderLoadAddress(t *testing.T) {
+	data := make([]byte, 0x76)
+	copy(data, RSIDMagic)
+	binary.BigEndian.PutUint16(data[0x04:], 1)
+	binary.BigEndian.PutUint16(data[0x06:], 0x76)
+	binary.BigEndian.PutUint16(data[0x08:], 0xC000)
+	data = append(data, 0xA9, 0x00, 0x60)
+
+	h, segments, err := ParseSID(data)
+	if err != nil {
+		t.Fatalf("ParseSID() error = %v", err)
+	}
+	if h.Magic != RSIDMagic || h.LoadAddress != 0xC000 || h.InitAddress != 0xC000 || h.StartSong != 1 {
+		t.Errorf("header = %+v", h)
+	}
+	if h.SongSpeed(1) != SIDSpeedVBlank {
+		t.Error("RSID tunes ignore the speed field")
+	}
+	if segments[0].Data.Len() != 3 {
+		t.Errorf("tune length = %d, want 3", segments[0].Data.Len())
+	}
+
+	if _, _, err := ParseSID([]byte("PRG file")); err == nil {
+		t.Error("ParseSID() should reject files without a SID header")
+	}
+}
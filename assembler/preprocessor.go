@@ -8,8 +8,9 @@ import (
 	"github.com/jrsteele09/go-lexer/lexer"
 )
 
-// preprocessor performs the first pass of assembly: calculate memory layout and collect labels
-func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error) {
+// preprocessor performs the first pass of assembly: calculate memory layout and collect labels. It returns
+// the tokens with the loops unrolled, for the second pass.
+func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, []lexer.Token, error) {
 	// Track memory segments that will be needed
 	type SegmentInfo struct {
 		StartAddress uint16
@@ -18,6 +19,10 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 	var currentSegmentStart uint16
 
 	a.programCounter = 0x0000
+	a.conditions = nil
+	a.resetScopes()
+	a.encoding = asciiEncoding
+	a.firstPass = true
 	currentSegmentStart = a.programCounter
 	currentSegmentSize := 0
 
@@ -45,12 +50,13 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 		if t.ID == lexer.EOFType {
 			break
 		}
+		start := asmTokens.Index()
 
 		switch t.ID {
 		case AsterixSymbolToken:
 			err := a.addressForAsterixOrgDirective(asmTokens, finalizeCurrentSegment)
 			if err != nil {
-				return nil, err
+				return nil, nil, a.statementError(t, start, err)
 			}
 			// Check if program counter changed (due to *=) and start new segment
 			if a.programCounter != currentSegmentStart+uint16(currentSegmentSize) {
@@ -61,7 +67,7 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 		case PeriodToken:
 			err := a.preprocessDirective(asmTokens, advanceProgramCounter, finalizeCurrentSegment)
 			if err != nil {
-				return nil, err
+				return nil, nil, a.statementError(t, start, err)
 			}
 			// Check if program counter changed (due to .ORG) and start new segment
 			if a.programCounter != currentSegmentStart+uint16(currentSegmentSize) {
@@ -71,27 +77,29 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 
 		case PlusToken, MinusToken:
 			if tokenPosition != 1 {
-				return nil, fmt.Errorf("[preprocessor] unexpected token '%s'", t.Literal)
+				return nil, nil, a.statementError(t, start, fmt.Errorf("[preprocessor] unexpected token '%s'", t.Literal))
 			}
 			// Handle shorthand labels (+ and -)
 			err := a.recordPlusOrMinusLabelAddress(t, asmTokens)
 			if err != nil {
-				return nil, err
+				return nil, nil, a.statementError(t, start, err)
 			}
 
 		case LabelToken:
-			err := a.recordLabelAddress(t)
+			err := a.recordLabelAddress(t, start)
 			if err != nil {
-				return nil, err
+				return nil, nil, a.statementError(t, start, err)
 			}
 
 		case MnemonicToken:
 			// Calculate instruction size
+			index := asmTokens.Index()
 			addressingMode, err := a.parseAddressingMode(t.Literal, asmTokens, true)
 			if err != nil {
-				return nil, err
+				return nil, nil, a.statementError(t, start, err)
 			}
 			instructionSize := 1 + len(addressingMode.Operands)
+			a.instructionSizes[index] = instructionSize
 			advanceProgramCounter(instructionSize)
 			tokenPosition = 0
 
@@ -101,15 +109,15 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 			if nextToken.ID == EqualsSymbolToken {
 				err := a.processConstantAssignment(t, asmTokens)
 				if err != nil {
-					return nil, err
+					return nil, nil, a.statementError(t, start, err)
 				}
 			} else if tokenPosition == 1 {
-				err := a.recordLabelAddress(t)
+				err := a.recordLabelAddress(t, start)
 				if err != nil {
-					return nil, err
+					return nil, nil, a.statementError(t, start, err)
 				}
 			} else {
-				return nil, fmt.Errorf("[preprocessor] unexpected identifier '%s'", t.Literal)
+				return nil, nil, a.statementError(t, start, fmt.Errorf("[preprocessor] unexpected identifier '%s'", t.Literal))
 			}
 		case lexer.EndOfLineType:
 			tokenPosition = 0 // Reset position on new line
@@ -120,6 +128,12 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 		}
 	}
 
+	if err := a.checkConditionalsClosed(); err != nil {
+		return nil, nil, err
+	}
+	if err := a.checkScopesClosed(); err != nil {
+		return nil, nil, err
+	}
 	finalizeCurrentSegment()
 
 	// Create segements with start address
@@ -130,7 +144,7 @@ func (a *Assembler) preprocessor(tokens []lexer.Token) ([]AssembledData, error)
 		}
 	}
 
-	return segments, nil
+	return segments, asmTokens.All(), nil
 }
 
 // preprocessDirective handles directive processing during the first pass
@@ -179,20 +193,55 @@ func (a *Assembler) preprocessDirective(asmTokens *Tokens, advanceProgramCounter
 					return err
 				}
 				advanceProgramCounter(size)
+			case BasicUpstartDirective:
+				size, err := a.calculateBasicUpstartDirectiveSize(asmTokens)
+				if err != nil {
+					return err
+				}
+				advanceProgramCounter(size)
 			case VarDirective:
 				err := a.processVarDirective(asmTokens)
 				if err != nil {
 					return err
 				}
+			case IfDirective, ElseIfDirective, ElseDirective, EndIfDirective, IfDefDirective, IfNDefDirective:
+				err := a.conditionalDirective(asmTokens, directiveID, true)
+				if err != nil {
+					return err
+				}
+			case ReptDirective, ForDirective, WhileDirective:
+				err := a.loopDirective(asmTokens, directiveID)
+				if err != nil {
+					return err
+				}
+			case ProcDirective, EndProcDirective, ScopeDirective, EndScopeDirective:
+				err := a.scopeDirective(asmTokens, directiveID, true)
+				if err != nil {
+					return err
+				}
+			case EncodingDirective:
+				err := a.encodingDirective(asmTokens)
+				if err != nil {
+					return err
+				}
+			case IncBinDirective, IncPrgDirective:
+				data, err := a.incbinDirective(asmTokens, directiveID == IncPrgDirective)
+				if err != nil {
+					return err
+				}
+				advanceProgramCounter(len(data))
+			case EndReptDirective, EndForDirective, EndWhileDirective:
+				return fmt.Errorf("[preprocessDirective] .%s without a loop (line %d)", strings.ToUpper(asmTokens.Current().Literal), asmTokens.Current().SourceLine)
 			}
 		}
 	}
 	return nil
 }
 
-// recordLabelAddress records the current program counter as the address for a label
-func (a *Assembler) recordLabelAddress(t lexer.Token) error {
-	labelName := strings.TrimSuffix(t.Literal, ":")
+// recordLabelAddress records the current program counter as the address for a label, defined by the token
+// at index
+func (a *Assembler) recordLabelAddress(t lexer.Token, index int) error {
+	labelName := a.labelKey(strings.TrimSuffix(t.Literal, ":"))
 
 	// Check for duplicate label
 	if _, exists := a.labels[labelName]; exists {
@@ -204,7 +253,12 @@ func (a *Assembler) recordLabelAddress(t lexer.Token) error {
 		return fmt.Errorf("[recordLabelAddress] label '%s' conflicts with existing variable", labelName)
 	}
 
+	if err := a.checkOuterLookups(labelName, t); err != nil {
+		return fmt.Errorf("[recordLabelAddress] %w", err)
+	}
+
 	a.labels[labelName] = uint64(a.programCounter)
+	a.recordDefinition(labelName, t, index)
 	return nil
 }
 
@@ -228,38 +282,19 @@ func (a *Assembler) recordPlusOrMinusLabelAddress(t lexer.Token, asmTokens *Toke
 
 // Size calculation functions for first pass
 func (a *Assembler) calculateByteDirectiveSize(asmTokens *Tokens) (int, error) {
-	size := 0
-	for {
-		t := asmTokens.Peek()
-		if t.ID == CommaToken {
-			asmTokens.Next() // Skip comma
-			continue
-		}
-		if t.ID != lexer.HexLiteral && t.ID != lexer.IntegerLiteral && t.ID != IdentifierToken {
-			break
-		}
-		size++
-		asmTokens.Next() // Consume the token
+	values, err := a.expressionList(asmTokens, true)
+	if err != nil {
+		return 0, fmt.Errorf("[calculateByteDirectiveSize] %w", err)
 	}
-	return size, nil
+	return len(values), nil
 }
 
 func (a *Assembler) calculateWordDirectiveSize(asmTokens *Tokens) (int, error) {
-	size := 0
-	for {
-		t := asmTokens.Peek()
-		if isTerminatorToken(t.ID) {
-			break
-		}
-		asmTokens.Next() // Consume the token
-		if t.ID == CommaToken {
-			continue
-		}
-		if t.ID == lexer.HexLiteral || t.ID == lexer.IntegerLiteral || t.ID == IdentifierToken {
-			size += 2 // Words are 2 bytes
-		}
+	values, err := a.expressionList(asmTokens, true)
+	if err != nil {
+		return 0, fmt.Errorf("[calculateWordDirectiveSize] %w", err)
 	}
-	return size, nil
+	return len(values) * 2, nil // Words are 2 bytes
 }
 
 func (a *Assembler) calculateTextDirectiveSize(asmTokens *Tokens) (int, error) {
@@ -320,7 +355,7 @@ func (a *Assembler) preprocessorLabelSizer(mnemonic string) (string, any, error)
 
 // processConstantAssignment handles identifier = value assignments during preprocessing
 func (a *Assembler) processConstantAssignment(identifierToken lexer.Token, asmTokens *Tokens) error {
-	variableName := identifierToken.Literal
+	variableName := a.symbolKey(identifierToken.Literal)
 
 	// Check for duplicate variable
 	if _, exists := a.constants[variableName]; exists {
@@ -331,6 +366,16 @@ func (a *Assembler) processConstantAssignment(identifierToken lexer.Token, asmTo
 	if _, exists := a.labels[variableName]; exists {
 		return fmt.Errorf("[processConstantAssignment] variable '%s' conflicts with existing label", variableName)
 	}
+	if err := a.checkOuterLookups(variableName, identifierToken); err != nil {
+		return fmt.Errorf("[processConstantAssignment] %w", err)
+	}
+	a.recordDefinition(variableName, identifierToken, asmTokens.Index())
+	return a.assignConstant(variableName, asmTokens)
+}
+
+// assignConstant evaluates the '= value' following an identifier and assigns it to the constant recorded
+// as variableName.
+func (a *Assembler) assignConstant(variableName string, asmTokens *Tokens) error {
 
 	// Consume the equals token
 	equalsToken := asmTokens.Next()
@@ -344,11 +389,12 @@ func (a *Assembler) processConstantAssignment(identifierToken lexer.Token, asmTo
 		return fmt.Errorf("[processConstantAssignment] expected value after %s '='", variableName)
 	}
 
-	evaluatedValue, err := a.EvaluateExpression(asmTokens, "", true)
+	// Kept as a float if it is one, and converted where the constant is used
+	evaluatedValue, err := a.parseCurrentExpression(asmTokens, "", PRECEDENCE_LOWEST, true)
 	if err != nil {
 		return fmt.Errorf("[processConstantAssignment] failed to evaluate expression for %s: %w", variableName, err)
 	}
 
-	a.constants[variableName] = evaluatedValue
+	a.constants[variableName] = evaluatedValue.value()
 	return nil
 }
@@ -0,0 +1,218 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// scopeSeparator separates the names of the scopes a symbol is defined in from its own: player::update
+const scopeSeparator = "::"
+
+// scopeEnds maps each scope directive to the directive that ends it
+var scopeEnds = map[int]int{
+	ProcDirective:  EndProcDirective,
+	ScopeDirective: EndScopeDirective,
+}
+
+// scope is an open .PROC or .SCOPE block
+type scope struct {
+	name        string
+	directive   int
+	line        uint
+	localPrefix string // The global label cheap locals belonged to before the block
+	file        *sourceFile
+	start       uint16 // The address the block starts at
+}
+
+// joinSymbolNames joins the tokens the lexer splits a scoped or local symbol into: a period or '@' and the
+// name that follows it, which make a cheap local such as .loop or @loop, and names joined by '::', which
+// make a qualified name such as player::update. A period followed by a directive is left alone. It returns
+// the joined tokens with their origins.
+func (a *Assembler) joinSymbolNames(tokens []lexer.Token, origins []origin) ([]lexer.Token, []origin) {
+	adjacent := func(t, next lexer.Token) bool {
+		return next.SourceLine == t.SourceLine && next.SourceColumn == t.SourceColumn+uint(len(t.Literal))
+	}
+	isName := func(t lexer.Token) bool {
+		return t.ID == IdentifierToken || t.ID == LabelToken
+	}
+
+	joined := make([]lexer.Token, 0, len(tokens))
+	joinedOrigins := make([]origin, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case (t.ID == PeriodToken || t.ID == AtToken) && i+1 < len(tokens) && isName(tokens[i+1]) && adjacent(t, tokens[i+1]) && a.directiveAt(tokens, i) == 0:
+			i++
+			t.ID, t.Literal = tokens[i].ID, t.Literal+tokens[i].Literal
+
+		case t.ID == ColonToken && len(joined) > 0 && joined[len(joined)-1].ID == LabelToken && adjacent(joined[len(joined)-1], t) &&
+			i+1 < len(tokens) && isName(tokens[i+1]) && adjacent(t, tokens[i+1]):
+			i++
+			previous := &joined[len(joined)-1]
+			previous.ID, previous.Literal = tokens[i].ID, previous.Literal+t.Literal+tokens[i].Literal
+			continue
+		}
+		joined = append(joined, t)
+		joinedOrigins = append(joinedOrigins, origins[i])
+	}
+	return joined, joinedOrigins
+}
+
+// isCheapLocal returns whether a symbol is a cheap local, which belongs to the global label before it.
+func isCheapLocal(name string) bool {
+	return strings.HasPrefix(name, "@") || strings.HasPrefix(name, ".")
+}
+
+// scopePrefix returns the qualified name of the innermost open scope followed by the separator, or "" at
+// the top level.
+func (a *Assembler) scopePrefix() string {
+	prefix := ""
+	for _, s := range a.scopes {
+		prefix += s.name + scopeSeparator
+	}
+	return prefix
+}
+
+// symbolKey returns the name a symbol defined at this point is recorded under: a cheap local qualified by
+// its global label, anything else by the open scopes.
+func (a *Assembler) symbolKey(name string) string {
+	if isCheapLocal(name) {
+		return a.localPrefix + name
+	}
+	return a.scopePrefix() + name
+}
+
+// labelKey returns the name a label defined at this point is recorded under. A label written in the source
+// that is not a cheap local starts a new set of cheap locals. The names given to the labels of a macro
+// expansion or a loop's copies do not, so the cheap locals around a macro invocation or loop belong to the
+// same label.
+func (a *Assembler) labelKey(name string) string {
+	key := a.symbolKey(name)
+	if !isCheapLocal(name) && !a.generatedLabels[name] {
+		a.localPrefix = key
+	}
+	return key
+}
+
+// lookupSymbol returns the name a symbol referred to at this point is recorded under. A name is looked up
+// in the innermost scope first and then in each scope around it, so that a scope can use the symbols
+// around it and refer to a nested scope's symbols by qualifying them. In the first pass, a name found in a
+// scope around the innermost one is remembered under each scope it was not found in, for
+// checkOuterLookups.
+func (a *Assembler) lookupSymbol(name string) string {
+	if isCheapLocal(name) {
+		return a.localPrefix + name
+	}
+	var missed []string
+	found := func(key string) string {
+		if a.firstPass {
+			for _, m := range missed {
+				a.outerLookups[m] = key
+			}
+		}
+		return key
+	}
+	for i := len(a.scopes); i > 0; i-- {
+		var prefix string
+		for _, s := range a.scopes[:i] {
+			prefix += s.name + scopeSeparator
+		}
+		if a.isDefined(prefix + name) {
+			return found(prefix + name)
+		}
+		missed = append(missed, prefix+name)
+	}
+	if a.isDefined(name) {
+		return found(name)
+	}
+	return name
+}
+
+// checkOuterLookups fails if a symbol defined in the first pass, recorded as key, was used earlier in its
+// scope to refer to a symbol around the scope. The second pass would find the new definition instead and
+// assemble the use differently.
+func (a *Assembler) checkOuterLookups(key string, t lexer.Token) error {
+	if outer, found := a.outerLookups[key]; found {
+		name := key[strings.LastIndex(key, scopeSeparator)+len(scopeSeparator):]
+		return fmt.Errorf("'%s' is defined after '%s' was used in its scope to refer to '%s'; define it before that use or rename it (line %d)", key, name, outer, t.SourceLine)
+	}
+	return nil
+}
+
+// isDefined returns whether a label or constant has been recorded under a name.
+func (a *Assembler) isDefined(key string) bool {
+	_, isLabel := a.labels[key]
+	_, isConstant := a.constants[key]
+	return isLabel || isConstant
+}
+
+// scopeDirective opens and closes .PROC and .SCOPE blocks in either pass. A .PROC defines a label for its
+// start, in the scope around it, and opens a scope with the same name; a .SCOPE opens a scope, which is
+// anonymous if it is not named.
+func (a *Assembler) scopeDirective(asmTokens *Tokens, directive int, preprocess bool) error {
+	t := asmTokens.Current()
+	switch directive {
+	case ProcDirective, ScopeDirective:
+		var name string
+		if nameToken := asmTokens.Peek(); nameToken.ID == IdentifierToken && !isCheapLocal(nameToken.Literal) {
+			name = asmTokens.Next().Literal
+		} else if directive == ProcDirective {
+			return fmt.Errorf("[scopeDirective] expected a name after .PROC (line %d)", t.SourceLine)
+		}
+		if name == "" {
+			a.anonymousScopes++
+			name = fmt.Sprintf("@%d", a.anonymousScopes) // Cannot be written in the source
+		}
+		if !isTerminatorToken(asmTokens.Peek().ID) {
+			return fmt.Errorf("[scopeDirective] unexpected '%s' after .%s (line %d)", asmTokens.Peek().Literal, strings.ToUpper(t.Literal), t.SourceLine)
+		}
+
+		opened := scope{name: name, directive: directive, line: t.SourceLine, localPrefix: a.localPrefix, start: a.programCounter}
+		if index := asmTokens.Index(); index < len(a.origins) {
+			opened.file = a.origins[index].file
+		}
+		if directive == ProcDirective {
+			if preprocess {
+				label := lexer.NewToken(LabelToken, name, nil)
+				label.SourceLine, label.SourceColumn = t.SourceLine, t.SourceColumn
+				if err := a.recordLabelAddress(label, asmTokens.Index()); err != nil {
+					return err
+				}
+			} else {
+				a.labelKey(name)
+			}
+		}
+		a.scopes = append(a.scopes, opened)
+
+	case EndProcDirective, EndScopeDirective:
+		if len(a.scopes) == 0 {
+			return fmt.Errorf("[scopeDirective] .%s without .PROC or .SCOPE (line %d)", strings.ToUpper(t.Literal), t.SourceLine)
+		}
+		innermost := a.scopes[len(a.scopes)-1]
+		if scopeEnds[innermost.directive] != directive {
+			return fmt.Errorf("[scopeDirective] .%s does not close the %s at line %d (line %d)", strings.ToUpper(t.Literal), a.directiveName(innermost.directive), innermost.line, t.SourceLine)
+		}
+		a.recordScope(innermost)
+		a.scopes = a.scopes[:len(a.scopes)-1]
+		a.localPrefix = innermost.localPrefix
+	}
+	return nil
+}
+
+// checkScopesClosed fails if a pass ends inside a .PROC or .SCOPE block.
+func (a *Assembler) checkScopesClosed() error {
+	if len(a.scopes) > 0 {
+		innermost := a.scopes[len(a.scopes)-1]
+		return fmt.Errorf("missing %s for %s (line %d)", a.directiveName(scopeEnds[innermost.directive]), a.directiveName(innermost.directive), innermost.line)
+	}
+	return nil
+}
+
+// resetScopes returns to the top level at the start of a pass.
+func (a *Assembler) resetScopes() {
+	a.scopes = nil
+	a.localPrefix = ""
+	a.anonymousScopes = 0
+}
@@ -0,0 +1,55 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// sourceFile is a source file as it was read, once for each time it was included
+type sourceFile struct {
+	name         string
+	lines        []string    // The file's text, by line
+	includedFrom *sourceFile // The file whose .include read it, nil for the main file
+	includeLine  uint        // The line of that .include
+}
+
+// origin is where a token came from: the file it was read from, and the macro expansion that produced it,
+// nil outside macros. The assembler keeps the origin of each token in a slice alongside the tokens.
+type origin struct {
+	file      *sourceFile
+	expansion *macroExpansion
+}
+
+// sourceOrigins returns the origins of tokens all read from one file
+func sourceOrigins(tokens []lexer.Token, file *sourceFile) []origin {
+	origins := make([]origin, len(tokens))
+	for i := range origins {
+		origins[i] = origin{file: file}
+	}
+	return origins
+}
+
+// location returns a line of the file as file:line
+func (f *sourceFile) location(line uint) string {
+	return fmt.Sprintf("%s:%d", f.name, line)
+}
+
+// text returns the text of a line of the file, or "" if it has no such line
+func (f *sourceFile) text(line uint) string {
+	if line == 0 || int(line) > len(f.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(f.lines[line-1], "\r")
+}
+
+// includes returns whether other is f or a file f included
+func (f *sourceFile) includes(other *sourceFile) bool {
+	for ; other != nil; other = other.includedFrom {
+		if other == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package assembler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jrsteele09/go-lexer/lexer"
+)
+
+// SymbolKind tells a label, which is an address, from a constant
+type SymbolKind string
+
+const (
+	LabelSymbol    SymbolKind = "label"
+	ConstantSymbol SymbolKind = "constant"
+)
+
+// SymbolFormat is a format symbols can be exported in
+type SymbolFormat string
+
+const (
+	VICESymbols  SymbolFormat = "vice"  // VICE monitor commands: al C:1000 .label
+	JSONSymbols  SymbolFormat = "json"  // A JSON document: {"symbols": [Symbol, ...]}
+	PlainSymbols SymbolFormat = "plain" // One assignment a line: label = $1000 ; label
+)
+
+// Symbol is a label or constant of an assembled program
+type Symbol struct {
+	Name  string     `json:"name"`  // Qualified by the scopes it is defined in, such as player::update
+	Scope string     `json:"scope"` // The .PROC or .SCOPE it is defined in, such as player, "" at the top level
+	Kind  SymbolKind `json:"kind"`
+	Value int64      `json:"value"` // A label's address, or a constant's value, without any fraction
+	File  string     `json:"file"`  // Where it is defined, "" if not known
+	Line  uint       `json:"line"`
+}
+
+// definition is where a label or constant was defined
+type definition struct {
+	file  *sourceFile
+	line  uint
+	scope string
+}
+
+// recordDefinition records where the label or constant recorded as key was defined: by token t, at index.
+// A .VAR keeps the place it was first assigned.
+func (a *Assembler) recordDefinition(key string, t lexer.Token, index int) {
+	if _, found := a.definitions[key]; found {
+		return
+	}
+	d := definition{line: t.SourceLine, scope: strings.TrimSuffix(a.scopePrefix(), scopeSeparator)}
+	if index >= 0 && index < len(a.origins) {
+		d.file = a.origins[index].file
+	}
+	a.definitions[key] = d
+}
+
+// Symbols returns the labels and constants of the last assembly, in alphabetical order.
+func (a *Assembler) Symbols() []Symbol {
+	symbol := func(name string, kind SymbolKind, value int64) Symbol {
+		s := Symbol{Name: name, Kind: kind, Value: value}
+		if d, found := a.definitions[name]; found {
+			s.Scope, s.Line = d.scope, d.line
+			if d.file != nil {
+				s.File = d.file.name
+			}
+		}
+		return s
+	}
+
+	var symbols []Symbol
+	for name, address := range a.labels {
+		symbols = append(symbols, symbol(name, LabelSymbol, int64(address)))
+	}
+	for name, value := range a.constants {
+		i, _ := toInt64(value)
+		symbols = append(symbols, symbol(name, ConstantSymbol, i))
+	}
+	slices.SortFunc(symbols, func(x, y Symbol) int { return strings.Compare(x.Name, y.Name) })
+	return symbols
+}
+
+// WriteSymbols writes the labels and constants of the last assembly in a format. VICE's monitor has no
+// constants, so the VICE format has only the labels, named as viceName gives; the others give the kind of
+// each symbol. It returns the labels left out of the VICE format because another label already has their
+// VICE name.
+func (a *Assembler) WriteSymbols(w io.Writer, format SymbolFormat) ([]string, error) {
+	symbols := a.Symbols()
+	var skipped []string
+	switch format {
+	case VICESymbols:
+		// A name VICE can parse as it is keeps it over a name that had to be changed
+		taken := make(map[string]bool)
+		for _, s := range symbols {
+			if s.Kind == LabelSymbol && viceName(s.Name) == s.Name {
+				taken[s.Name] = true
+			}
+		}
+		for _, s := range symbols {
+			if s.Kind != LabelSymbol {
+				continue
+			}
+			name := viceName(s.Name)
+			if name != s.Name {
+				if taken[name] {
+					skipped = append(skipped, s.Name)
+					continue
+				}
+				taken[name] = true
+			}
+			if _, err := fmt.Fprintf(w, "al C:%04x .%s\n", s.Value, name); err != nil {
+				return nil, err
+			}
+		}
+	case JSONSymbols:
+		if symbols == nil {
+			symbols = []Symbol{}
+		}
+		data, err := json.MarshalIndent(struct {
+			Symbols []Symbol `json:"symbols"`
+		}{symbols}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+			return nil, err
+		}
+	case PlainSymbols:
+		for _, s := range symbols {
+			value := constantString(s.Value)
+			if s.Kind == LabelSymbol {
+				value = fmt.Sprintf("$%04X", s.Value)
+			}
+			if _, err := fmt.Fprintf(w, "%s = %s ; %s\n", s.Name, value, s.Kind); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown symbol format '%s', expected vice, json or plain", format)
+	}
+	return skipped, nil
+}
+
+// ReadSymbols reads symbols written by WriteSymbols in the JSON or plain format, told apart by the first
+// character. The plain format has no files or lines, and a symbol's scope is taken from its name.
+func ReadSymbols(r io.Reader) ([]Symbol, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("[ReadSymbols] %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var document struct {
+			Symbols []Symbol `json:"symbols"`
+		}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("[ReadSymbols] %w", err)
+		}
+		return document.Symbols, nil
+	}
+
+	var symbols []Symbol
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, rest, found := strings.Cut(strings.TrimSpace(line), " = ")
+		value, kind, commented := strings.Cut(rest, " ; ")
+		if !found || !commented || (SymbolKind(kind) != LabelSymbol && SymbolKind(kind) != ConstantSymbol) {
+			return nil, fmt.Errorf("[ReadSymbols] expected name = value ; label or constant (line %d)", i+1)
+		}
+		var number int64
+		if hex, isHex := strings.CutPrefix(value, "$"); isHex {
+			number, err = strconv.ParseInt(hex, 16, 64)
+		} else {
+			number, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[ReadSymbols] invalid value '%s' (line %d)", value, i+1)
+		}
+		s := Symbol{Name: name, Kind: SymbolKind(kind), Value: number}
+		if separator := strings.LastIndex(name, scopeSeparator); separator >= 0 {
+			s.Scope = name[:separator]
+		}
+		symbols = append(symbols, s)
+	}
+	return symbols, nil
+}
+
+// viceName returns a symbol's name as VICE's monitor can parse it, with only letters, digits and
+// underscores: each scope separator becomes two underscores and any other character one, so
+// player::update is player__update and the cheap local main@loop is main_loop.
+func viceName(name string) string {
+	name = strings.ReplaceAll(name, scopeSeparator, "__")
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,37 @@
+; Conditional assembly of PAL/NTSC and debug/release variants
+PAL = 1
+DEBUG = 0
+            *=$c000
+
+            JMP main            ; A forward reference past the conditional blocks
+
+.IF PAL
+lines:      LDA #$37            ; 312 raster lines
+.ELSEIF NTSC
+lines:      LDA #$06
+.ELSE
+lines:      LDA #$05
+.ENDIF
+            STA $02
+
+.IF DEBUG
+            INC $D020
+            INC $D020
+.ELSE
+  .IF PAL - 1
+            NOP
+  .ELSE
+            LDX #1
+  .ENDIF
+.ENDIF
+
+.IFDEF later                    ; Defined later, so not yet: both passes skip this
+            BRK
+.ENDIF
+.IFNDEF NTSC
+            LDY #2
+.ENDIF
+.IFDEF lines
+main:       RTS
+.ENDIF
+later:
@@ -0,0 +1,8 @@
+; Library
+.MACRO add16 dest, value=1
+  CLC
+  LDA dest
+  ADC #<value
+  STA dest
+.ENDM
+BORDER = $D020
@@ -0,0 +1,15 @@
+; Listing test
+        *=$C000
+start:  LDA #0          ; clear
+.include "lib.asm"
+        STA BORDER
+        add16 $FB, $0102
+        .REPT 2
+        NOP
+        .ENDR
+.IF 0
+        BRK
+.ENDIF
+msg:    .TEXT "HELLO WORLD"
+        RTS
+; trailing comment
@@ -0,0 +1,29 @@
+; Loops unrolled by the assembler
+            *=$c000
+
+            JMP main            ; A forward reference past the loops
+
+.REPT 3
+            ASL A
+.ENDR
+
+.FOR i = 0 TO 6 STEP 2
+            LDA #i * 4
+wait:       DEX                 ; wait_0, wait_2, wait_4, wait_6
+            BNE wait
+.ENDFOR
+
+.FOR row = 2 TO 1 STEP -1       ; Counting down
+  .FOR col = 0 TO 1
+            STA $0400 + row * 40 + col
+  .ENDFOR
+.ENDFOR
+
+.VAR n = 1
+.WHILE 8 - n                    ; Until n is 8
+            LDY #n
+.VAR n = n * 2
+.ENDW
+
+main:       JMP wait_4
+            LDX #n              ; The last value assigned to n
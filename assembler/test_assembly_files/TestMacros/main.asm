@@ -0,0 +1,30 @@
+; Macros with positional, named and default arguments, local labels and nested invocations
+            *=$c000
+
+.MACRO add16 dest, value=1
+            CLC
+            LDA dest
+            ADC #<value
+            STA dest
+            BCC skip
+            INC dest+1
+skip:
+.ENDM
+
+.MACRO wait count
+            LDX #count
+loop        DEX
+            BNE loop
+.ENDM
+
+.MACRO pause count, border=$D020
+            INC border
+            wait count
+.ENDM
+
+start:      add16 $FB
+            add16 $FD, $10
+            add16 value=2, dest=$FB
+            pause 5
+            pause border=$D021, count=7
+            JMP start
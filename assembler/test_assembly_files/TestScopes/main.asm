@@ -0,0 +1,36 @@
+; Scoped, cheap local and qualified labels
+SCREEN = $0400
+            *=$c000
+
+            JSR player::update
+            JSR enemy::update
+
+.PROC player
+SPEED = 2
+update:     LDX #8
+@loop:      DEX                 ; Cheap locals belong to the label before them
+            BNE @loop
+            JSR draw
+            RTS
+draw:       LDY #SPEED
+.loop:      STA SCREEN,Y        ; A global constant from inside the scope
+            DEY
+            BPL .loop
+            RTS
+.ENDPROC
+
+.PROC enemy
+update:     LDX #4
+@loop:      DEX                 ; Not the same @loop as player::update's
+            BNE @loop
+-           DEY                 ; Plus/minus labels work inside scopes
+            BNE -
+            BEQ +
+            NOP
++           JMP player::draw
+.ENDPROC
+
+.SCOPE                          ; Anonymous
+update:     LDA #player::SPEED  ; A constant qualified by its scope
+            RTS
+.ENDSCOPE
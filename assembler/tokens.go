@@ -1,6 +1,8 @@
 package assembler
 
 import (
+	"slices"
+
 	"github.com/jrsteele09/go-lexer/lexer"
 )
 
@@ -8,6 +10,7 @@ import (
 type Tokens struct {
 	tokens    []lexer.Token
 	tokenIdx  int
+	currIdx   int
 	currToken lexer.Token
 	nextToken lexer.Token
 }
@@ -26,6 +29,7 @@ func NewAssemblerTokens(tokens []lexer.Token) *Tokens {
 // Next advances to the next token and returns the current token
 func (at *Tokens) Next() lexer.Token {
 	at.currToken = at.nextToken
+	at.currIdx = at.tokenIdx
 	if at.tokenIdx >= len(at.tokens)-1 {
 		at.nextToken = lexer.Token{ID: lexer.EOFType}
 		return lexer.Token{ID: lexer.EOFType}
@@ -43,3 +47,20 @@ func (at *Tokens) Peek() lexer.Token {
 func (at *Tokens) Current() lexer.Token {
 	return at.currToken
 }
+
+// Index returns the position of the current token in the token slice
+func (at *Tokens) Index() int {
+	return at.currIdx
+}
+
+// All returns the token slice, including any replacements
+func (at *Tokens) All() []lexer.Token {
+	return at.tokens
+}
+
+// Replace replaces tokens[start:end] with replacement and continues processing from its first token
+func (at *Tokens) Replace(start, end int, replacement []lexer.Token) {
+	at.tokens = slices.Replace(at.tokens, start, end, replacement...)
+	at.tokenIdx = start
+	at.nextToken = at.tokens[start]
+}
@@ -2,6 +2,9 @@
 
 go build -o ./bin/asm6502 ./cmd/assembler
 go build -o ./bin/debug6502 ./cmd/debugger
+go build -o ./bin/apple1 ./cmd/apple1
+go build -o ./bin/run6502 ./cmd/runner
+go build -o ./bin/sidplay6502 ./cmd/sidplayer
 
 BIN_PATH="$(pwd)/bin"
 case ":$PATH:" in
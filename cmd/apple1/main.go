@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/jrsteele09/go-6502-emulator/machine/apple1"
+)
+
+func main() {
+	var (
+		romFile  = flag.String("rom", "", "Woz Monitor ROM image (required); images larger than 256 bytes are mapped to end at $FFFF")
+		clockHz  = flag.Uint64("hz", apple1.ClockHz, "CPU clock in Hz, 0 runs unthrottled")
+		showHelp = flag.Bool("h", false, "Show help")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Apple-1 Emulator\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] -rom <wozmon.bin>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nThe keyboard reads from stdin and the display writes to stdout. Press Ctrl+C to quit.\n")
+	}
+
+	flag.Parse()
+
+	if *showHelp {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if *romFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: ROM file is required\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	rom, err := apple1.LoadROM(*romFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	machine, err := apple1.New(rom, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+
+	if err := machine.Run(*clockHz, stop); err != nil {
+		fmt.Fprintf(os.Stderr, "\nCPU error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
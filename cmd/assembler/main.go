@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/jrsteele09/go-6502-emulator/assembler"
@@ -22,8 +23,20 @@ func main() {
 	var (
 		inputFile    = flag.String("i", "", "Input assembly file (required)")
 		outputFile   = flag.String("o", "", "Output file (default: input filename with appropriate extension)")
-		outputFormat = flag.String("f", "prg", "Output format: prg, d64, or t64 (default: prg)")
-		programName  = flag.String("n", "", "Program name for D64/T64 formats (default: derived from output filename)")
+		outputFormat = flag.String("f", "prg", "Output format: prg, d64, t64 or sid (default: prg)")
+		programName  = flag.String("n", "", "Program name for D64/T64 formats, tune name for SID (default: derived from output filename)")
+		sidInit      = flag.String("sid-init", "", "SID init address (default: load address)")
+		sidPlay      = flag.String("sid-play", "", "SID play address, called once per frame")
+		sidSongs     = flag.Uint("sid-songs", 1, "Number of songs in the SID tune")
+		sidStart     = flag.Uint("sid-start", 1, "Default song of the SID tune")
+		sidAuthor    = flag.String("sid-author", "", "SID author")
+		sidReleased  = flag.String("sid-released", "", "SID release (year and publisher)")
+		basicStub    = flag.Bool("basic", false, "Add a \"10 SYS <start>\" BASIC line at $0801 so the program can be RUN; the listing, symbols and debug info leave it out")
+		listingFile  = flag.String("l", "", "Write a listing of the source with addresses, bytes and cycles to this file")
+		listingBytes = flag.Int("list-bytes", assembler.DefaultListingBytes, "Bytes on each line of the listing before the rest continue on the next")
+		symbolFile   = flag.String("s", "", "Write the labels and constants to this file")
+		symbolFormat = flag.String("symbol-format", "vice", "Format of the -s file: vice, json or plain")
+		debugFile    = flag.String("g", "", "Write debug info linking addresses to source lines to this file")
 		showHelp     = flag.Bool("h", false, "Show help")
 		showVer      = flag.Bool("version", false, "Show version")
 		verbose      = flag.Bool("v", false, "Verbose output")
@@ -38,12 +51,18 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  prg  - Commodore 64 PRG file (default)\n")
 		fmt.Fprintf(os.Stderr, "  d64  - Commodore 64 disk image (1541 format)\n")
 		fmt.Fprintf(os.Stderr, "  t64  - Commodore 64 tape archive\n")
+		fmt.Fprintf(os.Stderr, "  sid  - PSID v2 music file\n")
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s -i game.asm                    # Output to game.prg\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i game.asm -f d64             # Output to game.d64\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i game.asm -o disk.d64 -f d64 # Output to disk.d64\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i game.asm -f t64 -v          # Output to game.t64 with verbose\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i game.asm -f d64 -n MYGAME   # D64 with custom program name\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i game.asm -basic             # LOAD \"*\",8,1 and RUN\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i game.asm -l game.lst        # Also write a listing\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i game.asm -s game.vs         # Also write VICE labels\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i game.asm -g game.dbg        # Also write debug info\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i tune.asm -f sid -sid-play '$1003' -n \"My Tune\" -sid-author Me\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -66,12 +85,47 @@ func main() {
 
 	// Validate output format
 	*outputFormat = strings.ToLower(*outputFormat)
-	validFormats := map[string]bool{"prg": true, "d64": true, "t64": true}
+	validFormats := map[string]bool{"prg": true, "d64": true, "t64": true, "sid": true}
 	if !validFormats[*outputFormat] {
-		fmt.Fprintf(os.Stderr, "Error: Invalid output format '%s'. Valid formats: prg, d64, t64\n", *outputFormat)
+		fmt.Fprintf(os.Stderr, "Error: Invalid output format '%s'. Valid formats: prg, d64, t64, sid\n", *outputFormat)
 		os.Exit(1)
 	}
 
+	*symbolFormat = strings.ToLower(*symbolFormat)
+	switch assembler.SymbolFormat(*symbolFormat) {
+	case assembler.VICESymbols, assembler.JSONSymbols, assembler.PlainSymbols:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid symbol format '%s'. Valid formats: vice, json, plain\n", *symbolFormat)
+		os.Exit(1)
+	}
+
+	var initAddr, playAddr uint16
+	if *outputFormat == "sid" {
+		if *basicStub {
+			fmt.Fprintf(os.Stderr, "Error: -basic cannot be used with the sid format\n")
+			os.Exit(1)
+		}
+		var err error
+		if *sidInit != "" {
+			if initAddr, err = parseAddress(*sidInit); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid SID init address '%s': %v\n", *sidInit, err)
+				os.Exit(1)
+			}
+		}
+		if *sidPlay == "" {
+			fmt.Fprintf(os.Stderr, "Error: -sid-play is required for the sid format\n")
+			os.Exit(1)
+		}
+		if playAddr, err = parseAddress(*sidPlay); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid SID play address '%s': %v\n", *sidPlay, err)
+			os.Exit(1)
+		}
+		if *sidSongs < 1 || *sidSongs > output.SIDMaxSongs || *sidStart < 1 || *sidStart > *sidSongs {
+			fmt.Fprintf(os.Stderr, "Error: SID songs must be 1-%d and the start song one of them\n", output.SIDMaxSongs)
+			os.Exit(1)
+		}
+	}
+
 	// Check if input file exists
 	if _, err := os.Stat(*inputFile); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Error: Input file '%s' does not exist\n", *inputFile)
@@ -117,6 +171,44 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *listingFile != "" {
+		if err := writeListing(asm, *listingFile, *listingBytes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing listing: %v\n", err)
+			os.Exit(1)
+		}
+		if *verbose {
+			fmt.Printf("Listing written to %s\n", *listingFile)
+		}
+	}
+
+	if *symbolFile != "" {
+		if err := writeSymbols(asm, *symbolFile, assembler.SymbolFormat(*symbolFormat)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing symbols: %v\n", err)
+			os.Exit(1)
+		}
+		if *verbose {
+			fmt.Printf("Symbols written to %s\n", *symbolFile)
+		}
+	}
+
+	if *debugFile != "" {
+		if err := writeDebugInfo(asm, *debugFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing debug info: %v\n", err)
+			os.Exit(1)
+		}
+		if *verbose {
+			fmt.Printf("Debug info written to %s\n", *debugFile)
+		}
+	}
+
+	if *basicStub {
+		segments, err = assembler.PrependBASICUpstart(segments)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error adding BASIC stub: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Create binary format based on output format flag
 	var format output.BinaryFormat
 	switch *outputFormat {
@@ -156,6 +248,12 @@ func main() {
 		}
 
 		format = output.NewT64FormatWithFilename(tapeName, fileName, 30)
+	case "sid":
+		name := *programName
+		if name == "" {
+			name = filepath.Base(strings.TrimSuffix(*inputFile, filepath.Ext(*inputFile)))
+		}
+		format = output.NewSIDFormat(name, *sidAuthor, *sidReleased, initAddr, playAddr, uint16(*sidSongs), uint16(*sidStart))
 	}
 
 	err = format.CreateFile(*outputFile, segments, *verbose)
@@ -175,6 +273,63 @@ func main() {
 	}
 }
 
+// writeListing writes the listing of the assembly to a file
+func writeListing(asm *assembler.Assembler, filename string, bytesPerLine int) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := asm.WriteListing(f, assembler.ListingOptions{BytesPerLine: bytesPerLine}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSymbols writes the labels and constants of the assembly to a file in a format, warning of any the
+// format leaves out
+func writeSymbols(asm *assembler.Assembler, filename string, format assembler.SymbolFormat) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	skipped, err := asm.WriteSymbols(f, format)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, name := range skipped {
+		fmt.Fprintf(os.Stderr, "Warning: label '%s' left out of the %s symbols, another label has the same name there\n", name, format)
+	}
+	return f.Close()
+}
+
+// writeDebugInfo writes the debug info of the assembly to a file
+func writeDebugInfo(asm *assembler.Assembler, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := asm.DebugInfo().Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseAddress parses a hex ($1000, 0x1000) or decimal address
+func parseAddress(s string) (uint16, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(strings.ToLower(s), "0x"):
+		s, base = s[2:], 16
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	return uint16(v), err
+}
+
 // createOpcodes creates the full 6502 instruction set
 func createOpcodes() []*cpu.OpCodeDef {
 	mem := memory.NewMemory[uint16](64 * 1024)
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/assembler/cbmbasic"
+	"github.com/jrsteele09/go-6502-emulator/assembler/output"
+)
+
+const (
+	version = "1.0.0"
+)
+
+func main() {
+	var (
+		outputFile   = flag.String("o", "", "Output file (default: input filename with .prg, or stdout when listing)")
+		list         = flag.Bool("d", false, "Detokenize: list a PRG file as text")
+		startAddr    = flag.String("a", "$0801", "Address the BASIC program is loaded at")
+		outputFormat = flag.String("f", "prg", "Output format: prg, d64 or t64")
+		programName  = flag.String("n", "", "Program name for D64/T64 formats (default: derived from input filename)")
+		machineCode  = flag.String("ml", "", "Comma separated PRG files of machine code to merge after the BASIC program")
+		showHelp     = flag.Bool("h", false, "Show help")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "6502 BASIC V2 Tokenizer v%s\n\n", version)
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <program.bas>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -d [options] <program.prg>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nThe listing has one numbered line per line of text. Keywords may be in either case; letters\n")
+		fmt.Fprintf(os.Stderr, "between quotes in lower case are shifted. Control codes are written between braces by name,\n")
+		fmt.Fprintf(os.Stderr, "such as {clr}, {rvon} or {red}, or by code, such as {$93}.\n")
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s game.bas                       # Output to game.prg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -ml code.prg -f d64 game.bas   # BASIC and machine code on game.d64\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -d game.prg                    # List game.prg\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if *showHelp {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: an input file is required\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	inputFile := flag.Arg(0)
+
+	if *list {
+		if err := detokenize(inputFile, *outputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	start, err := parseAddress(*startAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid start address '%s': %v\n", *startAddr, err)
+		os.Exit(1)
+	}
+
+	*outputFormat = strings.ToLower(*outputFormat)
+	baseName := strings.TrimSuffix(inputFile, filepath.Ext(inputFile))
+	if *outputFile == "" {
+		*outputFile = baseName + "." + *outputFormat
+	}
+	fileName := *programName
+	if fileName == "" {
+		fileName = strings.ToUpper(filepath.Base(baseName))
+	}
+	var format output.BinaryFormat
+	switch *outputFormat {
+	case "prg":
+		format = output.NewPRGFormat()
+	case "d64":
+		format = output.NewD64FormatWithFilename(truncate(fileName, 16), "01", truncate(fileName, 16))
+	case "t64":
+		format = output.NewT64FormatWithFilename(truncate(fileName, 24), truncate(fileName, 16), 30)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid output format '%s'. Valid formats: prg, d64, t64\n", *outputFormat)
+		os.Exit(1)
+	}
+
+	text, err := os.ReadFile(inputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	program, err := cbmbasic.TokenizeBASIC(string(text), start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", inputFile, err)
+		os.Exit(1)
+	}
+
+	basic := assembler.AssembledData{StartAddress: start}
+	basic.Data.Write(program)
+	segments := []assembler.AssembledData{basic}
+	if *machineCode != "" {
+		for _, file := range strings.Split(*machineCode, ",") {
+			loaded, err := output.NewPRGFormat().LoadFile(file, false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			segments = append(segments, loaded...)
+		}
+	}
+	merged, err := mergeSegments(segments)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := format.CreateFile(*outputFile, []assembler.AssembledData{merged}, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating %s file: %v\n", strings.ToUpper(*outputFormat), err)
+		os.Exit(1)
+	}
+	fmt.Printf("Tokenized %s -> %s (%d bytes of BASIC at $%04X)\n", inputFile, *outputFile, len(program), start)
+}
+
+// detokenize lists the BASIC program in a PRG file to a text file, or to stdout when filename is empty
+func detokenize(inputFile, filename string) error {
+	segments, err := output.NewPRGFormat().LoadFile(inputFile, false)
+	if err != nil {
+		return err
+	}
+	lines, err := cbmbasic.DetokenizeBASIC(segments[0].Data.Bytes(), segments[0].StartAddress)
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line.String())
+		sb.WriteString("\n")
+	}
+	if filename == "" {
+		fmt.Print(sb.String())
+	} else if writeErr := os.WriteFile(filename, []byte(sb.String()), 0644); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// mergeSegments joins segments into one, in address order, filling the gaps between them with zeros so
+// that the BASIC program and its machine code load as a single file
+func mergeSegments(segments []assembler.AssembledData) (assembler.AssembledData, error) {
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].StartAddress < segments[j].StartAddress })
+	merged := assembler.AssembledData{StartAddress: segments[0].StartAddress}
+	end := int(segments[0].StartAddress)
+	for _, segment := range segments {
+		if int(segment.StartAddress) < end {
+			return merged, fmt.Errorf("code at $%04X overlaps the data before it, which ends at $%04X", segment.StartAddress, end-1)
+		}
+		merged.Data.Write(make([]byte, int(segment.StartAddress)-end))
+		merged.Data.Write(segment.Data.Bytes())
+		end = int(segment.StartAddress) + segment.Data.Len()
+	}
+	return merged, nil
+}
+
+// truncate shortens a name to a format's maximum length
+func truncate(name string, length int) string {
+	if len(name) > length {
+		return name[:length]
+	}
+	return name
+}
+
+// parseAddress parses a hex ($1000, 0x1000) or decimal address
+func parseAddress(s string) (uint16, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(strings.ToLower(s), "0x"):
+		s, base = s[2:], 16
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	return uint16(v), err
+}
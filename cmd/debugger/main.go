@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/jrsteele09/go-6502-emulator/debugger"
+	"github.com/jrsteele09/go-6502-emulator/devices/vic"
+	"github.com/jrsteele09/go-6502-emulator/machine/c1541"
+	"github.com/jrsteele09/go-6502-emulator/machine/c64"
 )
 
 // ANSI color codes
@@ -25,22 +30,163 @@ const (
 
 type DebuggerRepl struct {
 	debugger    *debugger.Debugger
+	drive       *debugger.Debugger // The 1541's CPU, when a drive is emulated
 	scanner     *bufio.Scanner
 	lastCommand string
 }
 
-func NewDebuggerRepl() *DebuggerRepl {
+func NewDebuggerRepl(d *debugger.Debugger) *DebuggerRepl {
 	return &DebuggerRepl{
-		debugger: debugger.NewDebugger(),
+		debugger: d,
 		scanner:  bufio.NewScanner(os.Stdin),
 	}
 }
 
+// newC64Debugger builds a C64 from the ROM files and a debugger for it. Loaded programs update the
+// BASIC pointers, as LOAD does. With traps, the KERNAL routines are emulated on the host.
+func newC64Debugger(basic, kernal, chargen string, ntsc bool, traps *c64.KernalTraps) (*debugger.Debugger, *c64.C64, error) {
+	roms, err := c64.LoadROMs(basic, kernal, chargen)
+	if err != nil {
+		return nil, nil, err
+	}
+	model := vic.PAL
+	if ntsc {
+		model = vic.NTSC
+	}
+	machine := c64.New(roms, model)
+	if traps != nil {
+		traps.Install(machine)
+	}
+	d := debugger.NewDebuggerWithBus(machine.Bus)
+	d.SetLoadHook(machine.Loaded)
+	return d, machine, nil
+}
+
+// newDriveDebugger attaches a 1541 running the DOS ROM in romFiles (one 16KB or two 8KB images) to the
+// C64's serial bus as device 8, with the D64 image inserted, and returns a debugger for the drive's CPU.
+func newDriveDebugger(machine *c64.C64, romFiles, disk string) (*debugger.Debugger, error) {
+	rom, err := c1541.LoadROM(strings.Split(romFiles, ",")...)
+	if err != nil {
+		return nil, err
+	}
+	drive, err := machine.AttachDrive(rom, c1541.DefaultDevice)
+	if err != nil {
+		return nil, err
+	}
+	if disk != "" {
+		d64, err := c1541.LoadDisk(disk)
+		if err != nil {
+			return nil, err
+		}
+		drive.Insert(d64)
+	}
+	return debugger.NewDebuggerWithBus(drive.Bus), nil
+}
+
+// newKernalTraps creates the KERNAL traps with screen output to stdout, keyboard input from inFile (if
+// any) and LOAD/SAVE using the D64 image, or failing that the directory.
+func newKernalTraps(inFile, dir, disk string) (*c64.KernalTraps, error) {
+	var in io.Reader
+	if inFile != "" {
+		f, err := os.Open(inFile)
+		if err != nil {
+			return nil, err
+		}
+		in = f
+	}
+	traps := c64.NewKernalTraps(os.Stdout, in)
+	if err := traps.SetDirectory(dir); err != nil {
+		return nil, err
+	}
+	if disk != "" {
+		if err := traps.InsertDisk(disk); err != nil {
+			return nil, err
+		}
+	}
+	return traps, nil
+}
+
 func main() {
-	repl := NewDebuggerRepl()
+	var (
+		consoleAddr  = flag.String("console", "", "Map the virtual console device at this address, e.g. $F000 (default: disabled)")
+		consoleInput = flag.String("console-in", "", "File the virtual console reads its input from (default: no input)")
+		machineName  = flag.String("machine", "", "Machine to emulate: c64 (default: bare 64KB RAM)")
+		basicROM     = flag.String("basic", "", "C64 BASIC ROM image (8KB)")
+		kernalROM    = flag.String("kernal", "", "C64 KERNAL ROM image (8KB)")
+		charROM      = flag.String("chargen", "", "C64 character ROM image (4KB)")
+		ntsc         = flag.Bool("ntsc", false, "Emulate an NTSC C64 rather than PAL")
+		kernalTraps  = flag.Bool("kernal-traps", false, "Emulate the KERNAL's CHROUT, CHRIN, GETIN, PLOT, LOAD, SAVE and clock routines on the host")
+		kernalInput  = flag.String("kernal-in", "", "File the trapped KERNAL reads keyboard input from (default: no input)")
+		diskDir      = flag.String("dir", ".", "Directory the trapped KERNAL loads and saves files in")
+		diskImage    = flag.String("disk", "", "D64 image the trapped KERNAL loads and saves files in, in place of -dir, or the emulated 1541 reads")
+		keepLoadPC   = flag.Bool("keep-load-pc", false, "Leave the PC at the load address of a BASIC program rather than at its SYS target")
+		driveROM     = flag.String("drive-rom", "", "1541 DOS ROM image (16KB, or two comma-separated 8KB halves) to emulate a disk drive on the serial bus")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "6502 Debugger v1.0.0\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [file.prg ...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	var d, drive *debugger.Debugger
+	switch strings.ToLower(*machineName) {
+	case "":
+		d = debugger.NewDebugger()
+	case "c64":
+		var traps *c64.KernalTraps
+		var err error
+		if *kernalTraps {
+			if traps, err = newKernalTraps(*kernalInput, *diskDir, *diskImage); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			defer traps.Close()
+		}
+		var machine *c64.C64
+		if d, machine, err = newC64Debugger(*basicROM, *kernalROM, *charROM, *ntsc, traps); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *driveROM != "" {
+			if drive, err = newDriveDebugger(machine, *driveROM, *diskImage); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown machine '%s'\n", *machineName)
+		os.Exit(1)
+	}
+
+	d.SetKeepLoadAddress(*keepLoadPC)
+	repl := NewDebuggerRepl(d)
+	repl.drive = drive
+	if *consoleAddr != "" {
+		addr, err := repl.debugger.ParseAddress(*consoleAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid console address '%s': %v\n", *consoleAddr, err)
+			os.Exit(1)
+		}
+		var in io.Reader
+		if *consoleInput != "" {
+			f, err := os.Open(*consoleInput)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			in = f
+		}
+		repl.debugger.AttachConsole(addr, in, os.Stdout)
+	}
+
 	// Auto-load any files passed on the command line
-	if len(os.Args) > 1 {
-		repl.AutoLoad(os.Args[1:])
+	if flag.NArg() > 0 {
+		repl.AutoLoad(flag.Args())
 	}
 	repl.Run()
 }
@@ -131,6 +277,16 @@ func (r *DebuggerRepl) processCommand(input string) bool {
 			output := r.debugger.LoadPRG(args[0])
 			fmt.Print(colorizeOutput(output))
 		}
+	case "SYM", "SYMBOLS":
+		if len(args) == 0 {
+			fmt.Printf("%sUsage: SYM <filename>%s\n", Red, Reset)
+		} else {
+			output := r.debugger.LoadSymbols(args[0])
+			fmt.Print(colorizeOutput(output))
+		}
+	case "LIST":
+		output := r.debugger.ListBASIC(args)
+		fmt.Print(colorizeOutput(output))
 	case "G", "GO":
 		output := r.debugger.Go(args)
 		fmt.Print(colorizeOutput(output))
@@ -146,6 +302,9 @@ func (r *DebuggerRepl) processCommand(input string) bool {
 	case "C", "CLEAR":
 		output := r.debugger.ClearBreakpoint(args)
 		fmt.Print(colorizeOutput(output))
+	case "X", "RESET":
+		output := r.debugger.Reset()
+		fmt.Print(colorizeOutput(output))
 	case "Z", "ZERO":
 		output := r.debugger.ZeroMemory(args)
 		fmt.Print(colorizeOutput(output))
@@ -155,6 +314,8 @@ func (r *DebuggerRepl) processCommand(input string) bool {
 	case "T", "TRANSFER":
 		output := r.debugger.TransferMemory(args)
 		fmt.Print(colorizeOutput(output))
+	case "DRIVE":
+		r.processDriveCommand(args)
 	default:
 		fmt.Printf("%sUnknown command: %s. Type 'H' for help.%s\n", Red, command, Reset)
 	}
@@ -162,6 +323,29 @@ func (r *DebuggerRepl) processCommand(input string) bool {
 	return false
 }
 
+// processDriveCommand examines the emulated 1541's CPU and memory. The drive runs in step with the
+// machine, so it is only examined here and never run on its own; its CPU may be part way through an
+// instruction.
+func (r *DebuggerRepl) processDriveCommand(args []string) {
+	if r.drive == nil {
+		fmt.Printf("%sNo drive is emulated; start with -machine c64 -drive-rom <file>%s\n", Red, Reset)
+		return
+	}
+	if len(args) == 0 {
+		args = []string{"R"}
+	}
+	switch strings.ToUpper(args[0]) {
+	case "R", "REGISTERS":
+		fmt.Print(colorizeOutput(r.drive.ShowRegisters()))
+	case "D", "DISASSEMBLE":
+		fmt.Print(colorizeOutput(r.drive.Disassemble(args[1:])))
+	case "M", "MEMORY":
+		fmt.Print(colorizeOutput(r.drive.HexDump(args[1:])))
+	default:
+		fmt.Printf("%sUsage: DRIVE [R | D [addr] [count] | M [addr] [count]]%s\n", Red, Reset)
+	}
+}
+
 // showHelp displays available commands
 func (r *DebuggerRepl) showHelp() {
 	fmt.Printf("%s%sAvailable Commands:%s\n", Bold, Yellow, Reset)
@@ -171,17 +355,21 @@ func (r *DebuggerRepl) showHelp() {
 	fmt.Printf("%s  D [addr] [count]%s  - Disassemble memory (default: PC, 10 instructions)\n", Cyan, Reset)
 	fmt.Printf("%s  M [addr] [count]%s  - Memory hex dump (default: $0000, 16 bytes)\n", Cyan, Reset)
 	fmt.Printf("%s  L <filename>%s      - Load PRG file into memory\n", Cyan, Reset)
+	fmt.Printf("%s  SYM <filename>%s    - Load the labels of a json or plain symbol file (-s)\n", Cyan, Reset)
+	fmt.Printf("%s  LIST [addr]%s       - List the BASIC program in memory (default: $0801)\n", Cyan, Reset)
 	fmt.Printf("%s  G [addr]%s          - Go/Run from address (default: current PC)\n", Cyan, Reset)
 	fmt.Printf("%s  S [count]%s         - Step instruction(s) (default: 1)\n", Cyan, Reset)
 	fmt.Printf("%s  B <addr>%s          - Set breakpoint at address\n", Cyan, Reset)
 	fmt.Printf("%s  BR, BREAKPOINTS%s   - List all breakpoints\n", Cyan, Reset)
 	fmt.Printf("%s  C <addr>%s          - Clear breakpoint at address\n", Cyan, Reset)
+	fmt.Printf("%s  X, RESET%s          - Reset the machine (a C64 boots into BASIC)\n", Cyan, Reset)
 	fmt.Printf("%s  Z <start> <end>%s   - Zero memory range\n", Cyan, Reset)
 	fmt.Printf("%s  F <start> <end> <val>%s - Fill memory range with value\n", Cyan, Reset)
 	fmt.Printf("%s  T <src> <dest> <len>%s - Transfer memory block\n", Cyan, Reset)
+	fmt.Printf("%s  DRIVE [R|D|M ...]%s - Show the 1541's registers, disassembly or memory (-drive-rom)\n", Cyan, Reset)
 	fmt.Println()
 	fmt.Printf("%s%sNotes:%s\n", Bold, Yellow, Reset)
-	fmt.Printf("  - Addresses can be in hex ($1000) or decimal (4096)\n")
+	fmt.Printf("  - Addresses can be in hex ($1000), decimal (4096) or a label loaded with SYM\n")
 	fmt.Printf("  - Press Enter to repeat last command\n")
 	fmt.Printf("  - Use Ctrl+C to break running program\n")
 	fmt.Println()
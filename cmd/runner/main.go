@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler/output"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/acia"
+	"github.com/jrsteele09/go-6502-emulator/devices/console"
+	"github.com/jrsteele09/go-6502-emulator/machine/sim65"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+const (
+	version = "1.0.0"
+
+	// exitCycleLimit is returned when the program does not exit within the cycle limit, as timeout(1) does.
+	exitCycleLimit = 124
+	// exitError is returned when the program cannot be loaded or the CPU fails.
+	exitError = 125
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the command and returns its exit code, so that deferred calls complete before the process exits.
+func run() int {
+	var (
+		consoleAddr = flag.String("console", fmt.Sprintf("$%04X", console.DefaultAddress), "Address of the virtual console device")
+		inputFile   = flag.String("in", "", "File the console reads its input from (default: stdin)")
+		startAddr   = flag.String("start", "", "Start address (default: start of the first PRG segment)")
+		maxCycles   = flag.Uint64("cycles", 0, "Stop after this many CPU cycles, 0 for no limit")
+		illegal     = flag.Bool("illegal", false, "Enable undocumented opcodes")
+		sandbox     = flag.String("sandbox", ".", "Directory sim65 programs may open files in")
+		romFile     = flag.String("rom", "", "Run a ROM image, mapped to end at $FFFF, from its reset vector in place of a PRG")
+		aciaAddr    = flag.String("acia", "", "Map a 6551 ACIA at this address, e.g. $5000 (default: disabled)")
+		aciaTCP     = flag.String("acia-tcp", "", "Connect the ACIA to a terminal on this TCP address, e.g. :6551 (default: stdin and stdout)")
+		showHelp    = flag.Bool("h", false, "Show help")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "6502 Headless Runner v%s\n\n", version)
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <program.prg> [more.prg ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] <program.sim65> [args ...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nThe program prints through the virtual console and ends by writing its exit code to\n")
+		fmt.Fprintf(os.Stderr, "console+3, which becomes the runner's exit code. If the cycle limit is reached the runner\n")
+		fmt.Fprintf(os.Stderr, "exits with %d; load and CPU errors exit with %d.\n", exitCycleLimit, exitError)
+		fmt.Fprintf(os.Stderr, "\nPrograms built with cl65 -t sim6502 are detected by their header and run with the sim65\n")
+		fmt.Fprintf(os.Stderr, "paravirtual calls instead of the console; the arguments after the program are passed to main.\n")
+		fmt.Fprintf(os.Stderr, "\nWith -rom the runner is a single-board computer: the ROM, 64K of RAM and, with -acia, a serial\n")
+		fmt.Fprintf(os.Stderr, "port. It runs until the cycle limit or Ctrl+C; PRG files given are loaded into RAM first.\n")
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s test.prg\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -rom monitor.bin -acia '$5000'                   # Terminal on stdin and stdout\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -rom monitor.bin -acia '$5000' -acia-tcp :6551   # Then: telnet localhost 6551\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if *showHelp {
+		flag.Usage()
+		return 0
+	}
+
+	if *romFile != "" {
+		return runROM(*romFile, *aciaAddr, *aciaTCP, flag.Args(), *illegal, *maxCycles)
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Error: a PRG file is required\n\n")
+		flag.Usage()
+		return exitError
+	}
+
+	if image, err := os.ReadFile(flag.Arg(0)); err == nil && sim65.HasHeader(image) {
+		return runSim65(image, flag.Args(), *sandbox, *maxCycles)
+	}
+
+	if err := checkStdinReaders(*aciaAddr, *aciaTCP, *inputFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	addr, err := parseAddress(*consoleAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid console address '%s': %v\n", *consoleAddr, err)
+		return exitError
+	}
+
+	var in io.Reader = os.Stdin
+	if *inputFile != "" {
+		f, err := os.Open(*inputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		defer f.Close()
+		in = f
+	}
+
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), *illegal)
+	con := console.New(in, os.Stdout, bus.CPU)
+	bus.Attach(addr, addr+console.Size-1, con)
+	if *aciaAddr != "" {
+		closeACIA, err := attachACIA(bus, *aciaAddr, *aciaTCP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		defer closeACIA()
+	}
+
+	start, err := loadPRGs(bus, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	if *startAddr != "" {
+		if start, err = parseAddress(*startAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid start address '%s': %v\n", *startAddr, err)
+			return exitError
+		}
+	}
+	bus.CPU.Reg.PC = start
+
+	return runConsole(bus, con, *maxCycles)
+}
+
+// loadPRGs loads each PRG file into memory and returns the start address of the first segment.
+func loadPRGs(bus *devices.Bus, files []string) (uint16, error) {
+	prgFormat := output.NewPRGFormat()
+	var start uint16
+	for i, f := range files {
+		segments, err := prgFormat.LoadFile(f, false)
+		if err != nil {
+			return 0, err
+		}
+		if len(segments) == 0 {
+			return 0, fmt.Errorf("no segments found in %s", f)
+		}
+		for _, segment := range segments {
+			bus.Memory.Write(segment.StartAddress, segment.Data.Bytes()...)
+		}
+		if i == 0 {
+			start = segments[0].StartAddress
+		}
+	}
+	return start, nil
+}
+
+// runConsole clocks the bus until the program exits, the CPU fails or the cycle limit is reached,
+// returning the process exit code.
+func runConsole(bus *devices.Bus, con *console.Console, maxCycles uint64) int {
+	for cycles := uint64(0); maxCycles == 0 || cycles < maxCycles; cycles++ {
+		if code, exited := con.Exited(); exited {
+			return code
+		}
+		if _, err := bus.Step(); err != nil {
+			fmt.Fprintf(os.Stderr, "\nExecution error at $%04X: %v\n", bus.CPU.Reg.PC, err)
+			return exitError
+		}
+	}
+	if code, exited := con.Exited(); exited {
+		return code
+	}
+	fmt.Fprintf(os.Stderr, "\nCycle limit of %d reached at $%04X\n", maxCycles, bus.CPU.Reg.PC)
+	return exitCycleLimit
+}
+
+// runROM runs a ROM image, mapped to end at $FFFF, from its reset vector, like a single-board computer
+// with a serial monitor in ROM. It returns the process exit code.
+func runROM(romFile, aciaAddr, aciaTCP string, prgs []string, illegal bool, maxCycles uint64) int {
+	rom, err := os.ReadFile(romFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	if len(rom) == 0 || len(rom) > 64*1024 {
+		fmt.Fprintf(os.Stderr, "Error: invalid ROM size %d\n", len(rom))
+		return exitError
+	}
+
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), illegal)
+	bus.Memory.Map(uint16(0x10000-len(rom)), 0xFFFF, memory.NewROM[uint16](rom))
+	if aciaAddr != "" {
+		closeACIA, err := attachACIA(bus, aciaAddr, aciaTCP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		defer closeACIA()
+	}
+	if len(prgs) > 0 {
+		if _, err := loadPRGs(bus, prgs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+	}
+	bus.Reset()
+
+	for cycles := uint64(0); maxCycles == 0 || cycles < maxCycles; cycles++ {
+		if _, err := bus.Step(); err != nil {
+			fmt.Fprintf(os.Stderr, "\nExecution error at $%04X: %v\n", bus.CPU.Reg.PC, err)
+			return exitError
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nCycle limit of %d reached at $%04X\n", maxCycles, bus.CPU.Reg.PC)
+	return exitCycleLimit
+}
+
+// checkStdinReaders fails if both the console and the ACIA of a PRG would read stdin, as each would get
+// only some of the input
+func checkStdinReaders(aciaAddr, aciaTCP, inputFile string) error {
+	if aciaAddr != "" && aciaTCP == "" && inputFile == "" {
+		return fmt.Errorf("-acia without -acia-tcp reads stdin, which the console reads too; add -acia-tcp or -in")
+	}
+	return nil
+}
+
+// attachACIA maps a 6551 ACIA at address, connected to a terminal on a TCP address or, if there is none,
+// to stdin and stdout. It returns a function that closes the connection.
+func attachACIA(bus *devices.Bus, address, tcpAddress string) (func(), error) {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACIA address '%s': %w", address, err)
+	}
+	var r io.Reader = newlineReader{os.Stdin}
+	var w io.Writer = os.Stdout
+	closeStream := func() {}
+	if tcpAddress != "" {
+		stream, err := acia.ListenTCP(tcpAddress)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "ACIA listening for a terminal on %s\n", stream.Addr())
+		r, w, closeStream = stream, stream, func() { stream.Close() }
+	}
+	bus.Attach(addr, addr+3, acia.New(bus.IRQ, r, w))
+	return closeStream, nil
+}
+
+// newlineReader sends the Enter key of a terminal line as the carriage return serial monitors expect
+type newlineReader struct {
+	io.Reader
+}
+
+func (n newlineReader) Read(p []byte) (int, error) {
+	count, err := n.Reader.Read(p)
+	for i := range p[:count] {
+		if p[i] == '\n' {
+			p[i] = '\r'
+		}
+	}
+	return count, err
+}
+
+// runSim65 runs a sim65 image with args as its argv, returning the process exit code.
+func runSim65(image []byte, args []string, sandbox string, maxCycles uint64) int {
+	pv, err := sim65.NewParavirt(sandbox, args, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	defer pv.Close()
+
+	machine, err := sim65.New(image, pv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	code, err := machine.Run(maxCycles)
+	if errors.Is(err, sim65.ErrCycleLimit) {
+		fmt.Fprintf(os.Stderr, "\nCycle limit of %d reached at $%04X\n", maxCycles, machine.CPU.Reg.PC)
+		return exitCycleLimit
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nExecution error: %v\n", err)
+		return exitError
+	}
+	return code
+}
+
+// parseAddress parses a hex ($F000, 0xF000) or decimal address.
+func parseAddress(s string) (uint16, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(strings.ToLower(s), "0x"):
+		s, base = s[2:], 16
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckStdinReaders(t *testing.T) {
+	assert.NoError(t, checkStdinReaders("", "", ""))
+	assert.NoError(t, checkStdinReaders("$5000", ":6551", ""))
+	assert.NoError(t, checkStdinReaders("$5000", "", "input.txt"))
+	assert.ErrorContains(t, checkStdinReaders("$5000", "", ""), "-acia without -acia-tcp reads stdin")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jrsteele09/go-6502-emulator/devices/sid"
+	"github.com/jrsteele09/go-6502-emulator/machine/sidplay"
+)
+
+const (
+	version = "1.0.0"
+)
+
+func main() {
+	var (
+		song       = flag.Int("song", 0, "Song to play, 1 based (default: the tune's start song)")
+		seconds    = flag.Float64("seconds", 30, "Length of audio to render")
+		wavFile    = flag.String("o", "", "WAV output file (default: tune filename with .wav extension)")
+		logFile    = flag.String("log", "", "Also write the SID register log to this file")
+		sampleRate = flag.Int("rate", sid.DefaultSampleRate, "WAV sample rate")
+		showHelp   = flag.Bool("h", false, "Show help")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "6502 SID Player v%s\n\n", version)
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <tune.sid>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nPlays a PSID tune headlessly by calling its init routine and then its play routine once per\n")
+		fmt.Fprintf(os.Stderr, "frame, and renders the SID output to a WAV file. The register log lists one write per line:\n")
+		fmt.Fprintf(os.Stderr, "the cycle, the SID register offset and the value in hex.\n")
+	}
+
+	flag.Parse()
+
+	if *showHelp {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: a SID file is required\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	tuneFile := flag.Arg(0)
+	if *wavFile == "" {
+		*wavFile = strings.TrimSuffix(tuneFile, filepath.Ext(tuneFile)) + ".wav"
+	}
+
+	player, err := sidplay.Load(tuneFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	h := player.Header
+	fmt.Printf("%s by %s (%s), %d song(s)\n", h.Name, h.Author, h.Released, h.Songs)
+
+	if err := player.Init(*song); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := player.PlaySeconds(*seconds); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *logFile != "" {
+		if err := writeLog(player.SID, *logFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := player.SID.SaveWAV(*wavFile, *sampleRate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Rendered %.1f seconds to %s\n", *seconds, *wavFile)
+}
+
+// writeLog saves the SID's register log to a file
+func writeLog(s *sid.SID, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := s.WriteLog(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -99,6 +99,12 @@ func (p *CPU) Operands() []byte {
 	return p.operands
 }
 
+// Cycles returns the number of cycles the CPU has executed since it was created, not counting cycles
+// while halted or stalled by RDY.
+func (p *CPU) Cycles() uint64 {
+	return p.cycles
+}
+
 // Stop halts the CPU's execution.
 func (p *CPU) Stop() {
 	p.halted = true
@@ -109,6 +115,11 @@ func (p *CPU) Resume() {
 	p.halted = false
 }
 
+// Halted returns whether the CPU's execution has been stopped.
+func (p *CPU) Halted() bool {
+	return p.halted
+}
+
 // Execute executes the current instruction and returns whether it is completed and any error encountered.
 func (p *CPU) Execute() (Completed, error) {
 	if p.halted {
@@ -200,10 +211,9 @@ func (p *CPU) Push(b byte) {
 
 // Pop pops a byte from the stack.
 func (p *CPU) Pop() byte {
-	a := stackPageAddress + uint16(p.Reg.S)
-	b := p.mem.Read(uint16(a))
 	p.Reg.S++
-	return b
+	a := stackPageAddress + uint16(p.Reg.S)
+	return p.mem.Read(uint16(a))
 }
 
 // Nmi triggers a non-maskable interrupt.
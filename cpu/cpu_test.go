@@ -1602,7 +1602,7 @@ func TestJump(t *testing.T) {
 			assert.Equal(t, uint64(6), p.cycles, name)
 			assert.Equal(t, uint16(0x5100), p.Reg.PC)
 			assert.Equal(t, byte(0xD0), p.mem.Read(stackAddress))
-			assert.Equal(t, byte(0x03), p.mem.Read(stackAddress-1))
+			assert.Equal(t, byte(0x02), p.mem.Read(stackAddress-1))
 		}},
 	}
 	executeTests(t, tests)
@@ -2188,6 +2188,7 @@ func TestPLA(t *testing.T) {
 		{"TestPLA", func(p *CPU) int {
 			p.mem.Write(startAddress, 0x68)
 			p.mem.Write(stackAddress, 0xFF)
+			p.Reg.S = uint8(0xFF - 1)
 			p.Reg.A = 0x00
 			return 1
 		}, func(t *testing.T, p *CPU, name string) {
@@ -2203,10 +2204,11 @@ func TestPLP(t *testing.T) {
 		{"TestPLP", func(p *CPU) int {
 			p.mem.Write(startAddress, 0x28)
 			p.mem.Write(stackAddress, 0xFF)
+			p.Reg.S = uint8(0xFF - 1)
 			p.Reg.Status = 0x00
 			return 1
 		}, func(t *testing.T, p *CPU, name string) {
-			assert.Equal(t, uint8(0xFF), p.Reg.Status) // Check values on stack
+			assert.Equal(t, uint8(0xCF), p.Reg.Status) // Check values on stack, without B and the unused bit
 			assert.Equal(t, uint64(4), p.cycles, name)
 		}},
 	}
@@ -2380,7 +2382,7 @@ func TestRTI(t *testing.T) {
 			p.mem.Write(stackAddress, 0xC0)
 			p.mem.Write(stackAddress-1, 0x00)
 			p.mem.Write(stackAddress-2, 0xFF)
-			p.Reg.S = uint8(0xFF - 2)
+			p.Reg.S = uint8(0xFF - 3)
 			return 1
 		}, func(t *testing.T, p *CPU, name string) {
 			assert.Equal(t, uint64(6), p.cycles, name)
@@ -2397,11 +2399,11 @@ func TestRTS(t *testing.T) {
 			p.mem.Write(startAddress, 0x60)
 			p.mem.Write(stackAddress, 0xC0)
 			p.mem.Write(stackAddress-1, 0x00)
-			p.Reg.S = uint8(0xFF - 1)
+			p.Reg.S = uint8(0xFF - 2)
 			return 1
 		}, func(t *testing.T, p *CPU, name string) {
 			assert.Equal(t, uint64(6), p.cycles, name)
-			assert.Equal(t, uint16(0xC000), p.Reg.PC, name)
+			assert.Equal(t, uint16(0xC001), p.Reg.PC, name)
 		}},
 	}
 	executeTests(t, tests)
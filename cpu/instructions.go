@@ -571,8 +571,10 @@ func (p *CPU) jmp(opcode OpCodeDef) InstructionFunc {
 
 func (p *CPU) jsr(opcode OpCodeDef) InstructionFunc {
 	return func() (Completed, error) {
-		p.Push(byte((p.Reg.PC & 0xFF00) >> 8))
-		p.Push(byte(p.Reg.PC & 0x00FF))
+		// The return address pushed is the last byte of the JSR instruction, RTS adds one
+		returnAddress := p.Reg.PC - 1
+		p.Push(byte((returnAddress & 0xFF00) >> 8))
+		p.Push(byte(returnAddress & 0x00FF))
 		address := opcode.AddressingMode.Address(p)
 		p.Reg.PC = address
 		return true, nil
@@ -676,7 +678,7 @@ func (p *CPU) pha(_ OpCodeDef) InstructionFunc {
 
 func (p *CPU) php(_ OpCodeDef) InstructionFunc {
 	return func() (Completed, error) {
-		p.Push(p.Reg.S)
+		p.Push(p.Reg.Status | BreakFlag | UnusedFlag)
 		return true, nil
 	}
 }
@@ -690,7 +692,9 @@ func (p *CPU) pla(_ OpCodeDef) InstructionFunc {
 
 func (p *CPU) plp(_ OpCodeDef) InstructionFunc {
 	return func() (Completed, error) {
-		p.Reg.Status = p.Pop()
+		// The B and unused bits are not flags in the register, so the ones pulled are ignored
+		ignored := byte(BreakFlag | UnusedFlag)
+		p.Reg.Status = (p.Pop() &^ ignored) | (p.Reg.Status & ignored)
 		return true, nil
 	}
 }
@@ -760,7 +764,7 @@ func (p *CPU) rts(_ OpCodeDef) InstructionFunc {
 	return func() (Completed, error) {
 		lowBytePC := p.Pop()
 		hiBytePC := p.Pop()
-		p.Reg.PC = (uint16(lowBytePC) | (uint16(hiBytePC) << 8))
+		p.Reg.PC = (uint16(lowBytePC) | (uint16(hiBytePC) << 8)) + 1
 		return true, nil
 	}
 }
@@ -2,34 +2,50 @@ package debugger
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 
+	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/assembler/cbmbasic"
 	"github.com/jrsteele09/go-6502-emulator/assembler/output"
 	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/console"
 	"github.com/jrsteele09/go-6502-emulator/memory"
 )
 
 // Debugger represents the 6502 debugger core functionality
 type Debugger struct {
-	cpu            cpu.CPU6502
-	memory         *memory.Memory[uint16]
+	bus            *devices.Bus
+	cpu            *cpu.CPU
+	memory         memory.Operations[uint16]
+	console        *console.Console
 	disassembler   *Disassembler
 	breakpoints    map[uint16]bool
 	running        bool
 	lastDisasmAddr uint16
+	onLoad         func(start, end uint16)
+	keepLoadPC     bool
+	symbols        map[string]uint16 // Label addresses by name, loaded with LoadSymbols
 }
 
 // NewDebugger creates a new 6502 debugger instance
 func NewDebugger() *Debugger {
-	mem := memory.NewMemory[uint16](64 * 1024) // 64KB memory
-	cpuInstance := cpu.NewCPU(mem, false)
-	opcodes := cpuInstance.OpCodes()
-	disasm := NewDisassembler(mem, opcodes)
+	ram := memory.NewMemory[uint16](64 * 1024) // 64KB memory
+	return NewDebuggerWithBus(devices.NewBus(ram, false))
+}
+
+// NewDebuggerWithBus creates a debugger for an existing machine, such as a C64, built on a device bus
+func NewDebuggerWithBus(bus *devices.Bus) *Debugger {
+	opcodes := bus.CPU.OpCodes()
+	disasm := NewDisassembler(bus.Memory, opcodes)
 
 	return &Debugger{
-		cpu:            cpuInstance,
-		memory:         mem,
+		bus:            bus,
+		cpu:            bus.CPU,
+		memory:         bus.Memory,
 		disassembler:   disasm,
 		breakpoints:    make(map[uint16]bool),
 		running:        false,
@@ -43,10 +59,61 @@ func (d *Debugger) GetCPU() cpu.CPU6502 {
 }
 
 // GetMemory returns the memory instance for external access
-func (d *Debugger) GetMemory() *memory.Memory[uint16] {
+func (d *Debugger) GetMemory() memory.Operations[uint16] {
 	return d.memory
 }
 
+// SetLoadHook sets a function called with the start and end address of each segment loaded by LoadPRG,
+// so a machine can update its own pointers as its loader would
+func (d *Debugger) SetLoadHook(f func(start, end uint16)) {
+	d.onLoad = f
+}
+
+// SetKeepLoadAddress makes LoadPRG leave the PC at the load address of a BASIC program, only reporting
+// where its SYS or USR call starts machine code, rather than moving the PC there
+func (d *Debugger) SetKeepLoadAddress(keep bool) {
+	d.keepLoadPC = keep
+}
+
+// Reset resets the machine as its reset line does and reports where execution starts
+func (d *Debugger) Reset() string {
+	d.bus.Reset()
+	d.lastDisasmAddr = d.cpu.Reg.PC
+	return fmt.Sprintf("Reset: PC set to %s\n", d.FormatAddress(d.cpu.Reg.PC))
+}
+
+// GetBus returns the device bus for attaching devices
+func (d *Debugger) GetBus() *devices.Bus {
+	return d.bus
+}
+
+// AttachConsole maps a paravirtual console at address, printing to out and reading from in
+func (d *Debugger) AttachConsole(address uint16, in io.Reader, out io.Writer) *console.Console {
+	d.console = console.New(in, out, d.cpu)
+	d.bus.Attach(address, address+console.Size-1, d.console)
+	return d.console
+}
+
+// exited reports whether the program has written to the console's exit register
+func (d *Debugger) exited() (string, bool) {
+	if d.console == nil {
+		return "", false
+	}
+	code, exited := d.console.Exited()
+	if !exited {
+		return "", false
+	}
+	return fmt.Sprintf("Program exited with code %d\n", code), true
+}
+
+// resume clears a previous console exit so the program can run again
+func (d *Debugger) resume() {
+	if d.console != nil {
+		d.console.Reset()
+	}
+	d.cpu.Resume()
+}
+
 // GetBreakpoints returns the breakpoints map for external access
 func (d *Debugger) GetBreakpoints() map[uint16]bool {
 	return d.breakpoints
@@ -88,12 +155,19 @@ func (d *Debugger) AutoLoadPRGs(files []string) string {
 	return b.String()
 }
 
-// parseAddress parses an address string (hex or decimal)
+// parseAddress parses an address string (hex or decimal), or the name of a label loaded with LoadSymbols
 func (d *Debugger) ParseAddress(addr string) (uint16, error) {
 	if addr == "" {
 		return 0, fmt.Errorf("address required")
 	}
 
+	if address, found := d.symbols[addr]; found {
+		return address, nil
+	}
+	if addr[0] != '$' && (addr[0] < '0' || addr[0] > '9') {
+		return 0, fmt.Errorf("unknown label '%s'", addr)
+	}
+
 	if strings.HasPrefix(addr, "$") {
 		// Hex address
 		val, err := strconv.ParseUint(addr[1:], 16, 16)
@@ -193,6 +267,13 @@ func (d *Debugger) ShowRegisters() string {
 	result += fmt.Sprintf("  Flags: %s (%%%s) (%s)  NV1BDIZC\n",
 		d.FormatByte(status), binary, flags)
 
+	// Device state, such as the VIC-II raster position
+	for _, dev := range d.bus.Devices() {
+		if i, ok := dev.(devices.Inspectable); ok {
+			result += fmt.Sprintf("  %s\n", i.Inspect())
+		}
+	}
+
 	return result
 }
 
@@ -237,6 +318,9 @@ func (d *Debugger) Disassemble(args []string) string {
 			marker = "*"
 		}
 
+		if name, found := d.disassembler.Label(addr); found {
+			result += fmt.Sprintf("  %s:\n", name)
+		}
 		instruction, length := d.disassembler.Disassemble(addr)
 		result += fmt.Sprintf("%-1s %s\n", marker, instruction)
 		addr += uint16(length)
@@ -309,8 +393,42 @@ func (d *Debugger) HexDump(args []string) string {
 	return result
 }
 
-// LoadPRG loads a PRG file into memory
+// LoadSymbols loads the labels of a symbol file the assembler wrote in the JSON or plain format, so that
+// addresses can be given by label and the disassembly names them. An address with more than one label is
+// shown by the shortest, as a .PROC's label is shorter than the qualified labels inside it.
+func (d *Debugger) LoadSymbols(filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Sprintf("Error loading symbols: %v\n", err)
+	}
+	defer f.Close()
+	symbols, err := assembler.ReadSymbols(f)
+	if err != nil {
+		return fmt.Sprintf("Error loading symbols: %v\n", err)
+	}
+
+	d.symbols = make(map[string]uint16)
+	labels := make(map[uint16]string)
+	for _, s := range symbols {
+		if s.Kind != assembler.LabelSymbol || s.Value < 0 || s.Value > 0xFFFF {
+			continue
+		}
+		address := uint16(s.Value)
+		d.symbols[s.Name] = address
+		if name, found := labels[address]; !found || len(s.Name) < len(name) || (len(s.Name) == len(name) && s.Name < name) {
+			labels[address] = s.Name
+		}
+	}
+	d.disassembler.SetLabels(labels)
+	return fmt.Sprintf("Loaded %d labels from %s\n", len(d.symbols), filename)
+}
+
+// LoadPRG loads a PRG file into memory. SID tunes are recognised by their header and loaded with LoadSID.
 func (d *Debugger) LoadPRG(filename string) string {
+	if data, err := os.ReadFile(filename); err == nil && output.IsSID(data) {
+		return d.LoadSID(filename, 0)
+	}
+
 	// Use the PRG format loader
 	prgFormat := output.NewPRGFormat()
 	segments, err := prgFormat.LoadFile(filename, false)
@@ -330,6 +448,9 @@ func (d *Debugger) LoadPRG(filename string) string {
 		for j, b := range segment.Data.Bytes() {
 			d.memory.Write(segment.StartAddress+uint16(j), b)
 		}
+		if d.onLoad != nil {
+			d.onLoad(segment.StartAddress, segment.StartAddress+uint16(len(segment.Data.Bytes())))
+		}
 
 		totalBytes += len(segment.Data.Bytes())
 		result += fmt.Sprintf("  Segment %d: %s to %s (%d bytes)\n",
@@ -341,12 +462,125 @@ func (d *Debugger) LoadPRG(filename string) string {
 
 	result += fmt.Sprintf("Total: %d bytes loaded\n", totalBytes)
 
-	// Set PC to first segment's start address
-	if len(segments) > 0 {
-		d.cpu.Registers().PC = segments[0].StartAddress
-		d.lastDisasmAddr = segments[0].StartAddress // Reset disassembly position to PC
-		result += fmt.Sprintf("PC set to %s\n", d.FormatAddress(segments[0].StartAddress))
+	// Set PC to first segment's start address, or to the machine code a BASIC loader starts
+	start := segments[0].StartAddress
+	if start == cbmbasic.BASICStart {
+		listing, entry, ok := d.listBASIC(segments[0].Data.Bytes(), start)
+		result += listing
+		switch {
+		case ok && d.keepLoadPC:
+			result += fmt.Sprintf("BASIC program starts machine code at %s; use G %s to run it\n",
+				d.FormatAddress(entry), d.FormatAddress(entry))
+		case ok:
+			result += fmt.Sprintf("BASIC program starts machine code at %s; use G %s to run the BASIC line instead\n",
+				d.FormatAddress(entry), d.FormatAddress(start))
+			start = entry
+		}
 	}
+	d.cpu.Registers().PC = start
+	d.lastDisasmAddr = start // Reset disassembly position to PC
+	result += fmt.Sprintf("PC set to %s\n", d.FormatAddress(start))
+
+	return result
+}
+
+// listBASIC lists a tokenized BASIC program loaded at start and finds the address its SYS or USR call
+// starts machine code at
+func (d *Debugger) listBASIC(data []byte, start uint16) (string, uint16, bool) {
+	lines, err := cbmbasic.DetokenizeBASIC(data, start)
+	if len(lines) == 0 {
+		return "", 0, false
+	}
+	result := "BASIC listing:\n"
+	for _, line := range lines {
+		result += fmt.Sprintf("  %s\n", line)
+	}
+	if err != nil {
+		result += fmt.Sprintf("  (listing stopped: %v)\n", err)
+	}
+	entry, keyword, ok := cbmbasic.BASICEntryPoint(lines)
+	switch {
+	case ok:
+		result += fmt.Sprintf("  %s target: %s\n", keyword, d.FormatAddress(entry))
+	case keyword != "":
+		result += fmt.Sprintf("  %s target: no literal target\n", keyword)
+	}
+	return result, entry, ok
+}
+
+// ListBASIC lists the tokenized BASIC program in memory at the given address, $0801 by default
+func (d *Debugger) ListBASIC(args []string) string {
+	start := uint16(cbmbasic.BASICStart)
+	if len(args) > 0 {
+		addr, err := d.ParseAddress(args[0])
+		if err != nil {
+			return fmt.Sprintf("Error parsing address: %v\n", err)
+		}
+		start = addr
+	}
+
+	listing, _, _ := d.listBASIC(d.readBASIC(start), start)
+	if listing == "" {
+		return fmt.Sprintf("No BASIC program at %s\n", d.FormatAddress(start))
+	}
+	return listing
+}
+
+// readBASIC copies the BASIC program at start out of memory for listing. Reading through the bus can
+// change a device, such as a CIA clearing its interrupts, so it follows the link pointers and reads only
+// each line's link, number and tokens, stopping at the zero link or a link that does not point forward.
+func (d *Debugger) readBASIC(start uint16) []byte {
+	var data []byte
+	for address := int(start); address+2 <= 0x10000; {
+		data = append(data, make([]byte, address-int(start)-len(data))...) // Bytes between lines are not read
+		lo, hi := d.memory.Read(uint16(address)), d.memory.Read(uint16(address+1))
+		data = append(data, lo, hi)
+		link := int(lo) | int(hi)<<8
+		if link <= address+4 {
+			return data
+		}
+		for i := address + 2; i < link; i++ {
+			b := d.memory.Read(uint16(i))
+			data = append(data, b)
+			if b == 0 && i >= address+4 {
+				break
+			}
+		}
+		address = link
+	}
+	return data
+}
+
+// LoadSID loads a PSID/RSID tune into memory and prepares a call to its init routine for a 1 based
+// song number, 0 for the tune's start song
+func (d *Debugger) LoadSID(filename string, song int) string {
+	sidFormat := &output.SIDFormat{}
+	segments, err := sidFormat.LoadFile(filename, false)
+	if err != nil {
+		return fmt.Sprintf("Error loading SID file: %v\n", err)
+	}
+	h := sidFormat.SIDHeader
+	if song == 0 {
+		song = int(h.StartSong)
+	}
+
+	segment := segments[0]
+	d.memory.Write(segment.StartAddress, segment.Data.Bytes()...)
+
+	result := fmt.Sprintf("Loaded %s file: %s\n", h.Magic, filename)
+	result += fmt.Sprintf("  %s by %s (%s)\n", h.Name, h.Author, h.Released)
+	result += fmt.Sprintf("  %s to %s (%d bytes), %d song(s)\n",
+		d.FormatAddress(segment.StartAddress),
+		d.FormatAddress(segment.StartAddress+uint16(segment.Data.Len())-1),
+		segment.Data.Len(), h.Songs)
+	if h.PlayAddress != 0 {
+		result += fmt.Sprintf("  Play routine at %s, call it once per frame after init\n", d.FormatAddress(h.PlayAddress))
+	}
+
+	d.cpu.Registers().PC = h.InitAddress
+	d.cpu.Registers().A = byte(song - 1)
+	d.lastDisasmAddr = h.InitAddress
+	result += fmt.Sprintf("PC set to init at %s with A=%s (song %d)\n", d.FormatAddress(h.InitAddress), d.FormatByte(byte(song-1)), song)
 
 	return result
 }
@@ -360,6 +594,7 @@ func (d *Debugger) Step(args []string) string {
 		}
 	}
 
+	d.resume()
 	result := ""
 	for i := 0; i < count; i++ {
 		pc := d.cpu.Registers().PC
@@ -374,14 +609,19 @@ func (d *Debugger) Step(args []string) string {
 
 		completed := cpu.Completed(false)
 		var err error
-		for !completed {
-			completed, err = d.cpu.Execute()
+		for !bool(completed) && !d.cpu.Halted() {
+			completed, err = d.bus.Step()
 			if err != nil {
 				result += fmt.Sprintf("Execution error: %v\n", err)
 				break
 			}
 		}
 
+		if msg, exited := d.exited(); exited {
+			result += msg
+			break
+		}
+
 		if !bool(completed) {
 			result += "Instruction not completed\n"
 		}
@@ -413,14 +653,16 @@ func (d *Debugger) Go(args []string) string {
 	startPC := d.cpu.Registers().PC
 	result := fmt.Sprintf("Running from %s... (Ctrl+C to break)\n", d.FormatAddress(startPC))
 
+	d.resume()
 	d.running = true
 	instructionCount := 0
+	boundary := true // Breakpoints are only checked between instructions
 
 	for d.running {
 		pc := d.cpu.Registers().PC
 
 		// Check for breakpoint
-		if d.breakpoints[pc] {
+		if boundary && d.breakpoints[pc] {
 			result += fmt.Sprintf("\nBreakpoint hit at %s\n", d.FormatAddress(pc))
 			instruction, _ := d.disassembler.Disassemble(pc)
 			result += fmt.Sprintf("Next: %s\n", instruction)
@@ -428,14 +670,21 @@ func (d *Debugger) Go(args []string) string {
 			break
 		}
 
-		completed, err := d.cpu.Execute()
+		completed, err := d.bus.Step()
 		if err != nil {
 			result += fmt.Sprintf("\nExecution error at %s: %v\n", d.FormatAddress(pc), err)
 			d.running = false
 			break
 		}
 
-		if !bool(completed) {
+		if msg, exited := d.exited(); exited {
+			result += "\n" + msg
+			d.running = false
+			break
+		}
+
+		boundary = bool(completed)
+		if !boundary {
 			// Instruction needs more cycles, continue
 			continue
 		}
@@ -0,0 +1,125 @@
+package debugger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler/cbmbasic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPRGWithSYSStub(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stub.prg")
+	require.NoError(t, os.WriteFile(file, []byte{
+		0x01, 0x08, // Load address
+		0x0C, 0x08, 0x0A, 0x00, 0x9E, 0x20, 0x32, 0x30, 0x36, 0x34, 0x00, // 10 SYS 2064
+		0x00, 0x00, 0x00, 0x00, 0x00,
+		0x60, // $0810: RTS
+	}, 0644))
+
+	d := NewDebugger()
+	result := d.LoadPRG(file)
+	assert.Contains(t, result, "10 SYS 2064")
+	assert.Contains(t, result, "SYS target: $0810")
+	assert.Equal(t, uint16(0x0810), d.GetCPU().Registers().PC)
+}
+
+func TestLoadPRGWithoutBASIC(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "code.prg")
+	require.NoError(t, os.WriteFile(file, []byte{0x00, 0x10, 0xA9, 0x01, 0x60}, 0644))
+
+	d := NewDebugger()
+	result := d.LoadPRG(file)
+	assert.NotContains(t, result, "BASIC listing")
+	assert.Equal(t, uint16(0x1000), d.GetCPU().Registers().PC)
+}
+
+func TestListBASIC(t *testing.T) {
+	d := NewDebugger()
+	assert.Contains(t, d.ListBASIC(nil), "No BASIC program")
+
+	data, err := cbmbasic.TokenizeBASIC("10 PRINT\"{clr}\"\n20 GOTO 10\n", 0x1001)
+	require.NoError(t, err)
+	for i, b := range data {
+		d.GetMemory().Write(0x1001+uint16(i), b)
+	}
+	result := d.ListBASIC([]string{"$1001"})
+	assert.Contains(t, result, "10 PRINT\"{clr}\"")
+	assert.Contains(t, result, "20 GOTO 10")
+}
+
+// readCounter is a device that counts the reads of its registers
+type readCounter struct {
+	reads int
+}
+
+func (r *readCounter) Read(uint16) byte      { r.reads++; return 0 }
+func (r *readCounter) Write(uint16, ...byte) {}
+func (r *readCounter) Tick()                 {}
+func (r *readCounter) Reset()                {}
+
+func TestListBASICReadsOnlyTheProgram(t *testing.T) {
+	d := NewDebugger()
+	device := &readCounter{}
+	d.GetBus().Attach(0x0900, 0xFFFF, device)
+
+	data, err := cbmbasic.TokenizeBASIC("10 PRINT\"HI\"\n20 GOTO 10\n", cbmbasic.BASICStart)
+	require.NoError(t, err)
+	for i, b := range data {
+		d.GetMemory().Write(cbmbasic.BASICStart+uint16(i), b)
+	}
+	result := d.ListBASIC(nil)
+	assert.Contains(t, result, "20 GOTO 10")
+	assert.Zero(t, device.reads, "LIST read past the end of the program")
+}
+
+func TestLoadPRGKeepLoadAddress(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stub.prg")
+	data, err := cbmbasic.TokenizeBASIC("10 SYS 2064\n", cbmbasic.BASICStart)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, append([]byte{0x01, 0x08}, data...), 0644))
+
+	d := NewDebugger()
+	d.SetKeepLoadAddress(true)
+	result := d.LoadPRG(file)
+	assert.Contains(t, result, "SYS target: $0810")
+	assert.Contains(t, result, "use G $0810 to run it")
+	assert.Equal(t, uint16(cbmbasic.BASICStart), d.GetCPU().Registers().PC)
+}
+
+func TestLoadPRGWithoutLiteralSYS(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "expr.prg")
+	data, err := cbmbasic.TokenizeBASIC("10 SYS 40960+256\n", cbmbasic.BASICStart)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, append([]byte{0x01, 0x08}, data...), 0644))
+
+	d := NewDebugger()
+	result := d.LoadPRG(file)
+	assert.Contains(t, result, "SYS target: no literal target")
+	assert.Equal(t, uint16(cbmbasic.BASICStart), d.GetCPU().Registers().PC)
+}
+
+func TestLoadSymbols(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "game.sym")
+	require.NoError(t, os.WriteFile(file, []byte("BORDER = $D020 ; constant\nplayer = $C003 ; label\n"+
+		"player::update = $C003 ; label\nstart = $C000 ; label\n"), 0644))
+
+	d := NewDebugger()
+	assert.Equal(t, "Loaded 3 labels from "+file+"\n", d.LoadSymbols(file))
+
+	address, err := d.ParseAddress("player::update")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xC003), address)
+	_, err = d.ParseAddress("BORDER")
+	assert.ErrorContains(t, err, "unknown label 'BORDER'")
+
+	d.GetMemory().Write(0xC000, 0x20, 0x03, 0xC0, 0xEE, 0x20, 0xD0) // JSR player, INC $D020
+	result := d.Disassemble([]string{"start", "2"})
+	assert.Contains(t, result, "  start:\n")
+	assert.Contains(t, result, "JSR $C003 ; player\n")
+	assert.Contains(t, result, "  player:\n")
+
+	assert.Contains(t, d.LoadSymbols(filepath.Join(t.TempDir(), "missing.sym")), "Error loading symbols")
+}
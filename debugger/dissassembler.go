@@ -15,18 +15,30 @@ const (
 
 // Disassembler is used to convert machine code into human-readable assembly instructions.
 type Disassembler struct {
-	mem     *memory.Memory[uint16]
+	mem     memory.Operations[uint16]
 	opCodes []*cpu.OpCodeDef
+	labels  map[uint16]string // The label shown for each address, set by SetLabels
 }
 
 // NewDisassembler creates a new Disassembler instance.
-func NewDisassembler(mem *memory.Memory[uint16], opCodes []*cpu.OpCodeDef) *Disassembler {
+func NewDisassembler(mem memory.Operations[uint16], opCodes []*cpu.OpCodeDef) *Disassembler {
 	return &Disassembler{
 		mem:     mem,
 		opCodes: opCodes,
 	}
 }
 
+// SetLabels sets the names of labelled addresses, shown after an instruction whose operand is one of them.
+func (d *Disassembler) SetLabels(labels map[uint16]string) {
+	d.labels = labels
+}
+
+// Label returns the label of an address, if it has one.
+func (d *Disassembler) Label(address uint16) (string, bool) {
+	name, found := d.labels[address]
+	return name, found
+}
+
 // Disassemble disassembles the machine code at the given address and returns the assembly instruction and its length.
 func (d *Disassembler) Disassemble(address uint16) (string, int) {
 	b := d.mem.Read(address)
@@ -44,10 +56,30 @@ func (d *Disassembler) Disassemble(address uint16) (string, int) {
 		operands[i] = d.mem.Read(uint16(address + uint16(1+i)))
 	}
 
-	return strings.TrimSpace(fmt.Sprintf(disassemblyFormat,
+	instruction := strings.TrimSpace(fmt.Sprintf(disassemblyFormat,
 		fmt.Sprintf("$%04X:", address),
 		strings.ToUpper(d.operandsToByteString(b, operands, opCode.Bytes)),
-		strings.ToUpper(d.opCodeToString(*opCode, operands, address)))), opCode.Bytes
+		strings.ToUpper(d.opCodeToString(*opCode, operands, address))))
+	if target, ok := operandAddress(*opCode, operands, address); ok {
+		if name, found := d.labels[target]; found {
+			instruction += " ; " + name
+		}
+	}
+	return instruction, opCode.Bytes
+}
+
+// operandAddress returns the address an instruction's operand refers to, if it is not immediate data.
+func operandAddress(opcode cpu.OpCodeDef, operands []byte, address uint16) (uint16, bool) {
+	mode := string(opcode.AddressingModeType)
+	switch {
+	case opcode.AddressingModeType == cpu.RelativeModeStr:
+		return uint16(int64(address) + 2 + int64(int8(operands[0]))), true
+	case strings.Contains(mode, cpu.WordAddressing):
+		return uint16(operands[0]) | uint16(operands[1])<<8, true
+	case strings.Contains(mode, cpu.ByteAddressing) && opcode.AddressingModeType != cpu.ImmediateModeStr:
+		return uint16(operands[0]), true
+	}
+	return 0, false
 }
 
 // operandsToByteString converts the operands to a string of hexadecimal values.
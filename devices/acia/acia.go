@@ -0,0 +1,283 @@
+// Package acia emulates the MOS/WDC 6551 Asynchronous Communications Interface Adapter,
+// bridging its transmit and receive registers to a host io.Reader/io.Writer pair.
+package acia
+
+import (
+	"io"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// Register offsets, mirrored every 4 bytes.
+const (
+	DataRegister    = 0x0 // Read: receive data. Write: transmit data
+	StatusRegister  = 0x1 // Read: status. Write: programmed reset
+	CommandRegister = 0x2
+	ControlRegister = 0x3
+
+	registerCount = 4
+)
+
+// Status register bits.
+const (
+	StatusParityError        byte = 0x01
+	StatusFramingError       byte = 0x02
+	StatusOverrun            byte = 0x04
+	StatusReceiverFull       byte = 0x08 // RDRF
+	StatusTransmitterEmpty   byte = 0x10 // TDRE
+	StatusDataCarrierMissing byte = 0x20 // DCD high: no carrier
+	StatusDataSetNotReady    byte = 0x40 // DSR high: not ready
+	StatusInterrupt          byte = 0x80
+)
+
+// Command register bits.
+const (
+	commandDTR                  byte = 0x01 // Data terminal ready: enables the receiver and transmitter
+	commandReceiverIRQDisable   byte = 0x02
+	commandTransmitterControl   byte = 0x0C
+	commandTransmitterIRQEnable byte = 0x04 // Transmitter control 01: RTS low, transmit interrupt enabled
+	commandEcho                 byte = 0x10
+	commandParityEnable         byte = 0x20
+)
+
+// Control register bits.
+const (
+	controlBaudRate   byte = 0x0F
+	controlWordLength byte = 0x60
+	controlStopBits   byte = 0x80
+)
+
+// baudRates maps the control register baud rate select to bits per second. Rate 0 is the 16x external
+// clock which, with the standard 1.8432MHz crystal, gives 115200 baud.
+var baudRates = [16]uint64{115200, 50, 75, 110, 135, 150, 300, 600, 1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200}
+
+// Ensure ACIA implements the Device interface.
+var _ devices.Device = &ACIA{}
+
+// ACIA represents a 6551 ACIA whose serial lines are bridged to a host stream.
+type ACIA struct {
+	interrupt *devices.InterruptLine
+	out       io.Writer
+	rx        chan byte
+
+	status  byte
+	command byte
+	control byte
+	rxData  byte
+
+	// Baud rate timing
+	cpuHz    uint64
+	txCycles uint64 // Cycles until the transmitter is empty again
+	txData   byte
+	rxCycles uint64 // Cycles until the next frame can be received
+}
+
+// New creates an ACIA that receives from r and transmits to w; either may be nil.
+// Received bytes are read from r on a background goroutine and delivered to the CPU as the
+// receive register is emptied, so no data is lost when the program is slow to read.
+func New(interrupt *devices.InterruptLine, r io.Reader, w io.Writer) *ACIA {
+	a := &ACIA{
+		interrupt: interrupt,
+		out:       w,
+		rx:        make(chan byte, 256),
+	}
+	if r != nil {
+		go a.readLoop(r)
+	} else {
+		close(a.rx)
+	}
+	a.Reset()
+	return a
+}
+
+// SetBaudTiming enables baud rate timing for a CPU clocked at cpuHz: the transmitter stays busy and
+// the receiver waits for a whole frame at the programmed baud rate, and a byte that arrives while the
+// receive register is still full is lost and flagged as an overrun. Pass 0 to disable timing.
+func (a *ACIA) SetBaudTiming(cpuHz uint64) {
+	a.cpuHz = cpuHz
+}
+
+func (a *ACIA) readLoop(r io.Reader) {
+	defer close(a.rx)
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			a.rx <- buf[0]
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Reset performs a hardware reset.
+func (a *ACIA) Reset() {
+	a.status = StatusTransmitterEmpty
+	a.command = 0
+	a.control = 0
+	a.txCycles, a.rxCycles = 0, 0
+	a.updateInterrupt()
+}
+
+// Read reads an ACIA register. Reading the status clears the interrupt flag, reading the data clears RDRF.
+func (a *ACIA) Read(address uint16) byte {
+	switch address % registerCount {
+	case DataRegister:
+		a.status &^= StatusReceiverFull | StatusOverrun | StatusParityError | StatusFramingError
+		a.updateInterrupt()
+		return a.rxData
+	case StatusRegister:
+		s := a.status
+		a.status &^= StatusInterrupt
+		a.interrupt.Set(a, false)
+		return s
+	case CommandRegister:
+		return a.command
+	case ControlRegister:
+		return a.control
+	}
+	return 0
+}
+
+// Write writes data to consecutive ACIA registers starting at address.
+func (a *ACIA) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		a.writeRegister(address+uint16(i), b)
+	}
+}
+
+func (a *ACIA) writeRegister(address uint16, b byte) {
+	switch address % registerCount {
+	case DataRegister:
+		a.transmit(b)
+	case StatusRegister:
+		// Programmed reset: clears the low command bits and the overrun flag, leaves the control register
+		a.command &^= 0x1F
+		a.status &^= StatusOverrun
+		a.updateInterrupt()
+	case CommandRegister:
+		a.command = b
+		a.updateInterrupt()
+	case ControlRegister:
+		a.control = b
+	}
+}
+
+// Tick advances the baud rate timers by one CPU cycle and receives any waiting byte.
+func (a *ACIA) Tick() {
+	if a.txCycles > 0 {
+		a.txCycles--
+		if a.txCycles == 0 {
+			a.completeTransmit()
+		}
+	}
+	if a.rxCycles > 0 {
+		a.rxCycles--
+		return
+	}
+	a.receive()
+}
+
+func (a *ACIA) transmit(b byte) {
+	if a.command&commandDTR == 0 {
+		return
+	}
+	a.txData = b & a.wordMask()
+	a.status &^= StatusTransmitterEmpty
+	if a.cpuHz == 0 {
+		a.completeTransmit()
+		return
+	}
+	a.txCycles = a.frameCycles()
+	a.updateInterrupt()
+}
+
+func (a *ACIA) completeTransmit() {
+	if a.out != nil {
+		a.out.Write([]byte{a.txData})
+	}
+	a.status |= StatusTransmitterEmpty
+	a.updateInterrupt()
+}
+
+func (a *ACIA) receive() {
+	if a.command&commandDTR == 0 {
+		return
+	}
+	// Without baud timing the stream is flow controlled: wait for the program to read the last byte
+	if a.cpuHz == 0 && a.status&StatusReceiverFull != 0 {
+		return
+	}
+	select {
+	case b, ok := <-a.rx:
+		if !ok {
+			return
+		}
+		if a.status&StatusReceiverFull != 0 {
+			a.status |= StatusOverrun
+		} else {
+			a.rxData = b & a.wordMask()
+			a.status |= StatusReceiverFull
+		}
+		if a.command&commandEcho != 0 && a.out != nil {
+			a.out.Write([]byte{b})
+		}
+		if a.cpuHz > 0 {
+			a.rxCycles = a.frameCycles()
+		}
+		a.updateInterrupt()
+	default:
+	}
+}
+
+// Ready reports whether a received byte is waiting in the receive register.
+func (a *ACIA) Ready() bool {
+	return a.status&StatusReceiverFull != 0
+}
+
+// BaudRate returns the programmed baud rate.
+func (a *ACIA) BaudRate() uint64 {
+	return baudRates[a.control&controlBaudRate]
+}
+
+// frameCycles returns the CPU cycles taken by one serial frame at the programmed baud rate.
+func (a *ACIA) frameCycles() uint64 {
+	bits := uint64(1 + a.wordLength()) // Start bit + data
+	if a.command&commandParityEnable != 0 {
+		bits++
+	}
+	bits++
+	if a.control&controlStopBits != 0 {
+		bits++
+	}
+	cycles := a.cpuHz * bits / a.BaudRate()
+	if cycles == 0 {
+		cycles = 1
+	}
+	return cycles
+}
+
+func (a *ACIA) wordLength() int {
+	return 8 - int((a.control&controlWordLength)>>5)
+}
+
+func (a *ACIA) wordMask() byte {
+	return byte(0xFF >> (8 - a.wordLength()))
+}
+
+func (a *ACIA) updateInterrupt() {
+	active := false
+	if a.command&commandDTR != 0 {
+		if a.status&StatusReceiverFull != 0 && a.command&commandReceiverIRQDisable == 0 {
+			active = true
+		}
+		if a.status&StatusTransmitterEmpty != 0 && a.command&commandTransmitterControl == commandTransmitterIRQEnable {
+			active = true
+		}
+	}
+	if active {
+		a.status |= StatusInterrupt
+	}
+	a.interrupt.Set(a, active)
+}
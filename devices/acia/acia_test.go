@@ -0,0 +1,201 @@
+package acia
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	cpuHz = 1000000
+)
+
+func newTestACIA(input string) (*ACIA, *bytes.Buffer, *devices.Bus) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	out := &bytes.Buffer{}
+	var in io.Reader
+	if input != "" {
+		in = strings.NewReader(input)
+	}
+	a := New(bus.IRQ, in, out)
+	bus.Attach(0x5000, 0x5003, a)
+	return a, out, bus
+}
+
+// waitForByte ticks the ACIA until a received byte is ready.
+func waitForByte(t *testing.T, a *ACIA) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !a.Ready() {
+		require.True(t, time.Now().Before(deadline), "timed out waiting for received byte")
+		a.Tick()
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	a, out, _ := newTestACIA("")
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable)
+	a.Write(DataRegister, 'H')
+	a.Write(DataRegister, 'i')
+	assert.Equal(t, "Hi", out.String())
+	assert.NotZero(t, a.Read(StatusRegister)&StatusTransmitterEmpty)
+}
+
+func TestTransmitIgnoredWithoutDTR(t *testing.T) {
+	a, out, _ := newTestACIA("")
+	a.Write(DataRegister, 'X')
+	assert.Empty(t, out.String())
+}
+
+func TestReceiveIsFlowControlled(t *testing.T) {
+	a, _, _ := newTestACIA("OK")
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable)
+
+	waitForByte(t, a)
+	for i := 0; i < 100; i++ {
+		a.Tick()
+	}
+	assert.Zero(t, a.Read(StatusRegister)&StatusOverrun)
+	assert.Equal(t, byte('O'), a.Read(DataRegister))
+	assert.Zero(t, a.Read(StatusRegister)&StatusReceiverFull)
+
+	waitForByte(t, a)
+	assert.Equal(t, byte('K'), a.Read(DataRegister))
+}
+
+func TestReceiveInterrupt(t *testing.T) {
+	a, _, bus := newTestACIA("A")
+	a.Write(CommandRegister, commandDTR)
+
+	waitForByte(t, a)
+	require.True(t, bus.IRQ.Active())
+	status := bus.Memory.Read(0x5000 + StatusRegister)
+	assert.NotZero(t, status&StatusInterrupt)
+	assert.NotZero(t, status&StatusReceiverFull)
+	assert.False(t, bus.IRQ.Active(), "reading the status clears the interrupt")
+	assert.Equal(t, byte('A'), bus.Memory.Read(0x5000+DataRegister))
+}
+
+func TestTransmitInterrupt(t *testing.T) {
+	a, _, bus := newTestACIA("")
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable|commandTransmitterIRQEnable)
+	assert.True(t, bus.IRQ.Active(), "transmitter empty raises an interrupt when enabled")
+}
+
+func TestEchoMode(t *testing.T) {
+	a, out, _ := newTestACIA("e")
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable|commandEcho)
+	waitForByte(t, a)
+	assert.Equal(t, "e", out.String())
+}
+
+func TestWordLength(t *testing.T) {
+	a, out, _ := newTestACIA("")
+	a.Write(ControlRegister, 0x20) // 7 data bits
+	a.Write(CommandRegister, commandDTR)
+	a.Write(DataRegister, 0xC1)
+	assert.Equal(t, []byte{0x41}, out.Bytes())
+}
+
+func TestBaudTiming(t *testing.T) {
+	a, out, _ := newTestACIA("")
+	a.SetBaudTiming(cpuHz)
+	a.Write(ControlRegister, 0x1E) // 9600 baud, 8 data bits, 1 stop bit
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable)
+	require.Equal(t, uint64(9600), a.BaudRate())
+
+	a.Write(DataRegister, 'Z')
+	assert.Zero(t, a.Read(StatusRegister)&StatusTransmitterEmpty)
+	assert.Empty(t, out.String())
+
+	// 10 bits at 9600 baud is ~1041 cycles at 1MHz
+	frame := cpuHz * 10 / 9600
+	for i := 0; i < frame-1; i++ {
+		a.Tick()
+	}
+	assert.Empty(t, out.String())
+	a.Tick()
+	assert.Equal(t, "Z", out.String())
+	assert.NotZero(t, a.Read(StatusRegister)&StatusTransmitterEmpty)
+}
+
+func TestBaudTimingOverrun(t *testing.T) {
+	a, _, _ := newTestACIA("12")
+	a.SetBaudTiming(cpuHz)
+	a.Write(ControlRegister, 0x1F) // 19200 baud
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable)
+
+	waitForByte(t, a)
+	deadline := time.Now().Add(2 * time.Second)
+	for a.Read(StatusRegister)&StatusOverrun == 0 {
+		require.True(t, time.Now().Before(deadline), "timed out waiting for overrun")
+		a.Tick()
+	}
+	assert.Equal(t, byte('1'), a.Read(DataRegister), "the byte that overran is lost")
+}
+
+func TestProgrammedReset(t *testing.T) {
+	a, _, _ := newTestACIA("")
+	a.Write(ControlRegister, 0x1E)
+	a.Write(CommandRegister, 0xEB)
+	a.Write(StatusRegister, 0x00)
+	assert.Equal(t, byte(0xE0), a.Read(CommandRegister))
+	assert.Equal(t, byte(0x1E), a.Read(ControlRegister))
+}
+
+func TestTCPStream(t *testing.T) {
+	stream, err := ListenTCP("127.0.0.1:0")
+	require.NoError(t, err)
+	defer stream.Close()
+
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	a := New(bus.IRQ, stream, stream)
+	a.Write(CommandRegister, commandDTR|commandReceiverIRQDisable)
+
+	conn, err := net.Dial("tcp", stream.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("?"))
+	require.NoError(t, err)
+	waitForByte(t, a)
+	assert.Equal(t, byte('?'), a.Read(DataRegister))
+
+	a.Write(DataRegister, '!')
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := bufio.NewReader(conn).ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte('!'), reply)
+}
+
+func TestTCPStreamCloseTwice(t *testing.T) {
+	stream, err := ListenTCP("127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	require.NoError(t, stream.Close())
+
+	n, err := stream.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestTCPStreamCloseBeforeAccepted(t *testing.T) {
+	stream, err := ListenTCP("127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	// A client the listener accepted just before Close took the lock
+	client, server := net.Pipe()
+	defer client.Close()
+	assert.False(t, stream.accepted(server))
+	_, err = server.Write([]byte("x"))
+	assert.Error(t, err, "the client should be hung up on")
+}
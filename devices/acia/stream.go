@@ -0,0 +1,133 @@
+package acia
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// TCPStream is an io.Reader/io.Writer that bridges the ACIA to a single client connected to a
+// local TCP listener, so a terminal program (telnet, nc, minicom) can talk to the emulated machine.
+// Reads block until a client connects and end when it disconnects and a new client is awaited.
+// Writes made while no client is connected are discarded, like characters sent down an unplugged line.
+type TCPStream struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conn     net.Conn
+	ready    chan struct{}
+	closed   bool
+}
+
+// ListenTCP starts listening for a terminal connection on address, e.g. "localhost:6551".
+func ListenTCP(address string) (*TCPStream, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("[acia ListenTCP] %w", err)
+	}
+	s := &TCPStream{listener: l, ready: make(chan struct{})}
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the address the stream is listening on.
+func (s *TCPStream) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *TCPStream) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.accepted(conn) {
+			return
+		}
+	}
+}
+
+// accepted connects a client the listener accepted, or hangs up on it if a client is already connected.
+// It returns false if the stream was closed after the client was accepted, and Close has already
+// released any reader waiting for a client.
+func (s *TCPStream) accepted(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return false
+	}
+	if s.conn != nil {
+		// Only one terminal at a time
+		conn.Close()
+		return true
+	}
+	s.conn = conn
+	close(s.ready)
+	return true
+}
+
+func (s *TCPStream) connection() net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// Read reads from the connected client, waiting for one to connect if necessary.
+func (s *TCPStream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		ready := s.ready
+		s.mu.Unlock()
+		<-ready
+
+		s.mu.Lock()
+		conn, closed := s.conn, s.closed
+		s.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+		n, err := conn.Read(p)
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		// Client went away, wait for the next one
+		s.mu.Lock()
+		conn.Close()
+		s.conn = nil
+		if s.closed {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		s.ready = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+// Write writes to the connected client, discarding the data when no client is connected.
+func (s *TCPStream) Write(p []byte) (int, error) {
+	conn := s.connection()
+	if conn == nil {
+		return len(p), nil
+	}
+	return conn.Write(p)
+}
+
+// Close stops listening and disconnects any client.
+func (s *TCPStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+	} else {
+		close(s.ready)
+	}
+	return s.listener.Close()
+}
+
+// Ensure TCPStream implements io.ReadWriteCloser.
+var _ io.ReadWriteCloser = &TCPStream{}
@@ -0,0 +1,120 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Bus wires a CPU to its memory map, its devices and the IRQ/NMI lines, and clocks them together.
+// Devices that need the bus for DMA, such as the VIC-II on badlines, hold RDY active to stall the CPU.
+type Bus struct {
+	CPU     *cpu.CPU
+	Memory  *memory.MemoryMap[uint16]
+	IRQ     *InterruptLine
+	NMI     *InterruptLine
+	RDY     *InterruptLine
+	devices []Device
+	traps   map[uint16]Trap
+}
+
+// Trap is called in place of the instruction at a trapped address, emulating a routine in Go.
+// It is responsible for leaving the CPU where execution should continue, usually by returning to
+// the caller with ReturnFromSubroutine.
+type Trap func(c *cpu.CPU) error
+
+// NewBus creates a bus with a CPU whose unmapped addresses fall through to ram.
+func NewBus(ram memory.Operations[uint16], useIllegalOpCodes bool) *Bus {
+	memoryMap := memory.NewMemoryMap(ram)
+	c := cpu.NewCPU(memoryMap, useIllegalOpCodes)
+	return &Bus{
+		CPU:    c,
+		Memory: memoryMap,
+		IRQ:    NewIRQLine(c),
+		NMI:    NewNMILine(c),
+		RDY:    NewLine(),
+	}
+}
+
+// Attach maps a device at the inclusive address range start-end and clocks it with the CPU.
+func (b *Bus) Attach(start, end uint16, d Device) {
+	b.Memory.Map(start, end, d)
+	b.AddDevice(d)
+}
+
+// AddDevice clocks a device with the CPU without mapping it into memory.
+func (b *Bus) AddDevice(d Device) {
+	b.devices = append(b.devices, d)
+}
+
+// Devices returns the devices clocked by the bus.
+func (b *Bus) Devices() []Device {
+	return b.devices
+}
+
+// SetTrap calls t whenever the CPU is about to execute the instruction at address, typically the
+// entry point of a ROM routine or a paravirtual call. Only one trap can be set per address.
+func (b *Bus) SetTrap(address uint16, t Trap) {
+	if b.traps == nil {
+		b.traps = make(map[uint16]Trap)
+	}
+	b.traps[address] = t
+}
+
+// ClearTrap removes the trap at address.
+func (b *Bus) ClearTrap(address uint16) {
+	delete(b.traps, address)
+}
+
+// Step advances every device and the CPU by one clock cycle. The CPU does not run while RDY is held.
+// When an instruction completes at a trapped address, the trap runs before the next instruction.
+func (b *Bus) Step() (cpu.Completed, error) {
+	for _, d := range b.devices {
+		d.Tick()
+	}
+	b.IRQ.Poll()
+	if b.RDY.Active() {
+		return false, nil
+	}
+	completed, err := b.CPU.Execute()
+	if err != nil || !completed {
+		return completed, err
+	}
+	if trap, ok := b.traps[b.CPU.Reg.PC]; ok && !b.CPU.Halted() {
+		if err := trap(b.CPU); err != nil {
+			return completed, fmt.Errorf("[Bus Step] trap at $%04X: %w", b.CPU.Reg.PC, err)
+		}
+	}
+	return completed, nil
+}
+
+// ReturnFromSubroutine performs an RTS on behalf of a trap, returning to the caller of a JSR.
+func ReturnFromSubroutine(c cpu.CPU6502) {
+	lo := c.Pop()
+	hi := c.Pop()
+	c.Registers().PC = (uint16(hi)<<8 | uint16(lo)) + 1
+}
+
+// StepInstruction clocks the bus until the CPU completes the current instruction.
+// It returns immediately if the CPU has been halted.
+func (b *Bus) StepInstruction() error {
+	for !b.CPU.Halted() {
+		completed, err := b.Step()
+		if err != nil {
+			return err
+		}
+		if completed {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Reset resets every device, then the CPU.
+func (b *Bus) Reset() {
+	for _, d := range b.devices {
+		d.Reset()
+	}
+	b.CPU.Reset()
+}
@@ -0,0 +1,592 @@
+// Package cia emulates the MOS 6526 Complex Interface Adapter: two parallel ports, two interval
+// timers, a time-of-day clock with alarm, a serial shift register and the interrupt control register.
+package cia
+
+import (
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// Register offsets, mirrored every 16 bytes.
+const (
+	PRA    = 0x0 // Port A data register
+	PRB    = 0x1 // Port B data register
+	DDRA   = 0x2 // Port A data direction register
+	DDRB   = 0x3 // Port B data direction register
+	TALO   = 0x4 // Timer A low byte
+	TAHI   = 0x5 // Timer A high byte
+	TBLO   = 0x6 // Timer B low byte
+	TBHI   = 0x7 // Timer B high byte
+	TOD10  = 0x8 // TOD tenths of seconds (BCD)
+	TODSEC = 0x9 // TOD seconds (BCD)
+	TODMIN = 0xA // TOD minutes (BCD)
+	TODHR  = 0xB // TOD hours (BCD, bit 7 = PM)
+	SDR    = 0xC // Serial data register
+	ICR    = 0xD // Interrupt control register
+	CRA    = 0xE // Control register A
+	CRB    = 0xF // Control register B
+
+	registerCount = 16
+)
+
+// Interrupt sources in the ICR.
+const (
+	InterruptTimerA byte = 1 << iota
+	InterruptTimerB
+	InterruptAlarm
+	InterruptSerial
+	InterruptFlag
+
+	interruptSetClear byte = 0x80 // Write: set (1) or clear (0) the mask bits. Read: an enabled interrupt occurred.
+	interruptMask     byte = 0x1F
+)
+
+// Control register bits.
+const (
+	controlStart    byte = 0x01 // Start (1) or stop (0) the timer
+	controlPBOn     byte = 0x02 // Timer output appears on PB6 (timer A) or PB7 (timer B)
+	controlOutMode  byte = 0x04 // Toggle (1) or pulse (0) timer output
+	controlRunMode  byte = 0x08 // One-shot (1) or continuous (0)
+	controlLoad     byte = 0x10 // Strobe: force load the latch into the counter
+	controlInMode   byte = 0x20 // CRA: count CNT (1) or φ2 (0). CRB: low bit of the two bit input mode
+	controlSPMode   byte = 0x40 // CRA only: serial port output (1) or input (0)
+	controlTODIn    byte = 0x80 // CRA only: TOD input is 50Hz (1) or 60Hz (0)
+	controlAlarm    byte = 0x80 // CRB only: TOD writes set the alarm (1) or the clock (0)
+	controlBInModes byte = 0x60 // CRB input mode mask
+)
+
+// Timer B input modes (CRB bits 5-6).
+const (
+	timerBCountClock         byte = 0x00
+	timerBCountCNT           byte = 0x20
+	timerBCountTimerA        byte = 0x40
+	timerBCountTimerAWithCNT byte = 0x60
+)
+
+// Power line frequencies that drive the TOD input.
+const (
+	PowerLine50Hz = 50
+	PowerLine60Hz = 60
+)
+
+// PortDevice is an external device connected to the CIA's parallel port pins, such as a keyboard
+// matrix or a joystick. Ports receives the levels the CIA drives on port A and port B (inputs read
+// as high) and returns the levels after the device has pulled lines low.
+type PortDevice interface {
+	Ports(pa, pb byte) (byte, byte)
+}
+
+// Ensure CIA implements the Device interface.
+var _ devices.Device = &CIA{}
+
+// CIA represents a MOS 6526 Complex Interface Adapter.
+type CIA struct {
+	interrupt *devices.InterruptLine
+	ports     []PortDevice
+
+	pra, prb   byte
+	ddra, ddrb byte
+
+	timerA timer
+	timerB timer
+	cra    byte
+	crb    byte
+
+	// PB6/PB7 timer outputs
+	timerAOutput bool
+	timerBOutput bool
+
+	tod         todClock
+	todAlarm    todClock
+	todLatch    todClock
+	todLatched  bool
+	todHalted   bool
+	todPrescale int
+	todCycles   uint64
+	todPeriod   uint64 // CPU cycles per power line pulse
+
+	sdr          byte
+	shiftReg     byte
+	shiftBits    int
+	shiftPending bool
+	shiftToggle  bool
+	cnt          bool
+	sp           bool
+
+	// SerialOut is called with each byte shifted out of the serial port in output mode.
+	SerialOut func(b byte)
+
+	icrData byte
+	icrMask byte
+}
+
+type timer struct {
+	latch   uint16
+	counter uint16
+}
+
+// todClock holds a BCD time of day.
+type todClock struct {
+	tenths, seconds, minutes, hours byte
+}
+
+// New creates a CIA connected to the given interrupt line (the IRQ line for CIA 1, the NMI line
+// for CIA 2). cpuHz and powerLineHz determine how often the time-of-day input is pulsed.
+func New(interrupt *devices.InterruptLine, cpuHz, powerLineHz uint64) *CIA {
+	c := &CIA{interrupt: interrupt}
+	if powerLineHz > 0 {
+		c.todPeriod = cpuHz / powerLineHz
+	}
+	c.Reset()
+	return c
+}
+
+// Attach connects an external device to the parallel ports.
+func (c *CIA) Attach(p PortDevice) {
+	c.ports = append(c.ports, p)
+}
+
+// Reset returns the CIA to its power-on state.
+func (c *CIA) Reset() {
+	c.pra, c.prb, c.ddra, c.ddrb = 0, 0, 0, 0
+	c.timerA = timer{latch: 0xFFFF, counter: 0xFFFF}
+	c.timerB = timer{latch: 0xFFFF, counter: 0xFFFF}
+	c.cra, c.crb = 0, 0
+	c.timerAOutput, c.timerBOutput = false, false
+	c.tod = todClock{hours: 0x01}
+	c.todAlarm = todClock{}
+	c.todLatched, c.todHalted = false, false
+	c.todPrescale, c.todCycles = 0, 0
+	c.sdr, c.shiftReg, c.shiftBits = 0, 0, 0
+	c.shiftPending, c.shiftToggle = false, false
+	c.cnt, c.sp = true, true
+	c.icrData, c.icrMask = 0, 0
+	c.updateInterrupt()
+}
+
+// Read reads a CIA register. Reading the ICR acknowledges and clears all pending interrupts.
+func (c *CIA) Read(address uint16) byte {
+	switch address % registerCount {
+	case PRA:
+		pa, _ := c.pins()
+		return pa
+	case PRB:
+		_, pb := c.pins()
+		return pb
+	case DDRA:
+		return c.ddra
+	case DDRB:
+		return c.ddrb
+	case TALO:
+		return byte(c.timerA.counter)
+	case TAHI:
+		return byte(c.timerA.counter >> 8)
+	case TBLO:
+		return byte(c.timerB.counter)
+	case TBHI:
+		return byte(c.timerB.counter >> 8)
+	case TOD10:
+		t := c.tod
+		if c.todLatched {
+			t = c.todLatch
+			c.todLatched = false
+		}
+		return t.tenths
+	case TODSEC:
+		if c.todLatched {
+			return c.todLatch.seconds
+		}
+		return c.tod.seconds
+	case TODMIN:
+		if c.todLatched {
+			return c.todLatch.minutes
+		}
+		return c.tod.minutes
+	case TODHR:
+		// Reading the hours latches the clock until the tenths are read
+		if !c.todLatched {
+			c.todLatch = c.tod
+			c.todLatched = true
+		}
+		return c.todLatch.hours
+	case SDR:
+		return c.sdr
+	case ICR:
+		data := c.icrData
+		c.icrData = 0
+		c.updateInterrupt()
+		return data
+	case CRA:
+		return c.cra &^ controlLoad
+	case CRB:
+		return c.crb &^ controlLoad
+	}
+	return 0
+}
+
+// Write writes data to consecutive CIA registers starting at address.
+func (c *CIA) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		c.writeRegister(address+uint16(i), b)
+	}
+}
+
+func (c *CIA) writeRegister(address uint16, b byte) {
+	switch address % registerCount {
+	case PRA:
+		c.pra = b
+	case PRB:
+		c.prb = b
+	case DDRA:
+		c.ddra = b
+	case DDRB:
+		c.ddrb = b
+	case TALO:
+		c.timerA.latch = (c.timerA.latch & 0xFF00) | uint16(b)
+	case TAHI:
+		c.timerA.latch = (c.timerA.latch & 0x00FF) | uint16(b)<<8
+		// Writing the high byte of a stopped timer loads the counter
+		if c.cra&controlStart == 0 {
+			c.timerA.counter = c.timerA.latch
+		}
+	case TBLO:
+		c.timerB.latch = (c.timerB.latch & 0xFF00) | uint16(b)
+	case TBHI:
+		c.timerB.latch = (c.timerB.latch & 0x00FF) | uint16(b)<<8
+		if c.crb&controlStart == 0 {
+			c.timerB.counter = c.timerB.latch
+		}
+	case TOD10:
+		c.todTarget().tenths = b & 0x0F
+		if c.crb&controlAlarm == 0 {
+			// Writing the tenths restarts a clock halted by a write to the hours
+			c.todHalted = false
+		}
+		c.checkAlarm()
+	case TODSEC:
+		c.todTarget().seconds = b & 0x7F
+		c.checkAlarm()
+	case TODMIN:
+		c.todTarget().minutes = b & 0x7F
+		c.checkAlarm()
+	case TODHR:
+		c.todTarget().hours = b & 0x9F
+		if c.crb&controlAlarm == 0 {
+			c.todHalted = true
+		}
+		c.checkAlarm()
+	case SDR:
+		c.sdr = b
+		if c.cra&controlSPMode != 0 {
+			c.shiftPending = true
+		}
+	case ICR:
+		if b&interruptSetClear != 0 {
+			c.icrMask |= b & interruptMask
+		} else {
+			c.icrMask &^= b & interruptMask
+		}
+		c.updateInterrupt()
+	case CRA:
+		if b&controlStart != 0 && c.cra&controlStart == 0 {
+			c.timerAOutput = true
+		}
+		if (b^c.cra)&controlSPMode != 0 {
+			c.shiftBits, c.shiftPending = 0, false
+		}
+		c.cra = b &^ controlLoad
+		if b&controlLoad != 0 {
+			c.timerA.counter = c.timerA.latch
+		}
+	case CRB:
+		if b&controlStart != 0 && c.crb&controlStart == 0 {
+			c.timerBOutput = true
+		}
+		c.crb = b &^ controlLoad
+		if b&controlLoad != 0 {
+			c.timerB.counter = c.timerB.latch
+		}
+	}
+}
+
+// Tick advances the timers and the TOD clock by one φ2 clock cycle.
+func (c *CIA) Tick() {
+	if c.cra&controlStart != 0 && c.cra&controlInMode == 0 {
+		c.countTimerA()
+	}
+	if c.crb&controlStart != 0 && c.crb&controlBInModes == timerBCountClock {
+		c.countTimerB()
+	}
+	c.tickTOD()
+}
+
+// SetCNT drives the CNT pin. A rising edge counts timers in CNT mode and clocks in a serial bit.
+func (c *CIA) SetCNT(high bool) {
+	rising := high && !c.cnt
+	c.cnt = high
+	if !rising {
+		return
+	}
+	if c.cra&controlStart != 0 && c.cra&controlInMode != 0 {
+		c.countTimerA()
+	}
+	if c.crb&controlStart != 0 && c.crb&controlBInModes == timerBCountCNT {
+		c.countTimerB()
+	}
+	if c.cra&controlSPMode == 0 {
+		c.shiftIn(c.sp)
+	}
+}
+
+// SetSP drives the serial port data pin that is sampled on rising CNT edges in input mode.
+func (c *CIA) SetSP(high bool) {
+	c.sp = high
+}
+
+// SerialIn shifts a whole byte into the serial port, most significant bit first, as if it had
+// been clocked in on the SP and CNT pins.
+func (c *CIA) SerialIn(b byte) {
+	for i := 7; i >= 0; i-- {
+		c.SetCNT(false)
+		c.SetSP(b&(1<<i) != 0)
+		c.SetCNT(true)
+	}
+}
+
+// Flag signals a negative edge on the FLAG pin.
+func (c *CIA) Flag() {
+	c.raise(InterruptFlag)
+}
+
+// PowerLinePulse pulses the TOD input. Only needed when the CIA was created without a power line frequency.
+func (c *CIA) PowerLinePulse() {
+	divider := 6
+	if c.cra&controlTODIn != 0 {
+		divider = 5
+	}
+	c.todPrescale++
+	if c.todPrescale < divider {
+		return
+	}
+	c.todPrescale = 0
+	if !c.todHalted {
+		c.tod.increment()
+		c.checkAlarm()
+	}
+}
+
+func (c *CIA) tickTOD() {
+	if c.todPeriod == 0 {
+		return
+	}
+	c.todCycles++
+	if c.todCycles >= c.todPeriod {
+		c.todCycles = 0
+		c.PowerLinePulse()
+	}
+}
+
+func (c *CIA) countTimerA() {
+	if c.timerA.counter > 0 {
+		c.timerA.counter--
+		return
+	}
+	// Underflow
+	c.timerA.counter = c.timerA.latch
+	c.timerAOutput = c.nextTimerOutput(c.cra, c.timerAOutput)
+	if c.cra&controlRunMode != 0 {
+		c.cra &^= controlStart
+	}
+	c.raise(InterruptTimerA)
+	c.serialClock()
+
+	if c.crb&controlStart != 0 {
+		switch c.crb & controlBInModes {
+		case timerBCountTimerA:
+			c.countTimerB()
+		case timerBCountTimerAWithCNT:
+			if c.cnt {
+				c.countTimerB()
+			}
+		}
+	}
+}
+
+func (c *CIA) countTimerB() {
+	if c.timerB.counter > 0 {
+		c.timerB.counter--
+		return
+	}
+	c.timerB.counter = c.timerB.latch
+	c.timerBOutput = c.nextTimerOutput(c.crb, c.timerBOutput)
+	if c.crb&controlRunMode != 0 {
+		c.crb &^= controlStart
+	}
+	c.raise(InterruptTimerB)
+}
+
+// nextTimerOutput returns the PB6/PB7 level after an underflow: toggled in toggle mode, a
+// one cycle high pulse (reported as high until the next read) in pulse mode.
+func (c *CIA) nextTimerOutput(control byte, output bool) bool {
+	if control&controlOutMode != 0 {
+		return !output
+	}
+	return true
+}
+
+// serialClock shifts one bit out of the serial port every second timer A underflow in output mode.
+func (c *CIA) serialClock() {
+	if c.cra&controlSPMode == 0 {
+		return
+	}
+	if c.shiftBits == 0 {
+		if !c.shiftPending {
+			return
+		}
+		c.shiftReg = c.sdr
+		c.shiftPending = false
+		c.shiftBits = 8
+		c.shiftToggle = false
+	}
+	c.shiftToggle = !c.shiftToggle
+	c.cnt = !c.shiftToggle
+	if c.shiftToggle {
+		return
+	}
+	c.sp = c.shiftReg&0x80 != 0
+	c.shiftReg <<= 1
+	c.shiftBits--
+	if c.shiftBits == 0 {
+		if c.SerialOut != nil {
+			c.SerialOut(c.sdr)
+		}
+		c.raise(InterruptSerial)
+	}
+}
+
+func (c *CIA) shiftIn(bit bool) {
+	c.shiftReg <<= 1
+	if bit {
+		c.shiftReg |= 1
+	}
+	c.shiftBits++
+	if c.shiftBits == 8 {
+		c.sdr = c.shiftReg
+		c.shiftBits = 0
+		c.raise(InterruptSerial)
+	}
+}
+
+func (c *CIA) raise(source byte) {
+	c.icrData |= source
+	c.updateInterrupt()
+}
+
+func (c *CIA) updateInterrupt() {
+	if c.icrData&c.icrMask != 0 {
+		c.icrData |= interruptSetClear
+	} else {
+		c.icrData &^= interruptSetClear
+	}
+	c.interrupt.Set(c, c.icrData&interruptSetClear != 0)
+}
+
+// pins returns the levels on port A and B: outputs as driven by the CIA, inputs pulled up, then
+// pulled low by any attached devices, with the timer outputs on PB6/PB7 when enabled.
+func (c *CIA) pins() (byte, byte) {
+	pa := c.pra | ^c.ddra
+	pb := c.prb | ^c.ddrb
+	if c.cra&controlPBOn != 0 {
+		pb = setBit(pb, 0x40, c.timerAOutput)
+		if c.cra&controlOutMode == 0 {
+			c.timerAOutput = false
+		}
+	}
+	if c.crb&controlPBOn != 0 {
+		pb = setBit(pb, 0x80, c.timerBOutput)
+		if c.crb&controlOutMode == 0 {
+			c.timerBOutput = false
+		}
+	}
+	for _, p := range c.ports {
+		a, b := p.Ports(pa, pb)
+		pa &= a
+		pb &= b
+	}
+	return pa, pb
+}
+
+// TimerALatch returns the value timer A reloads from when it underflows, which sets its period.
+func (c *CIA) TimerALatch() uint16 {
+	return c.timerA.latch
+}
+
+// PortA returns the current levels on the port A pins.
+func (c *CIA) PortA() byte {
+	pa, _ := c.pins()
+	return pa
+}
+
+// PortB returns the current levels on the port B pins.
+func (c *CIA) PortB() byte {
+	_, pb := c.pins()
+	return pb
+}
+
+func (c *CIA) todTarget() *todClock {
+	if c.crb&controlAlarm != 0 {
+		return &c.todAlarm
+	}
+	return &c.tod
+}
+
+func (c *CIA) checkAlarm() {
+	if c.tod == c.todAlarm {
+		c.raise(InterruptAlarm)
+	}
+}
+
+// increment advances the clock by a tenth of a second, in BCD, with a 12 hour AM/PM clock.
+func (t *todClock) increment() {
+	t.tenths = (t.tenths + 1) % 10
+	if t.tenths != 0 {
+		return
+	}
+	var carry bool
+	if t.seconds, carry = bcdIncrement(t.seconds, 0x59); !carry {
+		return
+	}
+	if t.minutes, carry = bcdIncrement(t.minutes, 0x59); !carry {
+		return
+	}
+	pm := t.hours & 0x80
+	hours := t.hours & 0x1F
+	switch hours {
+	case 0x11:
+		hours = 0x12
+		pm ^= 0x80
+	case 0x12:
+		hours = 0x01
+	default:
+		hours, _ = bcdIncrement(hours, 0x12)
+	}
+	t.hours = pm | hours
+}
+
+// bcdIncrement increments a BCD value, wrapping to zero and returning a carry after max.
+func bcdIncrement(v, max byte) (byte, bool) {
+	if v >= max {
+		return 0, true
+	}
+	v++
+	if v&0x0F > 9 {
+		v = (v & 0xF0) + 0x10
+	}
+	return v, false
+}
+
+func setBit(v, bit byte, on bool) byte {
+	if on {
+		return v | bit
+	}
+	return v &^ bit
+}
@@ -0,0 +1,253 @@
+package cia
+
+import (
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	palClockHz = 985248
+)
+
+func newTestCIA() (*CIA, *devices.Bus) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	c := New(bus.IRQ, palClockHz, PowerLine50Hz)
+	bus.Attach(0xDC00, 0xDCFF, c)
+	return c, bus
+}
+
+// setTOD writes the TOD registers hours first, as the clock halts on hours and restarts on tenths.
+func setTOD(c *CIA, hours, minutes, seconds, tenths byte) {
+	c.Write(TODHR, hours)
+	c.Write(TODMIN, minutes)
+	c.Write(TODSEC, seconds)
+	c.Write(TOD10, tenths)
+}
+
+func tick(c *CIA, n int) {
+	for i := 0; i < n; i++ {
+		c.Tick()
+	}
+}
+
+func TestTimerAContinuousUnderflow(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(TALO, 0x04, 0x00)
+	c.Write(CRA, controlStart|controlLoad)
+
+	tick(c, 4)
+	assert.Equal(t, byte(0x00), c.Read(TALO))
+	assert.Equal(t, byte(0x00), c.Read(ICR)&InterruptTimerA)
+
+	tick(c, 1)
+	assert.Equal(t, byte(0x04), c.Read(TALO), "counter reloads from the latch on underflow")
+	assert.Equal(t, InterruptTimerA, c.Read(ICR)&InterruptTimerA)
+	assert.Equal(t, byte(0x00), c.Read(ICR), "reading the ICR acknowledges the interrupt")
+	assert.NotZero(t, c.Read(CRA)&controlStart, "continuous timer keeps running")
+}
+
+func TestTimerAOneShot(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(TALO, 0x02, 0x00)
+	c.Write(CRA, controlStart|controlLoad|controlRunMode)
+
+	tick(c, 3)
+	assert.Zero(t, c.Read(CRA)&controlStart, "one-shot timer stops after underflow")
+	tick(c, 10)
+	assert.Equal(t, byte(0x02), c.Read(TALO))
+}
+
+func TestTimerInterruptDrivesIRQLine(t *testing.T) {
+	c, bus := newTestCIA()
+	c.Write(TALO, 0x01, 0x00)
+	c.Write(ICR, interruptSetClear|InterruptTimerA)
+	c.Write(CRA, controlStart|controlLoad)
+
+	tick(c, 2)
+	require.True(t, bus.IRQ.Active())
+	assert.Equal(t, interruptSetClear|InterruptTimerA, bus.Memory.Read(0xDC00+ICR))
+	assert.False(t, bus.IRQ.Active(), "reading the ICR releases the IRQ line")
+
+	c.Write(ICR, InterruptTimerA)
+	tick(c, 2)
+	assert.False(t, bus.IRQ.Active(), "masked interrupts do not drive the line")
+}
+
+func TestCIA2DrivesNMILine(t *testing.T) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	cia2 := New(bus.NMI, palClockHz, PowerLine50Hz)
+	cia2.Write(ICR, interruptSetClear|InterruptFlag)
+	cia2.Flag()
+	assert.True(t, bus.NMI.Active())
+	assert.False(t, bus.IRQ.Active())
+}
+
+func TestTimerBCascade(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(TALO, 0x01, 0x00)
+	c.Write(TBLO, 0x02, 0x00)
+	c.Write(CRB, controlStart|controlLoad|timerBCountTimerA)
+	c.Write(CRA, controlStart|controlLoad)
+
+	// Timer A underflows every 2 cycles, timer B every 3 timer A underflows
+	tick(c, 4)
+	assert.Equal(t, byte(0x00), c.Read(TBLO))
+	assert.Zero(t, c.Read(ICR)&InterruptTimerB)
+	tick(c, 2)
+	assert.Equal(t, InterruptTimerB, c.Read(ICR)&InterruptTimerB)
+	assert.Equal(t, byte(0x02), c.Read(TBLO))
+}
+
+func TestTimerCountsCNT(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(TALO, 0x05, 0x00)
+	c.Write(CRA, controlStart|controlLoad|controlInMode)
+
+	tick(c, 10)
+	assert.Equal(t, byte(0x05), c.Read(TALO), "CNT mode ignores the clock")
+	c.SetCNT(false)
+	c.SetCNT(true)
+	assert.Equal(t, byte(0x04), c.Read(TALO))
+}
+
+func TestTODClock(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(CRA, controlTODIn) // 50Hz
+	setTOD(c, 0x11, 0x59, 0x59, 0x09)
+
+	assert.Equal(t, byte(0x11), c.Read(TODHR))
+	pulse := func(n int) {
+		for i := 0; i < n; i++ {
+			c.PowerLinePulse()
+		}
+	}
+
+	// 5 pulses of 50Hz make a tenth of a second
+	pulse(5)
+	assert.Equal(t, byte(0x09), c.Read(TOD10), "hours read latches the clock until tenths are read")
+	assert.Equal(t, byte(0x12)|0x80, c.Read(TODHR))
+	assert.Equal(t, byte(0x00), c.Read(TODMIN))
+	assert.Equal(t, byte(0x00), c.Read(TODSEC))
+	assert.Equal(t, byte(0x00), c.Read(TOD10))
+}
+
+func TestTODHaltedUntilTenthsWritten(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(CRA, controlTODIn)
+	c.Write(TODHR, 0x01)
+	for i := 0; i < 50; i++ {
+		c.PowerLinePulse()
+	}
+	assert.Equal(t, byte(0x00), c.Read(TODSEC))
+
+	c.Write(TOD10, 0x00)
+	for i := 0; i < 50; i++ {
+		c.PowerLinePulse()
+	}
+	assert.Equal(t, byte(0x01), c.Read(TODSEC))
+}
+
+func TestTODFromCPUClock(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(CRA, controlTODIn)
+	tick(c, palClockHz/10+palClockHz/50)
+	assert.Equal(t, byte(0x01), c.Read(TOD10))
+}
+
+func TestTODAlarm(t *testing.T) {
+	c, bus := newTestCIA()
+	c.Write(CRB, controlAlarm)
+	setTOD(c, 0x01, 0x00, 0x01, 0x00)
+	c.Write(CRB, 0x00)
+	c.Write(CRA, controlTODIn)
+	setTOD(c, 0x01, 0x00, 0x00, 0x00)
+	c.Read(ICR)
+	c.Write(ICR, interruptSetClear|InterruptAlarm)
+	require.False(t, bus.IRQ.Active())
+
+	for i := 0; i < 50; i++ {
+		c.PowerLinePulse()
+	}
+	assert.True(t, bus.IRQ.Active())
+	assert.Equal(t, interruptSetClear|InterruptAlarm, c.Read(ICR))
+}
+
+func TestSerialOutput(t *testing.T) {
+	c, _ := newTestCIA()
+	var sent []byte
+	c.SerialOut = func(b byte) { sent = append(sent, b) }
+	c.Write(TALO, 0x00, 0x00)
+	c.Write(CRA, controlStart|controlLoad|controlSPMode)
+	c.Write(SDR, 0xA5)
+
+	tick(c, 15)
+	assert.Empty(t, sent)
+	tick(c, 1)
+	assert.Equal(t, []byte{0xA5}, sent)
+	assert.Equal(t, InterruptSerial, c.Read(ICR)&InterruptSerial)
+}
+
+func TestSerialInput(t *testing.T) {
+	c, _ := newTestCIA()
+	c.SerialIn(0x3C)
+	assert.Equal(t, byte(0x3C), c.Read(SDR))
+	assert.Equal(t, InterruptSerial, c.Read(ICR)&InterruptSerial)
+}
+
+func TestPortsAndDataDirection(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(DDRA, 0x0F)
+	c.Write(PRA, 0x05)
+	assert.Equal(t, byte(0xF5), c.Read(PRA), "inputs read high, outputs read as driven")
+}
+
+func TestKeyboardMatrixScan(t *testing.T) {
+	c, _ := newTestCIA()
+	kb := NewKeyboardMatrix()
+	c.Attach(kb)
+	c.Write(DDRA, 0xFF)
+	c.Write(DDRB, 0x00)
+
+	// 'A' on the C64 is column 1, row 2
+	kb.Press(1, 2)
+	c.Write(PRA, 0xFF)
+	assert.Equal(t, byte(0xFF), c.Read(PRB), "no column selected")
+	c.Write(PRA, 0xFD)
+	assert.Equal(t, byte(0xFB), c.Read(PRB))
+	c.Write(PRA, 0x00)
+	assert.Equal(t, byte(0xFB), c.Read(PRB))
+
+	kb.Release(1, 2)
+	assert.Equal(t, byte(0xFF), c.Read(PRB))
+}
+
+func TestJoystick(t *testing.T) {
+	c, _ := newTestCIA()
+	joy2 := NewJoystick(false)
+	joy1 := NewJoystick(true)
+	c.Attach(joy2)
+	c.Attach(joy1)
+
+	joy2.Set(JoystickUp|JoystickFire, true)
+	joy1.Set(JoystickLeft, true)
+	assert.Equal(t, byte(0xEE), c.Read(PRA))
+	assert.Equal(t, byte(0xFB), c.Read(PRB))
+
+	joy2.Set(JoystickFire, false)
+	assert.Equal(t, byte(0xFE), c.Read(PRA))
+}
+
+func TestTimerOutputOnPB6(t *testing.T) {
+	c, _ := newTestCIA()
+	c.Write(TALO, 0x01, 0x00)
+	c.Write(CRA, controlStart|controlLoad|controlPBOn|controlOutMode)
+	assert.NotZero(t, c.Read(PRB)&0x40, "toggle output starts high")
+	tick(c, 2)
+	assert.Zero(t, c.Read(PRB)&0x40)
+	tick(c, 2)
+	assert.NotZero(t, c.Read(PRB)&0x40)
+}
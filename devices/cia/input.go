@@ -0,0 +1,90 @@
+package cia
+
+// KeyboardMatrix is an 8x8 key matrix wired between port A (columns, driven low by the scan
+// routine) and port B (rows, read back). A pressed key connects its column and row lines, so
+// a low level on either side pulls the other side low, as on the C64 keyboard.
+type KeyboardMatrix struct {
+	pressed [8]byte // pressed[column] has a bit set for each pressed row
+}
+
+// Ensure KeyboardMatrix implements the PortDevice interface.
+var _ PortDevice = &KeyboardMatrix{}
+
+// NewKeyboardMatrix creates a keyboard matrix with no keys pressed.
+func NewKeyboardMatrix() *KeyboardMatrix {
+	return &KeyboardMatrix{}
+}
+
+// Press holds down the key at the given column (port A bit) and row (port B bit).
+func (k *KeyboardMatrix) Press(column, row int) {
+	k.pressed[column&7] |= 1 << (row & 7)
+}
+
+// Release lets go of the key at the given column and row.
+func (k *KeyboardMatrix) Release(column, row int) {
+	k.pressed[column&7] &^= 1 << (row & 7)
+}
+
+// ReleaseAll lets go of every key.
+func (k *KeyboardMatrix) ReleaseAll() {
+	k.pressed = [8]byte{}
+}
+
+// Ports pulls rows low for pressed keys in selected columns, and columns low for pressed keys in selected rows.
+func (k *KeyboardMatrix) Ports(pa, pb byte) (byte, byte) {
+	outA, outB := pa, pb
+	for column := 0; column < 8; column++ {
+		rows := k.pressed[column]
+		if rows == 0 {
+			continue
+		}
+		if pa&(1<<column) == 0 {
+			outB &^= rows
+		}
+		if ^pb&rows != 0 {
+			outA &^= 1 << column
+		}
+	}
+	return outA, outB
+}
+
+// Joystick directions and fire button, as port bits.
+const (
+	JoystickUp    byte = 0x01
+	JoystickDown  byte = 0x02
+	JoystickLeft  byte = 0x04
+	JoystickRight byte = 0x08
+	JoystickFire  byte = 0x10
+)
+
+// Joystick is a digital joystick that pulls the low five lines of one port low while a direction or fire is held.
+// On the C64, control port 2 is wired to CIA 1 port A and control port 1 to CIA 1 port B.
+type Joystick struct {
+	portB bool
+	state byte
+}
+
+// Ensure Joystick implements the PortDevice interface.
+var _ PortDevice = &Joystick{}
+
+// NewJoystick creates a joystick on port A, or port B when portB is true.
+func NewJoystick(portB bool) *Joystick {
+	return &Joystick{portB: portB}
+}
+
+// Set holds (on) or releases the given JoystickUp/Down/Left/Right/Fire bits.
+func (j *Joystick) Set(bits byte, on bool) {
+	if on {
+		j.state |= bits
+	} else {
+		j.state &^= bits
+	}
+}
+
+// Ports pulls the held direction and fire lines low.
+func (j *Joystick) Ports(pa, pb byte) (byte, byte) {
+	if j.portB {
+		return pa, pb &^ j.state
+	}
+	return pa &^ j.state, pb
+}
@@ -0,0 +1,153 @@
+// Package console provides a paravirtual console: a trivial memory-mapped device that lets test
+// programs print characters, read input and exit with a status code without needing a ROM.
+package console
+
+import (
+	"io"
+
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// DefaultAddress is where the console is usually mapped.
+const DefaultAddress = 0xF000
+
+// Register offsets.
+const (
+	OutputRegister = 0x0 // Write: print a character
+	InputRegister  = 0x1 // Read: the next input byte, 0 when none is ready
+	StatusRegister = 0x2 // Read: StatusInputReady and StatusInputEOF
+	ExitRegister   = 0x3 // Write: stop emulation with the written exit code
+
+	// Size is the number of bytes the console occupies in the address space.
+	Size = 4
+)
+
+// Status register bits.
+const (
+	StatusInputReady byte = 0x01 // An input byte can be read
+	StatusInputEOF   byte = 0x80 // The input stream has ended and no bytes remain
+)
+
+// Ensure Console implements the Device interface.
+var _ devices.Device = &Console{}
+
+// Console is a paravirtual console device. A program prints a character with STA OutputRegister,
+// polls StatusRegister then reads InputRegister for input, and ends with STA ExitRegister.
+type Console struct {
+	out      io.Writer
+	input    chan byte
+	next     byte
+	ready    bool
+	eof      bool
+	halt     cpu.HaltExecution
+	exited   bool
+	exitCode int
+}
+
+// New creates a console that prints to out and reads from in; either may be nil.
+// Input is read ahead on a background goroutine so reads never block emulation.
+// A write to the exit register stops halt, when it is not nil.
+func New(in io.Reader, out io.Writer, halt cpu.HaltExecution) *Console {
+	c := &Console{
+		out:   out,
+		input: make(chan byte, 256),
+		halt:  halt,
+	}
+	if in != nil {
+		go c.readLoop(in)
+	} else {
+		close(c.input)
+	}
+	return c
+}
+
+func (c *Console) readLoop(r io.Reader) {
+	defer close(c.input)
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			c.input <- buf[0]
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Reset clears the exit state, resuming the CPU the exit stopped, so the program can be run again.
+// Pending input is kept.
+func (c *Console) Reset() {
+	if c.exited && c.halt != nil {
+		c.halt.Resume()
+	}
+	c.exited = false
+	c.exitCode = 0
+}
+
+// Tick does nothing; the console responds immediately to reads and writes.
+func (c *Console) Tick() {}
+
+// Read reads a console register.
+func (c *Console) Read(address uint16) byte {
+	switch address {
+	case InputRegister:
+		c.poll()
+		if !c.ready {
+			return 0
+		}
+		c.ready = false
+		return c.next
+	case StatusRegister:
+		c.poll()
+		status := byte(0)
+		if c.ready {
+			status |= StatusInputReady
+		} else if c.eof {
+			status |= StatusInputEOF
+		}
+		return status
+	}
+	return 0
+}
+
+// Write writes data to consecutive console registers starting at address.
+func (c *Console) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		switch address + uint16(i) {
+		case OutputRegister:
+			if c.out != nil {
+				c.out.Write([]byte{b})
+			}
+		case ExitRegister:
+			c.exited = true
+			c.exitCode = int(b)
+			if c.halt != nil {
+				c.halt.Stop()
+			}
+		}
+	}
+}
+
+// poll fetches the next input byte when the last one has been read.
+func (c *Console) poll() {
+	if c.ready || c.eof {
+		return
+	}
+	select {
+	case b, ok := <-c.input:
+		if !ok {
+			c.eof = true
+			return
+		}
+		c.next = b
+		c.ready = true
+	default:
+	}
+}
+
+// Exited returns the exit code written by the program, and whether it has exited.
+func (c *Console) Exited() (int, bool) {
+	return c.exitCode, c.exited
+}
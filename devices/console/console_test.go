@@ -0,0 +1,91 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConsole(input string) (*Console, *bytes.Buffer, *devices.Bus) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	out := &bytes.Buffer{}
+	c := New(strings.NewReader(input), out, bus.CPU)
+	bus.Attach(DefaultAddress, DefaultAddress+Size-1, c)
+	return c, out, bus
+}
+
+func TestPrintAndExit(t *testing.T) {
+	c, out, bus := newTestConsole("")
+	bus.Memory.Write(0x0200,
+		0xA2, 0x00, // LDX #$00
+		0xBD, 0x20, 0x02, // loop: LDA msg,X
+		0xF0, 0x06, // BEQ done
+		0x20, 0x12, 0x02, // JSR print
+		0xE8,       // INX
+		0xD0, 0xF5, // BNE loop
+		0xA9, 0x03, // done: LDA #$03
+		0x8D, 0x03, 0xF0, // STA $F003
+		0x8D, 0x00, 0xF0, // print: STA $F000
+		0x60, // RTS
+	)
+	bus.Memory.Write(0x0220, []byte("hi!\x00")...)
+	bus.CPU.Reg.PC = 0x0200
+
+	for i := 0; i < 1000 && !bus.CPU.Halted(); i++ {
+		require.NoError(t, bus.StepInstruction())
+	}
+	code, exited := c.Exited()
+	require.True(t, exited)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "hi!", out.String())
+	assert.NoError(t, bus.StepInstruction(), "stepping a halted CPU returns immediately")
+
+	// A reset, such as the debugger's, runs the program again
+	bus.Reset()
+	_, exited = c.Exited()
+	assert.False(t, exited)
+	assert.False(t, bus.CPU.Halted())
+	bus.CPU.Reg.PC = 0x0200
+	for i := 0; i < 1000 && !bus.CPU.Halted(); i++ {
+		require.NoError(t, bus.StepInstruction())
+	}
+	code, exited = c.Exited()
+	require.True(t, exited)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "hi!hi!", out.String())
+}
+
+func TestInput(t *testing.T) {
+	c, _, _ := newTestConsole("ab")
+
+	var got []byte
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Read(StatusRegister)&StatusInputEOF == 0 {
+		require.True(t, time.Now().Before(deadline), "timed out waiting for input")
+		if c.Read(StatusRegister)&StatusInputReady != 0 {
+			got = append(got, c.Read(InputRegister))
+		}
+	}
+	assert.Equal(t, []byte("ab"), got)
+	assert.Zero(t, c.Read(InputRegister), "reads at end of input return zero")
+}
+
+func TestNoInput(t *testing.T) {
+	c := New(nil, nil, nil)
+	assert.Equal(t, StatusInputEOF, c.Read(StatusRegister))
+	c.Write(OutputRegister, 'x')
+	c.Write(ExitRegister, 1)
+	code, exited := c.Exited()
+	assert.True(t, exited)
+	assert.Equal(t, 1, code)
+
+	c.Reset()
+	_, exited = c.Exited()
+	assert.False(t, exited)
+}
@@ -0,0 +1,76 @@
+// Package devices provides the peripheral device model: memory-mapped devices that are clocked
+// alongside the CPU and signal it through shared interrupt lines.
+package devices
+
+import (
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Device is a memory-mapped peripheral that is clocked alongside the CPU.
+// Read and Write receive addresses relative to the start of the range the device is mapped at.
+type Device interface {
+	memory.Operations[uint16]
+	// Tick advances the device by one CPU clock cycle.
+	Tick()
+	// Reset returns the device to its power-on state.
+	Reset()
+}
+
+// Inspectable is implemented by devices that can describe their internal state, such as a raster
+// position, for the debugger.
+type Inspectable interface {
+	Inspect() string
+}
+
+// InterruptLine is a wired-OR interrupt line shared by several devices.
+// The IRQ line is level triggered: the CPU is signalled on every cycle while any source holds it active.
+// The NMI line is edge triggered: the CPU is signalled when the line goes from inactive to active.
+type InterruptLine struct {
+	sources map[any]bool
+	edge    bool
+	signal  func()
+}
+
+// NewIRQLine creates a level triggered interrupt line connected to the CPU's IRQ input.
+func NewIRQLine(c cpu.CPU6502) *InterruptLine {
+	return &InterruptLine{sources: make(map[any]bool), signal: c.Irq}
+}
+
+// NewNMILine creates an edge triggered interrupt line connected to the CPU's NMI input.
+func NewNMILine(c cpu.CPU6502) *InterruptLine {
+	return &InterruptLine{sources: make(map[any]bool), signal: c.Nmi, edge: true}
+}
+
+// NewLine creates a wired-OR line that is not connected to a CPU input and is polled instead, such as RDY.
+func NewLine() *InterruptLine {
+	return &InterruptLine{sources: make(map[any]bool)}
+}
+
+// Set asserts or releases the line on behalf of source.
+func (l *InterruptLine) Set(source any, active bool) {
+	if l == nil {
+		return
+	}
+	wasActive := l.Active()
+	if active {
+		l.sources[source] = true
+	} else {
+		delete(l.sources, source)
+	}
+	if l.edge && !wasActive && l.Active() && l.signal != nil {
+		l.signal()
+	}
+}
+
+// Active returns whether any source is holding the line active.
+func (l *InterruptLine) Active() bool {
+	return l != nil && len(l.sources) > 0
+}
+
+// Poll signals the CPU if the line is level triggered and currently active.
+func (l *InterruptLine) Poll() {
+	if l != nil && !l.edge && l.Active() && l.signal != nil {
+		l.signal()
+	}
+}
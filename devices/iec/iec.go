@@ -0,0 +1,45 @@
+// Package iec models the Commodore serial (IEC) bus: the open collector ATN, CLK and DATA lines that
+// connect a C64 to its disk drives and printers. A line is high unless a device pulls it low.
+package iec
+
+// Lines, as bits in the masks passed to Pull and returned by Lines.
+const (
+	ATN   byte = 0x01
+	Clock byte = 0x02
+	Data  byte = 0x04
+
+	allLines = ATN | Clock | Data
+)
+
+// Bus is a set of wired-AND lines shared by the devices on the serial bus.
+type Bus struct {
+	pulls map[any]byte
+}
+
+// NewBus creates a bus with every line released.
+func NewBus() *Bus {
+	return &Bus{pulls: make(map[any]byte)}
+}
+
+// Pull sets the lines that source holds low, releasing the others.
+func (b *Bus) Pull(source any, lines byte) {
+	if lines&allLines == 0 {
+		delete(b.pulls, source)
+		return
+	}
+	b.pulls[source] = lines & allLines
+}
+
+// Lines returns the lines that are high: those no device is pulling low.
+func (b *Bus) Lines() byte {
+	low := byte(0)
+	for _, lines := range b.pulls {
+		low |= lines
+	}
+	return allLines &^ low
+}
+
+// Low returns whether a line is held low by any device.
+func (b *Bus) Low(line byte) bool {
+	return b.Lines()&line == 0
+}
@@ -0,0 +1,21 @@
+package iec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWiredAND(t *testing.T) {
+	b := NewBus()
+	assert.Equal(t, ATN|Clock|Data, b.Lines(), "lines float high")
+
+	b.Pull("c64", ATN|Clock)
+	b.Pull("drive", Clock|Data)
+	assert.Equal(t, byte(0), b.Lines())
+
+	b.Pull("c64", 0)
+	assert.Equal(t, ATN, b.Lines(), "a line stays low while any device pulls it")
+	assert.True(t, b.Low(Clock))
+	assert.False(t, b.Low(ATN))
+}
@@ -0,0 +1,200 @@
+// Package pia emulates the Motorola 6821 Peripheral Interface Adapter: two 8 bit parallel ports,
+// each with a data direction register, a control register and two control lines (CA1/CA2, CB1/CB2).
+package pia
+
+import (
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// Register offsets, mirrored every 4 bytes. The data register and the data direction register of
+// a port share an address, selected by bit 2 of that port's control register.
+const (
+	PRA = 0x0 // Port A data register, or DDRA when CRA bit 2 is clear
+	CRA = 0x1 // Control register A
+	PRB = 0x2 // Port B data register, or DDRB when CRB bit 2 is clear
+	CRB = 0x3 // Control register B
+
+	registerCount = 4
+)
+
+// Port sides.
+const (
+	PortA = 0
+	PortB = 1
+)
+
+// Control register bits.
+const (
+	controlC1IRQEnable  byte = 0x01 // An active transition on C1 drives IRQ
+	controlC1RisingEdge byte = 0x02 // C1 is active on a low-to-high (1) or high-to-low (0) transition
+	controlDataSelect   byte = 0x04 // The data register (1) or the data direction register (0) is addressed
+	controlC2IRQEnable  byte = 0x08 // C2 input: an active transition on C2 drives IRQ. C2 output: level in manual mode
+	controlC2RisingEdge byte = 0x10 // C2 input: active edge. C2 output: manual (1) or handshake (0) mode
+	controlC2Output     byte = 0x20 // C2 is an output (1) or an input (0)
+	ControlIRQ2         byte = 0x40 // Read only: an active transition occurred on C2
+	ControlIRQ1         byte = 0x80 // Read only: an active transition occurred on C1
+
+	controlWritable byte = 0x3F
+)
+
+// Peripheral is an external device connected to one side of the PIA.
+type Peripheral interface {
+	// Input returns the levels the peripheral drives onto the port's lines; bits configured as outputs are ignored.
+	Input() byte
+	// Output is called when the CPU writes the data register, with the levels on the port's lines.
+	Output(data byte)
+}
+
+// Ticker is implemented by peripherals that need to be clocked alongside the PIA, e.g. to poll a host stream.
+type Ticker interface {
+	Tick()
+}
+
+// Ensure PIA implements the Device interface.
+var _ devices.Device = &PIA{}
+
+type port struct {
+	data       byte
+	ddr        byte
+	control    byte
+	c1, c2     bool // Input levels on the control lines
+	c2Out      bool // Output level of C2 when it is an output
+	peripheral Peripheral
+}
+
+// PIA represents a 6821 PIA. IRQA and IRQB are both wired to the same interrupt line, which may be nil
+// when the interrupt outputs are not connected (as on the Apple-1).
+type PIA struct {
+	interrupt *devices.InterruptLine
+	ports     [2]port
+}
+
+// New creates a PIA that signals interrupts on the given line.
+func New(interrupt *devices.InterruptLine) *PIA {
+	p := &PIA{interrupt: interrupt}
+	p.Reset()
+	return p
+}
+
+// Connect attaches a peripheral to port A or port B.
+func (p *PIA) Connect(side int, peripheral Peripheral) {
+	p.ports[side&1].peripheral = peripheral
+}
+
+// Reset clears every register, making all lines inputs.
+func (p *PIA) Reset() {
+	for i := range p.ports {
+		peripheral := p.ports[i].peripheral
+		p.ports[i] = port{c1: true, c2: true, c2Out: true, peripheral: peripheral}
+	}
+	p.updateInterrupt()
+}
+
+// Tick clocks any peripherals that implement Ticker.
+func (p *PIA) Tick() {
+	for i := range p.ports {
+		if t, ok := p.ports[i].peripheral.(Ticker); ok {
+			t.Tick()
+		}
+	}
+}
+
+// Read reads a PIA register. Reading a data register clears that port's interrupt flags.
+func (p *PIA) Read(address uint16) byte {
+	reg := address % registerCount
+	pt := &p.ports[reg>>1]
+	if reg&1 == 1 {
+		return pt.control
+	}
+	if pt.control&controlDataSelect == 0 {
+		return pt.ddr
+	}
+	pt.control &^= ControlIRQ1 | ControlIRQ2
+	p.updateInterrupt()
+	return p.pins(int(reg >> 1))
+}
+
+// Write writes data to consecutive PIA registers starting at address.
+func (p *PIA) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		p.writeRegister(address+uint16(i), b)
+	}
+}
+
+func (p *PIA) writeRegister(address uint16, b byte) {
+	reg := address % registerCount
+	side := int(reg >> 1)
+	pt := &p.ports[side]
+	if reg&1 == 1 {
+		pt.control = (pt.control &^ controlWritable) | (b & controlWritable)
+		if pt.control&controlC2Output != 0 && pt.control&controlC2RisingEdge != 0 {
+			pt.c2Out = pt.control&controlC2IRQEnable != 0
+		}
+		p.updateInterrupt()
+		return
+	}
+	if pt.control&controlDataSelect == 0 {
+		pt.ddr = b
+		return
+	}
+	pt.data = b
+	if pt.peripheral != nil {
+		pt.peripheral.Output(p.pins(side))
+	}
+}
+
+// pins returns the levels on a port's lines: outputs as driven by the data register, inputs from the peripheral.
+func (p *PIA) pins(side int) byte {
+	pt := &p.ports[side]
+	input := byte(0xFF)
+	if pt.peripheral != nil {
+		input = pt.peripheral.Input()
+	}
+	return (pt.data & pt.ddr) | (input &^ pt.ddr)
+}
+
+// SetC1 drives the C1 control line of a port, setting its IRQ1 flag on the active transition.
+func (p *PIA) SetC1(side int, level bool) {
+	pt := &p.ports[side&1]
+	if level != pt.c1 && level == (pt.control&controlC1RisingEdge != 0) {
+		pt.control |= ControlIRQ1
+	}
+	pt.c1 = level
+	p.updateInterrupt()
+}
+
+// SetC2 drives the C2 control line of a port, setting its IRQ2 flag on the active transition when C2 is an input.
+func (p *PIA) SetC2(side int, level bool) {
+	pt := &p.ports[side&1]
+	if pt.control&controlC2Output == 0 && level != pt.c2 && level == (pt.control&controlC2RisingEdge != 0) {
+		pt.control |= ControlIRQ2
+	}
+	pt.c2 = level
+	p.updateInterrupt()
+}
+
+// Strobe pulses C1 through its active transition, as a peripheral does to signal that data is ready.
+func (p *PIA) Strobe(side int) {
+	active := p.ports[side&1].control&controlC1RisingEdge != 0
+	p.SetC1(side, !active)
+	p.SetC1(side, active)
+}
+
+// C2 returns the output level of a port's C2 line when it is configured as an output.
+func (p *PIA) C2(side int) bool {
+	return p.ports[side&1].c2Out
+}
+
+func (p *PIA) updateInterrupt() {
+	active := false
+	for i := range p.ports {
+		c := p.ports[i].control
+		if c&ControlIRQ1 != 0 && c&controlC1IRQEnable != 0 {
+			active = true
+		}
+		if c&ControlIRQ2 != 0 && c&controlC2Output == 0 && c&controlC2IRQEnable != 0 {
+			active = true
+		}
+	}
+	p.interrupt.Set(p, active)
+}
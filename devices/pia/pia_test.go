@@ -0,0 +1,104 @@
+package pia
+
+import (
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPeripheral struct {
+	input   byte
+	written []byte
+}
+
+func (t *testPeripheral) Input() byte {
+	return t.input
+}
+
+func (t *testPeripheral) Output(data byte) {
+	t.written = append(t.written, data)
+}
+
+func newTestPIA() (*PIA, *devices.Bus) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	p := New(bus.IRQ)
+	bus.Attach(0xD010, 0xD013, p)
+	return p, bus
+}
+
+func TestDataDirectionSelect(t *testing.T) {
+	p, _ := newTestPIA()
+	p.Write(PRA, 0x0F)
+	assert.Equal(t, byte(0x0F), p.Read(PRA), "control bit 2 clear addresses the DDR")
+
+	p.Write(CRA, controlDataSelect)
+	p.Write(PRA, 0x05)
+	assert.Equal(t, byte(0xF5), p.Read(PRA), "inputs read high, outputs read as driven")
+	p.Write(CRA, 0x00)
+	assert.Equal(t, byte(0x0F), p.Read(PRA))
+}
+
+func TestPeripheralInputAndOutput(t *testing.T) {
+	p, _ := newTestPIA()
+	periph := &testPeripheral{input: 0x80}
+	p.Connect(PortB, periph)
+	p.Write(PRB, 0x7F)
+	p.Write(CRB, controlDataSelect)
+
+	p.Write(PRB, 0xC1)
+	require.Len(t, periph.written, 1)
+	assert.Equal(t, byte(0xC1), periph.written[0], "PB7 is an input held high by the peripheral")
+
+	periph.input = 0x00
+	assert.Equal(t, byte(0x41), p.Read(PRB))
+}
+
+func TestC1StrobeSetsFlag(t *testing.T) {
+	p, bus := newTestPIA()
+	p.Write(CRA, controlDataSelect|controlC1RisingEdge)
+	p.SetC1(PortA, false)
+	assert.Zero(t, p.Read(CRA)&ControlIRQ1, "wrong edge")
+	p.SetC1(PortA, true)
+	assert.Equal(t, ControlIRQ1, p.Read(CRA)&ControlIRQ1)
+	assert.False(t, bus.IRQ.Active(), "interrupt not enabled")
+
+	p.Read(PRA)
+	assert.Zero(t, p.Read(CRA)&ControlIRQ1, "reading the data register clears the flag")
+}
+
+func TestC1Interrupt(t *testing.T) {
+	p, bus := newTestPIA()
+	p.Write(CRB, controlDataSelect|controlC1IRQEnable)
+	p.Strobe(PortB)
+	require.True(t, bus.IRQ.Active())
+	assert.Equal(t, ControlIRQ1, bus.Memory.Read(0xD010+CRB)&ControlIRQ1)
+	bus.Memory.Read(0xD010 + PRB)
+	assert.False(t, bus.IRQ.Active())
+}
+
+func TestC2InputInterrupt(t *testing.T) {
+	p, bus := newTestPIA()
+	p.Write(CRA, controlDataSelect|controlC2IRQEnable)
+	p.SetC2(PortA, false)
+	assert.Equal(t, ControlIRQ2, p.Read(CRA)&ControlIRQ2)
+	assert.True(t, bus.IRQ.Active())
+}
+
+func TestC2ManualOutput(t *testing.T) {
+	p, _ := newTestPIA()
+	p.Write(CRA, controlC2Output|controlC2RisingEdge)
+	assert.False(t, p.C2(PortA))
+	p.Write(CRA, controlC2Output|controlC2RisingEdge|controlC2IRQEnable)
+	assert.True(t, p.C2(PortA))
+	p.SetC2(PortA, false)
+	assert.Zero(t, p.Read(CRA)&ControlIRQ2, "C2 transitions are ignored when it is an output")
+}
+
+func TestControlFlagsAreReadOnly(t *testing.T) {
+	p, _ := newTestPIA()
+	p.Write(CRA, 0xFF)
+	assert.Equal(t, byte(0x3F), p.Read(CRA))
+}
@@ -0,0 +1,167 @@
+// Package sid emulates the MOS 6581/8580 SID sound chip. The device records every register write with
+// the cycle it happened on, and a software synthesis engine replays the recording offline into
+// audio samples that can be saved as a WAV file.
+package sid
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// Register offsets, mirrored every 32 bytes. Voice n's registers are at the voice 1 offset + n*VoiceSize.
+const (
+	FreqLo     = 0x00
+	FreqHi     = 0x01
+	PWLo       = 0x02
+	PWHi       = 0x03 // Bits 0-3: pulse width bits 8-11
+	Control    = 0x04 // Bit 7: noise, 6: pulse, 5: sawtooth, 4: triangle, 3: test, 2: ring mod, 1: sync, 0: gate
+	AttackDec  = 0x05 // Bits 4-7: attack, 0-3: decay
+	SustainRel = 0x06 // Bits 4-7: sustain level, 0-3: release
+	VoiceSize  = 0x07
+
+	FCLo    = 0x15 // Bits 0-2: cutoff bits 0-2
+	FCHi    = 0x16 // Cutoff bits 3-10
+	ResFilt = 0x17 // Bits 4-7: resonance, bit 0-2: filter voice 1-3, 3: filter external input
+	ModeVol = 0x18 // Bit 7: voice 3 off, 6: high pass, 5: band pass, 4: low pass, 0-3: volume
+	PotX    = 0x19
+	PotY    = 0x1A
+	Osc3    = 0x1B // Upper 8 bits of voice 3's waveform output
+	Env3    = 0x1C // Voice 3's envelope level
+	Size    = 0x20 // Registers are mirrored every Size bytes
+)
+
+// Control register bits.
+const (
+	controlGate     byte = 0x01
+	controlSync     byte = 0x02
+	controlRing     byte = 0x04
+	controlTest     byte = 0x08
+	controlTriangle byte = 0x10
+	controlSawtooth byte = 0x20
+	controlPulse    byte = 0x40
+	controlNoise    byte = 0x80
+)
+
+// Clock rates of the C64's SID.
+const (
+	ClockPAL  = 985248
+	ClockNTSC = 1022727
+)
+
+// DefaultSampleRate is the sample rate used when rendering audio.
+const DefaultSampleRate = 44100
+
+// RegisterWrite is a write to a SID register, timestamped with the number of cycles since reset.
+type RegisterWrite struct {
+	Cycle    uint64
+	Register byte
+	Value    byte
+}
+
+// Ensure SID implements the Device interface.
+var _ devices.Device = &SID{}
+
+// SID represents a SID chip clocked by the CPU. It logs register writes for offline rendering and runs
+// voice 3's oscillator and envelope live so programs can read OSC3 and ENV3.
+type SID struct {
+	clockHz uint64
+	cycle   uint64
+	writes  []RegisterWrite
+	synth   *Synth
+	bus     byte // Last value written, which write-only registers read back
+}
+
+// New creates a SID clocked at clockHz, usually ClockPAL or ClockNTSC.
+func New(clockHz uint64) *SID {
+	s := &SID{clockHz: clockHz}
+	s.Reset()
+	return s
+}
+
+// Reset silences the SID and clears the cycle counter and the write log.
+func (s *SID) Reset() {
+	s.cycle = 0
+	s.writes = nil
+	s.synth = NewSynth(s.clockHz)
+	s.bus = 0
+}
+
+// Tick advances the SID by one cycle.
+func (s *SID) Tick() {
+	s.cycle++
+	s.synth.Clock()
+}
+
+// Read reads a SID register. The write-only registers return the last value written to the chip and
+// the paddle inputs, which are not connected, read $FF.
+func (s *SID) Read(address uint16) byte {
+	switch address % Size {
+	case PotX, PotY:
+		return 0xFF
+	case Osc3:
+		return s.synth.Osc3()
+	case Env3:
+		return s.synth.Env3()
+	}
+	return s.bus
+}
+
+// Write writes data to consecutive SID registers starting at address, logging each write.
+func (s *SID) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		reg := byte((address + uint16(i)) % Size)
+		s.bus = b
+		if reg >= PotX {
+			continue
+		}
+		s.writes = append(s.writes, RegisterWrite{Cycle: s.cycle, Register: reg, Value: b})
+		s.synth.WriteRegister(reg, b)
+	}
+}
+
+// ClockHz returns the clock rate the SID was created with.
+func (s *SID) ClockHz() uint64 {
+	return s.clockHz
+}
+
+// Cycles returns the number of cycles since reset.
+func (s *SID) Cycles() uint64 {
+	return s.cycle
+}
+
+// Writes returns the register writes logged since reset.
+func (s *SID) Writes() []RegisterWrite {
+	return s.writes
+}
+
+// Render synthesises the logged writes, from reset to the current cycle, as 16 bit mono samples.
+func (s *SID) Render(sampleRate int) []int16 {
+	return Render(s.writes, s.clockHz, s.cycle, sampleRate)
+}
+
+// SaveWAV renders the logged writes to a 16 bit mono WAV file.
+func (s *SID) SaveWAV(filename string, sampleRate int) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("[sid SaveWAV] %w", err)
+	}
+	if err := WriteWAV(f, s.Render(sampleRate), sampleRate); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteLog writes the logged register writes as text, one per line: the cycle, the register offset and
+// the value, the latter two in hex.
+func (s *SID) WriteLog(w io.Writer) error {
+	for _, rw := range s.writes {
+		if _, err := fmt.Fprintf(w, "%d %02X %02X\n", rw.Cycle, rw.Register, rw.Value); err != nil {
+			return fmt.Errorf("[sid WriteLog] %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package sid
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSampleRate = 44100
+
+func tick(s *SID, n int) {
+	for i := 0; i < n; i++ {
+		s.Tick()
+	}
+}
+
+// playNote sets up voice 1 with the given frequency register, waveform and envelope and gates it on.
+func playNote(s *SID, freq uint16, waveform byte) {
+	s.Write(FreqLo, byte(freq), byte(freq>>8), 0x00, 0x08) // 50% pulse width
+	s.Write(AttackDec, 0x00, 0xF0)                         // Instant attack, full sustain
+	s.Write(ModeVol, 0x0F)
+	s.Write(Control, waveform|controlGate)
+}
+
+// freqRegister returns the frequency register value for hz at the PAL clock.
+func freqRegister(hz float64) uint16 {
+	return uint16(hz * (1 << 24) / ClockPAL)
+}
+
+// zeroCrossings counts rising zero crossings, from which a tone's frequency can be estimated.
+func zeroCrossings(samples []int16) int {
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func rms(samples []int16) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestWritesAreLoggedWithCycles(t *testing.T) {
+	s := New(ClockPAL)
+	tick(s, 10)
+	s.Write(FreqLo, 0x12, 0x34)
+	tick(s, 5)
+	s.Write(Size+ModeVol, 0x0F)
+	s.Write(Osc3, 0xAA)
+
+	assert.Equal(t, []RegisterWrite{
+		{Cycle: 10, Register: FreqLo, Value: 0x12},
+		{Cycle: 10, Register: FreqHi, Value: 0x34},
+		{Cycle: 15, Register: ModeVol, Value: 0x0F},
+	}, s.Writes(), "registers are mirrored and the read-only registers are not logged")
+	assert.Equal(t, uint64(15), s.Cycles())
+
+	s.Reset()
+	assert.Empty(t, s.Writes())
+}
+
+func TestReadOnlyRegisters(t *testing.T) {
+	s := New(ClockPAL)
+	assert.Equal(t, byte(0xFF), s.Read(PotX))
+	s.Write(FreqLo, 0x42)
+	assert.Equal(t, byte(0x42), s.Read(ModeVol), "write-only registers read the last value written")
+
+	s.Write(FreqLo+2*VoiceSize, 0x00, 0x10)
+	s.Write(Control+2*VoiceSize, controlSawtooth)
+	assert.Equal(t, byte(0), s.Read(Osc3))
+	tick(s, 0x100)
+	assert.Equal(t, byte(0x10), s.Read(Osc3), "sawtooth rises with the accumulator")
+}
+
+func TestEnvelope(t *testing.T) {
+	s := New(ClockPAL)
+	s.Write(AttackDec+2*VoiceSize, 0x00, 0x80) // Attack 2ms, decay 6ms, sustain $88
+	s.Write(Control+2*VoiceSize, controlGate)
+	tick(s, 9*0xFF)
+	assert.Equal(t, byte(0xFF), s.Read(Env3), "attack reaches full level")
+
+	tick(s, 20000)
+	assert.Equal(t, byte(0x88), s.Read(Env3), "decay stops at the sustain level")
+
+	s.Write(Control+2*VoiceSize, 0)
+	tick(s, 200000)
+	assert.Equal(t, byte(0), s.Read(Env3), "release falls to silence")
+}
+
+func TestNoiseIsRandom(t *testing.T) {
+	s := New(ClockPAL)
+	s.Write(FreqLo+2*VoiceSize, 0xFF, 0xFF)
+	s.Write(Control+2*VoiceSize, controlNoise)
+	seen := map[byte]bool{}
+	for i := 0; i < 100; i++ {
+		tick(s, 100)
+		seen[s.Read(Osc3)] = true
+	}
+	assert.Greater(t, len(seen), 50)
+}
+
+func TestRenderFrequency(t *testing.T) {
+	for _, waveform := range []byte{controlTriangle, controlSawtooth, controlPulse} {
+		s := New(ClockPAL)
+		playNote(s, freqRegister(440), waveform)
+		tick(s, ClockPAL)
+		samples := s.Render(testSampleRate)
+		assert.InDelta(t, testSampleRate, len(samples), 1)
+		assert.InDelta(t, 440, zeroCrossings(samples), 3, "waveform %02X", waveform)
+	}
+}
+
+func TestRenderSilence(t *testing.T) {
+	s := New(ClockPAL)
+	playNote(s, freqRegister(440), controlSawtooth)
+	s.Write(ModeVol, 0x00)
+	tick(s, ClockPAL/10)
+	assert.Zero(t, rms(s.Render(testSampleRate)), "volume 0 is silent")
+}
+
+func TestRingModulation(t *testing.T) {
+	render := func(control byte) []int16 {
+		s := New(ClockPAL)
+		s.Write(FreqLo+2*VoiceSize, byte(freqRegister(1000)), byte(freqRegister(1000)>>8))
+		playNote(s, freqRegister(440), control)
+		tick(s, ClockPAL/10)
+		return s.Render(testSampleRate)
+	}
+	assert.NotEqual(t, render(controlTriangle), render(controlTriangle|controlRing))
+}
+
+func TestHardSync(t *testing.T) {
+	render := func(control byte) []int16 {
+		s := New(ClockPAL)
+		s.Write(FreqLo+2*VoiceSize, byte(freqRegister(400)), byte(freqRegister(400)>>8))
+		playNote(s, freqRegister(150), control)
+		tick(s, ClockPAL)
+		return s.Render(testSampleRate)
+	}
+	assert.InDelta(t, 150, zeroCrossings(render(controlSawtooth)), 3)
+	assert.Zero(t, zeroCrossings(render(controlSawtooth|controlSync)), "voice 3 restarts the ramp before it reaches half way")
+}
+
+func TestLowPassFilter(t *testing.T) {
+	render := func(filtered bool) []int16 {
+		s := New(ClockPAL)
+		playNote(s, freqRegister(3000), controlTriangle)
+		if filtered {
+			s.Write(FCLo, 0x00, 0x08) // Cutoff around 400Hz
+			s.Write(ResFilt, 0x01)
+			s.Write(ModeVol, 0x1F)
+		}
+		tick(s, ClockPAL/10)
+		return s.Render(testSampleRate)
+	}
+	assert.Less(t, rms(render(true)), rms(render(false))/4)
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, math.MaxInt16, math.MinInt16}
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteWAV(buf, samples, 22050))
+	assert.Equal(t, wavHeaderSize+2*len(samples), buf.Len())
+	assert.Equal(t, "RIFF", buf.String()[:4])
+
+	decoded, rate, err := ReadWAV(buf)
+	require.NoError(t, err)
+	assert.Equal(t, samples, decoded)
+	assert.Equal(t, 22050, rate)
+
+	_, _, err = ReadWAV(bytes.NewReader([]byte("not a wav")))
+	assert.Error(t, err)
+}
+
+func TestSaveWAV(t *testing.T) {
+	s := New(ClockPAL)
+	playNote(s, freqRegister(440), controlPulse)
+	tick(s, ClockPAL/50)
+	file := filepath.Join(t.TempDir(), "tune.wav")
+	require.NoError(t, s.SaveWAV(file, testSampleRate))
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	samples, _, err := ReadWAV(f)
+	require.NoError(t, err)
+	assert.Equal(t, s.Render(testSampleRate), samples)
+}
+
+func TestWriteLog(t *testing.T) {
+	s := New(ClockPAL)
+	tick(s, 1000)
+	s.Write(Control, controlPulse|controlGate)
+	buf := &bytes.Buffer{}
+	require.NoError(t, s.WriteLog(buf))
+	assert.Equal(t, "1000 04 41\n", buf.String())
+}
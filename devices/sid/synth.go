@@ -0,0 +1,321 @@
+package sid
+
+import "math"
+
+// Envelope generator states.
+const (
+	envelopeAttack = iota
+	envelopeDecay
+	envelopeRelease
+)
+
+// ratePeriods holds the number of cycles between envelope steps for each attack, decay and release
+// setting. Decay and release steps are slowed further by the exponential counter.
+var ratePeriods = [16]uint16{9, 32, 63, 95, 149, 220, 267, 313, 392, 977, 1954, 3126, 3907, 11720, 19532, 31251}
+
+const noiseSeed = 0x7FFFF8
+
+// Synth is the SID's synthesis engine: three oscillators with envelope generators mixed through a
+// state variable filter. It models the chip closely enough to hear and test a tune, not bit exactly;
+// combined waveforms are approximated by ANDing their outputs and the filter is an idealised one.
+type Synth struct {
+	clockHz uint64
+	voices  [3]voice
+	cutoff  uint16  // 11 bit filter cutoff
+	w       float64 // Filter coefficient for the cutoff, recalculated when it is written
+	resFilt byte
+	modeVol byte
+	lp, bp  float64 // Filter state
+}
+
+type voice struct {
+	freq      uint16
+	pw        uint16
+	control   byte
+	acc       uint32 // 24 bit phase accumulator
+	msbRising bool   // The accumulator's MSB went high on the last clock, syncing the next voice
+	noise     uint32 // 23 bit noise shift register
+	env       envelope
+}
+
+type envelope struct {
+	state       int
+	level       byte
+	rateCounter uint16
+	expCounter  byte
+	attack      byte
+	decay       byte
+	sustain     byte
+	release     byte
+}
+
+// NewSynth creates a silent synthesis engine clocked at clockHz.
+func NewSynth(clockHz uint64) *Synth {
+	s := &Synth{clockHz: clockHz}
+	for i := range s.voices {
+		s.voices[i].noise = noiseSeed
+		s.voices[i].env.state = envelopeRelease
+	}
+	s.setCutoff(0)
+	return s
+}
+
+// WriteRegister sets one of the SID's write-only registers.
+func (s *Synth) WriteRegister(reg, value byte) {
+	if reg < FCLo {
+		s.voices[reg/VoiceSize].write(reg%VoiceSize, value)
+		return
+	}
+	switch reg {
+	case FCLo:
+		s.setCutoff(s.cutoff&^0x07 | uint16(value&0x07))
+	case FCHi:
+		s.setCutoff(s.cutoff&0x07 | uint16(value)<<3)
+	case ResFilt:
+		s.resFilt = value
+	case ModeVol:
+		s.modeVol = value
+	}
+}
+
+// setCutoff sets the filter cutoff and calculates its coefficient, with the cutoff curve of a typical 6581
+// (about 30Hz to 12kHz).
+func (s *Synth) setCutoff(cutoff uint16) {
+	s.cutoff = cutoff
+	f := 30 + float64(cutoff)*5.8
+	s.w = 2 * math.Sin(math.Pi*f/float64(s.clockHz))
+}
+
+func (v *voice) write(reg, value byte) {
+	switch reg {
+	case FreqLo:
+		v.freq = v.freq&0xFF00 | uint16(value)
+	case FreqHi:
+		v.freq = v.freq&0x00FF | uint16(value)<<8
+	case PWLo:
+		v.pw = v.pw&0x0F00 | uint16(value)
+	case PWHi:
+		v.pw = v.pw&0x00FF | uint16(value&0x0F)<<8
+	case Control:
+		gateWas := v.control&controlGate != 0
+		v.control = value
+		if value&controlTest != 0 {
+			v.acc = 0
+			v.noise = noiseSeed
+		}
+		v.env.gate(gateWas, value&controlGate != 0)
+	case AttackDec:
+		v.env.attack, v.env.decay = value>>4, value&0x0F
+	case SustainRel:
+		v.env.sustain, v.env.release = value>>4, value&0x0F
+	}
+}
+
+// Clock advances the oscillators and envelopes by one cycle.
+func (s *Synth) Clock() {
+	for i := range s.voices {
+		s.voices[i].clock()
+	}
+	for i := range s.voices {
+		v := &s.voices[i]
+		if v.control&controlSync != 0 && s.syncSource(i).msbRising {
+			v.acc = 0
+		}
+	}
+	for i := range s.voices {
+		s.voices[i].env.clock()
+	}
+}
+
+// syncSource returns the voice that syncs and ring modulates voice i: voice 3 for voice 1, voice 1 for
+// voice 2 and voice 2 for voice 3.
+func (s *Synth) syncSource(i int) *voice {
+	return &s.voices[(i+2)%3]
+}
+
+// Osc3 returns the upper 8 bits of voice 3's waveform output, as read from the OSC3 register.
+func (s *Synth) Osc3() byte {
+	return byte(s.voices[2].waveform(s.syncSource(2)) >> 4)
+}
+
+// Env3 returns voice 3's envelope level, as read from the ENV3 register.
+func (s *Synth) Env3() byte {
+	return s.voices[2].env.level
+}
+
+// Output mixes the voices through the filter and master volume, returning a sample between -1 and 1.
+// The filter is advanced by one cycle, so Output should be called once for every call to Clock.
+func (s *Synth) Output() float64 {
+	var direct, filtered float64
+	for i := range s.voices {
+		routed := s.resFilt&(1<<i) != 0
+		if i == 2 && s.modeVol&0x80 != 0 && !routed {
+			continue // Voice 3 off only disconnects the unfiltered path
+		}
+		v := &s.voices[i]
+		out := (float64(v.waveform(s.syncSource(i))) - 2048) / 2048 * float64(v.env.level) / 255
+		if routed {
+			filtered += out
+		} else {
+			direct += out
+		}
+	}
+
+	// A Chamberlin state variable filter
+	damping := 1.4 - float64(s.resFilt>>4)/15
+	hp := filtered - s.lp - damping*s.bp
+	s.bp += s.w * hp
+	s.lp += s.w * s.bp
+
+	if s.modeVol&0x10 != 0 {
+		direct += s.lp
+	}
+	if s.modeVol&0x20 != 0 {
+		direct += s.bp
+	}
+	if s.modeVol&0x40 != 0 {
+		direct += hp
+	}
+	return direct / 3 * float64(s.modeVol&0x0F) / 15
+}
+
+func (v *voice) clock() {
+	if v.control&controlTest != 0 {
+		v.msbRising = false
+		return
+	}
+	prev := v.acc
+	v.acc = (v.acc + uint32(v.freq)) & 0xFFFFFF
+	v.msbRising = prev&0x800000 == 0 && v.acc&0x800000 != 0
+	if prev&0x080000 == 0 && v.acc&0x080000 != 0 {
+		bit := (v.noise>>22 ^ v.noise>>17) & 1
+		v.noise = (v.noise<<1 | bit) & 0x7FFFFF
+	}
+}
+
+// waveform returns the voice's 12 bit waveform output. Selecting several waveforms ANDs them together.
+func (v *voice) waveform(source *voice) uint16 {
+	out := uint16(0xFFF)
+	if v.control&0xF0 == 0 {
+		return 0
+	}
+	if v.control&controlTriangle != 0 {
+		msb := v.acc&0x800000 != 0
+		if v.control&controlRing != 0 && source.acc&0x800000 != 0 {
+			msb = !msb
+		}
+		t := uint16(v.acc>>11) & 0xFFF
+		if msb {
+			t ^= 0xFFF
+		}
+		out &= t
+	}
+	if v.control&controlSawtooth != 0 {
+		out &= uint16(v.acc >> 12)
+	}
+	if v.control&controlPulse != 0 && v.control&controlTest == 0 && uint16(v.acc>>12) < v.pw {
+		out = 0
+	}
+	if v.control&controlNoise != 0 {
+		n := v.noise
+		out &= uint16(n&0x100000>>9 | n&0x040000>>8 | n&0x004000>>5 | n&0x000800>>3 |
+			n&0x000200>>2 | n&0x000020<<1 | n&0x000004<<3 | n&0x000001<<4)
+	}
+	return out
+}
+
+// gate starts the attack when the gate bit is set and the release when it is cleared.
+func (e *envelope) gate(was, now bool) {
+	switch {
+	case now && !was:
+		e.state = envelopeAttack
+	case !now && was:
+		e.state = envelopeRelease
+	}
+}
+
+func (e *envelope) clock() {
+	var rate byte
+	switch e.state {
+	case envelopeAttack:
+		rate = e.attack
+	case envelopeDecay:
+		rate = e.decay
+	default:
+		rate = e.release
+	}
+	e.rateCounter++
+	if e.rateCounter < ratePeriods[rate] {
+		return
+	}
+	e.rateCounter = 0
+
+	if e.state == envelopeAttack {
+		e.expCounter = 0
+		if e.level < 0xFF {
+			e.level++
+		}
+		if e.level == 0xFF {
+			e.state = envelopeDecay
+		}
+		return
+	}
+	e.expCounter++
+	if e.expCounter < e.exponentialPeriod() {
+		return
+	}
+	e.expCounter = 0
+	if e.state == envelopeDecay && e.level <= e.sustain*0x11 {
+		return
+	}
+	if e.level > 0 {
+		e.level--
+	}
+}
+
+// exponentialPeriod returns how many rate periods each decay or release step takes at the current
+// level, approximating an exponential curve.
+func (e *envelope) exponentialPeriod() byte {
+	switch {
+	case e.level >= 0x5D:
+		return 1
+	case e.level >= 0x36:
+		return 2
+	case e.level >= 0x1A:
+		return 4
+	case e.level >= 0x0E:
+		return 8
+	case e.level >= 0x06:
+		return 16
+	}
+	return 30
+}
+
+// Render synthesises cycles cycles of audio from a register write log, averaging the SID's output
+// over each sample period, and returns 16 bit samples at sampleRate.
+func Render(writes []RegisterWrite, clockHz, cycles uint64, sampleRate int) []int16 {
+	s := NewSynth(clockHz)
+	rate := uint64(sampleRate)
+	samples := make([]int16, 0, cycles*rate/clockHz+1)
+	next := 0
+	var sum float64
+	var n int
+	for c := uint64(0); c < cycles; c++ {
+		for next < len(writes) && writes[next].Cycle <= c {
+			s.WriteRegister(writes[next].Register, writes[next].Value)
+			next++
+		}
+		s.Clock()
+		sum += s.Output()
+		n++
+		if (c+1)*rate/clockHz != c*rate/clockHz {
+			samples = append(samples, toSample(sum/float64(n)))
+			sum, n = 0, 0
+		}
+	}
+	return samples
+}
+
+func toSample(v float64) int16 {
+	return int16(math.Max(-1, math.Min(1, v)) * math.MaxInt16)
+}
@@ -0,0 +1,68 @@
+package sid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	wavHeaderSize = 44
+	wavFormatPCM  = 1
+)
+
+// WriteWAV encodes 16 bit mono samples as a PCM WAV file.
+func WriteWAV(w io.Writer, samples []int16, sampleRate int) error {
+	dataSize := uint32(len(samples) * 2)
+	buf := make([]byte, 0, wavHeaderSize+int(dataSize))
+	buf = append(buf, "RIFF"...)
+	buf = binary.LittleEndian.AppendUint32(buf, wavHeaderSize-8+dataSize)
+	buf = append(buf, "WAVEfmt "...)
+	buf = binary.LittleEndian.AppendUint32(buf, 16) // fmt chunk size
+	buf = binary.LittleEndian.AppendUint16(buf, wavFormatPCM)
+	buf = binary.LittleEndian.AppendUint16(buf, 1) // Channels
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sampleRate))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sampleRate*2)) // Bytes per second
+	buf = binary.LittleEndian.AppendUint16(buf, 2)                    // Bytes per sample frame
+	buf = binary.LittleEndian.AppendUint16(buf, 16)                   // Bits per sample
+	buf = append(buf, "data"...)
+	buf = binary.LittleEndian.AppendUint32(buf, dataSize)
+	for _, s := range samples {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(s))
+	}
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("[sid WriteWAV] %w", err)
+	}
+	return nil
+}
+
+// ReadWAV decodes a 16 bit mono PCM WAV file as written by WriteWAV, returning its samples and sample rate.
+func ReadWAV(r io.Reader) ([]int16, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[sid ReadWAV] %w", err)
+	}
+	if len(data) < wavHeaderSize || !bytes.Equal(data[0:4], []byte("RIFF")) || !bytes.Equal(data[8:16], []byte("WAVEfmt ")) {
+		return nil, 0, fmt.Errorf("[sid ReadWAV] not a WAV file")
+	}
+	format := binary.LittleEndian.Uint16(data[20:])
+	channels := binary.LittleEndian.Uint16(data[22:])
+	bits := binary.LittleEndian.Uint16(data[34:])
+	if format != wavFormatPCM || channels != 1 || bits != 16 {
+		return nil, 0, fmt.Errorf("[sid ReadWAV] only 16 bit mono PCM is supported")
+	}
+	if !bytes.Equal(data[36:40], []byte("data")) {
+		return nil, 0, fmt.Errorf("[sid ReadWAV] missing data chunk")
+	}
+	sampleRate := int(binary.LittleEndian.Uint32(data[24:]))
+	size := int(binary.LittleEndian.Uint32(data[40:]))
+	if size > len(data)-wavHeaderSize {
+		return nil, 0, fmt.Errorf("[sid ReadWAV] data chunk is truncated")
+	}
+	samples := make([]int16, size/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[wavHeaderSize+2*i:]))
+	}
+	return samples, sampleRate, nil
+}
@@ -0,0 +1,438 @@
+// Package via emulates the MOS 6522 Versatile Interface Adapter: two parallel ports with input
+// latching, the CA1/CA2 and CB1/CB2 control lines, two interval timers and the interrupt registers.
+package via
+
+import (
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// Register offsets, mirrored every 16 bytes.
+const (
+	ORB   = 0x0 // Port B output register / input register
+	ORA   = 0x1 // Port A output register / input register, with handshake
+	DDRB  = 0x2 // Port B data direction register
+	DDRA  = 0x3 // Port A data direction register
+	T1CL  = 0x4 // Timer 1 counter low byte. Write: latch low byte
+	T1CH  = 0x5 // Timer 1 counter high byte. Write: latch high byte, load and start the counter
+	T1LL  = 0x6 // Timer 1 latch low byte
+	T1LH  = 0x7 // Timer 1 latch high byte
+	T2CL  = 0x8 // Timer 2 counter low byte. Write: latch low byte
+	T2CH  = 0x9 // Timer 2 counter high byte. Write: load and start the counter
+	SR    = 0xA // Shift register
+	ACR   = 0xB // Auxiliary control register
+	PCR   = 0xC // Peripheral control register
+	IFR   = 0xD // Interrupt flag register
+	IER   = 0xE // Interrupt enable register
+	ORANH = 0xF // Port A without handshake
+
+	registerCount = 16
+)
+
+// Interrupt sources in the IFR and IER.
+const (
+	InterruptCA2 byte = 1 << iota
+	InterruptCA1
+	InterruptShift
+	InterruptCB2
+	InterruptCB1
+	InterruptTimer2
+	InterruptTimer1
+
+	interruptAny      byte = 0x80 // IFR read: an enabled interrupt occurred
+	interruptSetClear byte = 0x80 // IER write: set (1) or clear (0) the enable bits
+	interruptMask     byte = 0x7F
+)
+
+// Auxiliary control register bits.
+const (
+	auxLatchA        byte = 0x01 // Latch port A on an active CA1 transition
+	auxLatchB        byte = 0x02 // Latch port B on an active CB1 transition
+	auxT2CountPulses byte = 0x20 // Timer 2 counts PB6 pulses (1) or φ2 (0)
+	auxT1FreeRun     byte = 0x40 // Timer 1 reloads from its latch (1) or runs once (0)
+	auxT1PB7         byte = 0x80 // Timer 1 drives PB7
+)
+
+// Peripheral control register bits, for the A side; the B side's are shifted left by 4.
+const (
+	controlC1Rising byte = 0x01 // C1 is active on a rising (1) or falling (0) edge
+	controlC2Mode   byte = 0x0E // C2 mode
+
+	c2InputFalling       byte = 0x00
+	c2IndependentFalling byte = 0x02
+	c2InputRising        byte = 0x04
+	c2IndependentRising  byte = 0x06
+	c2Handshake          byte = 0x08
+	c2Pulse              byte = 0x0A
+	c2Low                byte = 0x0C
+	c2High               byte = 0x0E
+	c2Output             byte = 0x08 // Set in every output mode
+
+	sideBControlShift = 4
+)
+
+// PortDevice is an external device connected to the VIA's parallel port pins. Ports receives the
+// levels the VIA drives on port A and port B (inputs read as high) and returns the levels after the
+// device has pulled lines low. It is called whenever the ports are read or written, so a device can
+// also follow the VIA's outputs.
+type PortDevice interface {
+	Ports(pa, pb byte) (byte, byte)
+}
+
+// Ensure VIA implements the Device interface.
+var _ devices.Device = &VIA{}
+
+// side holds a port with its control lines.
+type side struct {
+	output, ddr byte
+	latch       byte
+	c1, c2      bool // Input levels on C1 and C2
+	c2Out       bool // Output level of C2 in an output mode
+	pulse       bool // C2 pulse output ends on the next cycle
+}
+
+// VIA represents a MOS 6522 Versatile Interface Adapter.
+type VIA struct {
+	interrupt *devices.InterruptLine
+	ports     []PortDevice
+
+	a, b side
+
+	t1Counter, t1Latch uint16
+	t1Armed            bool
+	pb7                bool
+	t2Counter          uint16
+	t2LatchLo          byte
+	t2Armed            bool
+
+	sr  byte
+	acr byte
+	pcr byte
+	ifr byte
+	ier byte
+}
+
+// New creates a VIA connected to the given interrupt line, which may be nil when IRQ is not wired.
+func New(interrupt *devices.InterruptLine) *VIA {
+	v := &VIA{interrupt: interrupt}
+	v.Reset()
+	return v
+}
+
+// Attach connects an external device to the parallel ports.
+func (v *VIA) Attach(p PortDevice) {
+	v.ports = append(v.ports, p)
+}
+
+// Reset clears the port, control and interrupt registers, as the reset line does. The timers and
+// the shift register are not affected on the real chip, but are cleared here for repeatability.
+func (v *VIA) Reset() {
+	v.a = side{c1: true, c2: true, c2Out: true}
+	v.b = side{c1: true, c2: true, c2Out: true}
+	v.t1Counter, v.t1Latch, v.t1Armed, v.pb7 = 0xFFFF, 0xFFFF, false, true
+	v.t2Counter, v.t2LatchLo, v.t2Armed = 0xFFFF, 0xFF, false
+	v.sr, v.acr, v.pcr, v.ifr, v.ier = 0, 0, 0, 0, 0
+	v.updateInterrupt()
+	v.pins()
+}
+
+// Read reads a VIA register. Reading a port clears its control line interrupts, and reading the low
+// byte of a timer clears that timer's interrupt.
+func (v *VIA) Read(address uint16) byte {
+	switch address % registerCount {
+	case ORB:
+		v.portAccess(&v.b, v.pcr>>sideBControlShift, InterruptCB1, InterruptCB2)
+		_, pb := v.pins()
+		if v.acr&auxLatchB != 0 {
+			pb = v.b.latch
+		}
+		return pb&^v.b.ddr | v.b.output&v.b.ddr
+	case ORA, ORANH:
+		if address%registerCount == ORA {
+			v.portAccess(&v.a, v.pcr, InterruptCA1, InterruptCA2)
+		}
+		pa, _ := v.pins()
+		if v.acr&auxLatchA != 0 {
+			return v.a.latch
+		}
+		return pa
+	case DDRB:
+		return v.b.ddr
+	case DDRA:
+		return v.a.ddr
+	case T1CL:
+		v.clearInterrupt(InterruptTimer1)
+		return byte(v.t1Counter)
+	case T1CH:
+		return byte(v.t1Counter >> 8)
+	case T1LL:
+		return byte(v.t1Latch)
+	case T1LH:
+		return byte(v.t1Latch >> 8)
+	case T2CL:
+		v.clearInterrupt(InterruptTimer2)
+		return byte(v.t2Counter)
+	case T2CH:
+		return byte(v.t2Counter >> 8)
+	case SR:
+		v.clearInterrupt(InterruptShift)
+		return v.sr
+	case ACR:
+		return v.acr
+	case PCR:
+		return v.pcr
+	case IFR:
+		if v.ifr&v.ier&interruptMask != 0 {
+			return v.ifr | interruptAny
+		}
+		return v.ifr
+	case IER:
+		return v.ier | 0x80
+	}
+	return 0
+}
+
+// Write writes data to consecutive VIA registers starting at address.
+func (v *VIA) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		v.writeRegister(address+uint16(i), b)
+	}
+}
+
+func (v *VIA) writeRegister(address uint16, b byte) {
+	switch address % registerCount {
+	case ORB:
+		v.portAccess(&v.b, v.pcr>>sideBControlShift, InterruptCB1, InterruptCB2)
+		v.b.output = b
+	case ORA:
+		v.portAccess(&v.a, v.pcr, InterruptCA1, InterruptCA2)
+		v.a.output = b
+	case ORANH:
+		v.a.output = b
+	case DDRB:
+		v.b.ddr = b
+	case DDRA:
+		v.a.ddr = b
+	case T1CL, T1LL:
+		v.t1Latch = v.t1Latch&0xFF00 | uint16(b)
+	case T1CH:
+		v.t1Latch = v.t1Latch&0x00FF | uint16(b)<<8
+		v.t1Counter = v.t1Latch
+		v.t1Armed = true
+		if v.acr&auxT1PB7 != 0 {
+			v.pb7 = false
+		}
+		v.clearInterrupt(InterruptTimer1)
+	case T1LH:
+		v.t1Latch = v.t1Latch&0x00FF | uint16(b)<<8
+		v.clearInterrupt(InterruptTimer1)
+	case T2CL:
+		v.t2LatchLo = b
+	case T2CH:
+		v.t2Counter = uint16(b)<<8 | uint16(v.t2LatchLo)
+		v.t2Armed = true
+		v.clearInterrupt(InterruptTimer2)
+	case SR:
+		v.sr = b
+		v.clearInterrupt(InterruptShift)
+	case ACR:
+		v.acr = b
+	case PCR:
+		v.pcr = b
+		v.a.c2Out = v.c2Level(v.pcr, v.a.c2Out)
+		v.b.c2Out = v.c2Level(v.pcr>>sideBControlShift, v.b.c2Out)
+	case IFR:
+		v.ifr &^= b & interruptMask
+		v.updateInterrupt()
+	case IER:
+		if b&interruptSetClear != 0 {
+			v.ier |= b & interruptMask
+		} else {
+			v.ier &^= b & interruptMask
+		}
+		v.updateInterrupt()
+	}
+	v.pins()
+}
+
+// Tick advances the timers by one φ2 clock cycle and ends any C2 output pulse.
+func (v *VIA) Tick() {
+	if v.t1Counter == 0 {
+		if v.t1Armed {
+			v.raise(InterruptTimer1)
+			v.pb7 = !v.pb7
+			v.t1Armed = v.acr&auxT1FreeRun != 0
+		}
+		if v.acr&auxT1FreeRun != 0 {
+			v.t1Counter = v.t1Latch
+		} else {
+			v.t1Counter = 0xFFFF
+		}
+	} else {
+		v.t1Counter--
+	}
+
+	if v.acr&auxT2CountPulses == 0 {
+		v.countTimer2()
+	}
+
+	for _, s := range []*side{&v.a, &v.b} {
+		if s.pulse {
+			s.pulse, s.c2Out = false, true
+		}
+	}
+}
+
+// SetCA1 drives the CA1 input. An active transition, as selected in the PCR, sets the CA1 interrupt
+// flag and, with latching enabled, latches port A.
+func (v *VIA) SetCA1(high bool) {
+	if v.controlEdge(&v.a.c1, high, v.pcr&controlC1Rising != 0) {
+		if v.acr&auxLatchA != 0 {
+			v.a.latch, _ = v.pins()
+		}
+		v.raise(InterruptCA1)
+		if v.pcr&controlC2Mode == c2Handshake {
+			v.a.c2Out = true
+		}
+	}
+}
+
+// SetCB1 drives the CB1 input. An active transition sets the CB1 interrupt flag and, with latching
+// enabled, latches port B.
+func (v *VIA) SetCB1(high bool) {
+	if v.controlEdge(&v.b.c1, high, (v.pcr>>sideBControlShift)&controlC1Rising != 0) {
+		if v.acr&auxLatchB != 0 {
+			_, v.b.latch = v.pins()
+		}
+		v.raise(InterruptCB1)
+		if (v.pcr>>sideBControlShift)&controlC2Mode == c2Handshake {
+			v.b.c2Out = true
+		}
+	}
+}
+
+// SetCA2 drives CA2 when it is an input, setting the CA2 interrupt flag on an active transition.
+func (v *VIA) SetCA2(high bool) {
+	mode := v.pcr & controlC2Mode
+	if v.controlEdge(&v.a.c2, high, mode&c2InputRising != 0) && mode&c2Output == 0 {
+		v.raise(InterruptCA2)
+	}
+}
+
+// SetCB2 drives CB2 when it is an input, setting the CB2 interrupt flag on an active transition.
+func (v *VIA) SetCB2(high bool) {
+	mode := (v.pcr >> sideBControlShift) & controlC2Mode
+	if v.controlEdge(&v.b.c2, high, mode&c2InputRising != 0) && mode&c2Output == 0 {
+		v.raise(InterruptCB2)
+	}
+}
+
+// PB6Pulse counts a falling edge on PB6, which decrements timer 2 in pulse counting mode.
+func (v *VIA) PB6Pulse() {
+	if v.acr&auxT2CountPulses != 0 {
+		v.countTimer2()
+	}
+}
+
+// CA2 returns the level on CA2: the output level in an output mode, else the input level.
+func (v *VIA) CA2() bool {
+	if v.pcr&c2Output != 0 {
+		return v.a.c2Out
+	}
+	return v.a.c2
+}
+
+// CB2 returns the level on CB2: the output level in an output mode, else the input level.
+func (v *VIA) CB2() bool {
+	if (v.pcr>>sideBControlShift)&c2Output != 0 {
+		return v.b.c2Out
+	}
+	return v.b.c2
+}
+
+// PortA returns the current levels on the port A pins.
+func (v *VIA) PortA() byte {
+	pa, _ := v.pins()
+	return pa
+}
+
+// PortB returns the current levels on the port B pins.
+func (v *VIA) PortB() byte {
+	_, pb := v.pins()
+	return pb
+}
+
+// controlEdge updates a control line input and returns whether it made the active transition.
+func (v *VIA) controlEdge(line *bool, high, rising bool) bool {
+	active := *line != high && high == rising
+	*line = high
+	return active
+}
+
+// portAccess clears a side's control line interrupts and starts a handshake or pulse on C2, as reading
+// or writing its port register does. control is the side's half of the PCR.
+func (v *VIA) portAccess(s *side, control byte, c1Flag, c2Flag byte) {
+	flags := c1Flag
+	if mode := control & controlC2Mode; mode != c2IndependentFalling && mode != c2IndependentRising {
+		flags |= c2Flag
+	}
+	v.clearInterrupt(flags)
+	switch control & controlC2Mode {
+	case c2Handshake:
+		s.c2Out = false
+	case c2Pulse:
+		s.c2Out, s.pulse = false, true
+	}
+}
+
+// c2Level returns the C2 output level after a PCR write: fixed in the manual modes, unchanged otherwise
+func (v *VIA) c2Level(control byte, level bool) bool {
+	switch control & controlC2Mode {
+	case c2Low:
+		return false
+	case c2High:
+		return true
+	}
+	return level
+}
+
+func (v *VIA) countTimer2() {
+	v.t2Counter--
+	if v.t2Counter == 0xFFFF && v.t2Armed {
+		v.raise(InterruptTimer2)
+		v.t2Armed = false
+	}
+}
+
+func (v *VIA) raise(source byte) {
+	v.ifr |= source
+	v.updateInterrupt()
+}
+
+func (v *VIA) clearInterrupt(sources byte) {
+	v.ifr &^= sources
+	v.updateInterrupt()
+}
+
+func (v *VIA) updateInterrupt() {
+	v.interrupt.Set(v, v.ifr&v.ier&interruptMask != 0)
+}
+
+// pins returns the levels on port A and B: outputs as driven by the VIA, inputs pulled up, then
+// pulled low by any attached devices. With timer 1 driving PB7, PB7 is the timer output.
+func (v *VIA) pins() (byte, byte) {
+	pa := v.a.output | ^v.a.ddr
+	pb := v.b.output | ^v.b.ddr
+	if v.acr&auxT1PB7 != 0 {
+		if v.pb7 {
+			pb |= 0x80
+		} else {
+			pb &^= 0x80
+		}
+	}
+	for _, p := range v.ports {
+		a, b := p.Ports(pa, pb)
+		pa &= a
+		pb &= b
+	}
+	return pa, pb
+}
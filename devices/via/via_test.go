@@ -0,0 +1,115 @@
+package via
+
+import (
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVIA() (*VIA, *devices.Bus) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	v := New(bus.IRQ)
+	bus.Attach(0x1800, 0x180F, v)
+	return v, bus
+}
+
+func tick(v *VIA, n int) {
+	for i := 0; i < n; i++ {
+		v.Tick()
+	}
+}
+
+// pulldown pulls the port lines in its masks low.
+type pulldown struct{ a, b byte }
+
+func (p *pulldown) Ports(pa, pb byte) (byte, byte) { return pa &^ p.a, pb &^ p.b }
+
+func TestTimer1OneShot(t *testing.T) {
+	v, bus := newTestVIA()
+	v.Write(IER, interruptSetClear|InterruptTimer1)
+	v.Write(T1CL, 0x03, 0x00)
+
+	tick(v, 3)
+	assert.Zero(t, v.Read(IFR)&InterruptTimer1)
+	tick(v, 1)
+	assert.Equal(t, InterruptTimer1|interruptAny, v.Read(IFR))
+	assert.True(t, bus.IRQ.Active())
+
+	v.Read(T1CL)
+	assert.Zero(t, v.Read(IFR), "reading T1CL acknowledges the interrupt")
+	assert.False(t, bus.IRQ.Active())
+	tick(v, 0x10000)
+	assert.Zero(t, v.Read(IFR), "a one-shot timer interrupts once")
+}
+
+func TestTimer1FreeRun(t *testing.T) {
+	v, _ := newTestVIA()
+	v.Write(ACR, auxT1FreeRun|auxT1PB7)
+	v.Write(DDRB, 0x80)
+	v.Write(T1CL, 0x02, 0x00)
+	assert.Zero(t, v.PortB()&0x80, "PB7 goes low when the timer starts")
+
+	tick(v, 3)
+	assert.NotZero(t, v.Read(IFR)&InterruptTimer1)
+	assert.NotZero(t, v.PortB()&0x80, "PB7 toggles on each time out")
+	v.Write(IFR, InterruptTimer1)
+	tick(v, 3)
+	assert.NotZero(t, v.Read(IFR)&InterruptTimer1, "a free running timer reloads from its latch")
+}
+
+func TestTimer2(t *testing.T) {
+	v, _ := newTestVIA()
+	v.Write(T2CL, 0x01, 0x00)
+	tick(v, 2)
+	assert.NotZero(t, v.Read(IFR)&InterruptTimer2)
+	v.Read(T2CL)
+	assert.Zero(t, v.Read(IFR))
+
+	v.Write(ACR, auxT2CountPulses)
+	v.Write(T2CL, 0x00, 0x00)
+	tick(v, 10)
+	assert.Zero(t, v.Read(IFR), "in pulse counting mode the clock does not count")
+	v.PB6Pulse()
+	assert.NotZero(t, v.Read(IFR)&InterruptTimer2)
+}
+
+func TestPortsAndLatching(t *testing.T) {
+	v, _ := newTestVIA()
+	p := &pulldown{}
+	v.Attach(p)
+	v.Write(DDRB, 0x0F)
+	v.Write(ORB, 0x05)
+	p.b = 0x30
+	assert.Equal(t, byte(0xC5), v.Read(ORB), "output bits read the output register, inputs the pins")
+
+	v.Write(ACR, auxLatchA)
+	p.a = 0x0F
+	v.SetCA1(false) // Active falling edge
+	p.a = 0xF0
+	assert.Equal(t, byte(0xF0), v.Read(ORA), "port A reads the value latched by CA1")
+	assert.Zero(t, v.Read(IFR)&InterruptCA1, "reading port A clears the CA1 interrupt")
+}
+
+func TestControlLines(t *testing.T) {
+	v, _ := newTestVIA()
+	v.Write(PCR, c2Low|c2High<<sideBControlShift)
+	assert.False(t, v.CA2())
+	assert.True(t, v.CB2())
+
+	v.Write(PCR, c2Pulse)
+	v.Write(ORA, 0x00)
+	assert.False(t, v.CA2(), "pulse output goes low on a port write")
+	tick(v, 1)
+	assert.True(t, v.CA2())
+
+	v.Write(PCR, controlC1Rising<<sideBControlShift)
+	v.SetCB1(false)
+	assert.Zero(t, v.Read(IFR)&InterruptCB1)
+	v.SetCB1(true)
+	assert.NotZero(t, v.Read(IFR)&InterruptCB1)
+
+	v.SetCA2(false)
+	assert.NotZero(t, v.Read(IFR)&InterruptCA2, "CA2 input is active on a falling edge")
+}
@@ -0,0 +1,238 @@
+package vic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Frame geometry: the 320x200 display window surrounded by the visible border of a PAL screen.
+const (
+	ScreenWidth  = 320
+	ScreenHeight = 200
+	BorderLeft   = 32
+	BorderRight  = 32
+	BorderTop    = 35
+	BorderBottom = 37
+	FrameWidth   = BorderLeft + ScreenWidth + BorderRight
+	FrameHeight  = BorderTop + ScreenHeight + BorderBottom
+
+	// Sprite coordinates of the top left pixel of the display window.
+	spriteOriginX = 24
+	spriteOriginY = 50
+
+	spriteWidth     = 24
+	spriteHeight    = 21
+	spriteCount     = 8
+	spritePointers  = 0x03F8 // Offset of the sprite pointers from the video matrix
+	spriteBlockSize = 64
+)
+
+// Palette holds the 16 VIC-II colours.
+var Palette = [16]color.RGBA{
+	{0x00, 0x00, 0x00, 0xFF}, // Black
+	{0xFF, 0xFF, 0xFF, 0xFF}, // White
+	{0x68, 0x37, 0x2B, 0xFF}, // Red
+	{0x70, 0xA4, 0xB2, 0xFF}, // Cyan
+	{0x6F, 0x3D, 0x86, 0xFF}, // Purple
+	{0x58, 0x8D, 0x43, 0xFF}, // Green
+	{0x35, 0x28, 0x79, 0xFF}, // Blue
+	{0xB8, 0xC7, 0x6F, 0xFF}, // Yellow
+	{0x6F, 0x4F, 0x25, 0xFF}, // Orange
+	{0x43, 0x39, 0x00, 0xFF}, // Brown
+	{0x9A, 0x67, 0x59, 0xFF}, // Light red
+	{0x44, 0x44, 0x44, 0xFF}, // Dark grey
+	{0x6C, 0x6C, 0x6C, 0xFF}, // Grey
+	{0x9A, 0xD2, 0x84, 0xFF}, // Light green
+	{0x6C, 0x5E, 0xB5, 0xFF}, // Light blue
+	{0x95, 0x95, 0x95, 0xFF}, // Light grey
+}
+
+// pixel is a graphics pixel: its colour and whether it is foreground for sprite priority.
+type pixel struct {
+	color      byte
+	foreground bool
+}
+
+// Frame renders the screen from the current register state and memory.
+func (v *VIC) Frame() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, FrameWidth, FrameHeight))
+	border := v.regs[BorderColor] & 0x0F
+
+	left, right, top, bottom := v.displayWindow()
+	den := v.regs[ControlY]&controlDEN != 0
+	for y := 0; y < FrameHeight; y++ {
+		for x := 0; x < FrameWidth; x++ {
+			c := border
+			if den && x >= left && x < right && y >= top && y < bottom {
+				p := v.graphicsPixel(x-BorderLeft, y-BorderTop)
+				c = p.color
+				if s, ok := v.spritePixel(x-BorderLeft, y-BorderTop, p.foreground); ok {
+					c = s
+				}
+			}
+			img.SetRGBA(x, y, Palette[c])
+		}
+	}
+	return img
+}
+
+// displayWindow returns the frame coordinates of the area not covered by the border. With RSEL clear
+// the border grows to 24 rows, with CSEL clear to 38 columns.
+func (v *VIC) displayWindow() (left, right, top, bottom int) {
+	left, right = BorderLeft, BorderLeft+ScreenWidth
+	top, bottom = BorderTop, BorderTop+ScreenHeight
+	if v.regs[ControlX]&controlCSEL == 0 {
+		left += 7
+		right -= 9
+	}
+	if v.regs[ControlY]&controlRSEL == 0 {
+		top += 4
+		bottom -= 4
+	}
+	return left, right, top, bottom
+}
+
+// graphicsPixel returns the text or bitmap pixel at display window coordinates x, y, applying the scroll registers.
+func (v *VIC) graphicsPixel(x, y int) pixel {
+	x -= int(v.regs[ControlX] & controlScroll)
+	y -= int(v.regs[ControlY]&controlScroll) - 3
+	background := pixel{color: v.regs[BackgroundColor0] & 0x0F}
+	if x < 0 || x >= ScreenWidth || y < 0 || y >= ScreenHeight {
+		return background
+	}
+
+	column, row, line := x/8, y/8, uint16(y%8)
+	cell := uint16(row*40 + column)
+	screen := v.fetch(v.videoMatrix() + cell)
+	colorNibble := v.color(cell)
+	ecm := v.regs[ControlY]&controlECM != 0
+	bmm := v.regs[ControlY]&controlBMM != 0
+	mcm := v.regs[ControlX]&controlMCM != 0
+
+	switch {
+	case !ecm && !bmm && !mcm:
+		data := v.fetch(v.charBase() + uint16(screen)*8 + line)
+		return hiresPixel(data, x, colorNibble, background.color)
+	case !ecm && !bmm && mcm:
+		data := v.fetch(v.charBase() + uint16(screen)*8 + line)
+		if colorNibble&0x08 == 0 {
+			return hiresPixel(data, x, colorNibble&0x07, background.color)
+		}
+		return multicolorPixel(data, x, [4]byte{
+			background.color,
+			v.regs[BackgroundColor1] & 0x0F,
+			v.regs[BackgroundColor2] & 0x0F,
+			colorNibble & 0x07,
+		})
+	case ecm && !bmm && !mcm:
+		data := v.fetch(v.charBase() + uint16(screen&0x3F)*8 + line)
+		return hiresPixel(data, x, colorNibble, v.regs[BackgroundColor0+uint16(screen>>6)]&0x0F)
+	case !ecm && bmm && !mcm:
+		data := v.fetch(v.bitmapBase() + cell*8 + line)
+		return hiresPixel(data, x, screen>>4, screen&0x0F)
+	case !ecm && bmm && mcm:
+		data := v.fetch(v.bitmapBase() + cell*8 + line)
+		return multicolorPixel(data, x, [4]byte{background.color, screen >> 4, screen & 0x0F, colorNibble})
+	}
+	// The invalid ECM combinations display black
+	return pixel{color: 0}
+}
+
+func hiresPixel(data byte, x int, foreground, background byte) pixel {
+	if data&(0x80>>(x%8)) != 0 {
+		return pixel{color: foreground, foreground: true}
+	}
+	return pixel{color: background}
+}
+
+// multicolorPixel decodes a double width pixel; bit pairs %10 and %11 are foreground.
+func multicolorPixel(data byte, x int, colors [4]byte) pixel {
+	bits := (data >> (6 - (x%8)&6)) & 0x03
+	return pixel{color: colors[bits], foreground: bits&0x02 != 0}
+}
+
+// spritePixel returns the colour of the highest priority sprite pixel at display window coordinates x, y.
+// Sprite 0 has the highest priority. A sprite with its priority bit set is hidden behind foreground graphics.
+func (v *VIC) spritePixel(x, y int, foreground bool) (byte, bool) {
+	enabled := v.regs[SpriteEnable]
+	if enabled == 0 {
+		return 0, false
+	}
+	for n := 0; n < spriteCount; n++ {
+		bit := byte(1) << n
+		if enabled&bit == 0 {
+			continue
+		}
+		sx := int(v.regs[SpriteX0+2*n]) | int(v.regs[SpriteXMSB]&bit)<<(8-n)
+		sy := int(v.regs[SpriteY0+2*n])
+		dx := x + spriteOriginX - sx
+		dy := y + spriteOriginY - sy
+		if dy < 0 {
+			dy += 256 // Sprites wrap vertically
+		}
+		if v.regs[SpriteExpandX]&bit != 0 {
+			dx /= 2
+			if x+spriteOriginX < sx {
+				dx = -1
+			}
+		}
+		if v.regs[SpriteExpandY]&bit != 0 {
+			dy /= 2
+		}
+		if dx < 0 || dx >= spriteWidth || dy < 0 || dy >= spriteHeight {
+			continue
+		}
+
+		pointer := v.fetch(v.videoMatrix() + spritePointers + uint16(n))
+		data := v.fetch(uint16(pointer)*spriteBlockSize + uint16(dy*3+dx/8))
+
+		var c byte
+		if v.regs[SpriteMulticolor]&bit != 0 {
+			switch (data >> (6 - (dx%8)&6)) & 0x03 {
+			case 0:
+				continue
+			case 1:
+				c = v.regs[SpriteMulticolor0]
+			case 2:
+				c = v.regs[SpriteColor0+n]
+			case 3:
+				c = v.regs[SpriteMulticolor1]
+			}
+		} else {
+			if data&(0x80>>(dx%8)) == 0 {
+				continue
+			}
+			c = v.regs[SpriteColor0+n]
+		}
+		if foreground && v.regs[SpritePriority]&bit != 0 {
+			return 0, false
+		}
+		return c & 0x0F, true
+	}
+	return 0, false
+}
+
+// WritePNG renders a frame and encodes it as a PNG.
+func (v *VIC) WritePNG(w io.Writer) error {
+	if err := png.Encode(w, v.Frame()); err != nil {
+		return fmt.Errorf("[vic WritePNG] %w", err)
+	}
+	return nil
+}
+
+// SavePNG renders a frame to a PNG file.
+func (v *VIC) SavePNG(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("[vic SavePNG] %w", err)
+	}
+	if err := v.WritePNG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
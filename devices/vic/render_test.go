@@ -0,0 +1,267 @@
+package vic
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	screenAddress = 0x0400
+	spriteBlock   = 0x0D // Sprite data at $0340
+)
+
+// testCharROM has a solid block at character 1 and a left half block at character 2.
+func testCharROM() []byte {
+	rom := make([]byte, CharROMSize)
+	for i := 0; i < 8; i++ {
+		rom[1*8+i] = 0xFF
+		rom[2*8+i] = 0xF0
+		rom[3*8+i] = 0x1B // Multicolour pairs %00 %01 %10 %11
+	}
+	return rom
+}
+
+func newTestVIC() (*VIC, *memory.Memory[uint16], *memory.Memory[uint16]) {
+	ram := memory.NewMemory[uint16](64 * 1024)
+	colorRAM := memory.NewMemory[uint16](ColorRAMSize)
+	v := New(nil, nil, ram, colorRAM, testCharROM())
+	v.Write(ControlY, controlDEN|controlRSEL|3)
+	v.Write(ControlX, controlCSEL)
+	v.Write(MemoryPointers, 0x14) // Screen at $0400, characters at $1000 (character ROM)
+	v.Write(BorderColor, 14)
+	v.Write(BackgroundColor0, 6)
+	return v, ram, colorRAM
+}
+
+// at returns the palette index of the frame pixel at display window coordinates x, y.
+func at(t *testing.T, v *VIC, x, y int) int {
+	c := v.Frame().RGBAAt(BorderLeft+x, BorderTop+y)
+	for i, p := range Palette {
+		if p == c {
+			return i
+		}
+	}
+	t.Fatalf("colour %v is not in the palette", c)
+	return -1
+}
+
+func TestBorderAndBackground(t *testing.T) {
+	v, _, _ := newTestVIC()
+	img := v.Frame()
+	assert.Equal(t, FrameWidth, img.Bounds().Dx())
+	assert.Equal(t, FrameHeight, img.Bounds().Dy())
+	assert.Equal(t, Palette[14], img.RGBAAt(0, 0))
+	assert.Equal(t, 6, at(t, v, 0, 0))
+
+	v.Write(ControlY, controlRSEL|3)
+	assert.Equal(t, 14, at(t, v, 0, 0), "blanked screen shows the border colour")
+}
+
+func TestRowAndColumnSelect(t *testing.T) {
+	v, _, _ := newTestVIC()
+	v.Write(ControlY, controlDEN|3)
+	v.Write(ControlX, 0)
+	assert.Equal(t, 14, at(t, v, 0, 3), "24 row mode covers the top 4 lines")
+	assert.Equal(t, 6, at(t, v, 7, 4))
+	assert.Equal(t, 14, at(t, v, 6, 4), "38 column mode covers 7 pixels on the left")
+	assert.Equal(t, 14, at(t, v, 311, 4))
+}
+
+func TestStandardText(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	ram.Write(screenAddress, 2)
+	colorRAM.Write(0, 1)
+
+	assert.Equal(t, 1, at(t, v, 0, 0))
+	assert.Equal(t, 1, at(t, v, 3, 7))
+	assert.Equal(t, 6, at(t, v, 4, 0))
+}
+
+func TestCharacterDataFromRAM(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	v.Write(MemoryPointers, 0x18) // Characters at $2000, which is RAM
+	ram.Write(screenAddress, 1)
+	ram.Write(0x2008, 0x80)
+	colorRAM.Write(0, 5)
+
+	assert.Equal(t, 5, at(t, v, 0, 0))
+	assert.Equal(t, 6, at(t, v, 1, 0))
+}
+
+func TestCharacterROMOnlyInBanks0And2(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	v.SetBank(1)
+	ram.Write(0x4000+screenAddress, 1)
+	colorRAM.Write(0, 1)
+	assert.Equal(t, 6, at(t, v, 0, 0), "bank 1 reads character data from RAM")
+
+	v.SetBank(2)
+	ram.Write(0x8000+screenAddress, 1)
+	assert.Equal(t, 1, at(t, v, 0, 0))
+}
+
+func TestMulticolorText(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	v.Write(ControlX, controlCSEL|controlMCM)
+	v.Write(BackgroundColor1, 2)
+	v.Write(BackgroundColor2, 7)
+	ram.Write(screenAddress, 3, 2)
+	colorRAM.Write(0, 0x08|5, 5)
+
+	assert.Equal(t, 6, at(t, v, 0, 0))
+	assert.Equal(t, 2, at(t, v, 2, 0))
+	assert.Equal(t, 7, at(t, v, 4, 0))
+	assert.Equal(t, 5, at(t, v, 7, 0))
+	assert.Equal(t, 5, at(t, v, 8, 0), "colour below 8 displays hires")
+	assert.Equal(t, 6, at(t, v, 12, 0))
+}
+
+func TestExtendedBackgroundColor(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	v.Write(ControlY, controlDEN|controlRSEL|controlECM|3)
+	v.Write(BackgroundColor2, 13)
+	ram.Write(screenAddress, 0x82) // Character 2 on background 2
+	colorRAM.Write(0, 1)
+
+	assert.Equal(t, 1, at(t, v, 0, 0))
+	assert.Equal(t, 13, at(t, v, 4, 0))
+}
+
+func TestHiresBitmap(t *testing.T) {
+	v, ram, _ := newTestVIC()
+	v.Write(ControlY, controlDEN|controlRSEL|controlBMM|3)
+	v.Write(MemoryPointers, 0x18) // Bitmap at $2000
+	ram.Write(screenAddress+1, 0x1B)
+	ram.Write(0x2000+8+2, 0x80)
+
+	assert.Equal(t, 1, at(t, v, 8, 2))
+	assert.Equal(t, 11, at(t, v, 9, 2))
+}
+
+func TestMulticolorBitmap(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	v.Write(ControlY, controlDEN|controlRSEL|controlBMM|3)
+	v.Write(ControlX, controlCSEL|controlMCM)
+	v.Write(MemoryPointers, 0x18)
+	ram.Write(screenAddress, 0x25)
+	colorRAM.Write(0, 7)
+	ram.Write(0x2000, 0x1B)
+
+	assert.Equal(t, 6, at(t, v, 0, 0))
+	assert.Equal(t, 2, at(t, v, 2, 0))
+	assert.Equal(t, 5, at(t, v, 4, 0))
+	assert.Equal(t, 7, at(t, v, 6, 0))
+}
+
+func TestInvalidModeIsBlack(t *testing.T) {
+	v, _, _ := newTestVIC()
+	v.Write(ControlY, controlDEN|controlRSEL|controlECM|controlBMM|3)
+	assert.Equal(t, 0, at(t, v, 0, 0))
+}
+
+func TestScroll(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	ram.Write(screenAddress, 1)
+	colorRAM.Write(0, 1)
+	v.Write(ControlX, controlCSEL|2)
+	assert.Equal(t, 6, at(t, v, 1, 0))
+	assert.Equal(t, 1, at(t, v, 2, 0))
+
+	v.Write(ControlY, controlDEN|controlRSEL|5)
+	assert.Equal(t, 6, at(t, v, 2, 1))
+	assert.Equal(t, 1, at(t, v, 2, 2))
+}
+
+func setupSprite(v *VIC, ram *memory.Memory[uint16], n int, x, y byte) {
+	ram.Write(screenAddress+spritePointers+uint16(n), spriteBlock)
+	for row := 0; row < spriteHeight; row++ {
+		ram.Write(spriteBlock*spriteBlockSize+uint16(row*3), 0xC0, 0x00, 0x01)
+	}
+	v.Write(SpriteEnable, v.Read(SpriteEnable)|1<<n)
+	v.Write(SpriteX0+uint16(2*n), x)
+	v.Write(SpriteY0+uint16(2*n), y)
+	v.Write(SpriteColor0+uint16(n), byte(n+1))
+}
+
+func TestSprites(t *testing.T) {
+	v, ram, _ := newTestVIC()
+	setupSprite(v, ram, 0, spriteOriginX+10, spriteOriginY+20)
+
+	assert.Equal(t, 1, at(t, v, 10, 20))
+	assert.Equal(t, 1, at(t, v, 11, 40))
+	assert.Equal(t, 6, at(t, v, 12, 20))
+	assert.Equal(t, 1, at(t, v, 33, 20))
+	assert.Equal(t, 6, at(t, v, 10, 41))
+
+	v.Write(SpriteExpandX, 0x01)
+	v.Write(SpriteExpandY, 0x01)
+	assert.Equal(t, 1, at(t, v, 13, 61))
+	assert.Equal(t, 6, at(t, v, 14, 20))
+	assert.Equal(t, 1, at(t, v, 57, 20))
+}
+
+func TestSpriteXMSB(t *testing.T) {
+	v, ram, _ := newTestVIC()
+	setupSprite(v, ram, 3, 0x10, spriteOriginY)
+	v.Write(SpriteXMSB, 0x08)
+	assert.Equal(t, 4, at(t, v, 256+0x10-spriteOriginX, 0))
+}
+
+func TestSpritePriority(t *testing.T) {
+	v, ram, colorRAM := newTestVIC()
+	setupSprite(v, ram, 1, spriteOriginX, spriteOriginY)
+	setupSprite(v, ram, 0, spriteOriginX+1, spriteOriginY)
+	assert.Equal(t, 2, at(t, v, 0, 0))
+	assert.Equal(t, 1, at(t, v, 1, 0), "sprite 0 is in front of sprite 1")
+
+	ram.Write(screenAddress, 1)
+	colorRAM.Write(0, 5)
+	v.Write(SpritePriority, 0x02)
+	assert.Equal(t, 5, at(t, v, 0, 0), "sprite 1 is behind the character")
+	assert.Equal(t, 1, at(t, v, 1, 0))
+}
+
+func TestMulticolorSprite(t *testing.T) {
+	v, ram, _ := newTestVIC()
+	setupSprite(v, ram, 0, spriteOriginX, spriteOriginY)
+	ram.Write(spriteBlock*spriteBlockSize, 0x1B)
+	v.Write(SpriteMulticolor, 0x01)
+	v.Write(SpriteMulticolor0, 9)
+	v.Write(SpriteMulticolor1, 10)
+
+	assert.Equal(t, 6, at(t, v, 0, 0))
+	assert.Equal(t, 9, at(t, v, 2, 0))
+	assert.Equal(t, 1, at(t, v, 4, 0))
+	assert.Equal(t, 10, at(t, v, 6, 0))
+}
+
+func TestSpritesHiddenByBorder(t *testing.T) {
+	v, ram, _ := newTestVIC()
+	setupSprite(v, ram, 0, 0, spriteOriginY)
+	img := v.Frame()
+	assert.Equal(t, Palette[14], img.RGBAAt(BorderLeft-1, BorderTop))
+}
+
+func TestWritePNG(t *testing.T) {
+	v, _, _ := newTestVIC()
+	buf := &bytes.Buffer{}
+	require.NoError(t, v.WritePNG(buf))
+	img, err := png.Decode(buf)
+	require.NoError(t, err)
+	r, g, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, Palette[14].R, byte(r>>8))
+	assert.Equal(t, Palette[14].G, byte(g>>8))
+	assert.Equal(t, Palette[14].B, byte(b>>8))
+}
+
+func TestRegisters(t *testing.T) {
+	v, _, _ := newTestVIC()
+	assert.Equal(t, byte(0xFE), v.Read(BorderColor))
+	assert.Equal(t, byte(0xFE), v.Read(BorderColor+mirrorSize), "registers are mirrored every 64 bytes")
+	assert.Equal(t, byte(0xFF), v.Read(0x30))
+}
@@ -0,0 +1,172 @@
+package vic
+
+import "fmt"
+
+// Model describes the raster timing of a VIC-II variant.
+type Model struct {
+	Name          string
+	CyclesPerLine int
+	Lines         int
+	ClockHz       uint64
+}
+
+// The VIC-II variants: the PAL 6569, the NTSC 6567R8 and the early NTSC 6567R56A.
+var (
+	PAL     = Model{Name: "PAL", CyclesPerLine: 63, Lines: 312, ClockHz: 985248}
+	NTSC    = Model{Name: "NTSC", CyclesPerLine: 65, Lines: 263, ClockHz: 1022727}
+	NTSCOld = Model{Name: "NTSC-old", CyclesPerLine: 64, Lines: 262, ClockHz: 1022727}
+)
+
+// Interrupt sources in InterruptStatus and InterruptEnable.
+const (
+	InterruptRaster         byte = 0x01
+	InterruptSpriteData     byte = 0x02
+	InterruptSpriteSprite   byte = 0x04
+	InterruptLightPen       byte = 0x08
+	InterruptAny            byte = 0x80
+	interruptSources        byte = 0x0F
+	interruptStatusReadMask byte = 0x70
+)
+
+// Badline timing. Lines $30-$F7 can be badlines; on a badline BA goes low 3 cycles before the 40
+// c-accesses of cycles 15-54, and the CPU is stalled for the whole window.
+const (
+	firstBadline   = 0x30
+	lastBadline    = 0xF7
+	badlineBAStart = 12
+	badlineBAEnd   = 54
+
+	baLeadCycles = 3 // BA goes low this many cycles before a DMA access
+)
+
+// SetModel selects the raster timing and restarts the frame.
+func (v *VIC) SetModel(m Model) {
+	v.model = m
+	v.line, v.cycle = 0, 1
+}
+
+// Model returns the raster timing in use.
+func (v *VIC) Model() Model {
+	return v.model
+}
+
+// RasterLine returns the line the raster beam is on.
+func (v *VIC) RasterLine() int {
+	return v.line
+}
+
+// RasterCycle returns the cycle within the current line, counting from 1.
+func (v *VIC) RasterCycle() int {
+	return v.cycle
+}
+
+// Frames returns the number of frames completed since reset.
+func (v *VIC) Frames() uint64 {
+	return v.frames
+}
+
+// Inspect describes the raster position for the debugger.
+func (v *VIC) Inspect() string {
+	badline := ""
+	if v.badline() {
+		badline = " badline"
+	}
+	return fmt.Sprintf("VIC-II %s: line $%03X cycle %d%s", v.model.Name, v.line, v.cycle, badline)
+}
+
+// Tick advances the raster beam by one cycle, raising the raster interrupt at the start of the
+// compare line and holding RDY while the VIC-II needs the bus.
+func (v *VIC) Tick() {
+	v.cycle++
+	if v.cycle > v.model.CyclesPerLine {
+		v.cycle = 1
+		v.line++
+		if v.line == v.model.Lines {
+			v.line = 0
+			v.frames++
+			v.denSeen = false
+			if v.OnFrame != nil {
+				v.OnFrame()
+			}
+		}
+		if v.line == v.rasterCompare() {
+			v.raiseInterrupt(InterruptRaster)
+		}
+	}
+	if v.line == firstBadline && v.regs[ControlY]&controlDEN != 0 {
+		v.denSeen = true
+	}
+	v.rdy.Set(v, v.busRequested())
+}
+
+// rasterCompare returns the 9 bit line written to Raster and ControlY bit 7.
+func (v *VIC) rasterCompare() int {
+	return int(v.regs[Raster]) | int(v.regs[ControlY]&controlRaster8)<<1
+}
+
+// raiseInterrupt latches an interrupt source and updates the IRQ line.
+func (v *VIC) raiseInterrupt(source byte) {
+	v.irqStatus |= source
+	v.updateInterrupt()
+}
+
+// acknowledgeInterrupts clears the latched sources written as 1 to InterruptStatus.
+func (v *VIC) acknowledgeInterrupts(data byte) {
+	v.irqStatus &^= data & interruptSources
+	v.updateInterrupt()
+}
+
+func (v *VIC) updateInterrupt() {
+	v.irq.Set(v, v.irqStatus&v.regs[InterruptEnable]&interruptSources != 0)
+}
+
+// interruptStatus returns InterruptStatus as read by the CPU, with bit 7 set while an enabled source is latched.
+func (v *VIC) interruptStatus() byte {
+	status := v.irqStatus | interruptStatusReadMask
+	if v.irqStatus&v.regs[InterruptEnable]&interruptSources != 0 {
+		status |= InterruptAny
+	}
+	return status
+}
+
+// badline returns whether the current line fetches a new row of character pointers: the low 3 bits of
+// the raster match YSCROLL within the display lines, provided DEN was set on line $30.
+func (v *VIC) badline() bool {
+	return v.denSeen && v.line >= firstBadline && v.line <= lastBadline &&
+		byte(v.line)&controlScroll == v.regs[ControlY]&controlScroll
+}
+
+// busRequested returns whether BA is low on the current cycle, for a badline or for sprite DMA.
+func (v *VIC) busRequested() bool {
+	if v.badline() && v.cycle >= badlineBAStart && v.cycle <= badlineBAEnd {
+		return true
+	}
+	for n := 0; n < spriteCount; n++ {
+		if !v.spriteDMA(n) {
+			continue
+		}
+		// The two s-accesses of sprite n follow its pointer fetch near the end of the line; the
+		// fetches of the later sprites are taken from the start of the line rather than the next one.
+		first := v.model.CyclesPerLine - 5 + 2*n - baLeadCycles
+		for c := first; c <= first+baLeadCycles+1; c++ {
+			if (c-1)%v.model.CyclesPerLine+1 == v.cycle {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spriteDMA returns whether sprite n is enabled and has data to fetch on the current line.
+func (v *VIC) spriteDMA(n int) bool {
+	bit := byte(1) << n
+	if v.regs[SpriteEnable]&bit == 0 {
+		return false
+	}
+	height := spriteHeight
+	if v.regs[SpriteExpandY]&bit != 0 {
+		height *= 2
+	}
+	dy := v.line - int(v.regs[SpriteY0+2*n])
+	return dy >= 0 && dy < height
+}
@@ -0,0 +1,164 @@
+package vic
+
+import (
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTimedVIC() (*VIC, *devices.Bus) {
+	bus := devices.NewBus(memory.NewMemory[uint16](64*1024), false)
+	v := New(bus.IRQ, bus.RDY, bus.Memory, memory.NewMemory[uint16](ColorRAMSize), testCharROM())
+	bus.Attach(0xD000, 0xD3FF, v)
+	return v, bus
+}
+
+// tickTo advances the VIC-II until the raster beam reaches line and cycle.
+func tickTo(v *VIC, line, cycle int) {
+	for v.RasterLine() != line || v.RasterCycle() != cycle {
+		v.Tick()
+	}
+}
+
+func TestRasterCounter(t *testing.T) {
+	for _, m := range []Model{PAL, NTSC, NTSCOld} {
+		v, _ := newTimedVIC()
+		v.SetModel(m)
+		for i := 0; i < m.CyclesPerLine*m.Lines; i++ {
+			v.Tick()
+		}
+		assert.Equal(t, uint64(1), v.Frames(), m.Name)
+		assert.Equal(t, 0, v.RasterLine(), m.Name)
+		assert.Equal(t, 1, v.RasterCycle(), m.Name)
+	}
+}
+
+func TestRasterRegisters(t *testing.T) {
+	v, _ := newTimedVIC()
+	v.Write(ControlY, controlDEN|controlRSEL|3)
+	tickTo(v, 0x123, 1)
+	assert.Equal(t, byte(0x23), v.Read(Raster))
+	assert.Equal(t, controlRaster8|controlDEN|controlRSEL|3, v.Read(ControlY))
+	assert.Equal(t, "VIC-II PAL: line $123 cycle 1", v.Inspect())
+
+	tickTo(v, 0x40, 1)
+	assert.Equal(t, byte(0x40), v.Read(Raster))
+	assert.Equal(t, controlDEN|controlRSEL|3, v.Read(ControlY))
+}
+
+func TestRasterInterrupt(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(Raster, 0x80)
+	v.Write(InterruptEnable, InterruptRaster)
+
+	tickTo(v, 0x7F, PAL.CyclesPerLine)
+	assert.False(t, bus.IRQ.Active())
+	v.Tick()
+	assert.True(t, bus.IRQ.Active())
+	assert.Equal(t, byte(0xF1), v.Read(InterruptStatus))
+
+	v.Write(InterruptStatus, InterruptRaster)
+	assert.False(t, bus.IRQ.Active(), "writing 1 acknowledges the interrupt")
+	assert.Equal(t, byte(0x70), v.Read(InterruptStatus))
+}
+
+func TestRasterInterruptLine256(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(ControlY, controlRaster8)
+	v.Write(Raster, 0x00)
+	v.Write(InterruptEnable, InterruptRaster)
+	tickTo(v, 0xFF, 1)
+	assert.False(t, bus.IRQ.Active())
+	tickTo(v, 0x100, 1)
+	assert.True(t, bus.IRQ.Active())
+}
+
+func TestDisabledRasterInterruptIsLatched(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(Raster, 0x10)
+	tickTo(v, 0x10, 1)
+	assert.False(t, bus.IRQ.Active())
+	assert.Equal(t, byte(0x71), v.Read(InterruptStatus))
+
+	v.Write(InterruptEnable, InterruptRaster)
+	assert.True(t, bus.IRQ.Active(), "enabling a latched source raises the IRQ")
+}
+
+func TestCompareWriteOnCurrentLine(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(InterruptEnable, InterruptRaster)
+	tickTo(v, 0x20, 30)
+	v.Write(Raster, 0x20)
+	assert.True(t, bus.IRQ.Active())
+}
+
+func TestBadlineStallsCPU(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(ControlY, controlDEN|controlRSEL|3)
+
+	tickTo(v, 0x32, badlineBAStart)
+	assert.False(t, bus.RDY.Active(), "only lines matching YSCROLL are badlines")
+
+	tickTo(v, 0x33, badlineBAStart-1)
+	assert.False(t, bus.RDY.Active())
+	stalled := 0
+	for v.RasterLine() == 0x33 {
+		v.Tick()
+		if bus.RDY.Active() {
+			stalled++
+		}
+	}
+	assert.Equal(t, badlineBAEnd-badlineBAStart+1, stalled)
+}
+
+func TestNoBadlinesWithoutDEN(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(ControlY, controlRSEL|3)
+	tickTo(v, 0x31, 1)
+	v.Write(ControlY, controlDEN|controlRSEL|3)
+	tickTo(v, 0x33, 20)
+	assert.False(t, bus.RDY.Active(), "DEN must be set during line $30")
+}
+
+func TestSpriteDMAStallsCPU(t *testing.T) {
+	v, bus := newTimedVIC()
+	v.Write(SpriteEnable, 0x01)
+	v.Write(SpriteY0, 0x80)
+
+	tickTo(v, 0x7F, 1)
+	for i := 0; i < PAL.CyclesPerLine; i++ {
+		assert.False(t, bus.RDY.Active())
+		v.Tick()
+	}
+	stalled := 0
+	for v.RasterLine() == 0x80 {
+		if bus.RDY.Active() {
+			stalled++
+		}
+		v.Tick()
+	}
+	assert.Equal(t, 5, stalled, "3 cycles of BA lead and 2 s-accesses")
+}
+
+func TestBusHoldsCPUWhileRDY(t *testing.T) {
+	v, bus := newTimedVIC()
+	bus.Memory.Write(0x0200, 0xE8, 0x4C, 0x00, 0x02) // INX; JMP $0200
+	bus.CPU.Reg.PC = 0x0200
+	v.Write(ControlY, controlDEN|controlRSEL|3)
+
+	tickTo(v, 0x33, badlineBAStart)
+	x := bus.CPU.Reg.X
+	for i := 0; i < badlineBAEnd-badlineBAStart; i++ {
+		_, err := bus.Step()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, x, bus.CPU.Reg.X, "the CPU does not run during the badline")
+
+	for i := 0; i < 20; i++ {
+		_, err := bus.Step()
+		assert.NoError(t, err)
+	}
+	assert.NotEqual(t, x, bus.CPU.Reg.X)
+}
@@ -0,0 +1,217 @@
+// Package vic emulates the MOS 6569/6567 VIC-II video chip: its registers, its view of memory and
+// a renderer that draws frames of the text, bitmap and sprite modes as images.
+package vic
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Register offsets, mirrored every 64 bytes.
+const (
+	SpriteX0          = 0x00 // Sprite n X position is at SpriteX0 + 2n, Y position at SpriteY0 + 2n
+	SpriteY0          = 0x01
+	SpriteXMSB        = 0x10 // Bit 8 of each sprite's X position
+	ControlY          = 0x11 // Bit 7: raster bit 8, 6: ECM, 5: BMM, 4: DEN, 3: RSEL, 0-2: YSCROLL
+	Raster            = 0x12
+	LightPenX         = 0x13
+	LightPenY         = 0x14
+	SpriteEnable      = 0x15
+	ControlX          = 0x16 // Bit 4: MCM, 3: CSEL, 0-2: XSCROLL
+	SpriteExpandY     = 0x17
+	MemoryPointers    = 0x18 // Bits 4-7: video matrix base / 1K, bits 1-3: character base / 2K
+	InterruptStatus   = 0x19
+	InterruptEnable   = 0x1A
+	SpritePriority    = 0x1B // A set bit puts the sprite behind foreground graphics
+	SpriteMulticolor  = 0x1C
+	SpriteExpandX     = 0x1D
+	SpriteSprite      = 0x1E // Sprite-sprite collisions
+	SpriteData        = 0x1F // Sprite-data collisions
+	BorderColor       = 0x20
+	BackgroundColor0  = 0x21
+	BackgroundColor1  = 0x22
+	BackgroundColor2  = 0x23
+	BackgroundColor3  = 0x24
+	SpriteMulticolor0 = 0x25
+	SpriteMulticolor1 = 0x26
+	SpriteColor0      = 0x27 // Sprite n colour is at SpriteColor0 + n
+
+	registerCount = 0x2F
+	mirrorSize    = 0x40
+)
+
+// ControlY and ControlX bits.
+const (
+	controlRaster8 byte = 0x80
+	controlECM     byte = 0x40
+	controlBMM     byte = 0x20
+	controlDEN     byte = 0x10
+	controlRSEL    byte = 0x08
+	controlMCM     byte = 0x10
+	controlCSEL    byte = 0x08
+	controlScroll  byte = 0x07
+)
+
+// Sizes of the VIC-II's memory areas.
+const (
+	BankSize     = 0x4000
+	CharROMSize  = 0x1000
+	ColorRAMSize = 0x0400
+
+	charROMBankAddress = 0x1000 // The character ROM appears here in banks 0 and 2
+)
+
+// unusedBits are the register bits that are not connected and read back as 1.
+var unusedBits = [registerCount]byte{
+	ControlX: 0xC0, MemoryPointers: 0x01, InterruptStatus: 0x70, InterruptEnable: 0xF0,
+	BorderColor: 0xF0, BackgroundColor0: 0xF0, BackgroundColor1: 0xF0, BackgroundColor2: 0xF0,
+	BackgroundColor3: 0xF0, SpriteMulticolor0: 0xF0, SpriteMulticolor1: 0xF0,
+	SpriteColor0: 0xF0, SpriteColor0 + 1: 0xF0, SpriteColor0 + 2: 0xF0, SpriteColor0 + 3: 0xF0,
+	SpriteColor0 + 4: 0xF0, SpriteColor0 + 5: 0xF0, SpriteColor0 + 6: 0xF0, SpriteColor0 + 7: 0xF0,
+}
+
+// Ensure VIC implements the Device interface.
+var _ devices.Device = &VIC{}
+
+// Ensure VIC can report its raster position to the debugger.
+var _ devices.Inspectable = &VIC{}
+
+// VIC represents a VIC-II. It sees a 16K bank of RAM selected with SetBank, the character ROM at
+// $1000-$1FFF of banks 0 and 2, and the 4 bit colour RAM on its own bus.
+type VIC struct {
+	ram       memory.Operations[uint16]
+	colorRAM  memory.Operations[uint16]
+	charROM   []byte
+	bank      uint16
+	regs      [registerCount]byte
+	irq       *devices.InterruptLine
+	rdy       *devices.InterruptLine
+	irqStatus byte
+	model     Model
+	line      int
+	cycle     int
+	denSeen   bool // DEN was set during line $30, enabling badlines for the frame
+	frames    uint64
+
+	// OnFrame, if set, is called each time the raster beam returns to line 0.
+	OnFrame func()
+}
+
+// New creates a PAL VIC-II that fetches graphics from ram (usually the memory map's base RAM, as the
+// VIC-II never sees the ROMs or I/O the CPU does), colour from colorRAM (addressed $000-$3FF) and
+// character data from charROM, a 4K character ROM image. It signals raster interrupts on irq and holds
+// rdy while it takes the bus from the CPU; either line may be nil.
+func New(irq, rdy *devices.InterruptLine, ram, colorRAM memory.Operations[uint16], charROM []byte) *VIC {
+	v := &VIC{irq: irq, rdy: rdy, ram: ram, colorRAM: colorRAM, charROM: charROM, model: PAL}
+	v.Reset()
+	return v
+}
+
+// LoadCharROM reads a 4K character ROM image from a file.
+func LoadCharROM(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("[vic LoadCharROM] %w", err)
+	}
+	if len(data) != CharROMSize {
+		return nil, fmt.Errorf("[vic LoadCharROM] character ROM must be %d bytes, got %d", CharROMSize, len(data))
+	}
+	return data, nil
+}
+
+// SetBank selects the 16K bank (0-3) the VIC-II fetches from. On the C64 this is the inverse of
+// CIA 2 port A bits 0-1.
+func (v *VIC) SetBank(bank int) {
+	v.bank = uint16(bank&3) * BankSize
+}
+
+// Bank returns the selected 16K bank.
+func (v *VIC) Bank() int {
+	return int(v.bank / BankSize)
+}
+
+// Reset clears every register and interrupt and returns the raster beam to the top of the frame.
+func (v *VIC) Reset() {
+	v.regs = [registerCount]byte{}
+	v.irqStatus = 0
+	v.line, v.cycle = 0, 1
+	v.denSeen = false
+	v.frames = 0
+	v.irq.Set(v, false)
+	v.rdy.Set(v, false)
+}
+
+// Read reads a VIC-II register. Unconnected bits and registers read as 1.
+// Raster and ControlY bit 7 return the current raster line rather than the compare value written.
+func (v *VIC) Read(address uint16) byte {
+	reg := address % mirrorSize
+	switch {
+	case reg >= registerCount:
+		return 0xFF
+	case reg == ControlY:
+		return v.regs[ControlY]&^controlRaster8 | byte(v.line>>1)&controlRaster8
+	case reg == Raster:
+		return byte(v.line)
+	case reg == InterruptStatus:
+		return v.interruptStatus()
+	}
+	return v.regs[reg] | unusedBits[reg]
+}
+
+// Write writes data to consecutive VIC-II registers starting at address. Writing 1 bits to
+// InterruptStatus acknowledges those interrupts, and moving the raster compare onto the current line
+// raises the raster interrupt immediately.
+func (v *VIC) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		reg := (address + uint16(i)) % mirrorSize
+		switch {
+		case reg >= registerCount:
+		case reg == InterruptStatus:
+			v.acknowledgeInterrupts(b)
+		case reg == InterruptEnable:
+			v.regs[reg] = b
+			v.updateInterrupt()
+		case reg == ControlY || reg == Raster:
+			previous := v.rasterCompare()
+			v.regs[reg] = b
+			if compare := v.rasterCompare(); compare != previous && compare == v.line {
+				v.raiseInterrupt(InterruptRaster)
+			}
+		default:
+			v.regs[reg] = b
+		}
+	}
+}
+
+// fetch reads a byte from the VIC-II's 16K view of memory.
+func (v *VIC) fetch(address uint16) byte {
+	address &= BankSize - 1
+	if v.bank&BankSize == 0 && address&0xF000 == charROMBankAddress {
+		offset := int(address - charROMBankAddress)
+		if offset < len(v.charROM) {
+			return v.charROM[offset]
+		}
+		return 0xFF
+	}
+	return v.ram.Read(v.bank + address)
+}
+
+// color reads a colour RAM nibble.
+func (v *VIC) color(offset uint16) byte {
+	return v.colorRAM.Read(offset%ColorRAMSize) & 0x0F
+}
+
+func (v *VIC) videoMatrix() uint16 {
+	return uint16(v.regs[MemoryPointers]>>4) * 0x0400
+}
+
+func (v *VIC) charBase() uint16 {
+	return uint16((v.regs[MemoryPointers]>>1)&0x07) * 0x0800
+}
+
+func (v *VIC) bitmapBase() uint16 {
+	return uint16((v.regs[MemoryPointers]>>3)&0x01) * 0x2000
+}
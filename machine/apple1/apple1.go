@@ -0,0 +1,102 @@
+// Package apple1 assembles an Apple-1 from the emulator's CPU, memory map and devices: RAM, the Woz
+// Monitor ROM and a 6821 PIA at $D010-$D013 connecting the keyboard and display to host streams.
+package apple1
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/pia"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Memory map.
+const (
+	KBD        = 0xD010 // Keyboard data
+	KBDCR      = 0xD011 // Keyboard control
+	DSP        = 0xD012 // Display data
+	DSPCR      = 0xD013 // Display control
+	WozMonitor = 0xFF00 // Woz Monitor entry point
+
+	memorySize = 64 * 1024
+	romEnd     = 0xFFFF
+)
+
+// ClockHz is the Apple-1 CPU clock frequency.
+const ClockHz = 1022727
+
+// Apple1 is an Apple-1 built on a device bus.
+type Apple1 struct {
+	*devices.Bus
+	PIA      *pia.PIA
+	Keyboard *Keyboard
+	Display  *Display
+	ROM      *memory.ROM[uint16]
+}
+
+// New creates an Apple-1 with 64K of RAM and the given ROM image mapped so that it ends at $FFFF
+// (a 256 byte Woz Monitor image sits at $FF00). Keys are read from in and the display is written to out.
+// The PIA's interrupt outputs are not connected, as on the original board.
+func New(rom []byte, in io.Reader, out io.Writer) (*Apple1, error) {
+	if len(rom) == 0 || len(rom) > memorySize {
+		return nil, fmt.Errorf("[apple1 New] invalid ROM size %d", len(rom))
+	}
+	bus := devices.NewBus(memory.NewMemory[uint16](memorySize), false)
+	a := &Apple1{
+		Bus: bus,
+		PIA: pia.New(nil),
+		ROM: memory.NewROM[uint16](rom),
+	}
+	a.Keyboard = NewKeyboard(a.PIA, in)
+	a.Display = NewDisplay(out)
+	a.PIA.Connect(pia.PortA, a.Keyboard)
+	a.PIA.Connect(pia.PortB, a.Display)
+
+	bus.Memory.Map(uint16(romEnd-len(rom)+1), romEnd, a.ROM)
+	bus.Attach(KBD, DSPCR, a.PIA)
+	bus.Reset()
+	return a, nil
+}
+
+// LoadROM reads a ROM image, such as the Woz Monitor, from a file.
+func LoadROM(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("[apple1 LoadROM] %w", err)
+	}
+	return data, nil
+}
+
+// Run clocks the machine at hz cycles per second (0 runs unthrottled) until the CPU returns an error
+// or stop is closed.
+func (a *Apple1) Run(hz uint64, stop <-chan struct{}) error {
+	const slice = 10 * time.Millisecond
+	cyclesPerSlice := hz / uint64(time.Second/slice)
+	if hz == 0 {
+		cyclesPerSlice = 10000
+	}
+	next := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		for i := uint64(0); i < cyclesPerSlice; i++ {
+			if _, err := a.Step(); err != nil {
+				return err
+			}
+		}
+		if hz > 0 {
+			next = next.Add(slice)
+			if d := time.Until(next); d > 0 {
+				time.Sleep(d)
+			} else {
+				next = time.Now()
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package apple1
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoROM is a 256 byte ROM at $FF00 that initialises the PIA as the Woz Monitor does, then echoes
+// every key to the display through a JSR to a routine that waits for the display to be ready.
+func echoROM() []byte {
+	rom := make([]byte, 256)
+	copy(rom, []byte{
+		0xA0, 0x7F, //       LDY #$7F
+		0x8C, 0x12, 0xD0, // STY DSP      ; DDRB: PB0-PB6 outputs, PB7 input
+		0xA9, 0xA7, //       LDA #$A7
+		0x8D, 0x11, 0xD0, // STA KBDCR
+		0x8D, 0x13, 0xD0, // STA DSPCR
+		0xAD, 0x11, 0xD0, // loop: LDA KBDCR
+		0x10, 0xFB, //       BPL loop
+		0xAD, 0x10, 0xD0, // LDA KBD
+		0x20, 0x1B, 0xFF, // JSR echo
+		0x4C, 0x0D, 0xFF, // JMP loop
+		0x2C, 0x12, 0xD0, // echo: BIT DSP
+		0x30, 0xFB, //       BMI echo
+		0x8D, 0x12, 0xD0, // STA DSP
+		0x60, //             RTS
+	})
+	rom[0xFC], rom[0xFD] = 0x00, 0xFF // Reset vector
+	return rom
+}
+
+func TestResetStartsInROM(t *testing.T) {
+	a, err := New(echoROM(), nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(WozMonitor), a.CPU.Reg.PC)
+
+	a.Memory.Write(0xFF00, 0x00)
+	assert.Equal(t, byte(0xA0), a.Memory.Read(0xFF00), "ROM is read only")
+	a.Memory.Write(0x0280, 0x42)
+	assert.Equal(t, byte(0x42), a.Memory.Read(0x0280))
+}
+
+func TestKeyboardEchoesToDisplay(t *testing.T) {
+	out := &bytes.Buffer{}
+	a, err := New(echoROM(), nil, out)
+	require.NoError(t, err)
+
+	a.Keyboard.Type("hello\n")
+	for i := 0; i < 5000 && out.Len() < 6; i++ {
+		require.NoError(t, a.StepInstruction())
+	}
+	assert.Equal(t, "HELLO\n", out.String())
+	assert.Equal(t, byte(0xFF), a.Memory.Read(0x01FF), "JSR pushed the return address high byte")
+}
+
+func TestInvalidROM(t *testing.T) {
+	_, err := New(nil, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package apple1
+
+import (
+	"io"
+
+	"github.com/jrsteele09/go-6502-emulator/devices/pia"
+)
+
+// Apple-1 character codes that differ from the host's.
+const (
+	carriageReturn = 0x0D
+	rubout         = '_' // The Apple-1 has no backspace; the Woz Monitor treats underscore as rubout
+)
+
+// Keyboard is the ASCII keyboard on PIA port A. Each key is presented on PA0-PA6 with PA7 held high,
+// and strobed on CA1 so the CPU sees bit 7 of KBDCR set until it reads KBD.
+// Keys are read from a host stream: lower case is folded to upper case, newline becomes carriage return
+// and backspace/delete become the rubout character.
+type Keyboard struct {
+	pia  *pia.PIA
+	keys chan byte
+	data byte
+}
+
+// Ensure Keyboard implements the pia.Peripheral and pia.Ticker interfaces.
+var _ pia.Peripheral = &Keyboard{}
+var _ pia.Ticker = &Keyboard{}
+
+// NewKeyboard creates a keyboard that types the bytes read from r into port A of p. r may be nil.
+func NewKeyboard(p *pia.PIA, r io.Reader) *Keyboard {
+	k := &Keyboard{pia: p, keys: make(chan byte, 256)}
+	if r != nil {
+		go k.readLoop(r)
+	}
+	return k
+}
+
+func (k *Keyboard) readLoop(r io.Reader) {
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if key, ok := hostToApple(buf[0]); ok {
+				k.keys <- key
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func hostToApple(b byte) (byte, bool) {
+	switch {
+	case b == '\n':
+		return carriageReturn, true
+	case b == '\r':
+		return 0, false
+	case b == 0x08 || b == 0x7F:
+		return rubout, true
+	case b >= 'a' && b <= 'z':
+		return b - 'a' + 'A', true
+	}
+	return b & 0x7F, true
+}
+
+// Type queues keys as if they had been typed on the keyboard.
+func (k *Keyboard) Type(s string) {
+	for i := 0; i < len(s); i++ {
+		if key, ok := hostToApple(s[i]); ok {
+			k.keys <- key
+		}
+	}
+}
+
+// Input returns the current key with PA7 held high.
+func (k *Keyboard) Input() byte {
+	return k.data | 0x80
+}
+
+// Output ignores writes, the keyboard is input only.
+func (k *Keyboard) Output(_ byte) {}
+
+// Tick presents the next key once the CPU has read the previous one, so pasted text is not lost.
+func (k *Keyboard) Tick() {
+	if k.pia.Read(pia.CRA)&pia.ControlIRQ1 != 0 {
+		return
+	}
+	select {
+	case key := <-k.keys:
+		k.data = key
+		k.pia.Strobe(pia.PortA)
+	default:
+	}
+}
+
+// Display is the terminal section on PIA port B. The CPU writes a character to PB0-PB6 and polls PB7,
+// which is low when the display is ready for the next character. Characters are written to a host
+// stream, with carriage return translated to newline; other control characters are ignored.
+type Display struct {
+	out io.Writer
+}
+
+// Ensure Display implements the pia.Peripheral interface.
+var _ pia.Peripheral = &Display{}
+
+// NewDisplay creates a display that writes to w. w may be nil.
+func NewDisplay(w io.Writer) *Display {
+	return &Display{out: w}
+}
+
+// Input holds PB7 low: the display is always ready.
+func (d *Display) Input() byte {
+	return 0x00
+}
+
+// Output displays the character written to the port.
+func (d *Display) Output(data byte) {
+	if d.out == nil {
+		return
+	}
+	c := data & 0x7F
+	switch {
+	case c == carriageReturn:
+		d.out.Write([]byte{'\n'})
+	case c >= 0x20 && c < 0x7F:
+		d.out.Write([]byte{c})
+	}
+}
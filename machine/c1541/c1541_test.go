@@ -0,0 +1,140 @@
+package c1541
+
+import (
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/devices/iec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testROM returns a DOS ROM holding program at $C000, which the reset vector points to.
+func testROM(program ...byte) []byte {
+	rom := make([]byte, ROMSize)
+	copy(rom, program)
+	rom[0x3FFC], rom[0x3FFD] = 0x00, 0xC0
+	return rom
+}
+
+// testD64 returns a D64 image whose sectors are filled with their track number, with disk ID "AB".
+func testD64() []byte {
+	d64 := make([]byte, D64Size)
+	for track := 1; track <= Tracks; track++ {
+		for sector := 0; sector < SectorsPerTrack(track); sector++ {
+			offset := SectorOffset(track, sector)
+			for i := 0; i < SectorSize; i++ {
+				d64[offset+i] = byte(track)
+			}
+		}
+	}
+	copy(d64[SectorOffset(bamTrack, 0)+diskIDLo:], "AB")
+	return d64
+}
+
+func TestGCR(t *testing.T) {
+	data := []byte{0x08, 0x12, 0x00, 0x12}
+	gcr := EncodeGCR(data)
+	assert.Equal(t, []byte{0x52, 0x57, 0x25, 0x29, 0x72}, gcr)
+
+	decoded, err := DecodeGCR(gcr)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	_, err = DecodeGCR([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+	assert.Error(t, err)
+}
+
+func TestTrackRoundTrip(t *testing.T) {
+	d64 := testD64()
+	assert.Equal(t, 357*SectorSize, SectorOffset(18, 0))
+	assert.Equal(t, 683*SectorSize, D64Size)
+
+	for _, track := range []int{1, 18, 25, 35} {
+		gcr := EncodeTrack(d64, track)
+		assert.Len(t, gcr, speedZones[zoneOf(track)].trackBytes, "track %d", track)
+
+		decoded := make([]byte, D64Size)
+		DecodeTrack(gcr, decoded, track)
+		start, end := SectorOffset(track, 0), SectorOffset(track+1, 0)
+		assert.Equal(t, d64[start:end], decoded[start:end], "track %d", track)
+	}
+}
+
+func TestDiskWrite(t *testing.T) {
+	disk, err := NewDisk(testD64())
+	require.NoError(t, err)
+
+	// Rewrite sector 3 of track 1 as the drive would, by replacing its data block
+	d64 := testD64()
+	for i := 0; i < SectorSize; i++ {
+		d64[SectorOffset(1, 3)+i] = 0xAA
+	}
+	disk.tracks[2] = EncodeTrack(d64, 1)
+	disk.written[1] = true
+
+	assert.Equal(t, d64, disk.D64())
+}
+
+func TestReadByteFromDisk(t *testing.T) {
+	rom := testROM(
+		0xA9, 0xEE, //       LDA #$EE       ; CA2 (byte ready to SO) high, CB2 high: read mode
+		0x8D, 0x0C, 0x1C, // STA $1C0C
+		0xA9, 0x6F, //       LDA #$6F
+		0x8D, 0x02, 0x1C, // STA $1C02      ; Stepper, motor, LED and speed zone are outputs
+		0xA9, 0x44, //       LDA #$44
+		0x8D, 0x00, 0x1C, // STA $1C00      ; Motor on, speed zone 2
+		0x2C, 0x00, 0x1C, // BIT $1C00      ; Wait for a sync mark
+		0x30, 0xFB, //       BMI *-3
+		0xB8,       //       CLV
+		0x50, 0xFE, //       BVC *          ; Wait for the first byte after the sync
+		0xAD, 0x01, 0x1C, // LDA $1C01
+		0x8D, 0x00, 0x03, // STA $0300
+		0x4C, 0x1D, 0xC0, // JMP *
+	)
+	drive, err := New(rom, iec.NewBus(), DefaultDevice)
+	require.NoError(t, err)
+	disk, err := NewDisk(testD64())
+	require.NoError(t, err)
+	drive.Insert(disk)
+
+	for i := 0; i < 2000; i++ {
+		drive.Tick()
+	}
+	require.NoError(t, drive.Err())
+	assert.Equal(t, byte(0x52), drive.Memory.Read(0x0300), "a header block starts with GCR $52")
+	assert.Equal(t, 18, drive.Track())
+	assert.Contains(t, drive.Inspect(), "motor on")
+}
+
+func TestSerialBus(t *testing.T) {
+	rom := testROM(
+		0xA9, 0x1A, //       LDA #$1A
+		0x8D, 0x02, 0x18, // STA $1802      ; DATA, CLK and ATNA are outputs
+		0xA9, 0x00, //       LDA #$00
+		0x8D, 0x00, 0x18, // STA $1800      ; Release the lines
+		0xAD, 0x00, 0x18, // LDA $1800
+		0x8D, 0x00, 0x03, // STA $0300
+		0x4C, 0x0A, 0xC0, // JMP *-6
+	)
+	serial := iec.NewBus()
+	drive, err := New(rom, serial, 9)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		drive.Tick()
+	}
+	assert.False(t, serial.Low(iec.Data))
+	assert.Equal(t, busDevice&0x20, drive.Memory.Read(0x0300)&busDevice, "device 9 has jumper 1 cut")
+
+	serial.Pull("host", iec.ATN|iec.Clock)
+	for i := 0; i < 100; i++ {
+		drive.Tick()
+	}
+	assert.True(t, serial.Low(iec.Data), "the drive acknowledges ATN in hardware")
+	assert.Equal(t, busATNIn|busClockIn|busDataIn, drive.Memory.Read(0x0300)&(busATNIn|busClockIn|busDataIn))
+
+	serial.Pull("host", 0)
+	for i := 0; i < 100; i++ {
+		drive.Tick()
+	}
+	assert.False(t, serial.Low(iec.Data))
+}
@@ -0,0 +1,74 @@
+package c1541
+
+import (
+	"fmt"
+	"os"
+)
+
+// halfTracks is the number of head positions: half tracks 2-71 cover tracks 1-35, and the head can
+// step a little further out, as copy protection schemes do.
+const halfTracks = 84
+
+// Disk is a disk in the drive, recorded as GCR tracks from a D64 image. Writes by the drive change the
+// GCR tracks; D64 decodes them back into an image and Save writes that to the disk's file.
+type Disk struct {
+	File           string
+	WriteProtected bool
+
+	d64     []byte
+	tracks  [halfTracks][]byte // GCR data by half track; odd half tracks are unformatted
+	written map[int]bool       // Tracks the drive has written to
+}
+
+// NewDisk records a D64 image as GCR tracks. The image's error information, if any, is ignored.
+func NewDisk(d64 []byte) (*Disk, error) {
+	if len(d64) < D64Size {
+		return nil, fmt.Errorf("[c1541 NewDisk] D64 image must be at least %d bytes, got %d", D64Size, len(d64))
+	}
+	d := &Disk{d64: append([]byte(nil), d64[:D64Size]...), written: make(map[int]bool)}
+	for track := 1; track <= Tracks; track++ {
+		d.tracks[track*2] = EncodeTrack(d.d64, track)
+	}
+	return d, nil
+}
+
+// LoadDisk reads a D64 image file.
+func LoadDisk(file string) (*Disk, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("[c1541 LoadDisk] %w", err)
+	}
+	d, err := NewDisk(data)
+	if err != nil {
+		return nil, err
+	}
+	d.File = file
+	return d, nil
+}
+
+// D64 returns the disk as a D64 image, decoding the sectors of any tracks the drive has written.
+func (d *Disk) D64() []byte {
+	for track := range d.written {
+		DecodeTrack(d.tracks[track*2], d.d64, track)
+	}
+	return append([]byte(nil), d.d64...)
+}
+
+// Save writes the disk back to its file.
+func (d *Disk) Save() error {
+	if d.File == "" {
+		return fmt.Errorf("[c1541 Save] the disk has no file")
+	}
+	if err := os.WriteFile(d.File, d.D64(), 0644); err != nil {
+		return fmt.Errorf("[c1541 Save] %w", err)
+	}
+	return nil
+}
+
+// track returns the GCR data under the head at a half track, nil if it is unformatted.
+func (d *Disk) track(halfTrack int) []byte {
+	if halfTrack < 0 || halfTrack >= halfTracks {
+		return nil
+	}
+	return d.tracks[halfTrack]
+}
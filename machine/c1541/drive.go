@@ -0,0 +1,305 @@
+// Package c1541 emulates a Commodore 1541 disk drive: its own 6502 running the drive's DOS ROM,
+// RAM and two 6522 VIAs, one connected to the serial bus and one to the read/write head, which reads a
+// disk recorded as GCR tracks from a D64 image as it rotates. The DOS ROM is not included and must be
+// supplied by the user.
+package c1541
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/iec"
+	"github.com/jrsteele09/go-6502-emulator/devices/via"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Memory map.
+const (
+	VIA1Address = 0x1800 // Serial bus
+	VIA2Address = 0x1C00 // Disk controller
+	ROMAddress  = 0xC000
+
+	memorySize = 64 * 1024
+	viaSize    = 0x0400 // Each VIA is mirrored through 1K
+)
+
+// ROMSize is the size of the DOS ROM, usually dumped as two 8K halves at $C000 and $E000.
+const ROMSize = 0x4000
+
+// ClockHz is the drive's CPU clock frequency.
+const ClockHz = 1000000
+
+// DefaultDevice is the device number a 1541 answers to with its address jumpers intact.
+const DefaultDevice = 8
+
+// VIA 1 port B: the serial bus. Outputs pull a line low through an inverter and inputs read 1 while a
+// line is low.
+const (
+	busDataIn  byte = 0x01
+	busDataOut byte = 0x02
+	busClockIn byte = 0x04
+	busClkOut  byte = 0x08
+	busATNAck  byte = 0x10
+	busDevice  byte = 0x60 // Address jumpers: the device number - 8
+	busATNIn   byte = 0x80
+)
+
+// VIA 2 port B: the drive mechanics.
+const (
+	headStepper   byte = 0x03 // Stepper motor phase; counting up steps the head in, towards track 35
+	headMotor     byte = 0x04
+	headLED       byte = 0x08
+	headWriteProt byte = 0x10 // 0 when the disk is write protected
+	headSpeed     byte = 0x60 // Speed zone, which sets the bit rate
+	headSync      byte = 0x80 // 0 while the head reads a sync mark
+)
+
+// Drive is a 1541 disk drive built on its own device bus.
+type Drive struct {
+	*devices.Bus
+	VIA1   *via.VIA
+	VIA2   *via.VIA
+	Device int
+
+	serial *iec.Bus
+	disk   *Disk
+
+	halfTrack  int
+	phase      byte
+	motor, led bool
+	position   int // GCR byte under the head
+	byteCycles int // Cycles until the next byte passes the head
+	current    byte
+	previous   byte
+	sync       bool
+
+	err error
+}
+
+// LoadROM reads the DOS ROM from one 16K file, or from two 8K files in address order.
+func LoadROM(files ...string) ([]byte, error) {
+	var rom []byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("[c1541 LoadROM] %w", err)
+		}
+		rom = append(rom, data...)
+	}
+	if len(rom) != ROMSize {
+		return nil, fmt.Errorf("[c1541 LoadROM] the DOS ROM must be %d bytes, got %d", ROMSize, len(rom))
+	}
+	return rom, nil
+}
+
+// New creates a drive with the given DOS ROM, connected to a serial bus as device 8-11, and resets it.
+func New(rom []byte, serial *iec.Bus, device int) (*Drive, error) {
+	if len(rom) != ROMSize {
+		return nil, fmt.Errorf("[c1541 New] the DOS ROM must be %d bytes, got %d", ROMSize, len(rom))
+	}
+	if device < 8 || device > 11 {
+		return nil, fmt.Errorf("[c1541 New] device number %d is not 8-11", device)
+	}
+	bus := devices.NewBus(memory.NewMemory[uint16](memorySize), false)
+	d := &Drive{
+		Bus:    bus,
+		VIA1:   via.New(bus.IRQ),
+		VIA2:   via.New(bus.IRQ),
+		Device: device,
+		serial: serial,
+	}
+	d.VIA1.Attach(&serialPort{d})
+	d.VIA2.Attach(&headPort{d})
+	bus.Memory.Map(ROMAddress, ROMAddress+ROMSize-1, memory.NewROM[uint16](rom))
+	bus.Attach(VIA1Address, VIA1Address+viaSize-1, d.VIA1)
+	bus.Attach(VIA2Address, VIA2Address+viaSize-1, d.VIA2)
+	d.halfTrack = 36 // Track 18, where the directory is
+	d.Reset()
+	return d, nil
+}
+
+// Reset resets the VIAs and the CPU, which restarts the DOS. The disk and the head stay where they are.
+func (d *Drive) Reset() {
+	d.err = nil
+	d.Bus.Reset()
+}
+
+// Insert puts a disk in the drive, replacing any disk already there.
+func (d *Drive) Insert(disk *Disk) {
+	d.disk = disk
+	d.position, d.sync = 0, false
+}
+
+// Eject removes the disk from the drive and returns it, nil if there was none.
+func (d *Drive) Eject() *Disk {
+	disk := d.disk
+	d.disk = nil
+	return disk
+}
+
+// Track returns the track under the head; a half track position returns the track it is half way past.
+func (d *Drive) Track() int {
+	return d.halfTrack / 2
+}
+
+// LED returns whether the drive's activity LED is lit.
+func (d *Drive) LED() bool {
+	return d.led
+}
+
+// Err returns the error that stopped the drive's CPU, if any.
+func (d *Drive) Err() error {
+	return d.err
+}
+
+// Inspect describes the drive for the debugger.
+func (d *Drive) Inspect() string {
+	state := fmt.Sprintf("1541 #%d: PC $%04X, track %d", d.Device, d.CPU.Reg.PC, d.Track())
+	if d.halfTrack%2 != 0 {
+		state += ".5"
+	}
+	if d.motor {
+		state += ", motor on"
+	}
+	if d.led {
+		state += ", LED on"
+	}
+	if d.disk == nil {
+		state += ", no disk"
+	}
+	if d.err != nil {
+		state += fmt.Sprintf(", stopped: %v", d.err)
+	}
+	return state
+}
+
+// Tick clocks the drive by one cycle of its 1MHz clock: the serial bus inputs, the rotating disk, the
+// VIAs and the CPU. An error from the CPU stops the drive until it is reset.
+func (d *Drive) Tick() {
+	if d.err != nil {
+		return
+	}
+	d.VIA1.SetCA1(d.serial.Low(iec.ATN))
+	d.VIA1.PortB() // The ATN acknowledge logic follows ATN
+	d.rotate()
+	if _, err := d.Step(); err != nil {
+		d.err = fmt.Errorf("[c1541 Tick] at $%04X: %w", d.CPU.Reg.PC, err)
+	}
+}
+
+// rotate moves the disk under the head. Each time a whole byte has passed the head, a byte read outside
+// a sync mark is latched into VIA 2 port A and signalled as BYTE READY, which sets the CPU's overflow
+// flag through its SO pin when enabled by CA2, and pulses CA1. In write mode, CB2 low, the byte in port
+// A is written instead.
+func (d *Drive) rotate() {
+	if !d.motor || d.disk == nil {
+		d.sync = false
+		return
+	}
+	if d.byteCycles--; d.byteCycles > 0 {
+		return
+	}
+	zone := int(d.VIA2.PortB()&headSpeed) >> 5
+	d.byteCycles = 32 - 2*zone // Zone 3, the outer tracks, spins 26µs bytes past the head
+
+	track := d.disk.track(d.halfTrack)
+	if len(track) == 0 {
+		d.sync = false
+		return
+	}
+	d.position = (d.position + 1) % len(track)
+	if !d.VIA2.CB2() && !d.disk.WriteProtected {
+		track[d.position] = d.VIA2.PortA()
+		d.disk.written[d.halfTrack/2] = true
+		d.previous, d.current, d.sync = d.current, track[d.position], false
+	} else {
+		d.previous, d.current = d.current, track[d.position]
+		d.sync = d.previous == 0xFF && d.current == 0xFF
+		if d.sync {
+			return
+		}
+	}
+
+	if d.VIA2.CA2() {
+		d.CPU.Reg.SetStatus(cpu.OverflowFlag, true)
+	}
+	d.VIA2.SetCA1(false)
+	d.VIA2.SetCA1(true)
+}
+
+// step moves the head when the stepper motor phase changes by one position.
+func (d *Drive) step(phase byte) {
+	switch (phase - d.phase) & headStepper {
+	case 1:
+		if d.halfTrack < halfTracks-1 {
+			d.halfTrack++
+		}
+	case 3:
+		if d.halfTrack > 2 {
+			d.halfTrack--
+		}
+	}
+	d.phase = phase
+	if d.disk != nil {
+		if track := d.disk.track(d.halfTrack); len(track) > 0 {
+			d.position %= len(track)
+		}
+	}
+}
+
+// serialPort connects VIA 1 port B to the serial bus. While ATN is held low by the computer and not
+// acknowledged with ATNA, or acknowledged while it is high, the drive's hardware pulls DATA low.
+type serialPort struct {
+	d *Drive
+}
+
+// Ports drives the serial bus from the outputs and reads the lines and address jumpers.
+func (s *serialPort) Ports(pa, pb byte) (byte, byte) {
+	var pull byte
+	if pb&busDataOut != 0 || s.d.serial.Low(iec.ATN) != (pb&busATNAck != 0) {
+		pull |= iec.Data
+	}
+	if pb&busClkOut != 0 {
+		pull |= iec.Clock
+	}
+	s.d.serial.Pull(s.d, pull)
+
+	lines := s.d.serial.Lines()
+	if lines&iec.Data != 0 {
+		pb &^= busDataIn
+	}
+	if lines&iec.Clock != 0 {
+		pb &^= busClockIn
+	}
+	if lines&iec.ATN != 0 {
+		pb &^= busATNIn
+	}
+	pb &^= busDevice &^ (byte(s.d.Device-8) << 5)
+	return pa, pb
+}
+
+// headPort connects VIA 2 to the drive mechanics: port A to the read/write head's data and port B to
+// the stepper motor, spindle motor, LED, write protect sensor and sync detector.
+type headPort struct {
+	d *Drive
+}
+
+// Ports follows the mechanics' outputs and reads the head's byte, sync and write protect state.
+func (h *headPort) Ports(pa, pb byte) (byte, byte) {
+	d := h.d
+	// The stepper only moves once the DOS drives its lines; while they are inputs they float high
+	if phase := pb & headStepper; phase != d.phase && d.VIA2.Read(via.DDRB)&headStepper == headStepper {
+		d.step(phase)
+	}
+	d.motor = pb&headMotor != 0
+	d.led = pb&headLED != 0
+	if d.disk != nil && d.disk.WriteProtected {
+		pb &^= headWriteProt
+	}
+	if d.sync {
+		pb &^= headSync
+	}
+	return pa & d.current, pb
+}
@@ -0,0 +1,189 @@
+package c1541
+
+import (
+	"fmt"
+)
+
+// D64 geometry.
+const (
+	Tracks      = 35
+	SectorSize  = 256
+	D64Size     = 174848 // 683 sectors
+	bamTrack    = 18
+	diskIDLo    = 0xA2 // Offset of the disk ID in the BAM sector
+	syncLength  = 5    // $FF bytes in a sync mark
+	headerGap   = 9    // $55 bytes between a header block and its data block
+	headerBlock = 0x08
+	dataBlock   = 0x07
+)
+
+// gcrCodes maps each nybble to the five bit group code recording uses: no code has more than two zero
+// bits in a row, so the drive's clock recovery never sees a long run of zeros.
+var gcrCodes = [16]byte{
+	0x0A, 0x0B, 0x12, 0x13, 0x0E, 0x0F, 0x16, 0x17,
+	0x09, 0x19, 0x1A, 0x1B, 0x0D, 0x1D, 0x1E, 0x15,
+}
+
+// speedZones gives, for tracks 1-17, 18-24, 25-30 and 31 on, the number of sectors, the speed zone
+// the DOS selects in VIA 2 port B bits 5-6 and the GCR bytes that fit on the track at that bit rate.
+var speedZones = []struct {
+	firstTrack, sectors, zone, trackBytes int
+}{
+	{1, 21, 3, 7692},
+	{18, 19, 2, 7142},
+	{25, 18, 1, 6666},
+	{31, 17, 0, 6250},
+}
+
+// zoneOf returns the speed zone entry of a track.
+func zoneOf(track int) int {
+	for i := len(speedZones) - 1; i > 0; i-- {
+		if track >= speedZones[i].firstTrack {
+			return i
+		}
+	}
+	return 0
+}
+
+// SectorsPerTrack returns the number of sectors on a track, 1 to 35.
+func SectorsPerTrack(track int) int {
+	return speedZones[zoneOf(track)].sectors
+}
+
+// SectorOffset returns the offset of a track and sector in a D64 image.
+func SectorOffset(track, sector int) int {
+	offset := 0
+	for t := 1; t < track; t++ {
+		offset += SectorsPerTrack(t) * SectorSize
+	}
+	return offset + sector*SectorSize
+}
+
+// EncodeGCR encodes groups of four bytes as five GCR bytes. len(data) must be a multiple of 4.
+func EncodeGCR(data []byte) []byte {
+	out := make([]byte, 0, len(data)/4*5)
+	for i := 0; i+4 <= len(data); i += 4 {
+		var bits uint64
+		for _, b := range data[i : i+4] {
+			bits = bits<<10 | uint64(gcrCodes[b>>4])<<5 | uint64(gcrCodes[b&0x0F])
+		}
+		for shift := 32; shift >= 0; shift -= 8 {
+			out = append(out, byte(bits>>shift))
+		}
+	}
+	return out
+}
+
+// DecodeGCR decodes groups of five GCR bytes to four bytes, failing on a code that no nybble has.
+func DecodeGCR(gcr []byte) ([]byte, error) {
+	out := make([]byte, 0, len(gcr)/5*4)
+	for i := 0; i+5 <= len(gcr); i += 5 {
+		var bits uint64
+		for _, b := range gcr[i : i+5] {
+			bits = bits<<8 | uint64(b)
+		}
+		for shift := 30; shift >= 0; shift -= 10 {
+			hi, okHi := gcrNybble(byte(bits>>(shift+5)) & 0x1F)
+			lo, okLo := gcrNybble(byte(bits>>shift) & 0x1F)
+			if !okHi || !okLo {
+				return out, fmt.Errorf("[c1541 DecodeGCR] invalid GCR code at byte %d", i)
+			}
+			out = append(out, hi<<4|lo)
+		}
+	}
+	return out, nil
+}
+
+func gcrNybble(code byte) (byte, bool) {
+	for n, c := range gcrCodes {
+		if c == code {
+			return byte(n), true
+		}
+	}
+	return 0, false
+}
+
+// EncodeTrack records a track of a D64 image as the 1541 formats it: for each sector a sync mark, the
+// GCR header block, a gap, a sync mark and the GCR data block, with the remaining space spread as
+// gaps between sectors.
+func EncodeTrack(d64 []byte, track int) []byte {
+	zone := speedZones[zoneOf(track)]
+	id := d64[SectorOffset(bamTrack, 0)+diskIDLo:]
+	id1, id2 := id[0], id[1]
+
+	var sectors [][]byte
+	used := 0
+	for sector := 0; sector < zone.sectors; sector++ {
+		var s []byte
+		s = appendSync(s)
+		s = append(s, EncodeGCR([]byte{headerBlock, byte(sector) ^ byte(track) ^ id2 ^ id1, byte(sector), byte(track)})...)
+		s = append(s, EncodeGCR([]byte{id2, id1, 0x0F, 0x0F})...)
+		s = appendGap(s, headerGap)
+		s = appendSync(s)
+
+		data := d64[SectorOffset(track, sector):][:SectorSize]
+		block := make([]byte, 0, SectorSize+4)
+		block = append(block, dataBlock)
+		block = append(block, data...)
+		checksum := byte(0)
+		for _, b := range data {
+			checksum ^= b
+		}
+		block = append(block, checksum, 0x00, 0x00)
+		s = append(s, EncodeGCR(block)...)
+		sectors = append(sectors, s)
+		used += len(s)
+	}
+
+	gap := (zone.trackBytes - used) / zone.sectors
+	out := make([]byte, 0, zone.trackBytes)
+	for _, s := range sectors {
+		out = append(out, s...)
+		out = appendGap(out, gap)
+	}
+	return appendGap(out, zone.trackBytes-len(out))
+}
+
+// DecodeTrack reads the sectors of a GCR track back into a D64 image, finding each header block after
+// a sync mark and the data block that follows it. Sectors that cannot be decoded are left unchanged.
+func DecodeTrack(gcr []byte, d64 []byte, track int) {
+	n := len(gcr)
+	// Search twice round the track so a sector that wraps past the end is decoded
+	circular := append(append([]byte{}, gcr...), gcr...)
+	for i := 1; i < n+1; i++ {
+		if circular[i-1] != 0xFF || circular[i] == 0xFF {
+			continue
+		}
+		header, err := DecodeGCR(circular[i : i+10])
+		if err != nil || header[0] != headerBlock || int(header[3]) != track || int(header[2]) >= SectorsPerTrack(track) {
+			continue
+		}
+		j := i + 10
+		for j < len(circular)-1 && !(circular[j] == 0xFF && circular[j+1] != 0xFF) {
+			j++
+		}
+		j++
+		if j+325 > len(circular) {
+			continue
+		}
+		block, err := DecodeGCR(circular[j : j+325])
+		if err != nil || block[0] != dataBlock {
+			continue
+		}
+		copy(d64[SectorOffset(track, int(header[2])):], block[1:SectorSize+1])
+	}
+}
+
+func appendSync(b []byte) []byte {
+	for i := 0; i < syncLength; i++ {
+		b = append(b, 0xFF)
+	}
+	return b
+}
+
+func appendGap(b []byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		b = append(b, 0x55)
+	}
+	return b
+}
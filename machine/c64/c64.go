@@ -0,0 +1,291 @@
+// Package c64 assembles a Commodore 64 from the emulator's CPU and devices: 64K of RAM banked by the
+// PLA under the BASIC, KERNAL and character ROMs, two CIAs, the VIC-II and the SID. The ROM images are
+// not included and must be supplied by the user.
+package c64
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/cia"
+	"github.com/jrsteele09/go-6502-emulator/devices/iec"
+	"github.com/jrsteele09/go-6502-emulator/devices/sid"
+	"github.com/jrsteele09/go-6502-emulator/devices/vic"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Memory map.
+const (
+	VICAddress      = 0xD000
+	SIDAddress      = 0xD400
+	ColorRAMAddress = 0xD800
+	CIA1Address     = 0xDC00
+	CIA2Address     = 0xDD00
+
+	BasicStart = 0x0801 // Start of BASIC program text
+
+	memorySize = 64 * 1024
+	ioSize     = 0x1000
+)
+
+// ROM image sizes.
+const (
+	BasicROMSize  = 0x2000
+	KernalROMSize = 0x2000
+	CharROMSize   = vic.CharROMSize
+)
+
+// KERNAL zero page and buffer locations used when loading programs and typing.
+const (
+	txtTab      = 0x2B   // Start of BASIC program text
+	varTab      = 0x2D   // Start of BASIC variables, the end of the program
+	loadEnd     = 0xAE   // End address of the last LOAD
+	keyCount    = 0xC6   // Number of characters in the keyboard buffer
+	keyBuffer   = 0x0277 // Keyboard buffer
+	keyBufferSz = 10
+)
+
+// ROMs holds the C64's ROM images. A nil image leaves the RAM beneath it visible.
+type ROMs struct {
+	Basic  []byte
+	Kernal []byte
+	Char   []byte
+}
+
+// LoadROMs reads the BASIC, KERNAL and character ROM images from files. Empty filenames are skipped.
+func LoadROMs(basic, kernal, char string) (ROMs, error) {
+	var roms ROMs
+	for _, r := range []struct {
+		file string
+		size int
+		data *[]byte
+	}{
+		{basic, BasicROMSize, &roms.Basic},
+		{kernal, KernalROMSize, &roms.Kernal},
+		{char, CharROMSize, &roms.Char},
+	} {
+		if r.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(r.file)
+		if err != nil {
+			return ROMs{}, fmt.Errorf("[c64 LoadROMs] %w", err)
+		}
+		if len(data) != r.size {
+			return ROMs{}, fmt.Errorf("[c64 LoadROMs] %s must be %d bytes, got %d", r.file, r.size, len(data))
+		}
+		*r.data = data
+	}
+	return roms, nil
+}
+
+// C64 is a Commodore 64 built on a device bus.
+type C64 struct {
+	*devices.Bus
+	Model    vic.Model
+	RAM      *memory.Memory[uint16]
+	PLA      *PLA
+	VIC      *vic.VIC
+	SID      *sid.SID
+	CIA1     *cia.CIA
+	CIA2     *cia.CIA
+	ColorRAM *memory.Memory[uint16]
+	Keyboard *cia.KeyboardMatrix
+	IEC      *iec.Bus // The serial bus, for disk drives created with AttachDrive
+	typed    *keyboardQueue
+}
+
+// New creates a C64 with the given ROMs and VIC-II model (vic.PAL or vic.NTSC, which also set the
+// CPU clock) and resets it, which boots into BASIC when the KERNAL and BASIC ROMs are present.
+func New(roms ROMs, model vic.Model) *C64 {
+	ram := memory.NewMemory[uint16](memorySize)
+	io := memory.NewMemoryMap[uint16](memory.NewMemory[uint16](ioSize))
+	pla := NewPLA(ram, roms.Basic, roms.Kernal, roms.Char, io)
+	bus := devices.NewBus(pla, false)
+
+	powerLineHz := uint64(cia.PowerLine50Hz)
+	if model.Lines < vic.PAL.Lines {
+		powerLineHz = cia.PowerLine60Hz
+	}
+	c := &C64{
+		Bus:      bus,
+		Model:    model,
+		RAM:      ram,
+		PLA:      pla,
+		SID:      sid.New(model.ClockHz),
+		CIA1:     cia.New(bus.IRQ, model.ClockHz, powerLineHz),
+		CIA2:     cia.New(bus.NMI, model.ClockHz, powerLineHz),
+		ColorRAM: memory.NewMemory[uint16](vic.ColorRAMSize),
+		Keyboard: cia.NewKeyboardMatrix(),
+		IEC:      iec.NewBus(),
+		typed:    &keyboardQueue{ram: ram},
+	}
+	c.VIC = vic.New(bus.IRQ, bus.RDY, ram, c.ColorRAM, roms.Char)
+	c.VIC.SetModel(model)
+	c.CIA1.Attach(c.Keyboard)
+	c.CIA2.Attach(&serialPort{bus: c.IEC})
+
+	bus.AddDevice(pla)
+	bus.AddDevice(c.typed)
+	c.attachIO(io, VICAddress, SIDAddress-1, c.VIC)
+	c.attachIO(io, SIDAddress, ColorRAMAddress-1, c.SID)
+	io.Map(ColorRAMAddress, CIA1Address-1, c.ColorRAM)
+	c.attachIO(io, CIA1Address, CIA2Address-1, c.CIA1)
+	c.attachIO(io, CIA2Address, CIA2Address+0xFF, &videoBankSelect{CIA: c.CIA2, vic: c.VIC})
+	c.Reset()
+	return c
+}
+
+// attachIO maps a device into the I/O area and clocks it with the CPU.
+func (c *C64) attachIO(io *memory.MemoryMap[uint16], start, end uint16, d devices.Device) {
+	io.Map(start, end, d)
+	c.AddDevice(d)
+}
+
+// Reset resets the CPU and every chip, as the reset line does. With the ROMs present the KERNAL
+// then initialises the machine and starts BASIC.
+func (c *C64) Reset() {
+	c.Bus.Reset()
+}
+
+// ClockHz returns the CPU clock frequency.
+func (c *C64) ClockHz() uint64 {
+	return c.Model.ClockHz
+}
+
+// RunCycles clocks the machine for the given number of cycles.
+func (c *C64) RunCycles(cycles uint64) error {
+	for i := uint64(0); i < cycles; i++ {
+		if _, err := c.Step(); err != nil {
+			return fmt.Errorf("[c64 RunCycles] at $%04X: %w", c.CPU.Reg.PC, err)
+		}
+	}
+	return nil
+}
+
+// Run clocks the machine at hz cycles per second (0 runs unthrottled) until the CPU returns an error
+// or stop is closed. ClockHz gives the real machine's rate.
+func (c *C64) Run(hz uint64, stop <-chan struct{}) error {
+	const slice = 10 * time.Millisecond
+	cyclesPerSlice := hz / uint64(time.Second/slice)
+	if hz == 0 {
+		cyclesPerSlice = 10000
+	}
+	next := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if err := c.RunCycles(cyclesPerSlice); err != nil {
+			return err
+		}
+		if hz > 0 {
+			next = next.Add(slice)
+			if d := time.Until(next); d > 0 {
+				time.Sleep(d)
+			} else {
+				next = time.Now()
+			}
+		}
+	}
+}
+
+// Type queues text for the KERNAL's keyboard buffer, as if it were typed. Letters are sent as upper case
+// PETSCII and newlines as RETURN.
+func (c *C64) Type(text string) {
+	for _, r := range strings.ToUpper(text) {
+		switch {
+		case r == '\n':
+			c.typed.pending = append(c.typed.pending, '\r')
+		case r < 0x80:
+			c.typed.pending = append(c.typed.pending, byte(r))
+		}
+	}
+}
+
+// LoadPRG loads a PRG file into RAM as the KERNAL's LOAD does, returning its load and end addresses.
+// A program loaded at the start of BASIC also has the BASIC pointers set, so it can be RUN.
+func (c *C64) LoadPRG(filename string) (uint16, uint16, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, 0, fmt.Errorf("[c64 LoadPRG] %w", err)
+	}
+	if len(data) < 2 {
+		return 0, 0, fmt.Errorf("[c64 LoadPRG] %s is too small to be a PRG", filename)
+	}
+	start := uint16(data[0]) | uint16(data[1])<<8
+	end := start + uint16(len(data)-2)
+	c.RAM.Write(start, data[2:]...)
+	c.Loaded(start, end)
+	return start, end, nil
+}
+
+// Loaded updates the KERNAL and BASIC pointers after a program has been placed in memory at start-end
+// by other means, such as a debugger, as a LOAD from BASIC would.
+func (c *C64) Loaded(start, end uint16) {
+	c.writeWord(loadEnd, end)
+	if start == BasicStart {
+		c.writeWord(txtTab, start)
+		c.writeWord(varTab, end)
+		c.writeWord(varTab+2, end) // Arrays
+		c.writeWord(varTab+4, end) // End of arrays
+	}
+}
+
+func (c *C64) writeWord(address, value uint16) {
+	c.RAM.Write(address, byte(value), byte(value>>8))
+}
+
+// videoBankSelect is CIA 2 with port A bits 0-1 wired, inverted, to the VIC-II's bank select lines.
+type videoBankSelect struct {
+	*cia.CIA
+	vic *vic.VIC
+}
+
+// Reset resets CIA 2, which makes port A an input so the pull-ups select bank 0.
+func (v *videoBankSelect) Reset() {
+	v.CIA.Reset()
+	v.vic.SetBank(int(^v.PortA() & 0x03))
+}
+
+// Write writes to CIA 2 and updates the VIC-II's bank from the port A pins.
+func (v *videoBankSelect) Write(address uint16, data ...byte) {
+	v.CIA.Write(address, data...)
+	v.vic.SetBank(int(^v.PortA() & 0x03))
+}
+
+// keyboardQueue feeds typed text to the KERNAL's keyboard buffer as the KERNAL empties it. It is clocked
+// by the bus but not mapped into memory.
+type keyboardQueue struct {
+	ram     *memory.Memory[uint16]
+	pending []byte
+}
+
+// Reset discards any text not yet typed.
+func (k *keyboardQueue) Reset() {
+	k.pending = nil
+}
+
+// Tick refills the keyboard buffer once it is empty.
+func (k *keyboardQueue) Tick() {
+	if len(k.pending) == 0 || k.ram.Read(keyCount) != 0 {
+		return
+	}
+	n := min(len(k.pending), keyBufferSz)
+	k.ram.Write(keyBuffer, k.pending[:n]...)
+	k.ram.Write(keyCount, byte(n))
+	k.pending = k.pending[n:]
+}
+
+// Read returns 0; the queue is not mapped into memory.
+func (k *keyboardQueue) Read(uint16) byte {
+	return 0
+}
+
+// Write does nothing; the queue is not mapped into memory.
+func (k *keyboardQueue) Write(uint16, ...byte) {}
@@ -0,0 +1,146 @@
+package c64
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/devices/iec"
+	"github.com/jrsteele09/go-6502-emulator/devices/vic"
+	"github.com/jrsteele09/go-6502-emulator/machine/c1541"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testROMs returns ROMs filled with a marker byte. The KERNAL's reset routine at $E000 stores $42 at
+// $0400 and loops.
+func testROMs() ROMs {
+	fill := func(size int, b byte) []byte {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = b
+		}
+		return data
+	}
+	roms := ROMs{
+		Basic:  fill(BasicROMSize, 0xBA),
+		Kernal: fill(KernalROMSize, 0xEA),
+		Char:   fill(CharROMSize, 0xCC),
+	}
+	copy(roms.Kernal, []byte{
+		0xA9, 0x42, //       LDA #$42
+		0x8D, 0x00, 0x04, // STA $0400
+		0x4C, 0x05, 0xE0, // JMP *
+	})
+	roms.Kernal[0x1FFC], roms.Kernal[0x1FFD] = 0x00, 0xE0 // Reset vector
+	return roms
+}
+
+func TestResetRunsKernal(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	assert.Equal(t, uint16(0xE000), c.CPU.Reg.PC)
+	require.NoError(t, c.RunCycles(20))
+	assert.Equal(t, byte(0x42), c.RAM.Read(0x0400))
+}
+
+func TestBanking(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	mem := c.Memory
+
+	assert.Equal(t, byte(0x17), mem.Read(0x0001), "port inputs read their pull-ups")
+	assert.Equal(t, byte(0xBA), mem.Read(0xA000))
+	assert.Equal(t, byte(0xEA), mem.Read(0xE100))
+
+	mem.Write(0xA000, 0x11)
+	assert.Equal(t, byte(0xBA), mem.Read(0xA000), "writes go to the RAM beneath the ROM")
+	assert.Equal(t, byte(0x11), c.RAM.Read(0xA000))
+
+	mem.Write(0x0000, 0x2F)
+	mem.Write(0x0001, 0x36) // BASIC out
+	assert.Equal(t, byte(0x11), mem.Read(0xA000))
+	assert.Equal(t, byte(0xEA), mem.Read(0xE100))
+
+	mem.Write(0x0001, 0x33) // Character ROM in place of I/O
+	assert.Equal(t, byte(0xCC), mem.Read(0xD000))
+
+	mem.Write(0x0001, 0x30) // All RAM
+	c.RAM.Write(0xD000, 0x22)
+	assert.Equal(t, byte(0x22), mem.Read(0xD000))
+	assert.Equal(t, byte(0x00), mem.Read(0xE100))
+	assert.Equal(t, byte(0x30), mem.Read(0x0001), "the cassette sense input reads its pull-up")
+}
+
+func TestIO(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	c.Memory.Write(ColorRAMAddress+1, 0x0E)
+	assert.Equal(t, byte(0x0E), c.ColorRAM.Read(1))
+	assert.Equal(t, byte(0x00), c.RAM.Read(ColorRAMAddress+1), "I/O writes do not reach RAM")
+
+	c.Memory.Write(SIDAddress+0x18, 0x0F)
+	require.Len(t, c.SID.Writes(), 1)
+	assert.Equal(t, byte(0x18), c.SID.Writes()[0].Register)
+}
+
+func TestVideoBankSelect(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	c.Memory.Write(CIA2Address+2, 0x03) // Port A bits 0-1 outputs
+	c.Memory.Write(CIA2Address, 0x00)
+	assert.Equal(t, 3, c.VIC.Bank())
+	c.Memory.Write(CIA2Address, 0x02)
+	assert.Equal(t, 1, c.VIC.Bank())
+}
+
+func TestSerialBusDrive(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	dos := make([]byte, c1541.ROMSize)
+	copy(dos, []byte{
+		0xA9, 0x1A, //       LDA #$1A
+		0x8D, 0x02, 0x18, // STA $1802      ; DATA, CLK and ATNA are outputs
+		0xA9, 0x00, //       LDA #$00
+		0x8D, 0x00, 0x18, // STA $1800      ; Release the lines
+		0x4C, 0x0A, 0xC0, // JMP *
+	})
+	dos[0x3FFC], dos[0x3FFD] = 0x00, 0xC0
+	drive, err := c.AttachDrive(dos, c1541.DefaultDevice)
+	require.NoError(t, err)
+	c.Reset()
+	c.Memory.Write(CIA2Address+2, 0x3F) // Port A bits 3-5 drive ATN, CLK and DATA
+	c.Memory.Write(CIA2Address, 0x00)
+	require.NoError(t, c.RunCycles(100))
+	assert.NotZero(t, c.Memory.Read(CIA2Address)&0x80, "DATA is released")
+
+	c.Memory.Write(CIA2Address, 0x08) // ATN low
+	assert.True(t, c.IEC.Low(iec.ATN))
+	require.NoError(t, c.RunCycles(100))
+	assert.Zero(t, c.Memory.Read(CIA2Address)&0x80, "the drive pulls DATA low to acknowledge ATN")
+	assert.NoError(t, drive.Err())
+}
+
+func TestNoROMs(t *testing.T) {
+	c := New(ROMs{}, vic.NTSC)
+	c.Memory.Write(0xE000, 0x55)
+	assert.Equal(t, byte(0x55), c.Memory.Read(0xE000), "missing ROMs leave RAM visible")
+	assert.Equal(t, uint64(vic.NTSC.ClockHz), c.ClockHz())
+}
+
+func TestLoadPRG(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	file := filepath.Join(t.TempDir(), "test.prg")
+	require.NoError(t, os.WriteFile(file, []byte{0x01, 0x08, 0x0B, 0x08, 0x0A, 0x00, 0x9E, 0x32, 0x30, 0x36, 0x31, 0x00, 0x00, 0x00}, 0o644))
+
+	start, end, err := c.LoadPRG(file)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(BasicStart), start)
+	assert.Equal(t, uint16(0x080D), end)
+	assert.Equal(t, byte(0x9E), c.RAM.Read(0x0805))
+	assert.Equal(t, []byte{0x0D, 0x08}, []byte{c.RAM.Read(varTab), c.RAM.Read(varTab + 1)})
+}
+
+func TestType(t *testing.T) {
+	c := New(testROMs(), vic.PAL)
+	c.Type("run\n")
+	_, err := c.Step()
+	require.NoError(t, err)
+	assert.Equal(t, byte(4), c.RAM.Read(keyCount))
+	assert.Equal(t, []byte("RUN\r"), []byte{c.RAM.Read(keyBuffer), c.RAM.Read(keyBuffer + 1), c.RAM.Read(keyBuffer + 2), c.RAM.Read(keyBuffer + 3)})
+}
@@ -0,0 +1,482 @@
+package c64
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler/output"
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// KERNAL jump table entries handled by KernalTraps.
+const (
+	SETLFS = 0xFFBA
+	SETNAM = 0xFFBD
+	CHRIN  = 0xFFCF
+	CHROUT = 0xFFD2
+	LOAD   = 0xFFD5
+	SAVE   = 0xFFD8
+	SETTIM = 0xFFDB
+	RDTIM  = 0xFFDE
+	GETIN  = 0xFFE4
+	PLOT   = 0xFFF0
+)
+
+// KERNAL variables, kept in zero page where the ROM keeps them so programs that read them still work.
+const (
+	zpStatus       = 0x90
+	zpJiffyClock   = 0xA0 // Three bytes, most significant first
+	zpFileNameLen  = 0xB7
+	zpLogicalFile  = 0xB8
+	zpSecondary    = 0xB9
+	zpDevice       = 0xBA
+	zpFileName     = 0xBB // Pointer to the file name
+	zpCursorColumn = 0xD3
+	zpCursorRow    = 0xD6
+)
+
+// KERNAL error codes, returned in A with carry set.
+const (
+	kernalErrFileNotFound     = 4
+	kernalErrDeviceNotPresent = 5
+	kernalErrMissingFileName  = 8
+	kernalErrIllegalDevice    = 9
+)
+
+const (
+	statusEOF          = 0x40
+	statusVerifyError  = 0x10
+	screenColumns      = 40
+	screenRows         = 25
+	jiffiesPerSecond   = 60
+	jiffyClockWrap     = 0x4F1A01 // 24 hours of jiffies
+	petsciiReturn      = 0x0D
+	petsciiHome        = 0x13
+	petsciiClearScreen = 0x93
+	hostFileExtension  = ".prg"
+)
+
+// kernalError is a KERNAL error code with the reason it was returned.
+type kernalError struct {
+	code byte
+	err  error
+}
+
+func (e *kernalError) Error() string {
+	return e.err.Error()
+}
+
+// KernalTraps implements the commonly used KERNAL routines in Go, so programs that call them through
+// the jump table run without a KERNAL ROM. Screen output goes to a host writer and keyboard input comes
+// from the keyboard buffer (see C64.Type), then a host reader. LOAD and SAVE use a D64 image when one
+// is inserted, otherwise a host directory; files in the directory are matched without their .prg
+// extension and regardless of case.
+type KernalTraps struct {
+	out      io.Writer
+	keys     chan byte // Host input, read ahead on a goroutine; nil without input, closed at its end
+	root     *os.Root
+	disk     []byte
+	diskFile string
+	d64      *output.D64Format
+	clockHz  uint64
+	jiffies  int64 // Offset set by SETTIM
+}
+
+// NewKernalTraps creates the KERNAL routines with screen output written to out and keyboard input read
+// from in, which may be nil. Input is read on a background goroutine, so that GETIN can return at once
+// when no key has been typed, as it does on the real machine.
+func NewKernalTraps(out io.Writer, in io.Reader) *KernalTraps {
+	k := &KernalTraps{out: out, d64: &output.D64Format{}}
+	if in != nil {
+		k.keys = make(chan byte, 256)
+		go k.readLoop(in)
+	}
+	return k
+}
+
+// readLoop queues the host input for CHRIN and GETIN, converted to PETSCII, until it ends.
+func (k *KernalTraps) readLoop(in io.Reader) {
+	defer close(k.keys)
+	r := bufio.NewReader(in)
+	for {
+		ch, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if b, ok := asciiToPETSCII(ch); ok {
+			k.keys <- b
+		}
+	}
+}
+
+// SetDirectory makes LOAD and SAVE use files beneath dir. Programs cannot reach files outside it.
+func (k *KernalTraps) SetDirectory(dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("[c64 SetDirectory] %w", err)
+	}
+	if k.root != nil {
+		k.root.Close()
+	}
+	k.root = root
+	return nil
+}
+
+// InsertDisk makes LOAD and SAVE use a D64 image in place of the directory. Saved files are written back
+// to the image file.
+func (k *KernalTraps) InsertDisk(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("[c64 InsertDisk] %w", err)
+	}
+	if len(data) != output.D64TotalSize {
+		return fmt.Errorf("[c64 InsertDisk] %s is not a D64 image", filename)
+	}
+	k.disk, k.diskFile = data, filename
+	return nil
+}
+
+// Close closes the directory.
+func (k *KernalTraps) Close() error {
+	if k.root == nil {
+		return nil
+	}
+	return k.root.Close()
+}
+
+// Install traps the KERNAL jump table entries on the machine's bus. The traps take the place of the
+// ROM's routines whether or not a KERNAL ROM is present.
+func (k *KernalTraps) Install(c *C64) {
+	k.clockHz = c.ClockHz()
+	for address, call := range map[uint16]func(*cpu.CPU){
+		SETLFS: k.setlfs,
+		SETNAM: k.setnam,
+		CHRIN:  k.chrin,
+		CHROUT: k.chrout,
+		LOAD:   k.load,
+		SAVE:   k.save,
+		SETTIM: k.settim,
+		RDTIM:  k.rdtim,
+		GETIN:  k.getin,
+		PLOT:   k.plot,
+	} {
+		c.SetTrap(address, returning(call))
+	}
+}
+
+func returning(call func(c *cpu.CPU)) devices.Trap {
+	return func(c *cpu.CPU) error {
+		call(c)
+		devices.ReturnFromSubroutine(c)
+		return nil
+	}
+}
+
+// setlfs: A = logical file, X = device, Y = secondary address.
+func (k *KernalTraps) setlfs(c *cpu.CPU) {
+	mem := c.Memory()
+	mem.Write(zpLogicalFile, c.Reg.A)
+	mem.Write(zpDevice, c.Reg.X)
+	mem.Write(zpSecondary, c.Reg.Y)
+}
+
+// setnam: A = name length, X/Y = name address.
+func (k *KernalTraps) setnam(c *cpu.CPU) {
+	mem := c.Memory()
+	mem.Write(zpFileNameLen, c.Reg.A)
+	mem.Write(zpFileName, c.Reg.X, c.Reg.Y)
+}
+
+// chrout prints the PETSCII character in A and moves the cursor.
+func (k *KernalTraps) chrout(c *cpu.CPU) {
+	mem := c.Memory()
+	column, row := mem.Read(zpCursorColumn), mem.Read(zpCursorRow)
+	switch b := c.Reg.A; b {
+	case petsciiReturn:
+		column, row = 0, row+1
+	case petsciiHome, petsciiClearScreen:
+		column, row = 0, 0
+	default:
+		if _, ok := petsciiToASCII(b); ok {
+			column++
+		}
+	}
+	if column >= screenColumns {
+		column, row = 0, row+1
+	}
+	mem.Write(zpCursorColumn, column)
+	mem.Write(zpCursorRow, min(row, screenRows-1))
+
+	if ch, ok := petsciiToASCII(c.Reg.A); ok && k.out != nil {
+		k.out.Write([]byte{ch})
+	}
+	c.Reg.SetStatus(cpu.CarryFlag, false)
+}
+
+// chrin returns the next character of the input line in A, RETURN at the end of the line or input. Like
+// the screen editor, it waits for the line to be typed.
+func (k *KernalTraps) chrin(c *cpu.CPU) {
+	b, ok := k.nextKey(c.Memory(), true)
+	if !ok {
+		b = petsciiReturn
+	}
+	k.returnKey(c, b)
+}
+
+// getin returns the next key in A, or 0 at once when none has been typed.
+func (k *KernalTraps) getin(c *cpu.CPU) {
+	b, _ := k.nextKey(c.Memory(), false)
+	k.returnKey(c, b)
+}
+
+// returnKey returns a key in A with the flags set from it, as loading A does, so that a BEQ after GETIN
+// waits for a key.
+func (k *KernalTraps) returnKey(c *cpu.CPU, b byte) {
+	c.Reg.A = b
+	c.Reg.SetZeroFlag(b)
+	c.Reg.SetNegativeFlag(b)
+	c.Reg.SetStatus(cpu.CarryFlag, false)
+}
+
+// nextKey takes a key from the keyboard buffer, or failing that from the host input. If wait is set it
+// waits for host input to arrive, otherwise it returns false when none has.
+func (k *KernalTraps) nextKey(mem memory.Operations[uint16], wait bool) (byte, bool) {
+	if n := mem.Read(keyCount); n > 0 {
+		b := mem.Read(keyBuffer)
+		for i := uint16(1); i < uint16(min(n, keyBufferSz)); i++ {
+			mem.Write(keyBuffer+i-1, mem.Read(keyBuffer+i))
+		}
+		mem.Write(keyCount, n-1)
+		return b, true
+	}
+	if k.keys == nil {
+		return 0, false
+	}
+	if wait {
+		b, ok := <-k.keys
+		return b, ok
+	}
+	select {
+	case b, ok := <-k.keys:
+		return b, ok
+	default:
+		return 0, false
+	}
+}
+
+// plot reads the cursor position into X (row) and Y (column) when carry is set, or moves it there
+// when carry is clear.
+func (k *KernalTraps) plot(c *cpu.CPU) {
+	mem := c.Memory()
+	if c.Reg.IsSet(cpu.CarryFlag) {
+		c.Reg.X, c.Reg.Y = mem.Read(zpCursorRow), mem.Read(zpCursorColumn)
+		return
+	}
+	mem.Write(zpCursorRow, min(c.Reg.X, screenRows-1))
+	mem.Write(zpCursorColumn, min(c.Reg.Y, screenColumns-1))
+}
+
+// rdtim returns the jiffy clock, in 60ths of a second, in A (low), X and Y (high).
+func (k *KernalTraps) rdtim(c *cpu.CPU) {
+	t := k.jiffyClock(c)
+	c.Reg.A, c.Reg.X, c.Reg.Y = byte(t), byte(t>>8), byte(t>>16)
+	c.Memory().Write(zpJiffyClock, c.Reg.Y, c.Reg.X, c.Reg.A)
+}
+
+// settim sets the jiffy clock from A (low), X and Y (high).
+func (k *KernalTraps) settim(c *cpu.CPU) {
+	t := int64(c.Reg.A) | int64(c.Reg.X)<<8 | int64(c.Reg.Y)<<16
+	k.jiffies += t - int64(k.jiffyClock(c))
+	c.Memory().Write(zpJiffyClock, c.Reg.Y, c.Reg.X, c.Reg.A)
+}
+
+// jiffyClock returns the time since power on, or since SETTIM, in jiffies, derived from the CPU's cycles.
+func (k *KernalTraps) jiffyClock(c *cpu.CPU) uint32 {
+	t := int64(c.Cycles()*jiffiesPerSecond/k.clockHz) + k.jiffies
+	return uint32(((t % jiffyClockWrap) + jiffyClockWrap) % jiffyClockWrap)
+}
+
+// load loads (A = 0) or verifies (A = 1) the file named by SETNAM from the device set by SETLFS. With
+// secondary address 0 the file goes to X/Y, otherwise to its own load address. The end address is
+// returned in X/Y.
+func (k *KernalTraps) load(c *cpu.CPU) {
+	mem := c.Memory()
+	data, err := k.readFile(mem)
+	if err == nil && len(data) < 2 {
+		err = &kernalError{kernalErrFileNotFound, errors.New("file too short")}
+	}
+	if err != nil {
+		k.fail(c, err)
+		return
+	}
+
+	start := uint16(data[0]) | uint16(data[1])<<8
+	if mem.Read(zpSecondary) == 0 {
+		start = uint16(c.Reg.X) | uint16(c.Reg.Y)<<8
+	}
+	status := byte(statusEOF)
+	for i, b := range data[2:] {
+		address := start + uint16(i)
+		if c.Reg.A == 0 {
+			mem.Write(address, b)
+		} else if mem.Read(address) != b {
+			status |= statusVerifyError
+		}
+	}
+	end := start + uint16(len(data)-2)
+	mem.Write(zpStatus, status)
+	mem.Write(loadEnd, byte(end), byte(end>>8))
+	c.Reg.X, c.Reg.Y = byte(end), byte(end>>8)
+	c.Reg.SetStatus(cpu.CarryFlag, false)
+}
+
+// save saves memory from the address in the zero page pointer at A up to, but not including, X/Y to the
+// file named by SETNAM on the device set by SETLFS.
+func (k *KernalTraps) save(c *cpu.CPU) {
+	mem := c.Memory()
+	start := uint16(mem.Read(uint16(c.Reg.A))) | uint16(mem.Read(uint16(c.Reg.A+1)))<<8
+	end := uint16(c.Reg.X) | uint16(c.Reg.Y)<<8
+	data := []byte{byte(start), byte(start >> 8)}
+	for address := start; address != end; address++ {
+		data = append(data, mem.Read(address))
+	}
+	if err := k.writeFile(mem, data); err != nil {
+		k.fail(c, err)
+		return
+	}
+	mem.Write(zpStatus, 0)
+	c.Reg.SetStatus(cpu.CarryFlag, false)
+}
+
+// fail returns a KERNAL error code in A with carry set.
+func (k *KernalTraps) fail(c *cpu.CPU, err error) {
+	code := byte(kernalErrDeviceNotPresent)
+	var ke *kernalError
+	if errors.As(err, &ke) {
+		code = ke.code
+	}
+	c.Reg.A = code
+	c.Reg.SetStatus(cpu.CarryFlag, true)
+}
+
+// fileName returns the name set by SETNAM without a drive prefix such as "0:".
+func (k *KernalTraps) fileName(mem memory.Operations[uint16]) (string, error) {
+	n := mem.Read(zpFileNameLen)
+	address := uint16(mem.Read(zpFileName)) | uint16(mem.Read(zpFileName+1))<<8
+	name := make([]byte, n)
+	for i := range name {
+		name[i] = mem.Read(address + uint16(i))
+	}
+	if i := strings.IndexByte(string(name), ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	if len(name) == 0 {
+		return "", &kernalError{kernalErrMissingFileName, errors.New("missing file name")}
+	}
+	return string(name), nil
+}
+
+// checkDevice checks the device set by SETLFS is storage: the tape (1) or a disk drive (8-11).
+func (k *KernalTraps) checkDevice(mem memory.Operations[uint16]) error {
+	switch device := mem.Read(zpDevice); {
+	case device == 1 || (device >= 8 && device <= 11):
+		if k.disk == nil && k.root == nil {
+			return &kernalError{kernalErrDeviceNotPresent, errors.New("no disk or directory")}
+		}
+		return nil
+	default:
+		return &kernalError{kernalErrIllegalDevice, fmt.Errorf("device %d cannot load or save", device)}
+	}
+}
+
+// readFile reads the file named by SETNAM, including its load address.
+func (k *KernalTraps) readFile(mem memory.Operations[uint16]) ([]byte, error) {
+	if err := k.checkDevice(mem); err != nil {
+		return nil, err
+	}
+	name, err := k.fileName(mem)
+	if err != nil {
+		return nil, err
+	}
+	if k.disk != nil {
+		_, data, err := k.d64.ReadFile(k.disk, name)
+		if err != nil {
+			return nil, &kernalError{kernalErrFileNotFound, err}
+		}
+		return data, nil
+	}
+
+	dir, err := k.root.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	for _, e := range entries {
+		hostName := strings.ToUpper(strings.TrimSuffix(strings.ToLower(e.Name()), hostFileExtension))
+		if e.Type().IsRegular() && output.MatchD64Name(strings.ToUpper(name), hostName) {
+			return k.root.ReadFile(e.Name())
+		}
+	}
+	return nil, &kernalError{kernalErrFileNotFound, fmt.Errorf("file not found: %s", name)}
+}
+
+// writeFile writes a file named by SETNAM. Host files are named in lower case with a .prg extension.
+func (k *KernalTraps) writeFile(mem memory.Operations[uint16], data []byte) error {
+	if err := k.checkDevice(mem); err != nil {
+		return err
+	}
+	name, err := k.fileName(mem)
+	if err != nil {
+		return err
+	}
+	if k.disk != nil {
+		if err := k.d64.WriteFile(k.disk, name, data); err != nil {
+			return err
+		}
+		return os.WriteFile(k.diskFile, k.disk, 0644)
+	}
+	return k.root.WriteFile(strings.ToLower(name)+hostFileExtension, data, 0644)
+}
+
+// petsciiToASCII converts a printable PETSCII character, in the upper case character set, to ASCII.
+// ASCII lower case letters, as an assembler emits for text, are passed through.
+func petsciiToASCII(b byte) (byte, bool) {
+	switch {
+	case b == petsciiReturn:
+		return '\n', true
+	case b >= 0x20 && b <= 0x5D, b >= 'a' && b <= 'z':
+		return b, true
+	case b == 0x5E: // Up arrow
+		return '^', true
+	case b == 0x5F: // Left arrow
+		return '_', true
+	case b >= 0xC1 && b <= 0xDA: // Shifted letters
+		return b - 0xC1 + 'A', true
+	}
+	return 0, false
+}
+
+// asciiToPETSCII converts a host key to PETSCII, as typed on a C64 with lower case folded to upper case.
+func asciiToPETSCII(ch byte) (byte, bool) {
+	switch {
+	case ch == '\n':
+		return petsciiReturn, true
+	case ch >= 'a' && ch <= 'z':
+		return ch - 'a' + 'A', true
+	case ch >= 0x20 && ch < 0x7F:
+		return ch, true
+	}
+	return 0, false
+}
@@ -0,0 +1,201 @@
+package c64
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/assembler/output"
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices/vic"
+	"github.com/jrsteele09/go-6502-emulator/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	callerAddress = 0xC000
+	nameAddress   = 0xC100
+)
+
+func newTrappedC64(t *testing.T, out io.Writer, in string) (*C64, *KernalTraps) {
+	c := New(ROMs{}, vic.PAL)
+	k := NewKernalTraps(out, strings.NewReader(in))
+	k.Install(c)
+	t.Cleanup(func() { k.Close() })
+	return c, k
+}
+
+// jsr calls a routine from callerAddress and runs until it returns.
+func jsr(t *testing.T, c *C64, address uint16, a, x, y byte, carry bool) {
+	c.Memory.Write(callerAddress, 0x20, byte(address), byte(address>>8), 0x4C, 0x03, 0xC0) // JSR address; JMP *
+	c.CPU.Reg.A, c.CPU.Reg.X, c.CPU.Reg.Y = a, x, y
+	c.CPU.Reg.SetStatus(cpu.CarryFlag, carry)
+	c.CPU.Reg.PC = callerAddress
+	for i := 0; c.CPU.Reg.PC != callerAddress+3; i++ {
+		require.Less(t, i, 100, "routine did not return")
+		require.NoError(t, c.StepInstruction())
+	}
+}
+
+func setFile(t *testing.T, c *C64, device, secondary byte, name string) {
+	c.Memory.Write(nameAddress, []byte(name)...)
+	jsr(t, c, SETLFS, 1, device, secondary, false)
+	jsr(t, c, SETNAM, byte(len(name)), nameAddress&0xFF, nameAddress>>8, false)
+}
+
+func TestCHROUTWithoutROM(t *testing.T) {
+	var out bytes.Buffer
+	c, _ := newTrappedC64(t, &out, "")
+	c.Memory.Write(0x1000,
+		0xA2, 0x00, //       LDX #0
+		0xBD, 0x10, 0x10, // LDA $1010,X
+		0xF0, 0x06, //       BEQ $100D
+		0x20, 0xD2, 0xFF, // JSR CHROUT
+		0xE8,       //       INX
+		0xD0, 0xF5, //       BNE $1002
+		0x4C, 0x0D, 0x10, // JMP *
+	)
+	c.Memory.Write(0x1010, 'H', 'E', 'L', 'L', 'O', 0x0D, 'a', 0x00)
+	c.CPU.Reg.PC = 0x1000
+	require.NoError(t, c.RunCycles(2000))
+
+	assert.Equal(t, "HELLO\na", out.String())
+	assert.Equal(t, byte(1), c.Memory.Read(zpCursorRow))
+	assert.Equal(t, byte(1), c.Memory.Read(zpCursorColumn))
+}
+
+func TestPLOT(t *testing.T) {
+	c, _ := newTrappedC64(t, nil, "")
+	jsr(t, c, PLOT, 0, 10, 20, false)
+	jsr(t, c, PLOT, 0, 0, 0, true)
+	assert.Equal(t, byte(10), c.CPU.Reg.X)
+	assert.Equal(t, byte(20), c.CPU.Reg.Y)
+}
+
+func TestKeyboardInput(t *testing.T) {
+	c, _ := newTrappedC64(t, nil, "hi\n")
+	c.Type("X")
+	require.NoError(t, c.StepInstruction())
+
+	jsr(t, c, GETIN, 0, 0, 0, false)
+	assert.Equal(t, byte('X'), c.CPU.Reg.A, "the keyboard buffer is read first")
+	assert.False(t, c.CPU.Reg.IsSet(cpu.ZeroFlag))
+	jsr(t, c, CHRIN, 0, 0, 0, false)
+	assert.Equal(t, byte('H'), c.CPU.Reg.A, "host input is folded to upper case")
+	jsr(t, c, CHRIN, 0, 0, 0, false)
+	assert.Equal(t, byte('I'), c.CPU.Reg.A)
+	jsr(t, c, CHRIN, 0, 0, 0, false)
+	assert.Equal(t, byte(0x0D), c.CPU.Reg.A)
+	jsr(t, c, CHRIN, 0, 0, 0, false)
+	assert.Equal(t, byte(0x0D), c.CPU.Reg.A, "RETURN at the end of the input")
+	jsr(t, c, GETIN, 0xFF, 0, 0, false)
+	assert.Equal(t, byte(0), c.CPU.Reg.A, "no key")
+	assert.True(t, c.CPU.Reg.IsSet(cpu.ZeroFlag))
+}
+
+func TestGETINDoesNotWait(t *testing.T) {
+	in, typing := io.Pipe()
+	defer typing.Close()
+	c := New(ROMs{}, vic.PAL)
+	k := NewKernalTraps(nil, in)
+	k.Install(c)
+	defer k.Close()
+
+	c.Memory.Write(0x1000,
+		0x20, 0xE4, 0xFF, // JSR GETIN
+		0xF0, 0xFB, //       BEQ $1000
+		0x8D, 0x00, 0x20, // STA $2000
+		0x4C, 0x08, 0x10, // JMP *
+	)
+	c.CPU.Reg.PC = 0x1000
+	require.NoError(t, c.RunCycles(10000))
+	assert.Equal(t, byte(0), c.Memory.Read(0x2000), "the loop polls while no key is typed")
+
+	go typing.Write([]byte("k"))
+	require.Eventually(t, func() bool {
+		return c.RunCycles(1000) == nil && c.Memory.Read(0x2000) == 'K'
+	}, 2*time.Second, time.Millisecond)
+}
+
+func TestRDTIM(t *testing.T) {
+	c, _ := newTrappedC64(t, nil, "")
+	c.Memory.Write(0x1000, 0x4C, 0x00, 0x10) // JMP *
+	c.CPU.Reg.PC = 0x1000
+	require.NoError(t, c.RunCycles(vic.PAL.ClockHz))
+
+	jsr(t, c, RDTIM, 0, 0, 0, false)
+	assert.Equal(t, byte(60), c.CPU.Reg.A, "a second is 60 jiffies")
+	assert.Equal(t, byte(60), c.Memory.Read(zpJiffyClock+2))
+
+	jsr(t, c, SETTIM, 0x00, 0x10, 0x00, false)
+	jsr(t, c, RDTIM, 0, 0, 0, false)
+	assert.Equal(t, []byte{0x00, 0x10, 0x00}, []byte{c.CPU.Reg.A, c.CPU.Reg.X, c.CPU.Reg.Y})
+}
+
+func TestSaveAndLoadHostFile(t *testing.T) {
+	dir := t.TempDir()
+	c, k := newTrappedC64(t, nil, "")
+	require.NoError(t, k.SetDirectory(dir))
+
+	c.Memory.Write(0x2000, 1, 2, 3, 4)
+	c.Memory.Write(0xFB, 0x00, 0x20)
+	setFile(t, c, 8, 0, "TEST")
+	jsr(t, c, SAVE, 0xFB, 0x04, 0x20, false)
+	require.False(t, c.CPU.Reg.IsSet(cpu.CarryFlag))
+	data, err := os.ReadFile(filepath.Join(dir, "test.prg"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x20, 1, 2, 3, 4}, data)
+
+	c.Memory.Write(0x2000, 0, 0, 0, 0)
+	setFile(t, c, 8, 1, "T*")
+	jsr(t, c, LOAD, 0, 0, 0, false)
+	require.False(t, c.CPU.Reg.IsSet(cpu.CarryFlag))
+	assert.Equal(t, byte(4), c.Memory.Read(0x2003), "secondary address 1 loads at the file's address")
+	assert.Equal(t, []byte{0x04, 0x20}, []byte{c.CPU.Reg.X, c.CPU.Reg.Y})
+
+	setFile(t, c, 8, 0, "TEST")
+	jsr(t, c, LOAD, 0, 0x00, 0x40, false)
+	assert.Equal(t, byte(4), c.Memory.Read(0x4003), "secondary address 0 loads at X/Y")
+
+	setFile(t, c, 8, 0, "MISSING")
+	jsr(t, c, LOAD, 0, 0x00, 0x40, false)
+	assert.True(t, c.CPU.Reg.IsSet(cpu.CarryFlag))
+	assert.Equal(t, byte(kernalErrFileNotFound), c.CPU.Reg.A)
+
+	setFile(t, c, 3, 0, "TEST")
+	jsr(t, c, LOAD, 0, 0x00, 0x40, false)
+	assert.Equal(t, byte(kernalErrIllegalDevice), c.CPU.Reg.A)
+}
+
+func TestSaveAndLoadD64(t *testing.T) {
+	disk, err := output.NewD64Format("DISK", "01").CreateData([]assembler.AssembledData{
+		{StartAddress: 0x0801, Data: utils.Value(bytes.NewBuffer([]byte{0xAA, 0xBB}))},
+	})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "disk.d64")
+	require.NoError(t, os.WriteFile(file, disk, 0644))
+
+	c, k := newTrappedC64(t, nil, "")
+	require.NoError(t, k.InsertDisk(file))
+	setFile(t, c, 8, 1, "0:PROG*")
+	jsr(t, c, LOAD, 0, 0, 0, false)
+	require.False(t, c.CPU.Reg.IsSet(cpu.CarryFlag))
+	assert.Equal(t, byte(0xBB), c.Memory.Read(0x0802))
+
+	c.Memory.Write(0xFB, 0x01, 0x08)
+	setFile(t, c, 8, 0, "COPY")
+	jsr(t, c, SAVE, 0xFB, 0x03, 0x08, false)
+	require.False(t, c.CPU.Reg.IsSet(cpu.CarryFlag))
+
+	saved, err := os.ReadFile(file)
+	require.NoError(t, err)
+	_, data, err := (&output.D64Format{}).ReadFile(saved, "COPY")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x08, 0xAA, 0xBB}, data)
+}
@@ -0,0 +1,138 @@
+package c64
+
+import (
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+// Processor port bits in $01 that select the memory configuration.
+const (
+	portLORAM  byte = 0x01 // BASIC ROM at $A000
+	portHIRAM  byte = 0x02 // KERNAL ROM at $E000
+	portCHAREN byte = 0x04 // I/O (1) or the character ROM (0) at $D000
+
+	// portPullUps are the port lines that read high when configured as inputs: the three banking
+	// lines and the cassette sense line (no button pressed).
+	portPullUps byte = 0x17
+)
+
+// Address ranges that can be banked.
+const (
+	basicStart  = 0xA000
+	basicEnd    = 0xBFFF
+	ioStart     = 0xD000
+	ioEnd       = 0xDFFF
+	kernalStart = 0xE000
+)
+
+// Ensure PLA implements the Device interface.
+var _ devices.Device = &PLA{}
+
+// PLA is the C64's memory banking logic together with the 6510's on-chip processor port at $00/$01.
+// It is the CPU's view of memory: RAM, with BASIC, the KERNAL, the character ROM or the I/O area
+// banked over it according to the port. Writes to a ROM area go to the RAM beneath. A missing ROM
+// leaves the RAM beneath visible.
+type PLA struct {
+	ram     *memory.Memory[uint16]
+	basic   *memory.ROM[uint16]
+	kernal  *memory.ROM[uint16]
+	charROM *memory.ROM[uint16]
+	io      *memory.MemoryMap[uint16]
+	ddr     byte
+	port    byte
+}
+
+// NewPLA creates the banking logic over 64K of RAM, with I/O devices mapped into io at their absolute
+// addresses ($D000-$DFFF). Any of the ROMs may be nil.
+func NewPLA(ram *memory.Memory[uint16], basic, kernal, charROM []byte, io *memory.MemoryMap[uint16]) *PLA {
+	p := &PLA{ram: ram, io: io}
+	if basic != nil {
+		p.basic = memory.NewROM[uint16](basic)
+	}
+	if kernal != nil {
+		p.kernal = memory.NewROM[uint16](kernal)
+	}
+	if charROM != nil {
+		p.charROM = memory.NewROM[uint16](charROM)
+	}
+	return p
+}
+
+// Reset makes every port line an input, so the pull-ups select BASIC, the KERNAL and I/O.
+func (p *PLA) Reset() {
+	p.ddr, p.port = 0, 0
+}
+
+// Tick does nothing; banking follows the processor port as soon as it is written.
+func (p *PLA) Tick() {}
+
+// Port returns the processor port as read at $01: output lines read back what was written and input
+// lines read their pull-ups.
+func (p *PLA) Port() byte {
+	return p.port&p.ddr | portPullUps&^p.ddr
+}
+
+// lines returns the levels on the banking lines, with inputs pulled high.
+func (p *PLA) lines() byte {
+	return (p.port | ^p.ddr) & (portLORAM | portHIRAM | portCHAREN)
+}
+
+// Read reads a byte as the CPU sees it in the current memory configuration.
+func (p *PLA) Read(address uint16) byte {
+	switch address {
+	case 0x0000:
+		return p.ddr
+	case 0x0001:
+		return p.Port()
+	}
+	if ops, start := p.bank(address); ops != nil {
+		return ops.Read(address - start)
+	}
+	return p.ram.Read(address)
+}
+
+// Write writes data as the CPU does: the processor port at $00/$01, I/O when it is banked in, and RAM
+// everywhere else, including beneath the ROMs.
+func (p *PLA) Write(address uint16, data ...byte) {
+	for i, b := range data {
+		a := address + uint16(i)
+		switch a {
+		case 0x0000:
+			p.ddr = b
+		case 0x0001:
+			p.port = b
+		}
+		if ops, _ := p.bank(a); ops == p.io {
+			p.io.Write(a, b)
+			continue
+		}
+		p.ram.Write(a, b)
+	}
+}
+
+// bank returns what is banked in at address and the address it starts at, or nil for RAM.
+// The I/O map is addressed absolutely, so its start is 0.
+func (p *PLA) bank(address uint16) (memory.Operations[uint16], uint16) {
+	lines := p.lines()
+	switch {
+	case address >= basicStart && address <= basicEnd:
+		if lines&(portLORAM|portHIRAM) == portLORAM|portHIRAM && p.basic != nil {
+			return p.basic, basicStart
+		}
+	case address >= ioStart && address <= ioEnd:
+		if lines&(portLORAM|portHIRAM) == 0 {
+			return nil, 0
+		}
+		if lines&portCHAREN != 0 {
+			return p.io, 0
+		}
+		if p.charROM != nil {
+			return p.charROM, ioStart
+		}
+	case address >= kernalStart:
+		if lines&portHIRAM != 0 && p.kernal != nil {
+			return p.kernal, kernalStart
+		}
+	}
+	return nil, 0
+}
@@ -0,0 +1,94 @@
+package c64
+
+import (
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/iec"
+	"github.com/jrsteele09/go-6502-emulator/machine/c1541"
+)
+
+// CIA 2 port A: the serial bus. Outputs pull a line low through an inverter; inputs read the lines
+// directly.
+const (
+	serialATNOut   byte = 0x08
+	serialClockOut byte = 0x10
+	serialDataOut  byte = 0x20
+	serialClockIn  byte = 0x40
+	serialDataIn   byte = 0x80
+)
+
+// serialPort connects CIA 2 port A to the serial bus.
+type serialPort struct {
+	bus *iec.Bus
+}
+
+// Ports drives ATN, CLK and DATA from the outputs and reads CLK and DATA.
+func (s *serialPort) Ports(pa, pb byte) (byte, byte) {
+	var pull byte
+	if pa&serialATNOut != 0 {
+		pull |= iec.ATN
+	}
+	if pa&serialClockOut != 0 {
+		pull |= iec.Clock
+	}
+	if pa&serialDataOut != 0 {
+		pull |= iec.Data
+	}
+	s.bus.Pull(s, pull)
+
+	if s.bus.Low(iec.Clock) {
+		pa &^= serialClockIn
+	}
+	if s.bus.Low(iec.Data) {
+		pa &^= serialDataIn
+	}
+	return pa, pb
+}
+
+// AttachDrive creates a 1541 drive running the given DOS ROM as a device on the serial bus and clocks
+// it with the machine, converting the machine's clock to the drive's 1MHz.
+func (c *C64) AttachDrive(rom []byte, device int) (*c1541.Drive, error) {
+	d, err := c1541.New(rom, c.IEC, device)
+	if err != nil {
+		return nil, err
+	}
+	c.AddDevice(&driveClock{drive: d, hostHz: c.Model.ClockHz})
+	return d, nil
+}
+
+// driveClock runs a drive in step with the machine. It is clocked by the bus but not mapped into memory.
+type driveClock struct {
+	drive  *c1541.Drive
+	hostHz uint64
+	cycles uint64 // Drive cycles owed, in units of 1/hostHz
+}
+
+// Ensure driveClock is inspectable by the debugger.
+var _ devices.Inspectable = &driveClock{}
+
+// Tick runs the drive for the cycles that have passed since the last machine cycle.
+func (d *driveClock) Tick() {
+	d.cycles += c1541.ClockHz
+	for d.cycles >= d.hostHz {
+		d.cycles -= d.hostHz
+		d.drive.Tick()
+	}
+}
+
+// Reset resets the drive with the machine, as the shared reset line on the serial bus does.
+func (d *driveClock) Reset() {
+	d.cycles = 0
+	d.drive.Reset()
+}
+
+// Read returns 0; the drive is not mapped into memory.
+func (d *driveClock) Read(uint16) byte {
+	return 0
+}
+
+// Write does nothing; the drive is not mapped into memory.
+func (d *driveClock) Write(uint16, ...byte) {}
+
+// Inspect describes the drive.
+func (d *driveClock) Inspect() string {
+	return d.drive.Inspect()
+}
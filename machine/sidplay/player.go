@@ -0,0 +1,180 @@
+// Package sidplay plays PSID tunes headlessly: it loads a tune into a 6502 with a SID and CIA 1, calls
+// the tune's init routine with a song number and its play routine once per frame, so the SID's register
+// log can be saved or rendered to audio.
+package sidplay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler/output"
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/devices/cia"
+	"github.com/jrsteele09/go-6502-emulator/devices/sid"
+	"github.com/jrsteele09/go-6502-emulator/devices/vic"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+const (
+	memorySize = 64 * 1024
+
+	// SIDAddress and CIAAddress are where the chips are mapped, as on the C64.
+	SIDAddress = 0xD400
+	CIAAddress = 0xDC00
+
+	// returnAddress is where init and play return to. It is trapped, so nothing there is executed.
+	returnAddress = 0x0000
+
+	initialStackAddr = 0xFF
+)
+
+// ErrCallTimeout is returned when init or play does not return within a second of emulated time.
+var ErrCallTimeout = errors.New("subroutine did not return")
+
+// Player is a 6502 with 64K of RAM, a SID and CIA 1 running a PSID tune.
+type Player struct {
+	*devices.Bus
+	SID         *sid.SID
+	CIA         *cia.CIA
+	Header      output.SIDHeader
+	clockHz     uint64
+	frameCycles uint64
+	song        int
+	returned    bool
+}
+
+// Load reads a PSID file and creates a player for it.
+func Load(filename string) (*Player, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("[sidplay Load] %w", err)
+	}
+	return New(data)
+}
+
+// New creates a player for a PSID file's contents. RSID tunes, which expect a complete C64, and tunes
+// without a play address, which install their own interrupt handler, are not supported.
+func New(data []byte) (*Player, error) {
+	header, segments, err := output.ParseSID(data)
+	if err != nil {
+		return nil, fmt.Errorf("[sidplay New] %w", err)
+	}
+	if header.Magic == output.RSIDMagic {
+		return nil, fmt.Errorf("[sidplay New] RSID tunes need a complete C64 and are not supported")
+	}
+	if header.PlayAddress == 0 {
+		return nil, fmt.Errorf("[sidplay New] tunes without a play address are not supported")
+	}
+
+	clockHz, model := uint64(sid.ClockPAL), vic.PAL
+	if header.NTSC() {
+		clockHz, model = sid.ClockNTSC, vic.NTSC
+	}
+
+	bus := devices.NewBus(memory.NewMemory[uint16](memorySize), false)
+	p := &Player{
+		Bus:         bus,
+		SID:         sid.New(clockHz),
+		CIA:         cia.New(nil, clockHz, cia.PowerLine50Hz),
+		Header:      header,
+		clockHz:     clockHz,
+		frameCycles: uint64(model.CyclesPerLine * model.Lines),
+	}
+	bus.Attach(SIDAddress, SIDAddress+0x3FF, p.SID)
+	bus.Attach(CIAAddress, CIAAddress+0xFF, p.CIA)
+	for _, segment := range segments {
+		bus.Memory.Write(segment.StartAddress, segment.Data.Bytes()...)
+	}
+	bus.SetTrap(returnAddress, func(*cpu.CPU) error {
+		p.returned = true
+		return nil
+	})
+	return p, nil
+}
+
+// Init calls the tune's init routine for a 1 based song number, 0 for the tune's start song.
+func (p *Player) Init(song int) error {
+	if song == 0 {
+		song = int(p.Header.StartSong)
+	}
+	if song < 1 || song > int(p.Header.Songs) {
+		return fmt.Errorf("[sidplay Init] song %d out of range 1-%d", song, p.Header.Songs)
+	}
+	p.song = song
+	p.CPU.Reg.S = initialStackAddr
+	if err := p.call(p.Header.InitAddress, byte(song-1)); err != nil {
+		return fmt.Errorf("[sidplay Init] %w", err)
+	}
+	return nil
+}
+
+// FrameCycles returns the number of cycles between play calls: a video frame, or the period of CIA 1
+// timer A for songs timed by the CIA once init has set it.
+func (p *Player) FrameCycles() uint64 {
+	if p.Header.SongSpeed(p.song) == output.SIDSpeedCIA {
+		if period := p.CIA.TimerALatch(); period != 0xFFFF {
+			return uint64(period) + 1
+		}
+	}
+	return p.frameCycles
+}
+
+// Play calls the tune's play routine, then clocks the SID until the frame is over.
+func (p *Player) Play() error {
+	start := p.SID.Cycles()
+	if err := p.call(p.Header.PlayAddress, 0); err != nil {
+		return fmt.Errorf("[sidplay Play] %w", err)
+	}
+	for end := start + p.FrameCycles(); p.SID.Cycles() < end; {
+		for _, d := range p.Devices() {
+			d.Tick()
+		}
+	}
+	return nil
+}
+
+// PlayFrames calls play n times.
+func (p *Player) PlayFrames(n int) error {
+	for i := 0; i < n; i++ {
+		if err := p.Play(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlaySeconds calls play for the given length of emulated time.
+func (p *Player) PlaySeconds(seconds float64) error {
+	end := p.SID.Cycles() + uint64(seconds*float64(p.clockHz))
+	for p.SID.Cycles() < end {
+		if err := p.Play(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call runs the subroutine at address with a in the accumulator until it returns.
+func (p *Player) call(address uint16, a byte) error {
+	ret := uint16(returnAddress)
+	ret-- // RTS adds 1
+	p.CPU.Push(byte(ret >> 8))
+	p.CPU.Push(byte(ret))
+	p.CPU.Reg.PC = address
+	p.CPU.Reg.A = a
+	p.CPU.Reg.X, p.CPU.Reg.Y = 0, 0
+	p.CPU.Reg.SetStatus(cpu.InterruptDisableFlag, true)
+
+	p.returned = false
+	for cycles := uint64(0); !p.returned; cycles++ {
+		if cycles == p.clockHz {
+			return fmt.Errorf("%w: $%04X", ErrCallTimeout, address)
+		}
+		if _, err := p.Step(); err != nil {
+			return fmt.Errorf("at $%04X: %w", p.CPU.Reg.PC, err)
+		}
+	}
+	return nil
+}
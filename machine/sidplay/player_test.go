@@ -0,0 +1,109 @@
+package sidplay
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jrsteele09/go-6502-emulator/assembler"
+	"github.com/jrsteele09/go-6502-emulator/assembler/output"
+	"github.com/jrsteele09/go-6502-emulator/devices/sid"
+	"github.com/jrsteele09/go-6502-emulator/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	loadAddress = 0x1000
+	playAddress = 0x1020
+)
+
+// testTune stores the song number at $FB and sets up voice 1 in init; play counts frames at $FC and
+// sweeps voice 1's frequency with the count.
+var testTune = func() []byte {
+	code := make([]byte, 0x30)
+	copy(code, []byte{
+		0x85, 0xFB, // STA $FB
+		0xA9, 0x0F, // LDA #$0F
+		0x8D, 0x18, 0xD4, // STA $D418
+		0xA9, 0x00, // LDA #$00
+		0x8D, 0x05, 0xD4, // STA $D405
+		0xA9, 0xF0, // LDA #$F0
+		0x8D, 0x06, 0xD4, // STA $D406
+		0xA9, 0x00, // LDA #$00
+		0x8D, 0x04, 0xDC, // STA $DC04
+		0xA9, 0x10, // LDA #$10
+		0x8D, 0x05, 0xDC, // STA $DC05
+		0x60, // RTS
+	})
+	copy(code[playAddress-loadAddress:], []byte{
+		0xE6, 0xFC, // INC $FC
+		0xA5, 0xFC, // LDA $FC
+		0x8D, 0x01, 0xD4, // STA $D401
+		0xA9, 0x21, // LDA #$21
+		0x8D, 0x04, 0xD4, // STA $D404
+		0x60, // RTS
+	})
+	return code
+}()
+
+func buildSID(t *testing.T, speed uint32) []byte {
+	format := output.NewSIDFormat("Test", "Tester", "2026", 0, playAddress, 2, 1)
+	format.Speed = speed
+	data, err := format.CreateData([]assembler.AssembledData{
+		{StartAddress: loadAddress, Data: utils.Value(bytes.NewBuffer(testTune))},
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func TestInitAndPlay(t *testing.T) {
+	p, err := New(buildSID(t, 0))
+	require.NoError(t, err)
+	require.NoError(t, p.Init(2))
+	assert.Equal(t, byte(1), p.Memory.Read(0xFB), "init receives the 0 based song number in A")
+
+	start := p.SID.Cycles()
+	require.NoError(t, p.PlayFrames(50))
+	assert.Equal(t, byte(50), p.Memory.Read(0xFC))
+	assert.Equal(t, uint64(50*63*312), p.SID.Cycles()-start, "play is called once per PAL frame")
+
+	samples := p.SID.Render(sid.DefaultSampleRate)
+	assert.NotZero(t, samples[len(samples)-1000])
+}
+
+func TestCIASpeed(t *testing.T) {
+	p, err := New(buildSID(t, 0x01))
+	require.NoError(t, err)
+	require.NoError(t, p.Init(0))
+	assert.Equal(t, uint64(0x1001), p.FrameCycles(), "song 1 is timed by CIA 1 timer A")
+
+	require.NoError(t, p.Init(2))
+	assert.Equal(t, uint64(63*312), p.FrameCycles())
+}
+
+func TestPlaySeconds(t *testing.T) {
+	p, err := New(buildSID(t, 0))
+	require.NoError(t, err)
+	require.NoError(t, p.Init(0))
+	require.NoError(t, p.PlaySeconds(1))
+	assert.Equal(t, byte(51), p.Memory.Read(0xFC), "a second is 50 PAL frames, rounded up")
+}
+
+func TestUnsupportedTunes(t *testing.T) {
+	data := buildSID(t, 0)
+	copy(data, output.RSIDMagic)
+	_, err := New(data)
+	assert.Error(t, err)
+
+	p, err := New(buildSID(t, 0))
+	require.NoError(t, err)
+	assert.Error(t, p.Init(3), "the tune has 2 songs")
+}
+
+func TestCallTimeout(t *testing.T) {
+	p, err := New(buildSID(t, 0))
+	require.NoError(t, err)
+	p.Memory.Write(playAddress, 0x4C, 0x20, 0x10) // JMP *
+	require.NoError(t, p.Init(0))
+	assert.ErrorIs(t, p.Play(), ErrCallTimeout)
+}
@@ -0,0 +1,78 @@
+package sim65
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// Magic identifies a sim65 program image.
+var Magic = []byte("sim65")
+
+// Header fields of a version 2 sim65 image, as written by the cc65 sim6502 and sim65c02 targets.
+const (
+	HeaderVersion = 2
+	headerSize    = 12
+
+	CPU6502  = 0
+	CPU65C02 = 1
+)
+
+// Header describes how a sim65 program is loaded and started.
+//
+//	Offset  Size  Field
+//	0       5     "sim65"
+//	5       1     Version (2)
+//	6       1     CPU type (0 = 6502, 1 = 65C02)
+//	7       1     Zero page address of the cc65 C stack pointer
+//	8       2     Load address
+//	10      2     Reset address
+type Header struct {
+	Version      byte
+	CPU          byte
+	SPAddress    byte
+	LoadAddress  uint16
+	ResetAddress uint16
+}
+
+// HasHeader reports whether data starts with the sim65 magic.
+func HasHeader(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+// ParseHeader parses the header of a sim65 image, returning it with the program bytes that follow.
+func ParseHeader(data []byte) (Header, []byte, error) {
+	if !HasHeader(data) {
+		return Header{}, nil, fmt.Errorf("[sim65 ParseHeader] missing sim65 magic")
+	}
+	if len(data) < headerSize {
+		return Header{}, nil, fmt.Errorf("[sim65 ParseHeader] header truncated")
+	}
+	h := Header{
+		Version:      data[5],
+		CPU:          data[6],
+		SPAddress:    data[7],
+		LoadAddress:  uint16(data[8]) | uint16(data[9])<<8,
+		ResetAddress: uint16(data[10]) | uint16(data[11])<<8,
+	}
+	if h.Version != HeaderVersion {
+		return Header{}, nil, fmt.Errorf("[sim65 ParseHeader] unsupported header version %d", h.Version)
+	}
+	if h.CPU != CPU6502 {
+		return Header{}, nil, fmt.Errorf("[sim65 ParseHeader] unsupported CPU type %d, only the 6502 is emulated", h.CPU)
+	}
+	program := data[headerSize:]
+	if int(h.LoadAddress)+len(program) > 0x10000 {
+		return Header{}, nil, fmt.Errorf("[sim65 ParseHeader] program of %d bytes does not fit at $%04X", len(program), h.LoadAddress)
+	}
+	return h, program, nil
+}
+
+// LoadFile reads and parses a sim65 image from a file.
+func LoadFile(filename string) (Header, []byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Header{}, nil, fmt.Errorf("[sim65 LoadFile] %w", err)
+	}
+	return ParseHeader(data)
+}
@@ -0,0 +1,295 @@
+package sim65
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jrsteele09/go-6502-emulator/cpu"
+	"github.com/jrsteele09/go-6502-emulator/devices"
+)
+
+// Paravirtual call addresses. The cc65 sim6502 runtime calls these with JSR; each call is handled
+// in Go and returns to the caller as if the routine had executed RTS.
+const (
+	ParavirtBase = 0xFFF4
+	PVOpen       = ParavirtBase + iota - 1
+	PVClose
+	PVRead
+	PVWrite
+	PVArgs
+	PVExit
+)
+
+// cc65 open() flags from fcntl.h.
+const (
+	openRead      = 0x01
+	openWrite     = 0x02
+	openReadWrite = 0x03
+	openCreate    = 0x10
+	openTruncate  = 0x20
+	openAppend    = 0x40
+	openExclusive = 0x80
+)
+
+// maxPathLength limits the file names read from memory, as sim65 does.
+const maxPathLength = 1024
+
+// firstFileDescriptor is the first descriptor handed out by open; 0-2 are the standard streams.
+const firstFileDescriptor = 3
+
+// Paravirt implements the sim65 paravirtual calls: open, close, read, write, args and exit.
+// Files are opened beneath a sandbox directory and cannot escape it; descriptors 0, 1 and 2 are
+// connected to the given standard streams. Arguments follow the cc65 calling convention: the last
+// argument is in A/X and the rest are on the C stack, whose pointer is at SPAddress in zero page.
+type Paravirt struct {
+	root      *os.Root
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	args      []string
+	spAddress byte
+	files     map[int]*os.File
+	exited    bool
+	exitCode  int
+}
+
+// NewParavirt creates the paravirtual calls for a program whose files live beneath sandbox.
+// args are passed to main as argv, and should start with the program name.
+func NewParavirt(sandbox string, args []string, stdin io.Reader, stdout, stderr io.Writer) (*Paravirt, error) {
+	root, err := os.OpenRoot(sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("[sim65 NewParavirt] %w", err)
+	}
+	return &Paravirt{
+		root:   root,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		args:   args,
+		files:  make(map[int]*os.File),
+	}, nil
+}
+
+// Install traps the paravirtual call addresses on the bus. spAddress is the zero page location of
+// the C stack pointer, taken from the program's header.
+func (p *Paravirt) Install(bus *devices.Bus, spAddress byte) {
+	p.spAddress = spAddress
+	bus.SetTrap(PVOpen, p.returning(p.open))
+	bus.SetTrap(PVClose, p.returning(p.close))
+	bus.SetTrap(PVRead, p.returning(p.read))
+	bus.SetTrap(PVWrite, p.returning(p.write))
+	bus.SetTrap(PVArgs, p.returning(p.argv))
+	bus.SetTrap(PVExit, p.exit)
+}
+
+// Exited returns the exit code passed to exit, and whether the program has exited.
+func (p *Paravirt) Exited() (int, bool) {
+	return p.exitCode, p.exited
+}
+
+// Close closes every file the program left open, and the sandbox.
+func (p *Paravirt) Close() error {
+	for fd, f := range p.files {
+		f.Close()
+		delete(p.files, fd)
+	}
+	return p.root.Close()
+}
+
+func (p *Paravirt) returning(call func(c *cpu.CPU)) devices.Trap {
+	return func(c *cpu.CPU) error {
+		call(c)
+		devices.ReturnFromSubroutine(c)
+		return nil
+	}
+}
+
+// open(const char* name, int flags, ...): variadic, so every argument is on the C stack and Y holds their size.
+func (p *Paravirt) open(c *cpu.CPU) {
+	extra := int(c.Reg.Y) - 4
+	if extra < 0 {
+		extra = 0
+	}
+	p.popParam(c, extra) // The optional mode is ignored; new files are created with mode 0644
+	flags := p.popParam(c, 2)
+	name := p.readString(c, p.popParam(c, 2))
+
+	var mode int
+	switch flags & openReadWrite {
+	case openRead:
+		mode = os.O_RDONLY
+	case openWrite:
+		mode = os.O_WRONLY
+	case openReadWrite:
+		mode = os.O_RDWR
+	}
+	if flags&openCreate != 0 {
+		mode |= os.O_CREATE
+	}
+	if flags&openTruncate != 0 {
+		mode |= os.O_TRUNC
+	}
+	if flags&openAppend != 0 {
+		mode |= os.O_APPEND
+	}
+	if flags&openExclusive != 0 {
+		mode |= os.O_EXCL
+	}
+
+	f, err := p.root.OpenFile(name, mode, 0644)
+	if err != nil {
+		setAX(c, -1)
+		return
+	}
+	fd := firstFileDescriptor
+	for p.files[fd] != nil {
+		fd++
+	}
+	p.files[fd] = f
+	setAX(c, fd)
+}
+
+// close(int fd)
+func (p *Paravirt) close(c *cpu.CPU) {
+	fd := int(getAX(c))
+	f, ok := p.files[fd]
+	if !ok {
+		if fd < firstFileDescriptor {
+			setAX(c, 0)
+			return
+		}
+		setAX(c, -1)
+		return
+	}
+	delete(p.files, fd)
+	if f.Close() != nil {
+		setAX(c, -1)
+		return
+	}
+	setAX(c, 0)
+}
+
+// read(int fd, void* buf, unsigned count)
+func (p *Paravirt) read(c *cpu.CPU) {
+	count := int(getAX(c))
+	buf := p.popParam(c, 2)
+	fd := int(p.popParam(c, 2))
+
+	var r io.Reader
+	if fd == 0 {
+		r = p.stdin
+	} else if f, ok := p.files[fd]; ok {
+		r = f
+	}
+	if r == nil {
+		setAX(c, -1)
+		return
+	}
+	data := make([]byte, count)
+	n, err := r.Read(data)
+	if err != nil && !errors.Is(err, io.EOF) {
+		setAX(c, -1)
+		return
+	}
+	for i := 0; i < n; i++ {
+		c.Memory().Write(buf+uint16(i), data[i])
+	}
+	setAX(c, n)
+}
+
+// write(int fd, const void* buf, unsigned count)
+func (p *Paravirt) write(c *cpu.CPU) {
+	count := int(getAX(c))
+	buf := p.popParam(c, 2)
+	fd := int(p.popParam(c, 2))
+
+	var w io.Writer
+	switch fd {
+	case 1:
+		w = p.stdout
+	case 2:
+		w = p.stderr
+	default:
+		if f, ok := p.files[fd]; ok {
+			w = f
+		}
+	}
+	if w == nil {
+		setAX(c, -1)
+		return
+	}
+	data := make([]byte, count)
+	for i := range data {
+		data[i] = c.Memory().Read(buf + uint16(i))
+	}
+	n, err := w.Write(data)
+	if err != nil {
+		setAX(c, -1)
+		return
+	}
+	setAX(c, n)
+}
+
+// argv(char*** argv): builds argv on the C stack below the current stack pointer and returns argc.
+func (p *Paravirt) argv(c *cpu.CPU) {
+	mem := c.Memory()
+	argvPtr := getAX(c)
+	sp := p.readWord(c, uint16(p.spAddress))
+	args := sp - uint16(len(p.args)+1)*2
+	p.writeWord(c, argvPtr, args)
+	sp = args
+	for _, arg := range p.args {
+		sp -= uint16(len(arg) + 1)
+		mem.Write(sp, append([]byte(arg), 0)...)
+		p.writeWord(c, args, sp)
+		args += 2
+	}
+	p.writeWord(c, args, 0)
+	p.writeWord(c, uint16(p.spAddress), sp)
+	setAX(c, len(p.args))
+}
+
+// exit(int status): stops the CPU; the call never returns.
+func (p *Paravirt) exit(c *cpu.CPU) error {
+	p.exited = true
+	p.exitCode = int(c.Reg.A)
+	c.Stop()
+	return nil
+}
+
+// popParam reads a word from the C stack and then drops size bytes from it.
+func (p *Paravirt) popParam(c *cpu.CPU, size int) uint16 {
+	sp := p.readWord(c, uint16(p.spAddress))
+	value := p.readWord(c, sp)
+	p.writeWord(c, uint16(p.spAddress), sp+uint16(size))
+	return value
+}
+
+// readString reads a NUL terminated string of up to maxPathLength bytes.
+func (p *Paravirt) readString(c *cpu.CPU, address uint16) string {
+	var s []byte
+	for b := c.Memory().Read(address); b != 0 && len(s) < maxPathLength; b = c.Memory().Read(address) {
+		s = append(s, b)
+		address++
+	}
+	return string(s)
+}
+
+func (p *Paravirt) readWord(c *cpu.CPU, address uint16) uint16 {
+	return uint16(c.Memory().Read(address)) | uint16(c.Memory().Read(address+1))<<8
+}
+
+func (p *Paravirt) writeWord(c *cpu.CPU, address uint16, value uint16) {
+	c.Memory().Write(address, byte(value), byte(value>>8))
+}
+
+func getAX(c *cpu.CPU) uint16 {
+	return uint16(c.Reg.A) | uint16(c.Reg.X)<<8
+}
+
+func setAX(c *cpu.CPU, value int) {
+	c.Reg.A = byte(value)
+	c.Reg.X = byte(value >> 8)
+}
@@ -0,0 +1,62 @@
+// Package sim65 runs programs built with the cc65 sim6502 target (cl65 -t sim6502) unchanged: it loads
+// the sim65 image header and implements the paravirtual calls the cc65 runtime uses for file I/O,
+// command line arguments and exit.
+package sim65
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jrsteele09/go-6502-emulator/devices"
+	"github.com/jrsteele09/go-6502-emulator/memory"
+)
+
+const (
+	memorySize       = 64 * 1024
+	resetVectorAddr  = 0xFFFC
+	initialStackAddr = 0xFF
+)
+
+// ErrCycleLimit is returned by Run when the program does not exit within the cycle limit.
+var ErrCycleLimit = errors.New("cycle limit reached")
+
+// Machine is a 6502 with 64K of RAM and the sim65 paravirtual calls.
+type Machine struct {
+	*devices.Bus
+	Header   Header
+	Paravirt *Paravirt
+}
+
+// New loads a sim65 image into a new machine, installs the paravirtual calls and resets the CPU to
+// the image's reset address.
+func New(image []byte, pv *Paravirt) (*Machine, error) {
+	header, program, err := ParseHeader(image)
+	if err != nil {
+		return nil, fmt.Errorf("[sim65 New] %w", err)
+	}
+	bus := devices.NewBus(memory.NewMemory[uint16](memorySize), false)
+	bus.Memory.Write(header.LoadAddress, program...)
+	bus.Memory.Write(resetVectorAddr, byte(header.ResetAddress), byte(header.ResetAddress>>8))
+	pv.Install(bus, header.SPAddress)
+	bus.Reset()
+	bus.CPU.Reg.S = initialStackAddr
+
+	return &Machine{Bus: bus, Header: header, Paravirt: pv}, nil
+}
+
+// Run clocks the machine until the program exits, returning its exit code. maxCycles limits the run,
+// 0 for no limit; ErrCycleLimit is returned when it is reached.
+func (m *Machine) Run(maxCycles uint64) (int, error) {
+	for cycles := uint64(0); maxCycles == 0 || cycles < maxCycles; cycles++ {
+		if code, exited := m.Paravirt.Exited(); exited {
+			return code, nil
+		}
+		if _, err := m.Step(); err != nil {
+			return 0, fmt.Errorf("[sim65 Run] at $%04X: %w", m.CPU.Reg.PC, err)
+		}
+	}
+	if code, exited := m.Paravirt.Exited(); exited {
+		return code, nil
+	}
+	return 0, ErrCycleLimit
+}
@@ -0,0 +1,155 @@
+package sim65
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const spAddress = 0x02
+
+var (
+	loadAddress uint16 = 0x0200
+	cStack      uint16 = 0x0300 // Arguments are laid out here and the C stack pointer set to point at them
+	dataAddress uint16 = 0x0320
+)
+
+// buildImage creates a sim65 image loaded at $0200 that points the C stack pointer at cStack and runs code,
+// with stack holding the prepared C stack and data the strings it refers to.
+func buildImage(code, stack, data []byte) []byte {
+	program := make([]byte, 0x200)
+	copy(program, []byte{
+		0xA9, byte(cStack), // LDA #<cStack
+		0x85, spAddress, // STA sp
+		0xA9, byte(cStack >> 8), // LDA #>cStack
+		0x85, spAddress + 1, // STA sp+1
+	})
+	copy(program[8:], code)
+	copy(program[cStack-loadAddress:], stack)
+	copy(program[dataAddress-loadAddress:], data)
+
+	header := append([]byte("sim65"), HeaderVersion, CPU6502, spAddress, byte(loadAddress), byte(loadAddress>>8), byte(loadAddress), byte(loadAddress>>8))
+	return append(header, program...)
+}
+
+func run(t *testing.T, image []byte, sandbox string, args []string) (int, *Machine, string) {
+	out := &bytes.Buffer{}
+	pv, err := NewParavirt(sandbox, args, strings.NewReader("input"), out, out)
+	require.NoError(t, err)
+	defer pv.Close()
+	m, err := New(image, pv)
+	require.NoError(t, err)
+	code, err := m.Run(100000)
+	require.NoError(t, err)
+	return code, m, out.String()
+}
+
+func TestParseHeader(t *testing.T) {
+	image := buildImage(nil, nil, nil)
+	h, program, err := ParseHeader(image)
+	require.NoError(t, err)
+	assert.Equal(t, Header{Version: 2, CPU: CPU6502, SPAddress: spAddress, LoadAddress: loadAddress, ResetAddress: loadAddress}, h)
+	assert.Len(t, program, 0x200)
+
+	_, _, err = ParseHeader([]byte("sim65\x02\x01\x00\x00\x02\x00\x02"))
+	assert.Error(t, err, "65C02 images are rejected")
+	_, _, err = ParseHeader([]byte("prg"))
+	assert.Error(t, err)
+}
+
+func TestWriteToStdoutAndExit(t *testing.T) {
+	code := []byte{
+		0xA9, 0x05, // LDA #5
+		0xA2, 0x00, // LDX #0
+		0x20, 0xF7, 0xFF, // JSR write
+		0xA9, 0x2A, // LDA #42
+		0x20, 0xF9, 0xFF, // JSR exit
+	}
+	stack := []byte{byte(dataAddress), byte(dataAddress >> 8), 0x01, 0x00} // buf, fd
+	exitCode, m, out := run(t, buildImage(code, stack, []byte("hello")), t.TempDir(), nil)
+	assert.Equal(t, 42, exitCode)
+	assert.Equal(t, "hello", out)
+	assert.Equal(t, cStack+4, uint16(m.Memory.Read(spAddress))|uint16(m.Memory.Read(spAddress+1))<<8, "parameters were popped")
+}
+
+func TestFileIOInSandbox(t *testing.T) {
+	code := []byte{
+		0xA0, 0x04, // LDY #4
+		0x20, 0xF4, 0xFF, // JSR open
+		0x85, 0x10, // STA $10
+		0xA9, 0x02, // LDA #2
+		0xA2, 0x00, // LDX #0
+		0x20, 0xF7, 0xFF, // JSR write
+		0xA5, 0x10, // LDA $10
+		0xA2, 0x00, // LDX #0
+		0x20, 0xF5, 0xFF, // JSR close
+		0xA0, 0x04, // LDY #4
+		0x20, 0xF4, 0xFF, // JSR open
+		0x85, 0x11, // STA $11
+		0xA9, 0x00, // LDA #0
+		0x20, 0xF9, 0xFF, // JSR exit
+	}
+	stack := []byte{
+		0x32, 0x00, byte(dataAddress), byte(dataAddress >> 8), // open("out.txt", O_WRONLY|O_CREAT|O_TRUNC)
+		byte(dataAddress + 8), byte(dataAddress >> 8), 0x03, 0x00, // write(3, "ok", 2)
+		0x01, 0x00, byte(dataAddress + 16), byte(dataAddress >> 8), // open("../escape", O_RDONLY)
+	}
+	data := make([]byte, 32)
+	copy(data, "out.txt\x00ok")
+	copy(data[16:], "../escape\x00")
+
+	sandbox := t.TempDir()
+	exitCode, m, _ := run(t, buildImage(code, stack, data), sandbox, nil)
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, byte(3), m.Memory.Read(0x10), "first file descriptor")
+	assert.Equal(t, byte(0xFF), m.Memory.Read(0x11), "paths outside the sandbox cannot be opened")
+
+	written, err := os.ReadFile(filepath.Join(sandbox, "out.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(written))
+}
+
+func TestReadFromStdin(t *testing.T) {
+	code := []byte{
+		0xA9, 0x10, // LDA #16
+		0xA2, 0x00, // LDX #0
+		0x20, 0xF6, 0xFF, // JSR read
+		0x20, 0xF9, 0xFF, // JSR exit
+	}
+	stack := []byte{byte(dataAddress), byte(dataAddress >> 8), 0x00, 0x00} // buf, fd
+	exitCode, m, _ := run(t, buildImage(code, stack, nil), t.TempDir(), nil)
+	assert.Equal(t, 5, exitCode, "read returned the byte count")
+	assert.Equal(t, byte('i'), m.Memory.Read(dataAddress))
+	assert.Equal(t, byte('t'), m.Memory.Read(dataAddress+4))
+}
+
+func TestArgs(t *testing.T) {
+	code := []byte{
+		0xA9, 0x00, // LDA #<$0400
+		0xA2, 0x04, // LDX #>$0400
+		0x20, 0xF8, 0xFF, // JSR args
+		0x20, 0xF9, 0xFF, // JSR exit
+	}
+	exitCode, m, _ := run(t, buildImage(code, nil, nil), t.TempDir(), []string{"prog", "-x"})
+	assert.Equal(t, 2, exitCode, "args returns argc")
+
+	word := func(a uint16) uint16 { return uint16(m.Memory.Read(a)) | uint16(m.Memory.Read(a+1))<<8 }
+	argv := word(0x0400)
+	assert.Equal(t, cStack-6, argv)
+	readString := func(a uint16) string {
+		var s []byte
+		for ; m.Memory.Read(a) != 0; a++ {
+			s = append(s, m.Memory.Read(a))
+		}
+		return string(s)
+	}
+	assert.Equal(t, "prog", readString(word(argv)))
+	assert.Equal(t, "-x", readString(word(argv+2)))
+	assert.Equal(t, uint16(0), word(argv+4))
+	assert.Equal(t, word(argv+2), word(spAddress), "the C stack is moved below the strings")
+}
@@ -0,0 +1,74 @@
+package memory
+
+// region is an address range routed to a specific set of memory operations.
+type region[AZ AddressSize] struct {
+	start AZ
+	end   AZ
+	ops   Operations[AZ]
+}
+
+// MemoryMap routes reads and writes for address ranges to mapped handlers (ROMs, peripherals),
+// falling back to a base memory for any address that is not mapped.
+// Mapped handlers receive the address relative to the start of their range.
+type MemoryMap[AZ AddressSize] struct {
+	base    Operations[AZ]
+	regions []region[AZ]
+}
+
+// Ensure MemoryMap implements the Operations interface.
+var _ Operations[uint16] = &MemoryMap[uint16]{}
+
+// NewMemoryMap creates a new MemoryMap backed by the given base memory.
+func NewMemoryMap[AZ AddressSize](base Operations[AZ]) *MemoryMap[AZ] {
+	return &MemoryMap[AZ]{base: base}
+}
+
+// Map routes the inclusive address range start-end to ops. Later mappings take priority over earlier ones.
+func (m *MemoryMap[AZ]) Map(start, end AZ, ops Operations[AZ]) {
+	m.regions = append(m.regions, region[AZ]{start: start, end: end, ops: ops})
+}
+
+// Unmap removes every mapping that starts at the given address.
+func (m *MemoryMap[AZ]) Unmap(start AZ) {
+	regions := m.regions[:0]
+	for _, r := range m.regions {
+		if r.start != start {
+			regions = append(regions, r)
+		}
+	}
+	m.regions = regions
+}
+
+// Base returns the memory used for unmapped addresses.
+func (m *MemoryMap[AZ]) Base() Operations[AZ] {
+	return m.base
+}
+
+// Write writes data starting at the specified address, routing each byte to its mapped handler.
+func (m *MemoryMap[AZ]) Write(address AZ, data ...byte) {
+	for i, b := range data {
+		a := address + AZ(i)
+		if r := m.find(a); r != nil {
+			r.ops.Write(a-r.start, b)
+			continue
+		}
+		m.base.Write(a, b)
+	}
+}
+
+// Read reads a byte from the specified address via its mapped handler, or the base memory.
+func (m *MemoryMap[AZ]) Read(address AZ) byte {
+	if r := m.find(address); r != nil {
+		return r.ops.Read(address - r.start)
+	}
+	return m.base.Read(address)
+}
+
+func (m *MemoryMap[AZ]) find(address AZ) *region[AZ] {
+	for i := len(m.regions) - 1; i >= 0; i-- {
+		if address >= m.regions[i].start && address <= m.regions[i].end {
+			return &m.regions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package memory
+
+// ROM is read-only memory: reads return its contents and writes are ignored.
+// Reads beyond the end of the image return $FF, like an unpopulated bus.
+type ROM[AZ AddressSize] struct {
+	bytes []byte
+}
+
+// Ensure ROM implements the Operations interface.
+var _ Operations[uint16] = &ROM[uint16]{}
+
+// NewROM creates a ROM holding a copy of data.
+func NewROM[AZ AddressSize](data []byte) *ROM[AZ] {
+	return &ROM[AZ]{bytes: append([]byte(nil), data...)}
+}
+
+// Write ignores writes, as the CPU cannot change ROM.
+func (r *ROM[AZ]) Write(_ AZ, _ ...byte) {}
+
+// Read reads a byte from the specified address in the ROM.
+func (r *ROM[AZ]) Read(address AZ) byte {
+	if uint64(address) >= uint64(len(r.bytes)) {
+		return 0xFF
+	}
+	return r.bytes[address]
+}
+
+// Size returns the size of the ROM image in bytes.
+func (r *ROM[AZ]) Size() int {
+	return len(r.bytes)
+}